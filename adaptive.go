@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// adaptiveInterval is how often the number of downloads run at once is tuned (w/ -adaptive)
+const adaptiveInterval = 30 * time.Second
+
+// adaptiveHold is how many intervals the number of downloads is held for, after adding one didn't make downloads
+// faster, before trying again
+const adaptiveHold = 4
+
+// downloadFailures counts the downloads which have failed, and tunedLimit is the limit the last run was tuned to, which
+// the next starts from, for -adaptive
+var (
+	downloadFailures uint64
+	tunedLimit       int
+)
+
+// concurrencyTuner tunes the number of downloads a scheduler runs at once, between 1 and -j, AIMD-style: it adds one
+// while that makes downloads faster, removes it again if it doesn't, and halves the number when downloads fail, e.g.
+// as a CDN starts resetting connections.
+type concurrencyTuner struct {
+	scheduler *jobScheduler
+	max       int
+
+	limit     int
+	increased bool
+	hold      int
+
+	// lastRate is the throughput of the last interval, and lastDownloaded and lastFailures the counters at its end
+	lastRate       float64
+	lastDownloaded uint64
+	lastFailures   uint64
+}
+
+// startConcurrencyTuner limits scheduler to one download at once (or as many as the last run was tuned to), and tunes
+// the limit every adaptiveInterval until stop is closed.
+func startConcurrencyTuner(scheduler *jobScheduler, max int, stop <-chan struct{}) {
+	limit := tunedLimit
+
+	if limit < 1 || limit > max {
+		limit = 1
+	}
+
+	t := &concurrencyTuner{
+		scheduler:      scheduler,
+		max:            max,
+		limit:          limit,
+		lastDownloaded: atomic.LoadUint64(&downloadedSize),
+		lastFailures:   atomic.LoadUint64(&downloadFailures),
+	}
+
+	scheduler.setLimit(t.limit)
+
+	go func() {
+		ticker := time.NewTicker(adaptiveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.tune()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// tune adjusts the limit after an interval.
+func (t *concurrencyTuner) tune() {
+	downloaded, failures := atomic.LoadUint64(&downloadedSize), atomic.LoadUint64(&downloadFailures)
+	rate := float64(downloaded-t.lastDownloaded) / adaptiveInterval.Seconds()
+	failed := failures - t.lastFailures
+
+	t.lastDownloaded, t.lastFailures = downloaded, failures
+
+	// while paused, or while the queue is too short to use the limit, the throughput says nothing about it
+	if isPaused() || !t.scheduler.saturated() {
+		return
+	}
+
+	limit := t.limit
+
+	switch {
+	case failed > 0:
+		limit = (t.limit + 1) / 2
+		t.hold = adaptiveHold
+	case t.increased && rate < t.lastRate*1.05:
+		limit = t.limit - 1
+		t.hold = adaptiveHold
+	case t.hold > 0:
+		t.hold--
+	case t.limit < t.max:
+		limit = t.limit + 1
+	}
+
+	t.increased, t.lastRate = limit > t.limit, rate
+
+	if limit == t.limit {
+		return
+	}
+
+	if failed > 0 {
+		infof("%d download(s) failed at %s/s, downloading %d firmware(s) at once instead of %d (-adaptive)", failed, humanize.Bytes(uint64(rate)), limit, t.limit)
+	} else {
+		infof("Downloading at %s/s, downloading %d firmware(s) at once instead of %d (-adaptive)", humanize.Bytes(uint64(rate)), limit, t.limit)
+	}
+
+	t.limit, tunedLimit = limit, limit
+	t.scheduler.setLimit(limit)
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"crypto/sha1"
 	_ "crypto/sha512"
 	"encoding/hex"
@@ -9,14 +9,16 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
-	"text/template"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cheggaaa/pb"
 	"github.com/cj123/go-ipsw/api"
@@ -24,13 +26,128 @@ import (
 )
 
 var (
-	ipswClient = api.NewIPSWClient("https://api.ipsw.me/v4", nil)
+	ipswClient metadataSource
 
 	filter, filterValue string
 
 	// flags
 	verifyIntegrity, reDownloadOnVerificationFailed, downloadSigned, downloadLatest bool
-	downloadDirectoryTemplate, specifiedDevice                                      string
+	lockInstance, waitForLock, lockFiles, signedFirst                               bool
+	downloadDirectoryTemplate, specifiedDevice, throughputLogFile, stateDir         string
+	auditLogFile                                                                    string
+	reportPath, pushgatewayURL, pushgatewayJob, otlpEndpoint                        string
+	verifyReportPath, statusFilePath                                                string
+	statusInterval                                                                  time.Duration
+	filenameTemplate, versionPatterns, onCollision, deviceVersions, layoutPreset    string
+	prefixIdentifier                                                                bool
+	safePaths, preallocate, refreshChecksums, checkManifests, deepVerify            bool
+	writeFastChecksums, fastRecheck, pipelineDownloads, refreshChanged              bool
+	cdnFallback, fastestMirror                                                      bool
+	mirrors                                                                         string
+	forceIPv4, forceIPv6, insecureTLS, debugHTTP                                    bool
+	caCertificate, clientCertificate, clientKey                                     string
+	resolveOverrides, dnsServer                                                     string
+	useHTTP2                                                                        bool
+	maxIdleConns                                                                    int
+	apiBaseURL, metadataToken, metadataTokenFile, pinFilePath, ignoreFilePath       string
+	credentialsFile                                                                 string
+	metadataSources, appleCatalogs                                                  string
+	bufferSizeValue, sizeToleranceValue                                             string
+	downloadOrder, downloadWindow, listenAddress                                    string
+	limitRate, rateSchedule, ioLimit, ioPriority                                    string
+	shshDevices, shshGenerator, deviceFilterValue, shardValue                       string
+	progressMode, progressInterval                                                  string
+	apiConcurrency, apiRetries, latestCount, sampleCount                            int
+	downloadRetries                                                                 int
+	sampleValue                                                                     string
+	apiRate                                                                         float64
+
+	// storage
+	destinationURL        string
+	keepLocal, streamOnly bool
+
+	// layout
+	contentAddressed bool
+	linkType         string
+	reuseExisting    string
+
+	// relayout
+	oldDirectoryTemplate, oldFilenameTemplate string
+	dryRun                                    bool
+	allowDowngrade                            bool
+
+	// gc
+	gcAction, gcDirectory string
+	cleanAge              time.Duration
+	trashDirectory        string
+	trashAge              time.Duration
+	snapshotDir           string
+	snapshotKeep          int
+
+	// permissions
+	dirModeValue, fileModeValue, ownerValue string
+
+	// limits
+	maxBytesValue, maxFileSizeValue    string
+	minFreeValue, maxArchiveSizeValue  string
+	confirmOverValue                   string
+	assumeYes, keyboardControls        bool
+	maxFiles                           int
+	downloadWorkers, deviceConcurrency int
+	adaptiveConcurrency                bool
+	hostConcurrencyValue               string
+
+	// pool
+	poolValue, poolRouteValue, poolBalance string
+
+	// sync
+	syncTarget, splitSizeValue string
+
+	// extract
+	extractMembers, extractDirectory string
+
+	// signing
+	signMethod, signKey string
+
+	verifyChunklists bool
+	chunklistKeyPath string
+
+	// benchmark
+	benchmarkDuration time.Duration
+
+	// link-check
+	linkCheckRate float64
+
+	// daemon
+	daemonInterval                 time.Duration
+	daemonSchedule                 string
+	configPath                     string
+	apiToken, apiUser, apiPassword string
+	healthMaxPollAge               time.Duration
+	tlsCertificate, tlsKey         string
+
+	// export
+	exportFormat, exportOutput string
+	jsonOutput                 bool
+	statsPeriod                string
+	torrentTrackers            string
+
+	// notifications
+	slackWebhook, discordWebhook       string
+	telegramToken, telegramChat        string
+	smtpServer, smtpUser, smtpPassword string
+	emailFrom, emailTo                 string
+	emailDigestInterval                time.Duration
+	desktopNotify                      bool
+	notifyExec                         string
+	mqttBroker, mqttTopic              string
+	mqttUser, mqttPassword             string
+	notifyEventTypes, notifyTemplate   string
+
+	// logging
+	logLevelName, logFile, logFileMaxSize string
+	logFileMaxBackups                     int
+	logToSyslog, logJournald, noColor     bool
 
 	// counters
 	downloadedSize, totalFirmwareSize    uint64
@@ -38,185 +155,1196 @@ var (
 )
 
 func init() {
-	flag.BoolVar(&downloadLatest, "l", false, "only download the latest firmware for the specified devices")
-	flag.BoolVar(&verifyIntegrity, "c", false, "just check the integrity of the currently downloaded files (if any)")
+	flag.BoolVar(&downloadLatest, "l", false, "only download the latest firmware for the specified devices (the same as -latest 1)")
+	flag.IntVar(&latestCount, "latest", 0, "only download the N latest firmwares for the specified devices, 0 for all")
+	flag.BoolVar(&verifyIntegrity, "c", false, "just check the integrity of the currently downloaded files (if any), or check each file before moving it (relayout)")
 	flag.BoolVar(&reDownloadOnVerificationFailed, "r", false, "redownload the file if it fails verification (w/ -c)")
+	flag.IntVar(&downloadRetries, "retries", 3, "how many times to redownload a firmware which fails verification before giving up (w/ -r)")
 	flag.BoolVar(&downloadSigned, "s", false, "only download signed firmwares")
 	flag.StringVar(&downloadDirectoryTemplate, "d", "./", "the location to save/check IPSW files.\n\tCan include templates e.g. {{.Identifier}} or {{.Name}} or {{.BuildID}}\n\n\tFor example try -d \"{{.Name}}/{{.Version}}\"\n")
-	flag.StringVar(&specifiedDevice, "i", "", "only download for the specified device")
+	flag.StringVar(&layoutPreset, "layout", "", "store firmwares with Apple's filenames where iTunes and Finder (itunes) or Apple Configurator (configurator) look for them, beneath the home directory, or -d if given")
+	flag.StringVar(&filenameTemplate, "filename", "", "the filename to save IPSW files as, with the same templates as -d, e.g. \"{{.Identifier}}_{{.Version}}_{{.BuildID}}.ipsw\" (default: the filename from Apple's URL)")
+	flag.BoolVar(&prefixIdentifier, "prefix-identifier", false, "prefix the filename from Apple's URL with the device identifier, e.g. iPhone14,2_iPhone_4.7_P3_16.0_20A362_Restore.ipsw, so that firmwares of different devices saved to the same directory can't collide (w/o -filename)")
+	flag.StringVar(&onCollision, "on-collision", "skip", "what to do when different firmwares would be saved to the same path: skip all but the first, with an error, or fail the run")
+	flag.BoolVar(&safePaths, "safe-paths", runtime.GOOS == "windows", "replace characters and names which are invalid on Windows in templated paths, e.g. for Windows shares (default on Windows)")
+	flag.BoolVar(&preallocate, "preallocate", true, "reserve disk space for each firmware before downloading it, to reduce fragmentation and fail early if there isn't enough")
+	flag.StringVar(&bufferSizeValue, "buffer-size", "1MiB", "the size of the buffer used to read downloads and write them to disk, and to read files while verifying, e.g. 4MiB for fast networks and disks")
+	flag.StringVar(&sizeToleranceValue, "size-tolerance", "0", "abort a download if the server's file size differs from the expected size by more than this, or once it is this much larger, or off to disable")
+	flag.BoolVar(&forceIPv4, "4", false, "only connect to servers over IPv4 when downloading firmwares")
+	flag.BoolVar(&forceIPv6, "6", false, "only connect to servers over IPv6 when downloading firmwares")
+	flag.StringVar(&resolveOverrides, "resolve", "", "connect to these addresses when downloading firmwares, like curl's --resolve, e.g. updates.cdn-apple.com:443:17.253.1.2 (separated by commas)")
+	flag.BoolVar(&cdnFallback, "cdn-fallback", true, "when a firmware isn't found on (or times out from) an Apple CDN hostname, try the others")
+	flag.StringVar(&mirrors, "mirrors", "", "also download firmwares from these mirrors of Apple's CDN, under the same path as on it, e.g. http://cache.local (separated by commas)")
+	flag.BoolVar(&fastestMirror, "fastest-mirror", false, "download each firmware from the fastest of the Apple CDN hostnames and -mirrors, found by downloading 1MB from each")
+	flag.BoolVar(&useHTTP2, "http2", true, "use HTTP/2 with servers which support it")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", 16, "the number of idle connections to each server kept open for reuse by later requests, or 0 to close each after its request")
+	flag.StringVar(&dnsServer, "dns", "", "look up the hosts firmwares are downloaded from with this DNS server, e.g. 10.0.0.53 or 10.0.0.53:5353")
+	flag.StringVar(&caCertificate, "ca-cert", "", "also trust the CA certificates in this PEM file, e.g. for a TLS intercepting proxy or an internal mirror")
+	flag.StringVar(&clientCertificate, "client-cert", "", "present this TLS client certificate to servers")
+	flag.StringVar(&clientKey, "client-key", "", "the private key file for -client-cert")
+	flag.BoolVar(&insecureTLS, "insecure", false, "don't verify servers' TLS certificates (dangerous, use -ca-cert instead if possible)")
+	flag.StringVar(&apiBaseURL, "metadata-url", "https://api.ipsw.me/v4", "the ipsw.me v4 compatible API to get firmware information from, e.g. a private mirror")
+	flag.StringVar(&metadataSources, "source", "api", "where to get firmware information from, in priority order, separated by commas: api (-metadata-url), apple (-apple-catalogs) or the URL of another ipsw.me v4 compatible API; the devices and firmwares of every source are merged, and sources which fail are skipped")
+	flag.StringVar(&appleCatalogs, "apple-catalogs", "https://itunes.apple.com/WebObjects/MZStore.woa/wa/com.apple.jingle.appserver.client.MZITunesClientCheck/version,https://mesu.apple.com/assets/macos/com_apple_macOSIPSW/com_apple_macOSIPSW.xml", "Apple's XML catalogs of restore images, separated by commas (w/ -source apple)")
+	flag.StringVar(&metadataToken, "metadata-token", "", "authenticate to the firmware information API with this bearer token, or set ALLTHEFIRMWARES_METADATA_TOKEN")
+	flag.StringVar(&metadataTokenFile, "metadata-token-file", "", "read the token for the firmware information API from this file")
+	flag.StringVar(&credentialsFile, "credentials-file", "", "authenticate downloads from hosts such as internal -mirrors with the credentials in this file: a line per host of \"host basic user:password\" or \"host bearer token\" (or set ALLTHEFIRMWARES_CREDENTIALS, separating them with semicolons)")
+	flag.BoolVar(&debugHTTP, "debug-http", false, "log each HTTP request's connection, response, redirects and transfer speed, to diagnose stalled or failing downloads")
+	flag.StringVar(&ignoreFilePath, "ignore", "", "never download (or check) the firmwares matching the rules in this file, e.g. known-bad or pulled builds: a rule per line of device identifiers, build IDs and versions, which must all match")
+	flag.StringVar(&pinFilePath, "pin", "", "only download the exact firmwares listed in this pin file, or the file to write (pin)")
+	flag.StringVar(&specifiedDevice, "i", "", "only download for the specified device(s), separated by commas")
+	flag.StringVar(&versionPatterns, "version", "", "only download (or check) these versions, separated by commas, each a version or prefix where x matches anything, e.g. 16.x or 15.7.1")
+	flag.StringVar(&deviceVersions, "device-versions", "", "the firmwares to download for particular devices, instead of -version and -latest, as rules separated by semicolons, each a device identifier (or glob) and its constraints, e.g. \"iPhone12,1: >=15.0 <17; iPad7,11: latest 2\"; in -config, each rule can be on a line of its own")
+	flag.StringVar(&deviceFilterValue, "device-filter", "", "only download for devices whose attributes match these glob patterns, separated by commas, e.g. boardconfig=d*ap or platform=t8010|t8011 (identifier, name, boardconfig, platform, cpid or bdid)")
 	flag.StringVar(&filter, "filter", "", "filter by a specific struct field")
 	flag.StringVar(&filterValue, "filterValue", "", "the value to filter by (used with -filter)")
+	flag.StringVar(&auditLogFile, "audit-log", "", "append a line of JSON to this file for each firmware created, moved, linked, renamed, deleted or pruned in the archive, recording when, by whom and why")
+	flag.StringVar(&throughputLogFile, "throughput-log", "", "append a CSV record of each completed download (size, duration, speed, retries and bytes transferred, less than its size if it was resumed) to this file")
+	flag.StringVar(&reportPath, "report", "", "write a JSON report of each run (planned firmwares, their outcomes and durations, errors and totals) to this file")
+	flag.BoolVar(&refreshChecksums, "refresh-checksums", false, "bypass any caches of the firmware information when checking files, flagging those whose SHA1 has changed upstream since it was recorded (w/ -c or verify)")
+	flag.BoolVar(&checkManifests, "check-manifest", false, "also check that the BuildManifest.plist of each firmware lists the device it is filed under, catching misfiled firmwares (w/ -c or verify)")
+	flag.BoolVar(&writeFastChecksums, "fast-checksums", false, "write the CRC-32C of each firmware which passes verification to a .fastcheck file beside it, for -fast-recheck (w/ -c or verify)")
+	flag.BoolVar(&fastRecheck, "fast-recheck", false, "check firmwares against their .fastcheck CRC-32C, rather than their SHA1, for routine checks for bit rot; firmwares without one are fully verified, and one written (w/ -c or verify)")
+	flag.StringVar(&sampleValue, "sample", "", "only check a random sample of this percentage of the downloaded firmwares each run, e.g. 10%, so that large archives are continuously checked for bit rot (w/ -c or verify)")
+	flag.IntVar(&sampleCount, "sample-count", 0, "only check a random sample of this many downloaded firmwares each run (w/ -c or verify)")
+	flag.BoolVar(&deepVerify, "deep", false, "also check the CRC-32 of every file inside each firmware, including firmwares without a checksum (w/ -c or verify)")
+	flag.BoolVar(&verifyChunklists, "chunklist", false, "also check macOS firmwares against the .chunklist of SHA-256 hashes Apple publishes beside them, which also verifies those without a checksum (w/ -c or verify)")
+	flag.StringVar(&chunklistKeyPath, "chunklist-key", "", "check the signature of each chunklist with this PEM RSA public key (w/ -chunklist)")
+	flag.StringVar(&statusFilePath, "status-file", "", "write the progress of the run (phase, active downloads with their progress and speed, and the number queued) to this JSON file every -status-interval")
+	flag.DurationVar(&statusInterval, "status-interval", 5*time.Second, "how often to write -status-file")
+	flag.StringVar(&verifyReportPath, "verify-report", "", "write the result of checking each firmware (w/ -c, verify or verify-mirror) to this file, as CSV if it ends in .csv, otherwise JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway", "", "push metrics about the run to this Prometheus Pushgateway when allthefirmwares exits, e.g. http://pushgateway:9091")
+	flag.StringVar(&pushgatewayJob, "pushgateway-job", "allthefirmwares", "the job to push metrics as (w/ -pushgateway)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export a trace of each run's phases and downloads, and its metrics, to this OpenTelemetry collector's OTLP/HTTP endpoint, e.g. http://collector:4318 (default: OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&slackWebhook, "slack-webhook", "", "send notifications to this Slack incoming webhook URL")
+	flag.StringVar(&discordWebhook, "discord-webhook", "", "send notifications to this Discord webhook URL")
+	flag.StringVar(&telegramToken, "telegram-token", "", "send notifications with this Telegram bot token, or set ALLTHEFIRMWARES_TELEGRAM_TOKEN")
+	flag.StringVar(&telegramChat, "telegram-chat", "", "the Telegram chat ID to send notifications to (w/ -telegram-token)")
+	flag.StringVar(&emailTo, "email-to", "", "email notifications to these addresses, separated by commas")
+	flag.StringVar(&emailFrom, "email-from", "", "the address to send notification emails from (w/ -email-to)")
+	flag.StringVar(&smtpServer, "smtp-server", "", "the SMTP server to send notification emails through, e.g. smtp.example.com:587 (w/ -email-to)")
+	flag.StringVar(&smtpUser, "smtp-user", "", "the user to authenticate to the SMTP server as (w/ -email-to)")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "the password for -smtp-user, or set ALLTHEFIRMWARES_SMTP_PASSWORD")
+	flag.DurationVar(&emailDigestInterval, "email-digest", 0, "instead of an email for each notification, send a digest of them this often, e.g. 24h (w/ -email-to)")
+	flag.StringVar(&notifyExec, "notify-exec", "", "send notifications to this plugin, run with \"notify\" and the event as JSON on its stdin")
+	flag.BoolVar(&desktopNotify, "desktop-notify", false, "show a desktop notification when the downloads finish or one fails, when running interactively")
+	flag.StringVar(&mqttBroker, "mqtt-broker", "", "publish events as JSON to this MQTT broker, e.g. tcp://broker:1883 or ssl://broker:8883")
+	flag.StringVar(&mqttTopic, "mqtt-topic", "allthefirmwares", "the prefix of the MQTT topics events are published to, followed by /<event type>")
+	flag.StringVar(&mqttUser, "mqtt-user", "", "the user to authenticate to the MQTT broker as")
+	flag.StringVar(&mqttPassword, "mqtt-password", "", "the password for -mqtt-user, or set ALLTHEFIRMWARES_MQTT_PASSWORD")
+	flag.StringVar(&notifyEventTypes, "notify-events", "download_completed,verification_failed,signing_closed,disk_space_low", "the events to send notifications for, separated by commas: download_completed, download_failed, verification_failed, signing_opened, signing_closed, queue_completed, disk_space_low, disk_space_recovered or firmware_pruned")
+	flag.StringVar(&notifyTemplate, "notify-template", "", "a Go template of the notification message, e.g. \"{{.Type}}: {{.Data.Device}} {{.Data.Version}}\" (default: a message for each event)")
+	flag.StringVar(&logLevelName, "log-level", "info", "the minimum level of messages to log (debug, info, warn, error)")
+	flag.StringVar(&logFile, "log-file", "", "write logs to this file instead of stderr")
+	flag.StringVar(&logFileMaxSize, "log-max-size", "10MB", "rotate the log file once it reaches this size (w/ -log-file)")
+	flag.IntVar(&logFileMaxBackups, "log-max-backups", 5, "the number of rotated log files to keep (w/ -log-file)")
+	flag.BoolVar(&logToSyslog, "syslog", false, "send logs to the local syslog daemon")
+	flag.BoolVar(&logJournald, "journald", false, "write logs with journald priority prefixes and no timestamps")
+	flag.BoolVar(&noColor, "no-color", false, "disable colored output, even when logging to a terminal")
+	flag.BoolVar(&lockInstance, "lock", false, "take a lock on the download root, so that only one instance can run against it at a time")
+	flag.BoolVar(&waitForLock, "wait", false, "wait for the running instance to finish if the download root is locked (w/ -lock)")
+	flag.BoolVar(&lockFiles, "lock-files", false, "lock each file while downloading it, skipping files which another instance is downloading")
+	flag.StringVar(&maxBytesValue, "max-bytes", "", "stop starting new downloads once this much has been downloaded in this run, e.g. 500GB")
+	flag.StringVar(&confirmOverValue, "confirm-over", "100GB", "when running interactively, ask before downloading at least this much (0 to never ask)")
+	flag.BoolVar(&keyboardControls, "keys", true, "while downloading in a terminal, press p to pause or resume, s to skip the current download(s) until the end of the queue, or q to stop after them, saving the rest of the queue for the next run")
+	flag.BoolVar(&assumeYes, "yes", false, "don't ask before downloading, whatever -confirm-over is")
+	flag.StringVar(&maxFileSizeValue, "max-file-size", "", "skip firmwares larger than this, e.g. 7GB")
+	flag.StringVar(&maxArchiveSizeValue, "max-archive-size", "", "keep the downloaded firmwares within this size, e.g. 4TB, by not downloading, and pruning when downloads finish, the oldest unsigned firmwares; signed firmwares are never pruned")
+	flag.StringVar(&minFreeValue, "min-free", "", "pause downloads while less than this is free on the download root, e.g. 20GB, resuming them once space has been freed")
+	flag.IntVar(&downloadWorkers, "j", 1, "the number of firmwares to download at once")
+	flag.BoolVar(&adaptiveConcurrency, "adaptive", false, "tune the number of firmwares downloaded at once, up to -j, to how fast downloads are and whether they fail")
+	flag.IntVar(&deviceConcurrency, "device-concurrency", 0, "the most firmwares of one device to download at once (w/ -j), so that other devices' firmwares aren't held up behind a device with many (0 for no limit)")
+	flag.StringVar(&hostConcurrencyValue, "host-concurrency", "", "the most firmwares to download at once from each host (w/ -j), e.g. 2, or per host, e.g. appldnld.apple.com=1,updates.cdn-apple.com=2,4 (the last being for any other host)")
+	flag.IntVar(&maxFiles, "max-files", 0, "download at most this many firmwares in this run")
+	flag.StringVar(&downloadOrder, "order", "device", "the order to download firmwares in: device (grouped by device, newest first), newest, oldest, smallest or largest")
+	flag.BoolVar(&signedFirst, "signed-first", true, "download currently signed firmwares before unsigned ones")
+	flag.StringVar(&shshDevices, "shsh", "", "save SHSH blobs beside the signed firmwares downloaded for these devices with tsschecker, e.g. iPhone10,3=0x1a2b3c4d5e,iPad7,5=5482657301265:j71bap (IDENTIFIER=ECID[:BOARDCONFIG])")
+	flag.StringVar(&shshGenerator, "shsh-generator", "", "the generator to save SHSH blobs with, e.g. 0x1111111111111111 (w/ -shsh)")
+	flag.BoolVar(&refreshChanged, "refresh-changed", false, "download firmwares again if their SHA1, MD5 or size upstream has changed since they were downloaded, bypassing any caches of the firmware information")
+	flag.StringVar(&shardValue, "shard", "", "only download this host's shard of the firmwares, e.g. 2/4 for the second of four, so that several hosts can share a backfill to the same storage")
+	flag.StringVar(&progressMode, "progress", "auto", "how to show the progress of each file: bar, log (a line every -progress-interval, for containers and CI), or auto for bar when stdout is a terminal and log otherwise")
+	flag.StringVar(&progressInterval, "progress-interval", "30s", "how often to log progress (w/ -progress log), as a duration, e.g. 30s, or a percentage of the file, e.g. 10%")
+	flag.BoolVar(&pipelineDownloads, "pipeline", false, "start downloading each device's firmwares as soon as they're retrieved, rather than retrieving every device's first; -order applies within each device, and -confirm-over and -reuse don't apply")
+	flag.StringVar(&limitRate, "limit-rate", "", "limit the combined speed of all downloads to this many bytes per second, e.g. 5MB")
+	flag.StringVar(&ioLimit, "io-limit", "", "limit the combined speed of writing downloads to disk and reading firmwares to verify or copy them to this many bytes per second, e.g. 50MB, so that other services sharing the disks stay responsive")
+	flag.StringVar(&ioPriority, "io-priority", "normal", "the I/O priority to run with: normal, low (the lowest best-effort ionice level) or idle (only using the disks when nothing else is), on Linux; low and idle use background mode on Windows")
+	flag.StringVar(&rateSchedule, "rate-schedule", "", "limit the speed of downloads differently during daily windows of local time, e.g. 01:00-07:00=unlimited,09:00-18:00=2MB, using -limit-rate outside of them")
+	flag.StringVar(&downloadWindow, "download-window", "", "only download during this daily window of local time, e.g. 01:00-07:00, pausing outside of it")
+	flag.DurationVar(&daemonInterval, "interval", time.Hour, "how often to check for new firmwares (daemon)")
+	flag.StringVar(&daemonSchedule, "schedule", "", "a cron expression for when to check for new firmwares, e.g. \"0 2 * * *\", instead of -interval (daemon)")
+	flag.StringVar(&configPath, "config", "", "also read flags from this file, one per line as name=value, which the command line overrides; the daemon reloads it between runs when it changes or on SIGHUP")
+	flag.StringVar(&listenAddress, "listen", "", "serve the control API on this address, e.g. localhost:8080 (daemon), or the archive (serve, default :8080)")
+	flag.StringVar(&apiToken, "api-token", "", "require this bearer token for the control API and dashboard, or set ALLTHEFIRMWARES_API_TOKEN (daemon)")
+	flag.StringVar(&apiUser, "api-user", "", "require basic auth with this user for the control API and dashboard (daemon)")
+	flag.StringVar(&apiPassword, "api-password", "", "the password for -api-user, or set ALLTHEFIRMWARES_API_PASSWORD (daemon)")
+	flag.StringVar(&tlsCertificate, "tls-cert", "", "serve the control API over TLS with this certificate file (daemon)")
+	flag.StringVar(&tlsKey, "tls-key", "", "the private key file for -tls-cert (daemon)")
+	flag.DurationVar(&healthMaxPollAge, "health-max-poll-age", 24*time.Hour, "report unhealthy on /healthz if firmware information hasn't been retrieved for this long, 0 to disable (daemon)")
+	flag.StringVar(&destinationURL, "dest", "", "upload downloaded firmwares to this destination, e.g. s3://bucket/prefix, azure://container/prefix, webdavs://host/path or exec:/path/to/plugin")
+	flag.BoolVar(&streamOnly, "stream", false, "stream downloads straight to the destination without storing them locally first (w/ -dest)")
+	flag.BoolVar(&keepLocal, "keep-local", false, "keep the local copy of firmwares once uploaded (w/ -dest)")
+	flag.BoolVar(&contentAddressed, "content-addressed", false, "store each firmware once under its SHA1 in objects/ beneath the download root, linking to it from the -d directory tree")
+	flag.StringVar(&reuseExisting, "reuse", "", "before downloading a firmware, look for a copy of it elsewhere in the download root (e.g. from an old -d template) and move or (hard) link it into place instead")
+	flag.StringVar(&linkType, "link", "symlink", "how to link to firmwares: symlink or hardlink (w/ -content-addressed)")
+	flag.StringVar(&dirModeValue, "dir-mode", "0700", "the permissions of created directories, in octal")
+	flag.StringVar(&fileModeValue, "file-mode", "0600", "the permissions of downloaded files, in octal, e.g. 0640 to let a web server's group read them")
+	flag.StringVar(&ownerValue, "owner", "", "change the owner of created files and directories to this user[:group] (as root)")
+	flag.StringVar(&oldDirectoryTemplate, "old-d", "", "the download directory template the firmwares were downloaded with, to move them from (relayout)")
+	flag.StringVar(&oldFilenameTemplate, "old-filename", "", "the filename template the firmwares were downloaded with, if any (relayout)")
+	flag.BoolVar(&dryRun, "dry-run", false, "only log what would be moved (relayout), collected (gc), pruned (prune), cleaned (clean), emptied from the trash (empty-trash), copied (sync) or installed (install-service)")
+	flag.BoolVar(&allowDowngrade, "allow-downgrade", false, "replace allthefirmwares with the latest release even if it isn't newer, e.g. for development builds (self-update)")
+	flag.StringVar(&gcAction, "gc", "list", "what gc does with files which aren't any firmware tracked in the catalog or upstream: list, remove or move (to -gc-dir)")
+	flag.DurationVar(&cleanAge, "clean-age", 24*time.Hour, "how long partial downloads and temporary files must have been left untouched for before clean removes them")
+	flag.StringVar(&trashDirectory, "trash", "", "move the files removed by prune (and -max-archive-size) and gc -gc remove to a dated directory here, which must be on the same filesystem, instead of deleting them, until empty-trash")
+	flag.DurationVar(&trashAge, "trash-age", 0, "only remove the files moved to -trash longer ago than this, e.g. 720h (empty-trash)")
+	flag.StringVar(&gcDirectory, "gc-dir", "", "the directory gc -gc move moves untracked files to (default: a dated directory in the state directory)")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "after each run, create a dated snapshot of the archive made of hardlinks in this directory, which must be on the same filesystem")
+	flag.IntVar(&snapshotKeep, "snapshot-keep", 7, "the number of snapshots to keep, 0 to keep all (w/ -snapshot-dir)")
+	flag.StringVar(&exportFormat, "format", "aria2", "the format to export in: aria2 or urls for the firmwares still to download, json or csv for the metadata of all firmwares matching the flags, sha1sum for a checksum manifest of those downloaded, or ical for a calendar of their release dates (export)")
+	flag.StringVar(&exportOutput, "o", "", "write the export (export), diff (diff), link check (link-check), coverage report (report), signing status (signing) or stats (stats) to this file instead of stdout, the HTML index (index) to this file instead of index.html in the download root, one torrent of all firmwares to this file (torrent), or the joined file to this path instead of beside its parts (join)")
+	flag.BoolVar(&jsonOutput, "json", false, "write JSON instead of text (diff, link-check, stats) or CSV (signing)")
+	flag.StringVar(&statsPeriod, "period", "month", "show the bytes transferred per day, week or month (stats)")
+	flag.StringVar(&torrentTrackers, "trackers", "", "announce torrents to these trackers, separated by commas (torrent)")
+	flag.IntVar(&apiConcurrency, "api-concurrency", 8, "the number of devices to retrieve firmware information for at once")
+	flag.Float64Var(&apiRate, "api-rate", 0, "the most requests to make to each firmware information API a second, e.g. 2, however many devices are retrieved at once (0 for no limit)")
+	flag.IntVar(&apiRetries, "api-retries", 5, "how many times to retry API requests which are rate limited or fail with a server error")
+	flag.StringVar(&poolValue, "pool", "", "also download firmwares to these roots, separated by commas, e.g. on other disks, with the same layout beneath each as the -d root")
+	flag.StringVar(&poolRouteValue, "pool-route", "", "download the firmwares of devices whose identifier matches a pattern to a root of the pool, e.g. iPad*=/mnt/disk2,AppleTV*=/mnt/disk3")
+	flag.StringVar(&poolBalance, "pool-balance", "fill", "which root of the pool other firmwares are downloaded to: fill (the first with enough space) or free (the one with the most)")
+	flag.DurationVar(&benchmarkDuration, "benchmark-time", time.Minute, "how long to spend measuring each of disk and download speed (benchmark)")
+	flag.Float64Var(&linkCheckRate, "link-check-rate", 2, "the number of links to check a second (link-check)")
+	flag.StringVar(&signMethod, "sign", "", "sign the files written by export -o and -report with gpg or minisign, writing a detached signature beside each")
+	flag.StringVar(&signKey, "sign-key", "", "the key to sign with (w/ -sign): a gpg key ID, or the path of a minisign secret key (default: the program's default key)")
+	flag.StringVar(&extractMembers, "members", "BuildManifest.plist", "the files to extract from each firmware (extract), separated by commas, e.g. kernelcache.*,BuildManifest.plist; patterns match the whole path inside the IPSW or its file name")
+	flag.StringVar(&extractDirectory, "extract-to", "extracted", "the directory to extract files into (extract), in a directory named after each firmware")
+	flag.StringVar(&splitSizeValue, "split-size", "", "split files larger than this, e.g. 2GiB, or fat32 for those FAT32 can't hold, into verified parts when syncing them to a directory, which join reassembles (sync)")
+	flag.StringVar(&syncTarget, "to", "", "the directory, or -dest style URL, e.g. s3://bucket/backup, to copy the archive to (sync)")
+	flag.StringVar(&stateDir, "state-dir", "", "where to keep state such as the failed download queue (default: .allthefirmwares in the download root)")
+	flag.Usage = usage
 	flag.Parse()
 }
 
+// command is an action which allthefirmwares can perform, selected by the first non-flag argument
+type command struct {
+	name, description string
+	run               func() error
+}
+
+var commands = []command{
+	{"download", "download (or check, w/ -c) all firmwares matching the flags (default)", downloadCommand},
+	{"verify", "check the integrity of the downloaded firmwares matching the flags, e.g. -i iPhone14,2 -version 16.x (the same as download -c)", verifyCommand},
+	{"verify-mirror", "stream the firmwares matching the flags from the HTTP mirror given as an argument, laid out as the download root, and check their checksums against the API without storing them", verifyMirrorCommand},
+	{"retry", "re-attempt only the downloads which failed in previous runs", retryCommand},
+	{"daemon", "keep running, downloading new firmwares every -interval or according to -schedule", daemonCommand},
+	{"benchmark", "measure how fast the download root can be written to and a firmware (the URL given as an argument, or the newest of the first device selected) can be downloaded, and suggest -buffer-size and -j", benchmarkCommand},
+	{"check", "check that every currently signed firmware matching the flags has been downloaded, failing if not", checkCommand},
+	{"clean", "remove the partial downloads and temporary files in the download root older than -clean-age, except partial downloads which can be resumed by a queued download", cleanCommand},
+	{"diff-ipsw", "compare the contents of the two IPSWs given as arguments: the files added, removed or changed, and the components of their BuildManifests whose digests changed, as text or (w/ -json) JSON", diffIPSWCommand},
+	{"diff", "compare the archive and the firmware catalog with upstream: missing, removed, extra and changed firmwares", diffCommand},
+	{"empty-trash", "remove the files moved to -trash by prune and gc (w/ -trash-age, longer ago than that)", emptyTrashCommand},
+	{"estimate", "show how much the firmwares matching the flags which haven't been downloaded are, per device and per major version, as text or (w/ -json) JSON", estimateCommand},
+	{"export", "write the firmwares which would be downloaded in -format, e.g. for aria2c -i", exportCommand},
+	{"gc", "list (or w/ -gc, remove or move aside) the files in the download root which aren't any firmware in the catalog or upstream under the current templates", gcCommand},
+	{"extract", "extract the -members of each firmware matching the flags to -extract-to, from the downloaded copy or else reading just those files from Apple with range requests, without downloading the whole IPSW", extractCommand},
+	{"fetch", "download the firmware URLs given as arguments (or read from stdin, given -), each optionally followed by its SHA1 or MD5, e.g. for firmwares ipsw.me doesn't list", fetchCommand},
+	{"get", "download (or check, w/ -c) just the newest firmware of the device given with -i matching -version, e.g. get -i iPhone14,2 -version 16.5, or the build given as an argument", getCommand},
+	{"index", "write a static HTML index of the downloaded firmwares of each device, with relative links, to index.html in the download root (or -o)", indexCommand},
+	{"inspect", "list the files inside the IPSW given as an argument (or the downloaded firmware of the device given with -i matching -version, or the build given as an argument) and what its BuildManifest says it is, as text or (w/ -json) JSON", inspectCommand},
+	{"install-service", "install the daemon, with the flags given, as a service which starts automatically: a systemd unit (Linux), launchd job (macOS) or Windows service", installServiceCommand},
+	{"import", "download the URLs listed in a file, each optionally followed by its SHA1", importCommand},
+	{"join", "reassemble and verify the file split by sync -split-size whose .split file is given as an argument, beside it (or to -o)", joinCommand},
+	{"link-check", "request the URL of every firmware in the catalog (at most -link-check-rate a second) and report which are dead or have changed size, and whether they have been downloaded, as text or (w/ -json) JSON", linkCheckCommand},
+	{"pin", "write the firmwares matching the flags to the -pin file, so that other mirrors download exactly the same firmwares", pinCommand},
+	{"pin-check", "check that the -pin file still matches the firmwares upstream, failing if it has drifted", pinCheckCommand},
+	{"prune", "remove the oldest unsigned firmwares until the archive is within -max-archive-size, as downloads do when they finish", pruneCommand},
+	{"relayout", "move the downloaded firmwares from the layout of -old-d (and -old-filename) to that of -d (and -filename)", relayoutCommand},
+	{"report", "write a coverage report of the firmwares matching the flags (whether each was downloaded or is missing, and if it's still signed, its size and when it was last verified) as CSV, or as an HTML matrix of devices and versions if -o ends in .html", reportCommand},
+	{"search", "print the firmwares fuzzily matching a query of device names, identifiers, versions and builds, e.g. search \"iphone 12 14.2\", and whether they have been downloaded", searchCommand},
+	{"self-update", "replace allthefirmwares with the latest release, after checking its checksum (and signature)", selfUpdateCommand},
+	{"serve", "serve the download tree over HTTP on -listen, with an index of firmwares by device", serveCommand},
+	{"stats", "show how much has been downloaded over all runs, per day, week or month (see -period) and per device, as text or (w/ -json) JSON", statsCommand},
+	{"signing", "write the signing status of every firmware, and when it last changed, as CSV or (w/ -json) JSON", signingCommand},
+	{"sync", "copy the files in the archive which are new or have changed to -to, a directory or a -dest style URL, checking each copy", syncCommand},
+	{"template-fields", "list the fields available in the -d and -filename templates, with example values", templateFieldsCommand},
+	{"uninstall-service", "stop and remove the service installed by install-service", uninstallServiceCommand},
+	{"torrent", "write a .torrent, web seeded from Apple's CDN, beside each downloaded firmware matching the flags", torrentCommand},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage of %s: [flags] [command] [flags]\n\nCommands:\n", os.Args[0])
+
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", c.name, c.description)
+	}
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// setupFlags parses and checks the flags, which is done again when the daemon reloads -config.
+func setupFlags() error {
+	steps := []func() error{
+		parseLimits,
+		checkOrder,
+		checkHTTPAuth,
+		parseBufferSize,
+		parseProgress,
+		parseShard,
+		parseDeviceFilters,
+		parseDeviceVersions,
+		parseSample,
+		loadIgnoreList,
+		parseSHSHTargets,
+		setupHTTPClients,
+		setupAPIClient,
+		parseSizeTolerance,
+		parsePermissions,
+		applyLayoutPreset,
+		checkTemplates,
+		checkLayout,
+		checkCollisions,
+		parsePool,
+		openDestination,
+		checkSigning,
+		loadChunklistKey,
+		setupIOThrottle,
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func main() {
-	// catch interrupt
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	commandName := "download"
+
+	if flag.NArg() > 0 {
+		commandName = flag.Arg(0)
+
+		// flags may also be given after the command
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if err := loadConfig(); err != nil {
+		fatalf("%s", err)
+	}
+
+	if err := setupLogging(); err != nil {
+		fatalf("Unable to set up logging, err: %s", err)
+	}
 
-	go func() {
-		for range c {
-			// sig is a ^C, handle it
-			fmt.Println()
-			log.Printf("Downloaded %v\n", humanize.Bytes(uint64(downloadedSize)))
+	if err := setupFlags(); err != nil {
+		fatalf("%s", err)
+	}
 
-			os.Exit(0)
+	if err := startNotifiers(); err != nil {
+		fatalf("%s", err)
+	}
+
+	if err := startMQTT(); err != nil {
+		fatalf("%s", err)
+	}
+
+	if lockInstance {
+		lock, err := acquireInstanceLock(waitForLock)
+
+		if err != nil {
+			fatalf("Unable to lock %s, err: %s", instanceLockPath(), err)
+		}
+
+		defer lock.release()
+	}
+
+	handleSignals()
+
+	if err := startDownloadWindow(); err != nil {
+		fatalf("%s", err)
+	}
+
+	if err := startRateLimit(); err != nil {
+		fatalf("%s", err)
+	}
+
+	for _, c := range commands {
+		if c.name != commandName {
+			continue
 		}
-	}()
 
-	log.Printf("Gathering IPSW information...")
+		startRunReport(c.name)
+		startAuditLog(c.name)
+		startRunTrace(c.name)
+		startStatusFile(c.name)
+		started := time.Now()
+
+		err := c.run()
+
+		writeRunReport(err)
+		endRunTrace(err)
+		endStatusFile(c.name, err)
+		pushMetrics(c.name, started, err)
+		flushNotifications()
+
+		if err != nil {
+			fatalf("Unable to %s, err: %s", c.name, err)
+		}
+
+		return
+	}
+
+	fatalf("Unknown command: %s", commandName)
+}
+
+// downloadJob is a firmware to be downloaded or checked, and the path it is stored at
+type downloadJob struct {
+	Device   api.BaseDevice `json:"device"`
+	Firmware api.Firmware   `json:"firmware"`
+	Path     string         `json:"path"`
+
+	// Replace is set if the firmware has already been downloaded, but has changed upstream since (w/ -refresh-changed)
+	Replace bool `json:"replace,omitempty"`
+}
+
+// downloadCommand resumes the queue saved by a previous run, or plans and processes a new one.
+func downloadCommand() error {
+	if verifyIntegrity && destination != nil {
+		return errors.New("-c can only check local files, not those in -dest")
+	}
+
+	if !verifyIntegrity {
+		jobs, err := loadResumeState()
+
+		if err != nil {
+			warnf("Unable to read saved download queue: %s, err: %s", resumeStatePath(), err)
+		} else if jobs != nil {
+			infof("Resuming %d queued download(s) from the previous run", len(jobs))
+			processJobs(jobs)
+
+			return snapshotArchive()
+		}
+	}
+
+	infof("Gathering IPSW information...")
+	currentStatus.setPhase("planning")
+
+	if pipelineDownloads && !verifyIntegrity {
+		return downloadPipelined()
+	}
+
+	jobs, err := planDownloads(verifyIntegrity)
+
+	if err != nil {
+		return err
+	}
+
+	if verifyIntegrity {
+		jobs = sampleJobs(jobs)
+
+		defer func() {
+			if err := writeVerifyReport(); err != nil {
+				errorf("Unable to write verification report: %s, err: %s", verifyReportPath, err)
+			}
+		}()
+	}
+
+	sortJobs(jobs)
+
+	if !verifyIntegrity {
+		if !confirmDownload(jobs) {
+			infof("Not downloading anything")
+			return nil
+		}
+
+		infof("Downloading: %v IPSW files for %v device(s) (%v)", totalFirmwareCount, totalDeviceCount, humanize.Bytes(totalFirmwareSize))
+	}
+
+	processJobs(jobs)
+
+	if !verifyIntegrity {
+		if err := pruneArchive(); err != nil {
+			errorf("%s", err)
+		}
+	}
+
+	return snapshotArchive()
+}
+
+// verifyCommand checks the integrity of the downloaded firmwares matching the flags.
+func verifyCommand() error {
+	verifyIntegrity = true
+
+	return downloadCommand()
+}
+
+// planDownloads finds all firmwares which match the flags and still need downloading,
+// or, if downloaded is set, those which have already been downloaded (e.g. to check them, w/ -c).
+func planDownloads(downloaded bool) ([]downloadJob, error) {
+	span := startSpan("enumerate", nil)
 
 	devices, err := ipswClient.Devices(false)
 
 	if err != nil {
-		log.Fatalf("Unable to retrieve firmware information, err: %s", err)
+		span.finish(err)
+		return nil, fmt.Errorf("unable to retrieve firmware information, err: %s", err)
 	}
 
-	firmwaresToDownload := make(map[api.BaseDevice][]api.Firmware)
+	totalFirmwareCount, totalFirmwareSize, totalDeviceCount = 0, 0, 0
 
-	for _, device := range devices {
-		if specifiedDevice != "" && device.Identifier != specifiedDevice {
+	selected, information := fetchSelectedDevices(devices)
+
+	span.finish(nil)
+
+	planner, err := newDownloadPlanner(downloaded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []downloadJob
+
+	for i := range selected {
+		jobs = append(jobs, planner.plan(&selected[i], information[i])...)
+	}
+
+	if !downloaded {
+		jobs = reuseExistingCopies(jobs, planner.current)
+	}
+
+	planner.finish()
+
+	if err := planner.collisionError(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// downloadPlanner plans the jobs for the firmwares of each device, recording what it finds in the catalog and the
+// coverage when finished.
+type downloadPlanner struct {
+	downloaded bool
+	pins       pinSet
+
+	// recorded are the firmwares in the catalog, by pinKey (w/ -refresh-checksums or -refresh-changed), and records
+	// what they were when downloaded, by path (w/ -refresh-changed)
+	recorded map[string]*api.Firmware
+	records  map[string]downloadRecord
+
+	// current are the paths of all firmwares matching the flags
+	current map[string]bool
+
+	// claimed are the firmwares saved to each path, and collisions how many were skipped as a different firmware
+	// was already saved to theirs
+	claimed    map[string]*api.Firmware
+	collisions int
+
+	// retention is which firmwares are kept within -max-archive-size, as of the previous run
+	retention *retentionPlan
+
+	coverage []deviceCoverage
+	fetched  []api.Device
+}
+
+func newDownloadPlanner(downloaded bool) (*downloadPlanner, error) {
+	pins, err := loadPins()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the ignore file may have been edited since the last run
+	if err := loadIgnoreList(); err != nil {
+		return nil, err
+	}
+
+	p := &downloadPlanner{downloaded: downloaded, pins: pins, current: make(map[string]bool), claimed: make(map[string]*api.Firmware)}
+
+	if !downloaded {
+		if p.retention, err = planRetention(); err != nil {
+			return nil, err
+		}
+	}
+
+	if (downloaded && refreshChecksums) || (!downloaded && refreshChanged) {
+		// the catalog is about to be replaced with what was just retrieved
+		if catalog, err := loadCatalog(); err != nil {
+			warnf("Unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+		} else {
+			p.recorded = catalog.firmwares()
+		}
+	}
+
+	// the records are also checked for files which were downloaded as other firmwares
+	if !downloaded {
+		if p.records, err = loadDownloadRecords(); err != nil {
+			return nil, fmt.Errorf("unable to read download records: %s, err: %s", downloadRecordsPath(), err)
+		}
+	}
+
+	return p, nil
+}
+
+// plan returns the jobs for the firmwares of device matching the flags, which need downloading (or have been
+// downloaded, if p.downloaded is set). deviceInformation is nil if the device's firmwares couldn't be retrieved.
+func (p *downloadPlanner) plan(device *api.BaseDevice, deviceInformation *api.Device) []downloadJob {
+	if deviceInformation == nil {
+		return nil
+	}
+
+	var jobs []downloadJob
+
+	totalDeviceCount++
+
+	p.fetched = append(p.fetched, *deviceInformation)
+
+	p.coverage = append(p.coverage, deviceCoverage{Identifier: device.Identifier, Name: device.Name})
+	deviceCoverage := &p.coverage[len(p.coverage)-1]
+
+	for index, ipsw := range deviceInformation.Firmwares {
+		if !firmwareSelected(index, &ipsw) || !p.pins.allows(&ipsw) {
 			continue
 		}
 
-		deviceInformation, err := ipswClient.DeviceInformation(device.Identifier)
+		downloadPath, err := firmwarePath(&ipsw, device)
 
 		if err != nil {
-			log.Printf("Could not get firmwares for device: %s, err: %s", device.Identifier, err)
+			errorf("Unable to parse download directory, err: %s", err)
+			continue
+		}
+
+		if !p.claim(downloadPath, &ipsw) {
+			continue
 		}
 
-		totalDeviceCount++
+		p.current[filepath.Clean(downloadPath)] = true
+
+		present, err := firmwareStored(downloadPath)
+
+		if err != nil {
+			errorf("Error reading download path: %s, err: %s", downloadPath, err)
+			continue
+		}
 
-		sort.Slice(deviceInformation.Firmwares, func(i int, j int) bool {
-			return deviceInformation.Firmwares[i].UploadDate.Time.After(deviceInformation.Firmwares[j].UploadDate.Time)
+		deviceCoverage.Firmwares = append(deviceCoverage.Firmwares, firmwareCoverage{
+			Version: ipsw.Version,
+			BuildID: ipsw.BuildID,
+			Size:    ipsw.Filesize,
+			Signed:  ipsw.Signed,
+			Present: present,
 		})
 
-		for index, ipsw := range deviceInformation.Firmwares {
-			if (downloadSigned && !ipsw.Signed) || (index > 0 && downloadLatest) {
-				continue
-			}
+		// other hosts download (or check) the firmwares of other shards
+		if !inShard(ipsw.URL) {
+			continue
+		}
 
-			if filter != "" && filterValue != "" && !passesFilter(ipsw, filter, filterValue) {
-				continue
+		if p.downloaded && !present {
+			skipf("Skipping %s, not downloaded", downloadPath)
+
+			if verifyIntegrity {
+				recordVerifyResult(newVerifyResult(device, &ipsw, downloadPath, "missing"))
 			}
 
-			directory, err := parseDownloadDirectory(&ipsw, &device)
+			continue
+		}
+
+		replace := false
 
-			if err != nil {
-				log.Printf("Unable to parse download directory, err: %s", err)
+		if !p.downloaded && present {
+			if !refreshChanged || !changedUpstream(p.records, p.recorded, &ipsw, downloadPath) {
+				p.warnIfRecordedAsOther(downloadPath, &ipsw)
+				skipf("Skipping %s, already exists", downloadPath)
 				continue
 			}
 
-			downloadPath := filepath.Join(directory, filepath.Base(ipsw.URL))
+			warnf("%s has changed upstream since it was downloaded, downloading it again", downloadPath)
+			replace = true
+		}
 
-			if _, err := os.Stat(downloadPath); os.IsNotExist(err) {
-				totalFirmwareCount++
-				totalFirmwareSize += ipsw.Filesize
+		if p.downloaded && p.recorded != nil {
+			checkRecordedChecksum(p.recorded, &ipsw, downloadPath)
+		}
 
-				if firmwaresToDownload[device] == nil {
-					firmwaresToDownload[device] = make([]api.Firmware, 0)
-				}
+		if !p.downloaded && !replace && p.retention != nil && !p.retention.allows(&ipsw, downloadPath) {
+			skipf("Skipping %s, it would be pruned to keep the archive within -max-archive-size", downloadPath)
+			continue
+		}
 
-				firmwaresToDownload[device] = append(firmwaresToDownload[device], ipsw)
-			}
+		totalFirmwareCount++
+		totalFirmwareSize += ipsw.Filesize
+
+		jobs = append(jobs, downloadJob{Device: *device, Firmware: ipsw, Path: downloadPath, Replace: replace})
+	}
+
+	return jobs
+}
+
+// finish records the coverage and the catalog of the devices planned.
+func (p *downloadPlanner) finish() {
+	currentStatus.setCoverage(p.coverage)
+	currentStatus.setLastSuccessfulPoll(time.Now())
+
+	if err := updateCatalog(p.fetched); err != nil {
+		warnf("Unable to write firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+}
+
+func processJobs(jobs []downloadJob) {
+	currentStatus.setQueue(jobs)
+	currentReport.plan(jobs)
+
+	runJobs(newJobScheduler(jobs), newVerifyProgress(jobs))
+}
+
+// runJobs downloads (or checks, w/ -c) the jobs of the scheduler, until it has none left, saving those remaining if
+// interrupted.
+func runJobs(scheduler *jobScheduler, progress *verifyProgress) {
+	summary := queueSummary{}
+	var summaryMu sync.Mutex
+	defer runTotals.add(&summary)
+
+	if verifyIntegrity {
+		currentStatus.setPhase("verifying")
+	} else {
+		currentStatus.setPhase("downloading")
+	}
+
+	defer currentStatus.setQueue(nil)
+
+	if !verifyIntegrity {
+		// downloads can be stopped cleanly and resumed by the next run
+		atomic.StoreInt32(&gracefulShutdown, 1)
+		defer atomic.StoreInt32(&gracefulShutdown, 0)
+	}
+
+	workers := downloadWorkers
+
+	if verifyIntegrity {
+		workers = 1
+	} else if adaptiveConcurrency && workers > 1 {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		startConcurrencyTuner(scheduler, workers, stop)
+	}
+
+	if !verifyIntegrity && minFreeSpace > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		startSpaceMonitor(stop)
+	}
+
+	if !verifyIntegrity {
+		defer startKeyboardControls(scheduler)()
+	}
+
+	interrupted := scheduler.run(workers, func(job *downloadJob) bool {
+		span := startSpan(filepath.Base(job.Path), map[string]string{
+			"identifier": job.Device.Identifier,
+			"version":    job.Firmware.Version,
+			"buildid":    job.Firmware.BuildID,
+			"path":       job.Path,
+		})
+
+		if verifyIntegrity {
+			err := verifyJob(job, progress)
+			span.finish(err)
+
+			return err == errShutdown
+		}
+
+		started := time.Now()
+		err := attemptDownload(job)
+
+		span.finish(err)
+
+		summaryMu.Lock()
+		defer summaryMu.Unlock()
+
+		switch err {
+		case errShutdown:
+			currentReport.record(job, "interrupted", time.Since(started), nil)
+			return true
+		case nil:
+			currentReport.record(job, "downloaded", time.Since(started), nil)
+			summary.Downloaded++
+		case errLocked:
+			currentReport.record(job, "skipped", time.Since(started), err)
+			atomic.AddInt64(&downloadsStarted, -1)
+		case errSkipped:
+			// retried at the end of the queue, where it is counted against -max-files again
+			scheduler.add([]downloadJob{*job})
+			atomic.AddInt64(&downloadsStarted, -1)
+		default:
+			currentReport.record(job, "failed", time.Since(started), err)
+			summary.Failed++
+			atomic.AddUint64(&downloadFailures, 1)
 		}
+
+		return false
+	})
+
+	summary.Queued = scheduler.count()
+
+	if interrupted {
+		saveResumeState(scheduler.remaining())
+		return
 	}
 
 	if !verifyIntegrity {
-		log.Printf("Downloading: %v IPSW files for %v device(s) (%v)", totalFirmwareCount, totalDeviceCount, humanize.Bytes(totalFirmwareSize))
+		publishEvent("queue_completed", summary)
+	}
+}
+
+// attemptDownload downloads a job (retrying w/ -r), recording it in the failure queue if it could not be downloaded.
+func attemptDownload(job *downloadJob) error {
+	directory := filepath.Dir(job.Path)
+
+	// ensure download directory exists
+	if err := makeDirectory(directory); err != nil {
+		errorf("Unable to create download directory: %s, err: %s", directory, err)
+		recordFailure(job, err, 1)
+		return err
+	}
+
+	downloadPath := job.Path
+
+	if storedAsObject(&job.Firmware) {
+		downloadPath = objectPath(&job.Firmware)
+
+		if err := makeDirectory(filepath.Dir(downloadPath)); err != nil {
+			errorf("Unable to create object directory: %s, err: %s", filepath.Dir(downloadPath), err)
+			recordFailure(job, err, 1)
+			return err
+		}
+	}
+
+	lock, err := lockDownload(downloadPath)
+
+	if err == errLocked {
+		infof("Skipping %s, another instance is downloading it", downloadPath)
+		return err
+	} else if err != nil {
+		errorf("Unable to lock %s, err: %s", downloadPath, err)
+		return err
 	}
 
-	for device, firmwares := range firmwaresToDownload {
-		if !verifyIntegrity {
-			log.Printf("Downloading %d firmwares for %s", len(firmwares), device.Name)
+	defer unlockDownload(lock, downloadPath)
+
+	if lock != nil && !job.Replace {
+		// another instance may have finished downloading the file before we took the lock
+		if stored, _ := firmwareStored(job.Path); stored {
+			skipf("Skipping %s, already downloaded by another instance", job.Path)
+			return nil
 		}
+	}
 
-		for _, ipsw := range firmwares {
-			if downloadSigned && !ipsw.Signed {
-				continue
-			}
+	attempts := 0
 
-			filename := filepath.Base(ipsw.URL)
+	_, streamed := destination.(streamingStorage)
+	streamed = streamed && streamOnly
 
-			directory, err := parseDownloadDirectory(&ipsw, &device)
+	if streamed {
+		for {
+			err = streamDownload(job, attempts)
+			attempts++
 
-			if err != nil {
-				log.Printf("Unable to parse download directory, err: %s", err)
-				continue
+			if err == nil || err == errShutdown || err == errSkipped || !reDownloadOnVerificationFailed {
+				break
+			}
+		}
+	} else if _, err = os.Stat(downloadPath); err == nil && ((destination != nil && !job.Replace) || downloadPath != job.Path) {
+		// downloaded by a previous run but not uploaded, or already downloaded for another device
+		infof("%s was already downloaded", downloadPath)
+		attempts = 1
+	} else {
+		for {
+			err = downloadWithProgressBar(&job.Firmware, &job.Device, downloadPath, attempts)
+			attempts++
+
+			if err == nil || err == errShutdown || err == errSkipped || !reDownloadOnVerificationFailed || attempts > downloadRetries {
+				break
 			}
+		}
+	}
+
+	if err == nil && downloadPath != job.Path {
+		if err = linkObject(downloadPath, job.Path); err != nil {
+			errorf("Unable to link %s to %s, err: %s", job.Path, downloadPath, err)
+		}
+	}
+
+	if err == nil && len(shshTargets) > 0 && !streamed {
+		saveSHSHBlobs(job)
+	}
+
+	if err == nil && destination != nil && !streamed {
+		if err = uploadToDestination(job); err != nil && err != errShutdown {
+			errorf("Unable to upload %s to %s, err: %s", job.Path, destination, err)
+		}
+	}
+
+	if err == errShutdown || err == errSkipped {
+		return err
+	} else if err != nil {
+		recordFailure(job, err, attempts)
+	} else {
+		clearFailure(job)
+		recordDownload(job)
+		recordPlacement(job.Path)
+	}
+
+	return err
+}
+
+// verifyJob checks a downloaded job (redownloading it w/ -r), returning why it failed verification, if it did.
+func verifyJob(job *downloadJob, progress *verifyProgress) error {
+	filename := filepath.Base(job.Path)
+	started := time.Now()
+
+	defer progress.fileVerified(job.Firmware.Filesize)
+
+	expected, hasChecksum := checksumFor(&job.Firmware)
+	checkChunks := verifyChunklists && isMacFirmware(&job.Device)
+
+	if !hasChecksum && !deepVerify && !checkChunks {
+		warnf("%s can't be verified, it has no SHA1 or MD5", filename)
+		recordVerifyResult(newVerifyResult(&job.Device, &job.Firmware, job.Path, "unverifiable"))
+		currentReport.record(job, "unverifiable", time.Since(started), nil)
+		return nil
+	}
+
+	var checksum string
+	var err error
+	fileOK := true
+
+	if !hasChecksum && deepVerify {
+		warnf("%s has no SHA1 or MD5, only checking the CRCs of its contents", filename)
+	} else if !hasChecksum {
+		warnf("%s has no SHA1 or MD5, only checking its chunklist", filename)
+	} else {
+		if expected.weak {
+			warnf("%s has no SHA1, verifying its MD5 instead, which only detects corruption", filename)
+		}
+
+		size := int64(0)
+
+		if info, err := os.Stat(job.Path); err == nil {
+			size = info.Size()
+		}
+
+		newBar := func() *pb.ProgressBar {
+			bar := newProgressBar(size, filename).Prefix(progress.prefix())
+			bar.Start()
+
+			return bar
+		}
+
+		rechecked := false
 
-			// ensure download directory exists
-			if !verifyIntegrity {
-				err := os.MkdirAll(directory, 0700)
+		if fastRecheck {
+			if sidecar, ok := readFastChecksum(job.Path, expected); ok {
+				bar := newBar()
+				fileOK, err = fastVerify(job.Path, sidecar, bar)
+				bar.Finish()
 
-				if err != nil {
-					log.Printf("Unable to create download directory: %s, err: %s", directory, err)
-					break
+				if err == nil && fileOK {
+					checksum, rechecked = sidecar.Checksum, true
+				} else if err == nil {
+					warnf("The CRC-32C of %s doesn't match the one recorded, verifying its %s", filename, strings.ToUpper(expected.algorithm))
 				}
 			}
+		}
 
-			downloadPath := filepath.Join(directory, filename)
+		if !rechecked {
+			// the CRC-32C is computed alongside the checksum, for -fast-recheck
+			crc := newFastHash()
+			bar := newBar()
 
-			_, err = os.Stat(downloadPath)
+			checksum, fileOK, err = verify(job.Path, expected, io.MultiWriter(bar, crc))
 
-			if os.IsNotExist(err) && !verifyIntegrity {
-				for {
-					err := downloadWithProgressBar(&ipsw, downloadPath)
+			bar.Finish()
 
-					if err == nil || !reDownloadOnVerificationFailed {
-						break
-					}
+			if err == nil && fileOK && (writeFastChecksums || fastRecheck) {
+				if err := writeFastChecksum(job.Path, crc.Sum32(), expected); err != nil {
+					warnf("Unable to write fast checksum: %s, err: %s", job.Path+fastChecksumSuffix, err)
 				}
-			} else if err == nil && verifyIntegrity {
-				fileOK, err := verify(downloadPath, ipsw.SHA1Sum)
+			}
+		}
 
-				if err != nil {
-					log.Printf("Error verifying: %s, err: %s", filename, err)
-				}
+		if err != nil {
+			errorf("Error verifying: %s, err: %s", filename, err)
+		}
+	}
 
-				if fileOK {
-					log.Printf("%s verified successfully", filename)
-					continue
-				}
+	result := newVerifyResult(&job.Device, &job.Firmware, job.Path, "pass")
+	result.Actual = checksum
+	result.Recorded = changedChecksums[job.Path]
 
-				log.Printf("%s did not verify successfully", filename)
+	// failure is why a file whose checksum matched failed verification anyway
+	failure := ""
 
-				if reDownloadOnVerificationFailed {
-					for {
-						err := downloadWithProgressBar(&ipsw, downloadPath)
+	if fileOK && checkManifests {
+		if err = checkBuildManifest(job); err != nil {
+			errorf("%s is not the firmware it's filed as, err: %s", filename, err)
+			fileOK, failure = false, "wrong_device"
+		}
+	}
 
-						if err == nil {
-							break
-						}
-					}
-				}
-			} else if err != nil && !os.IsNotExist(err) {
-				log.Printf("Error reading download path: %s, err: %s", downloadPath, err)
+	if fileOK && checkChunks {
+		var matched bool
+		matched, err = checkChunklist(job, progress.prefix())
+
+		if err == errNoChunklist {
+			warnf("Apple hasn't published a chunklist for %s: %s", filename, chunklistURL(&job.Firmware))
+			err = nil
+
+			if !hasChecksum && !deepVerify {
+				recordVerifyResult(newVerifyResult(&job.Device, &job.Firmware, job.Path, "unverifiable"))
+				currentReport.record(job, "unverifiable", time.Since(started), nil)
+				return nil
 			}
+		} else if err != nil {
+			errorf("Unable to check the chunklist of %s, err: %s", filename, err)
+			fileOK = false
+		} else if !matched {
+			fileOK, failure = false, "chunklist_mismatch"
+		}
+	}
+
+	if fileOK && deepVerify {
+		if err = checkZipCRCs(job.Path, progress.prefix()); err != nil {
+			errorf("%s is corrupt, err: %s", filename, err)
+			fileOK, failure = false, "corrupt"
 		}
 	}
+
+	defer func() {
+		recordVerifyResult(result)
+	}()
+
+	verifyEvent := newDownloadEvent(&job.Device, &job.Firmware, job.Path)
+
+	if fileOK {
+		successf("%s verified successfully", filename)
+		recordVerified(job, true)
+		publishEvent("verified", verifyEvent)
+		currentReport.record(job, "verified", time.Since(started), nil)
+		return nil
+	}
+
+	warnf("%s did not verify successfully", filename)
+	recordVerified(job, false)
+
+	result.Result, result.Action = "fail", "none"
+
+	if err != nil {
+		result.Result, result.Error = "error", err.Error()
+	}
+
+	if failure != "" {
+		result.Result = failure
+	}
+
+	verifyErr := err
+
+	if verifyErr == nil {
+		verifyErr = errors.New("checksum incorrect")
+	}
+
+	currentReport.record(job, "verification_failed", time.Since(started), verifyErr)
+
+	if err != nil {
+		verifyEvent.Error = err.Error()
+	}
+
+	publishEvent("verification_failed", verifyEvent)
+
+	if reDownloadOnVerificationFailed {
+		downloadPath := job.Path
+
+		if storedAsObject(&job.Firmware) {
+			downloadPath = objectPath(&job.Firmware)
+		}
+
+		lock, err := lockDownload(downloadPath)
+
+		if err == errLocked {
+			infof("Not redownloading %s, another instance is downloading it", filename)
+			result.Action = "locked"
+			return verifyErr
+		} else if err != nil {
+			errorf("Unable to lock %s, err: %s", downloadPath, err)
+			return verifyErr
+		}
+
+		defer unlockDownload(lock, downloadPath)
+
+		for attempt := 0; ; attempt++ {
+			err = downloadWithProgressBar(&job.Firmware, &job.Device, downloadPath, attempt)
+
+			if err == nil || err == errShutdown || err == errSkipped || attempt >= downloadRetries {
+				break
+			}
+		}
+
+		if err == errShutdown || err == errSkipped {
+			return err
+		} else if err != nil {
+			errorf("Unable to redownload %s, err: %s", filename, err)
+			result.Action = "redownload failed"
+			return verifyErr
+		}
+
+		result.Action = "redownloaded"
+
+		if downloadPath != job.Path {
+			if err := linkObject(downloadPath, job.Path); err != nil {
+				errorf("Unable to link %s to %s, err: %s", job.Path, downloadPath, err)
+			}
+		}
+	}
+
+	return verifyErr
 }
 
-func downloadWithProgressBar(ipsw *api.Firmware, downloadPath string) error {
+func downloadWithProgressBar(ipsw *api.Firmware, device *api.BaseDevice, downloadPath string, attempt int) error {
 	filename := filepath.Base(ipsw.URL)
+	partialPath := downloadPath + partialSuffix
+
+	offset := int64(0)
 
-	log.Printf("Downloading %s (%s)", filename, humanize.Bytes(ipsw.Filesize))
+	if info, err := os.Stat(partialPath); err == nil {
+		offset = info.Size()
+	}
+
+	if preallocate && ipsw.Filesize > uint64(offset) {
+		if err := preallocateFile(partialPath, int64(ipsw.Filesize)); err != nil {
+			errorf("Unable to download %s (%s), err: %s", filename, humanize.Bytes(ipsw.Filesize), err)
+			return err
+		}
+	}
+
+	if offset > 0 {
+		infof("Resuming %s from %s (%s)", filename, humanize.Bytes(uint64(offset)), humanize.Bytes(ipsw.Filesize))
+	} else {
+		infof("Downloading %s (%s)", filename, humanize.Bytes(ipsw.Filesize))
+	}
+
+	bar := newProgressBar(int64(ipsw.Filesize), filename)
+	bar.Set(int(offset))
+
+	if downloadWorkers > 1 {
+		// the bars of concurrent downloads take turns on the same line
+		bar.Prefix(filename + " ")
+	}
 
-	bar := pb.New(int(ipsw.Filesize)).SetUnits(pb.U_BYTES)
 	bar.Start()
 
-	checksum, err := download(ipsw.URL, downloadPath, bar, func(n, downloaded int, total int64) {
-		downloadedSize += uint64(n)
-	})
+	currentStatus.startDownload(&downloadJob{Device: *device, Firmware: *ipsw, Path: downloadPath}, offset)
+	defer currentStatus.finishDownload(downloadPath)
+
+	downloadEvent := newDownloadEvent(device, ipsw, downloadPath)
+	publishEvent("download_started", downloadEvent)
+
+	startTime := time.Now()
+
+	var checksum string
+	var err error
+
+	transferred := uint64(0)
+
+	for {
+		checksum, err = downloadWithFallback(ipsw.URL, partialPath, int64(ipsw.Filesize), bar, func(n int, downloaded, total int64) {
+			transferred += uint64(n)
+			atomic.AddUint64(&downloadedSize, uint64(n))
+			currentStatus.updateDownload(downloadPath, downloaded)
+			markAlive()
+		})
+
+		if err != errPaused {
+			break
+		}
+
+		// the connection is closed while paused, and the download resumed from the partial file afterwards
+		waitWhilePaused()
+
+		if shutdownRequested() {
+			err = errShutdown
+			break
+		}
+	}
 
 	bar.Finish()
 
-	if err != nil {
-		log.Printf("Error while downloading %s, err: %s", filename, err)
+	expected := ipsw.SHA1Sum
+
+	if md5Sum, ok := checksumFor(ipsw); ok && err == nil && md5Sum.weak {
+		// only the MD5 of e.g. some imported firmwares is known
+		expected = md5Sum.expected
+		checksum, _, err = verify(partialPath, md5Sum, io.Discard)
+	}
+
+	if err == errOversized {
+		errorf("File: %s is larger than expected (%s), aborted", filename, humanize.Bytes(ipsw.Filesize))
+
+		// the partial download isn't the firmware, so don't resume from it
+		if err := os.Remove(partialPath); err != nil {
+			warnf("Unable to remove partial download: %s, err: %s", partialPath, err)
+		}
+	} else if err == nil && expected != "" && !strings.EqualFold(checksum, expected) {
+		errorf("File: %s failed checksum (wanted: %s, got: %s)", filename, expected, checksum)
+
+		// don't resume from a corrupt partial download
+		if err := os.Remove(partialPath); err != nil {
+			warnf("Unable to remove partial download: %s, err: %s", partialPath, err)
+		}
+
+		err = errors.New("checksum incorrect")
+	} else if err == nil {
+		if err = os.Rename(partialPath, downloadPath); err != nil {
+			errorf("Unable to move %s into place, err: %s", filename, err)
+		} else {
+			auditf("create", downloadPath, "", "downloaded from "+ipsw.URL)
+
+			if err := applyPermissions(downloadPath, fileMode); err != nil {
+				warnf("Unable to set the permissions of %s, err: %s", downloadPath, err)
+			}
+		}
+	} else if err == errSkipped {
+		infof("Skipped %s, it will be resumed at the end of the queue", filename)
+	} else if err != errShutdown {
+		errorf("Error while downloading %s, err: %s", filename, err)
+	}
+
+	recordDownloadStats(device, ipsw, transferred, err == nil)
+
+	if err == errShutdown || err == errSkipped {
+		return err
+	} else if err != nil {
+		downloadEvent.Error = err.Error()
+		publishEvent("download_failed", downloadEvent)
+
 		return err
-	} else if checksum != ipsw.SHA1Sum {
-		log.Printf("File: %s failed checksum (wanted: %s, got: %s)", filename, ipsw.SHA1Sum, checksum)
-		return errors.New("checksum incorrect")
+	}
+
+	duration := time.Since(startTime)
+
+	downloadEvent.Duration = duration.Seconds()
+	publishEvent("download_completed", downloadEvent)
+
+	successf("%s (%s) downloaded successfully in %s, %s transferred (%s/s)", filename, humanize.Bytes(ipsw.Filesize), duration.Round(time.Second), humanize.Bytes(transferred), humanize.Bytes(uint64(float64(transferred)/duration.Seconds())))
+
+	if throughputLogFile != "" {
+		if err := appendThroughputRecord(device, ipsw, transferred, duration, attempt); err != nil {
+			warnf("Unable to write to throughput log: %s, err: %s", throughputLogFile, err)
+		}
 	}
 
 	return nil
@@ -228,48 +1356,88 @@ type fwDeviceCombo struct {
 	*api.Firmware
 }
 
-func parseDownloadDirectory(fw *api.Firmware, device *api.BaseDevice) (string, error) {
-	directoryBuffer := new(bytes.Buffer)
-
-	t, err := template.New("firmware").Parse(downloadDirectoryTemplate)
+// firmwarePath returns the path a firmware is downloaded to: the -d directory, and the -filename (or the URL's) filename,
+// prefixed with the device's identifier w/ -prefix-identifier.
+func firmwarePath(fw *api.Firmware, device *api.BaseDevice) (string, error) {
+	path, err := layoutPath(directoryTemplate(), filenameTemplate, fw, device)
 
 	if err != nil {
 		return "", err
 	}
 
-	err = t.Execute(directoryBuffer, &fwDeviceCombo{device.Identifier, device, fw})
+	if prefixIdentifier && filenameTemplate == "" {
+		filename := device.Identifier + "_" + filepath.Base(path)
+
+		if safePaths {
+			filename = sanitizePathComponent(filename)
+		}
+
+		path = filepath.Join(filepath.Dir(path), filename)
+	}
+
+	return poolPath(path, fw, device), nil
+}
+
+// layoutPath returns the path of a firmware with the given directory (-d) and filename (-filename) templates.
+func layoutPath(directoryTemplate, filenameTemplate string, fw *api.Firmware, device *api.BaseDevice) (string, error) {
+	directory, err := executeTemplate(directoryTemplate, fw, device)
 
 	if err != nil {
-		return "", nil
+		return "", err
+	}
+
+	if i := strings.Index(directoryTemplate, "{{"); i >= 0 && safePaths {
+		directory = sanitizeTemplatedPath(directory, directoryTemplate[:i])
+	}
+
+	filename := filepath.Base(fw.URL)
+
+	if filenameTemplate != "" {
+		if filename, err = executeTemplate(filenameTemplate, fw, device); err != nil {
+			return "", err
+		}
+
+		if safePaths {
+			filename = sanitizeTemplatedPath(filename, "")
+		}
+
+		if filename == "" {
+			return "", fmt.Errorf("filename template produced an empty filename for %s (%s)", fw.Identifier, fw.BuildID)
+		}
 	}
 
-	return directoryBuffer.String(), err
+	return filepath.Join(directory, filename), nil
 }
 
-func verify(location string, expectedSHA1sum string) (bool, error) {
+// verify hashes the file at location, writing its contents to progress as it is read,
+// returning its checksum and whether that is the expected checksum.
+func verify(location string, expected firmwareChecksum, progress io.Writer) (string, bool, error) {
 	file, err := os.Open(location)
 
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
 
 	defer file.Close()
 
-	h := sha1.New()
+	h := expected.new()
 
-	_, err = io.Copy(h, file)
+	// the file is read ahead of hashing, to keep fast disks busy
+	_, err = copyReadAhead(io.MultiWriter(h, progress), file)
 
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
 
-	bs := h.Sum(nil)
+	checksum := hex.EncodeToString(h.Sum(nil))
 
-	return expectedSHA1sum == hex.EncodeToString(bs), nil
+	return checksum, strings.EqualFold(expected.expected, checksum), nil
 }
 
-func download(url string, location string, writer io.Writer, callback func(n, downloaded int, total int64)) (string, error) {
-	out, err := os.Create(location)
+// download fetches url to location, resuming from the end of location if it was partially downloaded before.
+// It returns the SHA-1 of the complete file. The download is aborted if its size isn't expectedSize (if known).
+func download(url string, location string, expectedSize int64, writer io.Writer, callback func(n int, downloaded, total int64)) (string, error) {
+	out, err := os.OpenFile(location, os.O_CREATE|os.O_RDWR, fileMode)
 
 	if err != nil {
 		return "", err
@@ -277,42 +1445,185 @@ func download(url string, location string, writer io.Writer, callback func(n, do
 
 	defer out.Close()
 
-	h := sha1.New()
-	mw := io.MultiWriter(out, h, writer)
+	// hashed in another goroutine, so that hashing doesn't limit how fast the download is written
+	h := newAsyncHash(sha1.New())
+	defer h.close()
 
-	resp, err := http.Get(url)
+	// the previously downloaded part of the file must be included in the checksum
+	offset, err := resumeHash(out, location, h)
 
 	if err != nil {
 		return "", err
 	}
 
-	defer resp.Body.Close()
+	request, err := http.NewRequest("GET", url, nil)
 
-	buf := make([]byte, 128*1024)
+	if err != nil {
+		return "", err
+	}
 
-	downloaded := 0
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
-	for {
-		if n, err := resp.Body.Read(buf); (err == nil || err == io.EOF) && n > 0 {
-			_, err = mw.Write(buf[:n])
+	resp, err := downloadClient.Do(request)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
 
-			if err != nil {
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		// resuming
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the file was already completely downloaded
+		removeHashState(location)
+
+		return hex.EncodeToString(h.Sum(nil)), nil
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			// the server ignored the range, so start again from the beginning
+			if err := out.Truncate(0); err != nil {
 				return "", err
 			}
 
-			downloaded += n
-
-			if callback != nil {
-				callback(n, downloaded, resp.ContentLength)
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return "", err
 			}
-		} else if err != nil && err != io.EOF {
-			return "", err
+
+			h.Reset()
+			removeHashState(location)
+			offset = 0
+		}
+	default:
+		return "", &statusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	if err := checkContentLength(offset, resp.ContentLength, expectedSize); err != nil {
+		return "", err
+	}
+
+	// writes are buffered so that the disk sees large sequential writes, which must be flushed even when the
+	// download stops early so that it can be resumed from the end of the file
+	bw := bufio.NewWriterSize(out, bufferSize)
+
+	// the hash state is saved as the download progresses, and when it stops, so that it can be resumed without
+	// re-reading the partial download
+	state := &hashStateWriter{location: location, h: h, flush: bw.Flush, offset: offset, saved: offset}
+
+	progress := &progressWriter{
+		w:          io.MultiWriter(bw, h, state, writer),
+		downloaded: offset,
+		total:      offset + resp.ContentLength,
+		limit:      maximumSize(expectedSize),
+		callback:   callback,
+		skip:       atomic.LoadUint64(&skipGeneration),
+	}
+
+	_, err = io.CopyBuffer(progress, resp.Body, make([]byte, bufferSize))
+
+	flushErr := bw.Flush()
+
+	if err != nil && flushErr == nil && err != errOversized {
+		saveHashState(location, h, state.offset)
+	} else if err == nil {
+		err = flushErr
+	}
+
+	if pauseForSpace(location, err) {
+		// resumed from the partial file once space has been freed
+		return "", errPaused
+	} else if err != nil {
+		return "", err
+	}
+
+	removeHashState(location)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetchSelectedDevices retrieves the firmwares of the devices selected by the flags. The information for devices
+// which couldn't be retrieved is nil.
+func fetchSelectedDevices(devices []api.BaseDevice) ([]api.BaseDevice, []*api.Device) {
+	selected := selectDevices(devices)
+
+	information, errs := fetchDeviceInformation(selected)
+
+	if len(errs) > 0 {
+		errorf("Could not get firmwares for %d of %d device(s), their firmwares will not be downloaded in this run", len(errs), len(selected))
+	}
+
+	for _, deviceInformation := range information {
+		if deviceInformation != nil {
+			sortFirmwares(deviceInformation)
+		}
+	}
+
+	return selected, information
+}
+
+// selectDevices returns the devices selected by the flags.
+func selectDevices(devices []api.BaseDevice) []api.BaseDevice {
+	var selected []api.BaseDevice
+
+	for _, device := range devices {
+		if deviceSelected(device.Identifier) && deviceAttributesSelected(&device) {
+			selected = append(selected, device)
+		}
+	}
+
+	return selected
+}
+
+// sortFirmwares orders a device's firmwares newest first.
+func sortFirmwares(device *api.Device) {
+	firmwares := device.Firmwares
+
+	// firmwares without dates, e.g. from Apple's catalogs, stay in the order they were listed in
+	sort.SliceStable(firmwares, func(i int, j int) bool {
+		return firmwares[i].UploadDate.Time.After(firmwares[j].UploadDate.Time)
+	})
+}
+
+// firmwareSelected reports whether a device's firmware matches the flags, given its index among the device's
+// firmwares (newest first).
+func firmwareSelected(index int, ipsw *api.Firmware) bool {
+	if downloadSigned && !ipsw.Signed {
+		return false
+	}
+
+	if ignore, reason := ignored(ipsw); ignore {
+		if reason != "" {
+			skipf("Skipping %s %s (%s), ignored: %s", ipsw.Identifier, ipsw.Version, ipsw.BuildID, reason)
 		} else {
-			break
+			skipf("Skipping %s %s (%s), ignored", ipsw.Identifier, ipsw.Version, ipsw.BuildID)
+		}
+
+		return false
+	}
+
+	// devices with a -device-versions rule use it instead of -version and -latest
+	if rule := deviceVersionRuleFor(ipsw.Identifier); rule != nil {
+		if !rule.allows(index, ipsw) {
+			return false
 		}
+	} else if (latestLimit > 0 && index >= latestLimit) || !versionSelected(ipsw.Version) {
+		return false
+	}
+
+	if filter != "" && filterValue != "" && !passesFilter(*ipsw, filter, filterValue) {
+		skipf("Skipping %s (%s), does not match filter", ipsw.Identifier, ipsw.BuildID)
+		return false
+	}
+
+	if maxFileSize > 0 && ipsw.Filesize > maxFileSize {
+		skipf("Skipping %s (%s), larger than %s (%s)", ipsw.Identifier, ipsw.BuildID, humanize.Bytes(maxFileSize), humanize.Bytes(ipsw.Filesize))
+		return false
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), err
+	return true
 }
 
 func passesFilter(firmware api.Firmware, filterName, filterValue string) bool {
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// setupAPIClient creates ipswClient for -metadata-url, authenticating with -metadata-token (or ALLTHEFIRMWARES_METADATA_TOKEN,
+// or the contents of -metadata-token-file) if set.
+func setupAPIClient() error {
+	if metadataToken == "" {
+		metadataToken = os.Getenv("ALLTHEFIRMWARES_METADATA_TOKEN")
+	}
+
+	if metadataToken == "" && metadataTokenFile != "" {
+		b, err := os.ReadFile(metadataTokenFile)
+
+		if err != nil {
+			return fmt.Errorf("unable to read -metadata-token-file: %s, err: %s", metadataTokenFile, err)
+		}
+
+		metadataToken = strings.TrimSpace(string(b))
+	}
+
+	apiBaseURL = strings.TrimSuffix(apiBaseURL, "/")
+
+	transport := traced(http.DefaultTransport)
+	apiTransport := transport
+
+	if metadataToken != "" {
+		u, err := url.Parse(apiBaseURL)
+
+		if err != nil {
+			return fmt.Errorf("invalid -metadata-url: %s, err: %s", apiBaseURL, err)
+		}
+
+		apiTransport = &tokenTransport{next: transport, token: metadataToken, host: u.Host}
+	}
+
+	apiProbeClient.Transport = apiTransport
+
+	var sources []metadataSource
+
+	for _, name := range strings.Split(metadataSources, ",") {
+		switch name = strings.TrimSpace(name); {
+		case name == "api":
+			sources = append(sources, newAPISource(apiBaseURL, apiTransport))
+		case name == "apple":
+			var feeds []string
+
+			for _, feed := range strings.Split(appleCatalogs, ",") {
+				if feed = strings.TrimSpace(feed); feed != "" {
+					feeds = append(feeds, feed)
+				}
+			}
+
+			if len(feeds) == 0 {
+				return errors.New("-source apple requires -apple-catalogs")
+			}
+
+			sources = append(sources, newAppleSource(feeds, transport))
+		case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
+			sources = append(sources, newAPISource(name, transport))
+		default:
+			return fmt.Errorf("unknown -source: %s, use api (-metadata-url), apple or the URL of an ipsw.me v4 compatible API", name)
+		}
+	}
+
+	if len(sources) == 1 {
+		ipswClient = sources[0]
+	} else {
+		ipswClient = &fallbackSource{sources: sources}
+	}
+
+	return nil
+}
+
+// noCacheTransport asks any caches between allthefirmwares and the API (e.g. a CDN or proxy) for a fresh response
+type noCacheTransport struct {
+	next http.RoundTripper
+}
+
+func (t *noCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Cache-Control", "no-cache")
+
+	return t.next.RoundTrip(req)
+}
+
+// tokenTransport authenticates API requests with a bearer token, which is only sent to the API's host (not e.g. redirects)
+type tokenTransport struct {
+	next        http.RoundTripper
+	token, host string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	return t.next.RoundTrip(req)
+}
+
+// cachedResponse is an API response which can be revalidated with its ETag or Last-Modified time
+type cachedResponse struct {
+	etag, lastModified string
+	header             http.Header
+	body               []byte
+}
+
+// conditionalTransport makes API requests conditional on the response having changed since it was last retrieved,
+// so that polls which find nothing new cost (almost) nothing.
+type conditionalTransport struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	cache map[string]*cachedResponse
+}
+
+func newConditionalTransport(next http.RoundTripper) *conditionalTransport {
+	return &conditionalTransport{next: next, cache: make(map[string]*cachedResponse)}
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+
+	t.mu.Lock()
+	cached := t.cache[key]
+	t.mu.Unlock()
+
+	if cached != nil {
+		req = req.Clone(req.Context())
+
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		debugf("%s has not changed", key)
+
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Header = cached.header.Clone()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+
+		return resp, nil
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.cache[key] = &cachedResponse{etag: etag, lastModified: lastModified, header: resp.Header.Clone(), body: body}
+	t.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// rateLimitTransport waits, if need be, so that requests to each host are made at most -api-rate a second, however
+// many devices are retrieved at once
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+var (
+	// apiRequestTimes are when the next request to each host may be made (w/ -api-rate)
+	apiRequestTimes   = make(map[string]time.Time)
+	apiRequestTimesMu sync.Mutex
+)
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if apiRate > 0 {
+		interval := time.Duration(float64(time.Second) / apiRate)
+
+		apiRequestTimesMu.Lock()
+		now := time.Now()
+		at := apiRequestTimes[req.URL.Host]
+
+		if at.Before(now) {
+			at = now
+		}
+
+		apiRequestTimes[req.URL.Host] = at.Add(interval)
+		apiRequestTimesMu.Unlock()
+
+		if wait := at.Sub(now); wait > 0 {
+			debugf("Waiting %s to request %s (-api-rate)", wait.Round(time.Millisecond), req.URL)
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// retryTransport retries API requests which are rate limited or fail with a server error, waiting for as long as the
+// Retry-After header asks, or backing off exponentially. Other unsuccessful responses are returned as errors.
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+// maxRetryWait is the longest retryTransport waits between attempts
+const maxRetryWait = 10 * time.Minute
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := time.Second
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+
+		if resp.StatusCode < 400 {
+			return resp, nil
+		} else if !retryable || req.Method != http.MethodGet || attempt > apiRetries {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+		}
+
+		wait := retryAfter(resp.Header.Get("Retry-After"))
+		resp.Body.Close()
+
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+		}
+
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+
+		warnf("%s: %s, retrying in %s (attempt %d of %d)", req.URL, resp.Status, wait, attempt, apiRetries)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or a date, returning 0 if it is missing or invalid.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+// fetchDeviceInformation retrieves the firmwares of each device, -api-concurrency at a time. The information for devices
+// which couldn't be retrieved is nil, and the errors are logged and returned.
+func fetchDeviceInformation(devices []api.BaseDevice) ([]*api.Device, []error) {
+	information := make([]*api.Device, len(devices))
+
+	errs := streamDeviceInformation(devices, func(i int, deviceInformation *api.Device) {
+		information[i] = deviceInformation
+	})
+
+	return information, errs
+}
+
+// streamDeviceInformation retrieves the firmwares of each device like fetchDeviceInformation, calling fetched with each
+// device's as it arrives, one at a time. It stops early if a shutdown is requested.
+func streamDeviceInformation(devices []api.BaseDevice, fetched func(i int, deviceInformation *api.Device)) []error {
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	workers := apiConcurrency
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				markAlive()
+
+				deviceInformation, err := ipswClient.DeviceInformation(devices[i].Identifier)
+
+				if err != nil {
+					errorf("Could not get firmwares for device: %s, err: %s", devices[i].Identifier, err)
+
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+
+					continue
+				}
+
+				mu.Lock()
+				fetched(i, deviceInformation)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range devices {
+		if shutdownRequested() {
+			break
+		}
+
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// appleSource retrieves firmware information from Apple's own XML catalogs (w/ -source apple), which list the restore
+// images Apple currently offers, i.e. those it's signing, with their URL and SHA1, but not their size or release date
+type appleSource struct {
+	feeds  []string
+	client *http.Client
+
+	mu      sync.Mutex
+	loaded  time.Time
+	devices map[string]*api.Device
+}
+
+// appleCatalogTTL is how long the catalogs are used for before they're retrieved again, so that each run retrieves
+// them once, rather than for every device
+const appleCatalogTTL = time.Minute
+
+func newAppleSource(feeds []string, transport http.RoundTripper) *appleSource {
+	return &appleSource{feeds: feeds, client: &http.Client{Transport: metadataTransport(transport)}}
+}
+
+func (s *appleSource) String() string {
+	return "Apple's catalogs"
+}
+
+func (s *appleSource) probeURL() string {
+	return s.feeds[0]
+}
+
+func (s *appleSource) Devices(bool) ([]api.BaseDevice, error) {
+	devices, err := s.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var baseDevices []api.BaseDevice
+
+	for _, device := range devices {
+		baseDevices = append(baseDevices, device.BaseDevice)
+	}
+
+	sort.Slice(baseDevices, func(i, j int) bool {
+		return baseDevices[i].Identifier < baseDevices[j].Identifier
+	})
+
+	return baseDevices, nil
+}
+
+func (s *appleSource) DeviceInformation(identifier string) (*api.Device, error) {
+	devices, err := s.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	device, ok := devices[identifier]
+
+	if !ok {
+		return nil, fmt.Errorf("%s isn't in Apple's catalogs", identifier)
+	}
+
+	// callers may modify the device
+	copied := *device
+	copied.Firmwares = append([]api.Firmware(nil), device.Firmwares...)
+
+	return &copied, nil
+}
+
+// load retrieves and parses the catalogs, unless they were retrieved recently. Catalogs which can't be retrieved are
+// skipped, unless none can.
+func (s *appleSource) load() (map[string]*api.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.devices != nil && time.Since(s.loaded) < appleCatalogTTL {
+		return s.devices, nil
+	}
+
+	devices := make(map[string]*api.Device)
+	var firstErr error
+
+	for _, feed := range s.feeds {
+		if err := s.loadFeed(feed, devices); err != nil {
+			warnf("Unable to retrieve Apple's catalog: %s, err: %s", feed, err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(devices) == 0 {
+		if firstErr == nil {
+			firstErr = errors.New("no firmwares in Apple's catalogs")
+		}
+
+		return nil, firstErr
+	}
+
+	for _, device := range devices {
+		firmwares := device.Firmwares
+
+		// the catalogs don't have release dates, so firmwares are ordered by version, newest first
+		sort.SliceStable(firmwares, func(i, j int) bool {
+			return compareVersions(firmwares[i].Version, firmwares[j].Version) > 0
+		})
+	}
+
+	s.devices, s.loaded = devices, time.Now()
+
+	return devices, nil
+}
+
+// loadFeed adds the restore images of the catalog at url to devices, by identifier.
+func (s *appleSource) loadFeed(url string, devices map[string]*api.Device) error {
+	resp, err := s.client.Get(url)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	catalog, err := decodePlist(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	root, _ := catalog.(map[string]interface{})
+	versions, _ := root["MobileDeviceSoftwareVersionsByVersion"].(map[string]interface{})
+
+	if versions == nil {
+		return errors.New("no MobileDeviceSoftwareVersionsByVersion in catalog")
+	}
+
+	for _, version := range versions {
+		v, _ := version.(map[string]interface{})
+		productTypes, _ := v["MobileDeviceSoftwareVersions"].(map[string]interface{})
+
+		for identifier, builds := range productTypes {
+			// identifiers look like iPhone14,2, other keys aren't devices
+			if !strings.Contains(identifier, ",") {
+				continue
+			}
+
+			device, ok := devices[identifier]
+
+			if !ok {
+				device = &api.Device{BaseDevice: api.BaseDevice{Identifier: identifier, Name: identifier}}
+				devices[identifier] = device
+			}
+
+			addAppleRestores(device, builds)
+		}
+	}
+
+	return nil
+}
+
+// addAppleRestores adds the restore images found anywhere beneath entry, which are dictionaries with a Restore
+// dictionary, to device, once per build.
+func addAppleRestores(device *api.Device, entry interface{}) {
+	dict, ok := entry.(map[string]interface{})
+
+	if !ok {
+		return
+	}
+
+	if restore, ok := dict["Restore"].(map[string]interface{}); ok {
+		url, _ := restore["FirmwareURL"].(string)
+		buildID, _ := restore["BuildVersion"].(string)
+		version, _ := restore["ProductVersion"].(string)
+		sha1Sum, _ := restore["FirmwareSHA1"].(string)
+
+		if url == "" || buildID == "" {
+			return
+		}
+
+		for _, fw := range device.Firmwares {
+			if fw.BuildID == buildID {
+				return
+			}
+		}
+
+		device.Firmwares = append(device.Firmwares, api.Firmware{
+			Identifier: device.Identifier,
+			Version:    version,
+			BuildID:    buildID,
+			SHA1Sum:    sha1Sum,
+			URL:        url,
+			Signed:     true,
+		})
+
+		return
+	}
+
+	for _, child := range dict {
+		addAppleRestores(device, child)
+	}
+}
+
+// compareVersions compares dotted versions numerically, e.g. 16.10 is newer than 16.9, returning a positive number if
+// a is newer than b, a negative one if it's older, and 0 if they're the same.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		if x != y {
+			return x - y
+		}
+	}
+
+	return 0
+}
+
+// decodePlist decodes an XML property list into maps, slices, strings, int64s, float64s and bools.
+func decodePlist(r io.Reader) (interface{}, error) {
+	decoder := xml.NewDecoder(r)
+
+	for {
+		token, err := decoder.Token()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(decoder, start)
+		}
+	}
+}
+
+// decodePlistValue decodes the value which start starts.
+func decodePlistValue(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]interface{})
+		key := ""
+
+		for {
+			token, err := decoder.Token()
+
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := decoder.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+
+				value, err := decodePlistValue(decoder, t)
+
+				if err != nil {
+					return nil, err
+				}
+
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []interface{}
+
+		for {
+			token, err := decoder.Token()
+
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.StartElement:
+				value, err := decodePlistValue(decoder, t)
+
+				if err != nil {
+					return nil, err
+				}
+
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", decoder.Skip()
+	}
+
+	var text string
+
+	if err := decoder.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+
+	text = strings.TrimSpace(text)
+
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(text, 10, 64)
+	case "real":
+		return strconv.ParseFloat(text, 64)
+	}
+
+	// strings, dates and data
+	return text, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkArchive calls fn for each firmware file (or link to one) in the roots of the pool, skipping allthefirmwares' own
+// files: the state directory, snapshots, the trash, content-addressed objects, and partial downloads and locks.
+func walkArchive(fn func(path string, info os.FileInfo) error) error {
+	for _, root := range poolRoots() {
+		if err := walkRoot(root, false, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkTemporary calls fn for each partial download and temporary file in the roots of the pool, including those of
+// content-addressed objects, but not those in the state directory, snapshots or the trash.
+func walkTemporary(fn func(path string, info os.FileInfo) error) error {
+	for _, root := range poolRoots() {
+		if err := walkRoot(root, true, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// temporaryFile reports whether path is a partial download (or its hash state) or a temporary file.
+func temporaryFile(path string) bool {
+	return strings.HasSuffix(path, partialSuffix) || strings.HasSuffix(path, ".tmp")
+}
+
+// walkRoot walks one root of the pool for walkArchive, or w/ temporary, walkTemporary.
+func walkRoot(root string, temporary bool, fn func(path string, info os.FileInfo) error) error {
+	skip := map[string]bool{
+		filepath.Clean(stateDirectory()): true,
+	}
+
+	if snapshotDir != "" {
+		skip[filepath.Clean(snapshotDir)] = true
+	}
+
+	if trashDirectory != "" {
+		skip[filepath.Clean(trashDirectory)] = true
+	}
+
+	if contentAddressed && !temporary {
+		// objects are reached through the links to them
+		skip[filepath.Join(root, "objects")] = true
+	}
+
+	// nested roots are walked on their own
+	for _, other := range poolRoots() {
+		if other != root {
+			skip[other] = true
+		}
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// walking a large archive (e.g. for gc or retention) is progress to the watchdog
+		markAlive()
+
+		if info.IsDir() && skip[filepath.Clean(path)] {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if temporary {
+			if !temporaryFile(path) {
+				return nil
+			}
+		} else if strings.HasSuffix(path, partialSuffix) || strings.HasSuffix(path, lockSuffix) {
+			return nil
+		}
+
+		return fn(path, info)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"hash"
+	"sync"
+)
+
+// asyncHashBuffers is how many -buffer-size buffers an asyncHash can queue before writes wait for hashing to catch up
+const asyncHashBuffers = 4
+
+// asyncHash hashes what is written to it in another goroutine, so that hashing a download overlaps with receiving and
+// writing it rather than limiting how fast it can be. Writes are copied into a ring of buffers, so they only wait when
+// hashing has fallen asyncHashBuffers buffers behind. Sum, Reset and the binary (un)marshaling used to save the hash
+// state wait for what has been written to be hashed first. It must be closed once it's no longer used.
+type asyncHash struct {
+	hash.Hash
+
+	free    chan []byte
+	queued  chan []byte
+	pending sync.WaitGroup
+}
+
+func newAsyncHash(h hash.Hash) *asyncHash {
+	a := &asyncHash{
+		Hash:   h,
+		free:   make(chan []byte, asyncHashBuffers),
+		queued: make(chan []byte, asyncHashBuffers),
+	}
+
+	for i := 0; i < asyncHashBuffers; i++ {
+		a.free <- make([]byte, bufferSize)
+	}
+
+	go func() {
+		for b := range a.queued {
+			a.Hash.Write(b)
+			a.free <- b[:cap(b)]
+			a.pending.Done()
+		}
+	}()
+
+	return a
+}
+
+// Write queues b to be hashed, never failing, as hash.Hash writes don't.
+func (a *asyncHash) Write(b []byte) (int, error) {
+	written := 0
+
+	for written < len(b) {
+		buf := <-a.free
+		n := copy(buf, b[written:])
+
+		a.pending.Add(1)
+		a.queued <- buf[:n]
+
+		written += n
+	}
+
+	return written, nil
+}
+
+// wait waits for everything written to have been hashed.
+func (a *asyncHash) wait() {
+	a.pending.Wait()
+}
+
+func (a *asyncHash) Sum(b []byte) []byte {
+	a.wait()
+
+	return a.Hash.Sum(b)
+}
+
+func (a *asyncHash) Reset() {
+	a.wait()
+	a.Hash.Reset()
+}
+
+func (a *asyncHash) MarshalBinary() ([]byte, error) {
+	a.wait()
+
+	marshaler, ok := a.Hash.(encoding.BinaryMarshaler)
+
+	if !ok {
+		return nil, errors.New("the hash state can't be saved")
+	}
+
+	return marshaler.MarshalBinary()
+}
+
+func (a *asyncHash) UnmarshalBinary(state []byte) error {
+	a.wait()
+
+	unmarshaler, ok := a.Hash.(encoding.BinaryUnmarshaler)
+
+	if !ok {
+		return errors.New("the hash state can't be restored")
+	}
+
+	return unmarshaler.UnmarshalBinary(state)
+}
+
+// close stops the goroutine hashing, once everything written has been hashed.
+func (a *asyncHash) close() {
+	a.wait()
+	close(a.queued)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the -audit-log, recording a change to the archive, who made it and why
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Target    string    `json:"target,omitempty"`
+	Reason    string    `json:"reason"`
+	User      string    `json:"user"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+}
+
+var (
+	// auditMu serialises appending to the audit log
+	auditMu sync.Mutex
+
+	// auditUser, auditHost and auditCommand are who is changing the archive, recorded in each entry
+	auditUser, auditHost, auditCommand string
+)
+
+// startAuditLog records who the entries of the audit log written while running command are made by.
+func startAuditLog(command string) {
+	if auditLogFile == "" {
+		return
+	}
+
+	auditCommand = command
+	auditHost, _ = os.Hostname()
+
+	if u, err := user.Current(); err == nil {
+		auditUser = u.Username
+	}
+}
+
+// auditf appends an entry to the -audit-log for an operation on the archive: "create", "rename", "move", "link",
+// "delete" or "prune" of path (to target, if it has one). Failing to write it is warned about, as the operation has
+// already been performed.
+func auditf(operation, path, target, reason string) {
+	if auditLogFile == "" {
+		return
+	}
+
+	entry := auditEntry{
+		Time:      time.Now(),
+		Operation: operation,
+		Path:      auditPath(path),
+		Target:    auditPath(target),
+		Reason:    reason,
+		User:      auditUser,
+		Host:      auditHost,
+		PID:       os.Getpid(),
+		Command:   auditCommand,
+	}
+
+	if err := appendAuditEntry(&entry); err != nil {
+		warnf("Unable to write to audit log: %s, err: %s", auditLogFile, err)
+	}
+}
+
+// moveOperation returns how moving source to target is audited, as a "rename" if it stays in the same directory.
+func moveOperation(source, target string) string {
+	if filepath.Dir(filepath.Clean(source)) == filepath.Dir(filepath.Clean(target)) {
+		return "rename"
+	}
+
+	return "move"
+}
+
+// auditPath returns path made absolute, so that entries refer to the same file whatever directory they're written from.
+func auditPath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// appendAuditEntry appends entry to the audit log as a line of JSON, in a single write, and syncs it to disk before
+// returning.
+func appendAuditEntry(entry *auditEntry) error {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.OpenFile(auditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
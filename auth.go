@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// httpAuthConfigured reports whether the daemon's HTTP surfaces require authentication.
+func httpAuthConfigured() bool {
+	return apiToken != "" || (apiUser != "" && apiPassword != "")
+}
+
+// loadHTTPAuthFromEnvironment fills in secrets which weren't given as flags from the environment,
+// so they needn't appear in process listings.
+func loadHTTPAuthFromEnvironment() {
+	if apiToken == "" {
+		apiToken = os.Getenv("ALLTHEFIRMWARES_API_TOKEN")
+	}
+
+	if apiPassword == "" {
+		apiPassword = os.Getenv("ALLTHEFIRMWARES_API_PASSWORD")
+	}
+}
+
+// checkHTTPAuth checks that -api-user and -api-password are given together, as otherwise the control API and serve
+// would be served without authentication.
+func checkHTTPAuth() error {
+	loadHTTPAuthFromEnvironment()
+
+	if (apiUser == "") != (apiPassword == "") {
+		return errors.New("-api-user and -api-password (or ALLTHEFIRMWARES_API_PASSWORD) must be used together")
+	}
+
+	return nil
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authorized checks a request's credentials: either the API token as a bearer token (or as the password
+// of any basic auth user, for browsers), or the basic auth user and password.
+func authorized(r *http.Request) bool {
+	if apiToken != "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && secureCompare(strings.TrimPrefix(auth, "Bearer "), apiToken) {
+			return true
+		}
+	}
+
+	user, password, ok := r.BasicAuth()
+
+	if !ok {
+		return false
+	}
+
+	if apiToken != "" && secureCompare(password, apiToken) {
+		return true
+	}
+
+	return apiUser != "" && apiPassword != "" && secureCompare(user, apiUser) && secureCompare(password, apiPassword)
+}
+
+// requireAuth wraps handler so that it is only served to authorized requests, if authentication is configured.
+func requireAuth(handler http.Handler) http.Handler {
+	if !httpAuthConfigured() {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="allthefirmwares"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
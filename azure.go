@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	azureAPIVersion = "2019-12-12"
+
+	// azureBlockSize is the size of the blocks that blobs are staged in
+	azureBlockSize = 16 << 20
+
+	// azureBlockAttempts is how many times uploading a block is attempted
+	azureBlockAttempts = 3
+)
+
+// azureStorage stores firmwares as block blobs in an Azure Storage container, set with -dest azure://container/prefix.
+// The account is given by AZURE_STORAGE_ACCOUNT, and authenticated with either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+type azureStorage struct {
+	account, container, prefix string
+
+	endpoint string
+	key      []byte
+	sasToken url.Values
+}
+
+func newAzureStorage(u *url.URL) (storage, error) {
+	s := &azureStorage{
+		account:   os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		container: u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		endpoint:  os.Getenv("AZURE_STORAGE_ENDPOINT"),
+	}
+
+	if s.account == "" {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT is not set")
+	}
+
+	if s.container == "" {
+		return nil, errors.New("no container given, use azure://container/prefix")
+	}
+
+	if s.endpoint == "" {
+		s.endpoint = "https://" + s.account + ".blob.core.windows.net"
+	}
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		var err error
+
+		if s.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY, err: %s", err)
+		}
+	} else if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); token != "" {
+		var err error
+
+		if s.sasToken, err = url.ParseQuery(strings.TrimPrefix(token, "?")); err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN, err: %s", err)
+		}
+	} else {
+		return nil, errors.New("neither AZURE_STORAGE_KEY nor AZURE_STORAGE_SAS_TOKEN is set")
+	}
+
+	return s, nil
+}
+
+func (s *azureStorage) String() string {
+	return "azure://" + joinStorageName(s.container, s.prefix)
+}
+
+// azureError is an error response from the Blob service
+type azureError struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *azureError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("unexpected response status: %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("unexpected response status: %d (%s)", e.StatusCode, e.Code)
+}
+
+// do makes a request for the blob called name, returning an *azureError if the response was not successful.
+func (s *azureStorage) do(method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(s.endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.container + "/" + joinStorageName(s.prefix, name)
+
+	if query == nil {
+		query = url.Values{}
+	}
+
+	for k, v := range s.sasToken {
+		query[k] = v
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+
+	if s.key != nil {
+		s.sign(req)
+	}
+
+	resp, err := storageClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		// HEAD responses carry the error code in a header rather than the body
+		azErr := &azureError{StatusCode: resp.StatusCode, Code: resp.Header.Get("x-ms-error-code")}
+
+		if b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil && azErr.Code == "" {
+			var body struct {
+				Code string
+			}
+
+			if xml.Unmarshal(b, &body) == nil {
+				azErr.Code = body.Code
+			}
+		}
+
+		return nil, azErr
+	}
+
+	return resp, nil
+}
+
+// sign adds a Shared Key authorization header to req.
+func (s *azureStorage) sign(req *http.Request) {
+	var headers []string
+
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+
+	sort.Strings(headers)
+
+	resource := "/" + s.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	contentLength := ""
+
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + strings.Join(headers, "\n") + "\n" + resource
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(stringToSign))
+
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func (s *azureStorage) exists(name string) (bool, error) {
+	resp, err := s.do(http.MethodHead, name, nil, nil, nil)
+
+	if azErr, ok := err.(*azureError); ok && azErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	resp.Body.Close()
+
+	return true, nil
+}
+
+// uncommittedBlocks returns the sizes of the blocks staged for the blob called name by a previous, interrupted, upload.
+func (s *azureStorage) uncommittedBlocks(name string) (map[string]int64, error) {
+	resp, err := s.do(http.MethodGet, name, url.Values{"comp": {"blocklist"}, "blocklisttype": {"uncommitted"}}, nil, nil)
+
+	if azErr, ok := err.(*azureError); ok && azErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var blockList struct {
+		Blocks []struct {
+			Name string
+			Size int64
+		} `xml:"UncommittedBlocks>Block"`
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&blockList); err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[string]int64)
+
+	for _, block := range blockList.Blocks {
+		blocks[block.Name] = block.Size
+	}
+
+	return blocks, nil
+}
+
+func (s *azureStorage) store(path, name string, job *downloadJob) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return s.upload(file, name, job, nil)
+}
+
+func (s *azureStorage) storeStream(r io.Reader, name string, job *downloadJob, check func() error) error {
+	return s.upload(r, name, job, check)
+}
+
+// upload stages r as blocks, each checked by the service against its MD5, then commits them if check (if any) passes.
+// Blocks staged by an interrupted upload of the same file are reused, as block IDs include their MD5.
+func (s *azureStorage) upload(r io.Reader, name string, job *downloadJob, check func() error) error {
+	staged, err := s.uncommittedBlocks(name)
+
+	if err != nil {
+		warnf("Unable to list previously uploaded blocks of %s, err: %s", name, err)
+	}
+
+	fileHash := md5.New()
+	buf := make([]byte, azureBlockSize)
+
+	var blockIDs []string
+	var size int64
+
+	for i := 0; ; i++ {
+		if shutdownRequested() {
+			return errShutdown
+		}
+
+		n, err := io.ReadFull(r, buf)
+
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		block := buf[:n]
+		blockHash := md5.Sum(block)
+		size += int64(n)
+		fileHash.Write(block)
+
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%06d-%x", i, blockHash)))
+		blockIDs = append(blockIDs, id)
+
+		if size, ok := staged[id]; ok && size == int64(n) {
+			debugf("Block %d of %s was already uploaded", i, name)
+			continue
+		}
+
+		header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(blockHash[:])}}
+
+		for attempt := 1; ; attempt++ {
+			resp, err := s.do(http.MethodPut, name, url.Values{"comp": {"block"}, "blockid": {id}}, header, block)
+
+			if err == nil {
+				resp.Body.Close()
+				break
+			} else if attempt == azureBlockAttempts {
+				return fmt.Errorf("unable to upload block %d, err: %s", i, err)
+			}
+
+			warnf("Unable to upload block %d of %s, retrying, err: %s", i, name, err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		markAlive()
+	}
+
+	// uncommitted blocks are discarded by the service after a week
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	var blockList bytes.Buffer
+
+	blockList.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+
+	for _, id := range blockIDs {
+		blockList.WriteString("<Latest>" + id + "</Latest>")
+	}
+
+	blockList.WriteString("</BlockList>")
+
+	contentMD5 := base64.StdEncoding.EncodeToString(fileHash.Sum(nil))
+
+	header := http.Header{
+		"Content-Type":           {"application/xml"},
+		"X-Ms-Blob-Content-Md5":  {contentMD5},
+		"X-Ms-Blob-Content-Type": {"application/octet-stream"},
+		"X-Ms-Meta-Sha1":         {job.Firmware.SHA1Sum},
+		"X-Ms-Meta-Buildid":      {job.Firmware.BuildID},
+		"X-Ms-Meta-Identifier":   {job.Firmware.Identifier},
+	}
+
+	resp, err := s.do(http.MethodPut, name, url.Values{"comp": {"blocklist"}}, header, blockList.Bytes())
+
+	if err != nil {
+		return fmt.Errorf("unable to commit blocks, err: %s", err)
+	}
+
+	resp.Body.Close()
+
+	// check the committed blob is what was uploaded
+	resp, err = s.do(http.MethodHead, name, nil, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.ContentLength != size || resp.Header.Get("Content-MD5") != contentMD5 {
+		return fmt.Errorf("uploaded blob does not match: size %d, MD5 %s, expected size %d, MD5 %s", resp.ContentLength, resp.Header.Get("Content-MD5"), size, contentMD5)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	// benchmarkBufferSizes are the -buffer-size values the disk is written with
+	benchmarkBufferSizes = []int{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+	// benchmarkConcurrency are the -j values downloads are made with
+	benchmarkConcurrency = []int{1, 2, 4, 8, 16}
+)
+
+// benchmarkResult is the throughput, in bytes per second, measured with a setting
+type benchmarkResult struct {
+	setting int
+	rate    float64
+}
+
+// suggestSetting returns the smallest setting which reached 90% of the best throughput, since larger ones cost more
+// memory or connections for little gain.
+func suggestSetting(results []benchmarkResult) int {
+	best := 0.0
+
+	for _, result := range results {
+		if result.rate > best {
+			best = result.rate
+		}
+	}
+
+	for _, result := range results {
+		if result.rate >= best*0.9 {
+			return result.setting
+		}
+	}
+
+	return 0
+}
+
+// benchmarkCommand measures how fast the download root can be written to with different buffer sizes, and how fast a
+// firmware (given as an argument, or else the newest of the first device selected) can be downloaded at different
+// concurrencies, and suggests -buffer-size and -j.
+func benchmarkCommand() error {
+	if benchmarkDuration <= 0 {
+		return errors.New("-benchmark-time must be positive")
+	}
+
+	url := flag.Arg(0)
+
+	if url == "" {
+		var err error
+
+		if url, err = benchmarkURL(); err != nil {
+			return err
+		}
+	}
+
+	var diskResults, downloadResults []benchmarkResult
+
+	root := downloadRoot()
+
+	if destination != nil {
+		warnf("Only the download root, %s, is benchmarked, not %s", root, destination)
+	}
+
+	for _, size := range benchmarkBufferSizes {
+		rate, err := benchmarkDisk(root, size)
+
+		if err != nil {
+			return fmt.Errorf("unable to benchmark writing to %s, err: %s", root, err)
+		}
+
+		infof("Writing to %s with a %s buffer: %s/s", root, humanize.IBytes(uint64(size)), humanize.Bytes(uint64(rate)))
+		diskResults = append(diskResults, benchmarkResult{setting: size, rate: rate})
+	}
+
+	for _, concurrency := range benchmarkConcurrency {
+		if shutdownRequested() {
+			return errShutdown
+		}
+
+		rate, err := benchmarkDownload(url, concurrency)
+
+		if err != nil {
+			return fmt.Errorf("unable to benchmark downloading %s, err: %s", url, err)
+		}
+
+		infof("Downloading with %d connection(s): %s/s", concurrency, humanize.Bytes(uint64(rate)))
+		downloadResults = append(downloadResults, benchmarkResult{setting: concurrency, rate: rate})
+	}
+
+	buffer, workers := suggestSetting(diskResults), suggestSetting(downloadResults)
+
+	successf("Suggested settings: -buffer-size %s -j %d", humanize.IBytes(uint64(buffer)), workers)
+
+	bestDisk, bestDownload := 0.0, 0.0
+
+	for _, result := range diskResults {
+		if result.rate > bestDisk {
+			bestDisk = result.rate
+		}
+	}
+
+	for _, result := range downloadResults {
+		if result.rate > bestDownload {
+			bestDownload = result.rate
+		}
+	}
+
+	if bestDisk < bestDownload {
+		warnf("The disk (%s/s) is slower than the network (%s/s), more concurrent downloads won't help", humanize.Bytes(uint64(bestDisk)), humanize.Bytes(uint64(bestDownload)))
+	}
+
+	return nil
+}
+
+// benchmarkURL returns the URL of the newest firmware matching the flags of the first device selected, or of the first
+// device upstream.
+func benchmarkURL() (string, error) {
+	identifiers := selectedDevices()
+
+	if len(identifiers) == 0 {
+		devices, err := ipswClient.Devices(false)
+
+		if err != nil {
+			return "", fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+		}
+
+		if len(devices) == 0 {
+			return "", errors.New("no devices to benchmark downloading a firmware of")
+		}
+
+		identifiers = []string{devices[0].Identifier}
+	}
+
+	device, err := ipswClient.DeviceInformation(identifiers[0])
+
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve firmwares for %s, err: %s", identifiers[0], err)
+	}
+
+	fw, err := resolveFirmware(device, "")
+
+	if err != nil {
+		return "", err
+	}
+
+	infof("Benchmarking with %s %s (%s)", fw.Identifier, fw.Version, fw.BuildID)
+
+	return fw.URL, nil
+}
+
+// benchmarkDisk writes to a temporary file in directory with the given buffer size for -benchmark-time, returning how
+// many bytes per second were written, including syncing them to disk.
+func benchmarkDisk(directory string, size int) (float64, error) {
+	if err := makeDirectory(directory); err != nil {
+		return 0, err
+	}
+
+	f, err := ioutil.TempFile(directory, ".benchmark-*"+partialSuffix)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// random-looking data, so that compressing filesystems don't flatter the disk
+	buffer := make([]byte, size)
+
+	for i := range buffer {
+		buffer[i] = byte(i*7919 + i>>8)
+	}
+
+	var written int64
+
+	started := time.Now()
+
+	for time.Since(started) < benchmarkDuration/time.Duration(len(benchmarkBufferSizes)) && !shutdownRequested() {
+		n, err := f.Write(buffer)
+		written += int64(n)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return float64(written) / time.Since(started).Seconds(), nil
+}
+
+// benchmarkDownload downloads url over concurrency connections for -benchmark-time, each from a different offset,
+// discarding what is downloaded, and returns the combined bytes per second.
+func benchmarkDownload(url string, concurrency int) (float64, error) {
+	var downloaded int64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	duration := benchmarkDuration / time.Duration(len(benchmarkConcurrency))
+	deadline := time.Now().Add(duration)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func(offset int64) {
+			defer wg.Done()
+
+			err := benchmarkConnection(url, offset, deadline, &downloaded)
+
+			if err != nil {
+				mu.Lock()
+
+				if firstErr == nil {
+					firstErr = err
+				}
+
+				mu.Unlock()
+			}
+		}(int64(i) * 64 << 20)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil && atomic.LoadInt64(&downloaded) == 0 {
+		return 0, firstErr
+	}
+
+	return float64(atomic.LoadInt64(&downloaded)) / duration.Seconds(), nil
+}
+
+// benchmarkConnection downloads url from offset until deadline, adding what it downloads to downloaded.
+func benchmarkConnection(url string, offset int64, deadline time.Time, downloaded *int64) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := downloadClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	buffer := make([]byte, bufferSize)
+
+	for time.Now().Before(deadline) && !shutdownRequested() {
+		n, err := resp.Body.Read(buffer)
+		atomic.AddInt64(downloaded, int64(n))
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
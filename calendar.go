@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// calendarRelease is a calendar event for the firmwares of a version released (or, failing that, uploaded) on a day
+type calendarRelease struct {
+	Version string
+	Date    time.Time
+
+	builds, devices []string
+}
+
+// calendarReleases groups the firmwares of records into a release for each version and day, newest first.
+func calendarReleases(records []firmwareRecord) []*calendarRelease {
+	byKey := make(map[string]*calendarRelease)
+
+	var releases []*calendarRelease
+
+	for _, r := range records {
+		date := r.Firmware.ReleaseDate
+
+		if !date.Valid {
+			date = r.Firmware.UploadDate
+		}
+
+		if !date.Valid || r.Firmware.Version == "" {
+			continue
+		}
+
+		day := date.Time.UTC().Truncate(24 * time.Hour)
+		key := r.Firmware.Version + "/" + day.Format("20060102")
+
+		release, ok := byKey[key]
+
+		if !ok {
+			release = &calendarRelease{Version: r.Firmware.Version, Date: day}
+			byKey[key] = release
+			releases = append(releases, release)
+		}
+
+		release.builds = appendUnique(release.builds, r.Firmware.BuildID)
+		release.devices = appendUnique(release.devices, r.Device.Name)
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		if !releases[i].Date.Equal(releases[j].Date) {
+			return releases[i].Date.After(releases[j].Date)
+		}
+
+		return compareVersions(releases[i].Version, releases[j].Version) > 0
+	})
+
+	return releases
+}
+
+// appendUnique appends s to list if it isn't empty or already in it.
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+
+	return append(list, s)
+}
+
+// exportCalendar writes an iCalendar feed with an all-day event for each release of the firmwares in records.
+func exportCalendar(w io.Writer, records []firmwareRecord) error {
+	bw := bufio.NewWriter(w)
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//allthefirmwares//firmware releases//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Firmware releases",
+	}
+
+	for _, release := range calendarReleases(records) {
+		summary := fmt.Sprintf("%s (%s) released", release.Version, strings.Join(release.builds, ", "))
+
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+release.Version+"-"+release.Date.Format("20060102")+"@allthefirmwares",
+			"DTSTAMP:"+stamp,
+			"DTSTART;VALUE=DATE:"+release.Date.Format("20060102"),
+			"DTEND;VALUE=DATE:"+release.Date.AddDate(0, 0, 1).Format("20060102"),
+			"SUMMARY:"+escapeCalendarText(summary),
+			"DESCRIPTION:"+escapeCalendarText(strings.Join(release.devices, "\n")),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		)
+	}
+
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := bw.WriteString(foldCalendarLine(line)); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// escapeCalendarText escapes s for an iCalendar TEXT value.
+func escapeCalendarText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// foldCalendarLine returns line terminated by CRLF, folded so that no line is longer than 75 octets, without splitting
+// a UTF-8 character.
+func foldCalendarLine(line string) string {
+	var b strings.Builder
+
+	limit := 75
+
+	for len(line) > limit {
+		cut := limit
+
+		// don't split a multi-byte character
+		for cut > 0 && line[cut]&0xc0 == 0x80 {
+			cut--
+		}
+
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+
+		// continuation lines start with a space
+		limit = 74
+	}
+
+	b.WriteString(line)
+	b.WriteString("\r\n")
+
+	return b.String()
+}
+
+// calendarHandler serves an iCalendar feed of the release dates of the firmwares in the catalog, or with ?tracked=true,
+// only those of the devices selected with -i and -device-filter.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tracked := r.URL.Query().Get("tracked") == "true"
+
+	var records []firmwareRecord
+
+	for _, device := range catalog.Devices {
+		if tracked && (!deviceSelected(device.Identifier) || !deviceAttributesSelected(&device.BaseDevice)) {
+			continue
+		}
+
+		for _, fw := range device.Firmwares {
+			records = append(records, firmwareRecord{Device: device.BaseDevice, Firmware: fw})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+
+	if err := exportCalendar(w, records); err != nil {
+		debugf("Unable to write response, err: %s", err)
+	}
+}
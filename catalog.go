@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// firmwareCatalog is the firmware information last retrieved from the API, so that it is available offline
+type firmwareCatalog struct {
+	Updated time.Time    `json:"updated"`
+	Devices []api.Device `json:"devices"`
+
+	Stats downloadStats `json:"stats"`
+}
+
+// catalogMu serialises updating the catalog
+var catalogMu sync.Mutex
+
+func catalogPath() string {
+	return filepath.Join(stateDirectory(), "catalog.json")
+}
+
+// loadCatalog reads the catalog, which is empty if it hasn't been written yet.
+func loadCatalog() (*firmwareCatalog, error) {
+	var catalog firmwareCatalog
+
+	if err := readJSONFile(catalogPath(), &catalog); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return &catalog, nil
+}
+
+// firmwares returns the catalog's firmwares, by identifier and build.
+func (c *firmwareCatalog) firmwares() map[string]*api.Firmware {
+	firmwares := make(map[string]*api.Firmware)
+
+	for _, device := range c.Devices {
+		for i := range device.Firmwares {
+			fw := &device.Firmwares[i]
+			firmwares[pinKey(fw.Identifier, fw.BuildID)] = fw
+		}
+	}
+
+	return firmwares
+}
+
+// updateCatalog replaces the catalog's information for the given devices, keeping that of any others.
+func updateCatalog(devices []api.Device) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return err
+	}
+
+	if err := recordSigningChanges(devices); err != nil {
+		warnf("Unable to record signing changes: %s, err: %s", signingHistoryPath(), err)
+	}
+
+	updated := make(map[string]bool)
+
+	for _, device := range devices {
+		updated[device.Identifier] = true
+	}
+
+	for _, device := range catalog.Devices {
+		if !updated[device.Identifier] {
+			devices = append(devices, device)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Identifier < devices[j].Identifier
+	})
+
+	catalog.Devices = devices
+	catalog.Updated = time.Now()
+
+	return writeJSONFile(catalogPath(), catalog)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// cdnHostnames are the hostnames Apple has served firmwares from, by the scheme they're served over. Many old firmwares
+// are only still available from some of them.
+var cdnHostnames = []struct{ scheme, host string }{
+	{"http", "appldnld.apple.com"},
+	{"https", "secure-appldnld.apple.com"},
+	{"https", "updates.cdn-apple.com"},
+	{"http", "updates-http.cdn-apple.com"},
+}
+
+// statusError is an unexpected HTTP response status when downloading a firmware
+type statusError struct {
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return "unexpected response status: " + e.status
+}
+
+// firmwareURLs returns rawURL, followed (w/ -cdn-fallback) by the same path on each of the other Apple CDN hostnames
+// if it is on one of them.
+func firmwareURLs(rawURL string) []string {
+	urls := []string{rawURL}
+
+	if !cdnFallback {
+		return urls
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return urls
+	}
+
+	host := strings.ToLower(u.Hostname())
+	known := false
+
+	for _, cdn := range cdnHostnames {
+		known = known || cdn.host == host
+	}
+
+	if !known {
+		return urls
+	}
+
+	for _, cdn := range cdnHostnames {
+		if cdn.host == host {
+			continue
+		}
+
+		alternate := *u
+		alternate.Scheme, alternate.Host = cdn.scheme, cdn.host
+
+		urls = append(urls, alternate.String())
+	}
+
+	return urls
+}
+
+// unavailable reports whether err means that a firmware isn't available from a host, so another should be tried:
+// it wasn't found there, the host failed, or it couldn't be connected to or timed out.
+func unavailable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code == http.StatusNotFound || se.code == http.StatusGone || se.code == http.StatusForbidden || se.code >= 500
+	}
+
+	_, ok := err.(net.Error)
+
+	return ok
+}
+
+// downloadWithFallback downloads rawURL (see download) or, if it is unavailable there, from the first of its other
+// candidate URLs (see candidateURLs) it is available from.
+func downloadWithFallback(rawURL string, location string, expectedSize int64, writer io.Writer, callback func(n int, downloaded, total int64)) (string, error) {
+	var checksum string
+	var err error
+
+	for i, u := range candidateURLs(rawURL) {
+		if i > 0 {
+			warnf("Unable to download %s, err: %s, trying %s", filepath.Base(rawURL), err, u)
+		}
+
+		checksum, err = download(u, location, expectedSize, writer, callback)
+
+		if !unavailable(err) {
+			break
+		}
+	}
+
+	return checksum, err
+}
+
+// getWithFallback requests rawURL or, if it is unavailable there, the first of its other candidate URLs (see
+// candidateURLs) it is available from, returning the first successful response.
+func getWithFallback(rawURL string) (*http.Response, error) {
+	var err error
+
+	for i, u := range candidateURLs(rawURL) {
+		if i > 0 {
+			warnf("Unable to download %s, err: %s, trying %s", filepath.Base(rawURL), err, u)
+		}
+
+		var resp *http.Response
+
+		resp, err = downloadClient.Get(u)
+
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = &statusError{status: resp.Status, code: resp.StatusCode}
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		if !unavailable(err) {
+			break
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+)
+
+// checkCommand checks that every currently signed firmware matching the flags has been downloaded, printing a one line
+// summary (in the style of a Nagios plugin) and failing if any are missing, e.g. to monitor that the mirror can restore
+// every device.
+func checkCommand() error {
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		fmt.Printf("UNKNOWN: unable to retrieve firmware information: %s\n", err)
+		return err
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	signed, missing := 0, 0
+
+	for i := range selected {
+		if information[i] == nil {
+			fmt.Printf("UNKNOWN: unable to retrieve firmwares for %s\n", selected[i].Identifier)
+			return fmt.Errorf("unable to retrieve firmwares for %s", selected[i].Identifier)
+		}
+
+		for index, ipsw := range information[i].Firmwares {
+			if !ipsw.Signed || !firmwareSelected(index, &ipsw) {
+				continue
+			}
+
+			signed++
+
+			path, err := firmwarePath(&ipsw, &selected[i])
+
+			if err != nil {
+				return err
+			}
+
+			present, err := firmwareStored(path)
+
+			if err != nil {
+				return err
+			}
+
+			if !present {
+				warnf("%s %s (%s) is signed, but hasn't been downloaded", ipsw.Identifier, ipsw.Version, ipsw.BuildID)
+				missing++
+			}
+		}
+	}
+
+	if missing > 0 {
+		fmt.Printf("CRITICAL: %d of %d signed firmware(s) missing\n", missing, signed)
+		return fmt.Errorf("%d of %d signed firmware(s) missing", missing, signed)
+	}
+
+	fmt.Printf("OK: all %d signed firmware(s) for %d device(s) downloaded\n", signed, len(selected))
+
+	return nil
+}
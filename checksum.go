@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// firmwareChecksum is a checksum which a firmware can be verified against
+type firmwareChecksum struct {
+	algorithm string
+	expected  string
+	new       func() hash.Hash
+
+	// weak is set for checksums which only detect corruption, not tampering
+	weak bool
+}
+
+// validChecksum reports whether sum looks like a hex encoded checksum of size bytes.
+func validChecksum(sum string, size int) bool {
+	b, err := hex.DecodeString(sum)
+
+	return err == nil && len(b) == size
+}
+
+// checksumFor returns the checksum to verify fw against: its SHA-1 or, for old firmwares whose SHA-1
+// is missing or malformed in the API, its MD5. It returns false if fw has neither.
+func checksumFor(fw *api.Firmware) (firmwareChecksum, bool) {
+	if validChecksum(fw.SHA1Sum, sha1.Size) {
+		return firmwareChecksum{algorithm: "sha1", expected: fw.SHA1Sum, new: sha1.New}, true
+	}
+
+	if validChecksum(fw.MD5Sum, md5.Size) {
+		return firmwareChecksum{algorithm: "md5", expected: fw.MD5Sum, new: md5.New, weak: true}, true
+	}
+
+	return firmwareChecksum{}, false
+}
+
+// changedChecksums are the SHA-1s recorded in the catalog for firmwares whose SHA-1 has since changed upstream, by path
+var changedChecksums = make(map[string]string)
+
+// checkRecordedChecksum flags fw, stored at path, if its SHA-1 upstream differs from the one recorded in the catalog.
+func checkRecordedChecksum(recorded map[string]*api.Firmware, fw *api.Firmware, path string) {
+	old, ok := recorded[pinKey(fw.Identifier, fw.BuildID)]
+
+	if !ok || old.SHA1Sum == "" || strings.EqualFold(old.SHA1Sum, fw.SHA1Sum) {
+		return
+	}
+
+	warnf("The SHA1 of %s %s (%s) has changed upstream from %s to %s", fw.Identifier, fw.Version, fw.BuildID, old.SHA1Sum, fw.SHA1Sum)
+	changedChecksums[path] = old.SHA1Sum
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// chunklistSuffix is the suffix of the copy of a macOS firmware's chunklist kept beside it (w/ -chunklist)
+const chunklistSuffix = ".chunklist"
+
+const (
+	chunklistMagic        = 0x4C4B4E43 // CNKL
+	chunklistHeaderSize   = 36
+	chunklistEntrySize    = 36 // the size of a chunk, and its SHA-256
+	chunklistMethodSHA256 = 1
+	chunklistMaxSize      = 16 << 20
+)
+
+// errNoChunklist is returned when Apple doesn't publish a chunklist for a firmware
+var errNoChunklist = errors.New("no chunklist")
+
+// chunklistKey is the RSA key chunklists are signed with (w/ -chunklist-key)
+var chunklistKey *rsa.PublicKey
+
+// chunklistChunk is a chunk of a firmware, and its SHA-256
+type chunklistChunk struct {
+	Size   uint32
+	SHA256 [sha256.Size]byte
+}
+
+// chunklist is Apple's list of the chunks of a file, and their hashes, signed by Apple
+type chunklist struct {
+	Chunks    []chunklistChunk
+	signed    []byte
+	signature []byte
+}
+
+// loadChunklistKey reads the -chunklist-key, a PEM RSA public key.
+func loadChunklistKey() error {
+	chunklistKey = nil
+
+	if chunklistKeyPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(chunklistKeyPath)
+
+	if err != nil {
+		return fmt.Errorf("unable to read -chunklist-key: %s, err: %s", chunklistKeyPath, err)
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return fmt.Errorf("-chunklist-key is not a PEM key: %s", chunklistKeyPath)
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		chunklistKey = key
+		return nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return fmt.Errorf("unable to parse -chunklist-key: %s, err: %s", chunklistKeyPath, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+
+	if !ok {
+		return fmt.Errorf("-chunklist-key is not an RSA key: %s", chunklistKeyPath)
+	}
+
+	chunklistKey = rsaKey
+
+	return nil
+}
+
+// isMacFirmware returns whether the device is a Mac, whose restore firmwares Apple publishes chunklists for.
+func isMacFirmware(device *api.BaseDevice) bool {
+	for _, prefix := range []string{"Mac", "iMac", "VirtualMac", "ADP"} {
+		if strings.HasPrefix(device.Identifier, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chunklistURL returns where Apple publishes the chunklist of fw, beside the firmware.
+func chunklistURL(fw *api.Firmware) string {
+	return strings.TrimSuffix(fw.URL, filepath.Ext(fw.URL)) + chunklistSuffix
+}
+
+// parseChunklist parses a chunklist, checking its header.
+func parseChunklist(data []byte) (*chunklist, error) {
+	if len(data) < chunklistHeaderSize || binary.LittleEndian.Uint32(data) != chunklistMagic {
+		return nil, errors.New("not a chunklist")
+	}
+
+	headerSize := binary.LittleEndian.Uint32(data[4:])
+	chunkMethod := data[9]
+	count := binary.LittleEndian.Uint64(data[12:])
+	chunkOffset := binary.LittleEndian.Uint64(data[20:])
+	signatureOffset := binary.LittleEndian.Uint64(data[28:])
+
+	if headerSize != chunklistHeaderSize || chunkMethod != chunklistMethodSHA256 {
+		return nil, fmt.Errorf("unsupported chunklist (header size %d, chunk method %d)", headerSize, chunkMethod)
+	}
+
+	if chunkOffset > uint64(len(data)) || count > (uint64(len(data))-chunkOffset)/chunklistEntrySize || signatureOffset > uint64(len(data)) {
+		return nil, errors.New("truncated chunklist")
+	}
+
+	list := &chunklist{signed: data[:signatureOffset], signature: data[signatureOffset:]}
+
+	for i := uint64(0); i < count; i++ {
+		entry := data[chunkOffset+i*chunklistEntrySize:]
+
+		chunk := chunklistChunk{Size: binary.LittleEndian.Uint32(entry)}
+		copy(chunk.SHA256[:], entry[4:chunklistEntrySize])
+
+		list.Chunks = append(list.Chunks, chunk)
+	}
+
+	return list, nil
+}
+
+// checkSignature checks the chunklist's signature with the -chunklist-key, if there is one.
+func (c *chunklist) checkSignature() error {
+	if chunklistKey == nil {
+		return nil
+	}
+
+	hash := sha256.Sum256(c.signed)
+
+	if rsa.VerifyPKCS1v15(chunklistKey, crypto.SHA256, hash[:], c.signature) == nil {
+		return nil
+	}
+
+	// some chunklists store the signature little-endian
+	reversed := make([]byte, len(c.signature))
+
+	for i, b := range c.signature {
+		reversed[len(reversed)-1-i] = b
+	}
+
+	if rsa.VerifyPKCS1v15(chunklistKey, crypto.SHA256, hash[:], reversed) == nil {
+		return nil
+	}
+
+	return errors.New("the chunklist's signature doesn't match -chunklist-key")
+}
+
+// fetchChunklist returns the chunklist of a job's firmware, from the copy beside it if there is one, otherwise from
+// Apple, keeping a copy once its signature has been checked.
+func fetchChunklist(job *downloadJob) (*chunklist, error) {
+	path := job.Path + chunklistSuffix
+
+	if data, err := ioutil.ReadFile(path); err == nil {
+		list, err := parseChunklist(data)
+
+		if err == nil {
+			err = list.checkSignature()
+		}
+
+		if err == nil {
+			return list, nil
+		}
+
+		warnf("Ignoring chunklist: %s, err: %s", path, err)
+	}
+
+	url := chunklistURL(&job.Firmware)
+	resp, err := downloadClient.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return nil, errNoChunklist
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download chunklist: %s, status: %s", url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, chunklistMaxSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := parseChunklist(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse chunklist: %s, err: %s", url, err)
+	}
+
+	if err := list.checkSignature(); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path, data, fileMode); err != nil {
+		warnf("Unable to save chunklist: %s, err: %s", path, err)
+	}
+
+	return list, nil
+}
+
+// checkChunklist checks the SHA-256 of each chunk of a job's firmware against the chunklist Apple publishes with it,
+// showing progress after prefix, returning whether it matches, or errNoChunklist if there isn't one.
+func checkChunklist(job *downloadJob, prefix string) (bool, error) {
+	list, err := fetchChunklist(job)
+
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(job.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	total := int64(0)
+
+	for _, chunk := range list.Chunks {
+		total += int64(chunk.Size)
+	}
+
+	if info, err := f.Stat(); err != nil {
+		return false, err
+	} else if info.Size() != total {
+		errorf("%s is %d bytes, but its chunklist is of %d", filepath.Base(job.Path), info.Size(), total)
+		return false, nil
+	}
+
+	bar := newProgressBar(total, filepath.Base(job.Path)+" (chunklist)").Prefix(prefix + "chunklist ")
+	bar.Start()
+	defer bar.Finish()
+
+	hash := sha256.New()
+	offset := int64(0)
+
+	for i, chunk := range list.Chunks {
+		hash.Reset()
+
+		if _, err := io.CopyN(io.MultiWriter(hash, bar, aliveWriter{}), f, int64(chunk.Size)); err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(hash.Sum(nil), chunk.SHA256[:]) {
+			errorf("Chunk %d of %s (at %d bytes) doesn't match its chunklist", i, filepath.Base(job.Path), offset)
+			return false, nil
+		}
+
+		offset += int64(chunk.Size)
+	}
+
+	return true, nil
+}
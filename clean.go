@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// queuedDownloadPaths returns the paths downloaded to by every queued download: those planned under the flags, those
+// saved by an interrupted run and the failures waiting to be retried. incomplete is set if downloads couldn't be planned.
+func queuedDownloadPaths() (paths map[string]bool, incomplete bool, err error) {
+	var jobs []downloadJob
+
+	planned, err := planDownloads(false)
+
+	if err != nil {
+		warnf("Unable to plan downloads, only interrupted and failed downloads are queued, err: %s", err)
+		incomplete = true
+	}
+
+	jobs = append(jobs, planned...)
+
+	// not loadResumeState, which consumes the state
+	var state resumeState
+
+	if err := readJSONFile(resumeStatePath(), &state); err != nil && !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("unable to read resume state: %s, err: %s", resumeStatePath(), err)
+	}
+
+	jobs = append(jobs, state.Jobs...)
+
+	failures, err := loadFailures()
+
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read failure queue: %s, err: %s", failuresPath(), err)
+	}
+
+	for _, failure := range failures {
+		jobs = append(jobs, failure.downloadJob)
+	}
+
+	paths = make(map[string]bool)
+
+	for i := range jobs {
+		paths[filepath.Clean(jobDownloadPath(&jobs[i]))] = true
+	}
+
+	return paths, incomplete, nil
+}
+
+// partialFirmware returns the path of the firmware a partial download (or its hash state) is for, or "" for a
+// temporary file, which can't be resumed.
+func partialFirmware(path string) string {
+	if !strings.HasSuffix(path, partialSuffix) {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(path, partialSuffix), ".sha1state")
+}
+
+// cleanCommand removes the partial downloads and temporary files in the download root which haven't been modified for
+// -clean-age, left behind by crashes, except the partial downloads of queued downloads, which are resumed instead.
+// W/ -dry-run, they are just listed.
+func cleanCommand() error {
+	if destination != nil {
+		return errors.New("only local files can be cleaned, not those in -dest")
+	}
+
+	queued, incomplete, err := queuedDownloadPaths()
+
+	if err != nil {
+		return err
+	}
+
+	if incomplete && !dryRun {
+		return errors.New("not removing files, as the queued downloads couldn't be planned")
+	}
+
+	var stale []string
+	var size, resumableSize int64
+	resumable := 0
+
+	err = walkTemporary(func(path string, info os.FileInfo) error {
+		if time.Since(info.ModTime()) < cleanAge {
+			debugf("%s was modified less than %s ago, keeping it", path, cleanAge)
+			return nil
+		}
+
+		if firmware := partialFirmware(path); firmware != "" && queued[filepath.Clean(firmware)] {
+			debugf("%s can be resumed, keeping it", path)
+			resumable++
+			resumableSize += info.Size()
+			return nil
+		}
+
+		stale = append(stale, path)
+		size += info.Size()
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, path := range stale {
+		if dryRun {
+			fmt.Println(path)
+			continue
+		}
+
+		infof("Removing %s", path)
+
+		root, _, ok := poolRelative(path)
+
+		if !ok {
+			root = downloadRoot()
+		}
+
+		if err := removeWithEmptyParents(path, root); err != nil {
+			errorf("Unable to remove %s, err: %s", path, err)
+			failed++
+		}
+	}
+
+	if resumable > 0 {
+		infof("Kept %d partial download(s) which can be resumed, %s", resumable, humanize.Bytes(uint64(resumableSize)))
+	}
+
+	if dryRun {
+		infof("%d stale file(s), %s", len(stale), humanize.Bytes(uint64(size)))
+	} else {
+		infof("Removed %d stale file(s), %s, %d could not be removed", len(stale)-failed, humanize.Bytes(uint64(size)), failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to remove %d file(s)", failed)
+	}
+
+	return nil
+}
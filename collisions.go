@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// checkCollisions validates -on-collision.
+func checkCollisions() error {
+	if onCollision != "skip" && onCollision != "fail" {
+		return fmt.Errorf("unknown -on-collision: %s, use skip or fail", onCollision)
+	}
+
+	return nil
+}
+
+// sameFirmwareFile returns whether a and b are the same file, e.g. a firmware shared by several devices.
+func sameFirmwareFile(a, b *api.Firmware) bool {
+	return a.URL == b.URL || (a.SHA1Sum != "" && strings.EqualFold(a.SHA1Sum, b.SHA1Sum))
+}
+
+// claim records that fw is saved to path, returning false (with an error) if a different firmware already is, in
+// which case fw is skipped.
+func (p *downloadPlanner) claim(path string, fw *api.Firmware) bool {
+	path = filepath.Clean(path)
+	other, ok := p.claimed[path]
+
+	if !ok {
+		claimed := *fw
+		p.claimed[path] = &claimed
+
+		return true
+	}
+
+	if sameFirmwareFile(other, fw) {
+		return true
+	}
+
+	errorf("%s %s (%s) and %s %s (%s) would both be saved as %s, skipping the latter: use -prefix-identifier, or a -d or -filename which tells them apart",
+		other.Identifier, other.Version, other.BuildID, fw.Identifier, fw.Version, fw.BuildID, path)
+
+	p.collisions++
+
+	return false
+}
+
+// collisionError returns an error if firmwares were skipped because they would be saved to the same path as others,
+// w/ -on-collision fail.
+func (p *downloadPlanner) collisionError() error {
+	if p.collisions == 0 || onCollision != "fail" {
+		return nil
+	}
+
+	return fmt.Errorf("%d firmware(s) would be saved to the same path as others (-on-collision fail)", p.collisions)
+}
+
+// warnIfRecordedAsOther warns when the file at path, which is about to be skipped as already downloaded, was
+// downloaded as a firmware with a different SHA1 than fw, so it may not be fw at all.
+func (p *downloadPlanner) warnIfRecordedAsOther(path string, fw *api.Firmware) {
+	record, ok := p.records[filepath.Clean(path)]
+
+	if !ok || record.SHA1 == "" || fw.SHA1Sum == "" || strings.EqualFold(record.SHA1, fw.SHA1Sum) {
+		return
+	}
+
+	warnf("%s was downloaded as a firmware with SHA1 %s, not that of %s %s (%s), %s: it may be another device's firmware (see -prefix-identifier), or have changed upstream (see -refresh-changed)",
+		path, record.SHA1, fw.Identifier, fw.Version, fw.BuildID, fw.SHA1Sum)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configValues are the flags set by the -config file, by name, and commandLineFlags those given on the command line,
+// which the file doesn't override
+var (
+	configValues     map[string]string
+	commandLineFlags map[string]bool
+)
+
+// restartFlags are the flags which are only read when allthefirmwares starts, so reloading -config doesn't change them
+var restartFlags = map[string]bool{
+	"log-level": true, "log-file": true, "log-max-size": true, "log-max-backups": true, "syslog": true, "journald": true, "no-color": true,
+	"lock": true, "wait": true, "state-dir": true, "http2": true,
+	"listen": true, "api-token": true, "api-user": true, "api-password": true, "tls-cert": true, "tls-key": true,
+	"limit-rate": true, "rate-schedule": true, "download-window": true,
+	"slack-webhook": true, "discord-webhook": true, "telegram-token": true, "telegram-chat": true,
+	"email-to": true, "email-from": true, "smtp-server": true, "smtp-user": true, "smtp-password": true, "email-digest": true,
+	"notify-exec": true, "desktop-notify": true, "notify-events": true, "notify-template": true,
+	"mqtt-broker": true, "mqtt-topic": true, "mqtt-user": true, "mqtt-password": true,
+}
+
+// repeatableFlags are the flags which can be on several lines of the -config file, whose values are joined with the
+// given separator
+var repeatableFlags = map[string]string{
+	"device-versions": "; ",
+}
+
+// reloadRequested wakes the daemon to reload -config
+var reloadRequested = make(chan struct{}, 1)
+
+// requestReload asks the daemon to reload -config before its next run, if a reload isn't already pending.
+func requestReload() {
+	select {
+	case reloadRequested <- struct{}{}:
+	default:
+	}
+}
+
+// readConfigFile parses a -config file, which has a flag per line as name=value (or name value), with or without its
+// dash. A name on its own sets a boolean flag, and lines starting with # are comments. The repeatableFlags can be set
+// on several lines.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value := text, "true"
+
+		if i := strings.IndexAny(text, "= \t"); i >= 0 {
+			name = text[:i]
+			value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[i:]), "="))
+
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+
+		name = strings.TrimLeft(name, "-")
+
+		if name == "config" {
+			return nil, fmt.Errorf("line %d: -config can't be set in the config file", line)
+		} else if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("line %d: unknown flag: %s", line, name)
+		}
+
+		if separator, ok := repeatableFlags[name]; ok && values[name] != "" {
+			value = values[name] + separator + value
+		}
+
+		values[name] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// loadConfig sets the flags in the -config file which weren't given on the command line.
+func loadConfig() error {
+	if configPath == "" {
+		return nil
+	}
+
+	commandLineFlags = make(map[string]bool)
+
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	_, err := applyConfig()
+
+	return err
+}
+
+// applyConfig reads the -config file and sets its flags, resetting those it no longer sets to their defaults. It
+// returns the names of the flags which changed.
+func applyConfig() ([]string, error) {
+	values, err := readConfigFile(configPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %s, err: %s", configPath, err)
+	}
+
+	// the devices are read by the control API
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	var changed []string
+
+	set := func(name, value string) error {
+		f := flag.Lookup(name)
+
+		if f.Value.String() == value {
+			return nil
+		}
+
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid -%s in %s: %s, err: %s", name, configPath, value, err)
+		}
+
+		changed = append(changed, name)
+
+		return nil
+	}
+
+	for name := range configValues {
+		if _, ok := values[name]; !ok && !commandLineFlags[name] {
+			if err := set(name, flag.Lookup(name).DefValue); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, value := range values {
+		if commandLineFlags[name] {
+			continue
+		}
+
+		if err := set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	configValues = values
+	sort.Strings(changed)
+
+	return changed, nil
+}
+
+// reloadConfig applies changes to the -config file, which only happens between daemon runs, so that downloads aren't
+// interrupted. If the file or its flags are invalid, the current configuration is kept.
+func reloadConfig() {
+	previous := make(map[string]string)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		previous[f.Name] = f.Value.String()
+	})
+
+	previousValues := configValues
+
+	changed, err := applyConfig()
+
+	if err == nil {
+		err = setupFlags()
+	}
+
+	if err == nil {
+		_, err = parseDaemonSchedule()
+	}
+
+	if err != nil {
+		errorf("Unable to reload %s, keeping the current configuration, err: %s", configPath, err)
+
+		devicesMu.Lock()
+
+		for name, value := range previous {
+			if flag.Lookup(name).Value.String() != value {
+				flag.Set(name, value)
+			}
+		}
+
+		devicesMu.Unlock()
+
+		configValues = previousValues
+
+		if err := setupFlags(); err != nil {
+			errorf("Unable to restore the configuration, err: %s", err)
+		}
+
+		return
+	}
+
+	if len(changed) == 0 {
+		infof("Reloaded %s, nothing changed", configPath)
+		return
+	}
+
+	infof("Reloaded %s, changed: -%s", configPath, strings.Join(changed, ", -"))
+
+	for _, name := range changed {
+		if restartFlags[name] {
+			warnf("-%s only takes effect when allthefirmwares is restarted", name)
+		}
+	}
+}
+
+// watchConfig requests a reload when the -config file is modified, checking every interval.
+func watchConfig(interval time.Duration) error {
+	info, err := os.Stat(configPath)
+
+	if err != nil {
+		return fmt.Errorf("unable to watch config: %s, err: %s", configPath, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return errors.New("-config must be a regular file")
+	}
+
+	go func() {
+		modified, size := info.ModTime(), info.Size()
+
+		for range time.Tick(interval) {
+			info, err := os.Stat(configPath)
+
+			if err != nil || (info.ModTime().Equal(modified) && info.Size() == size) {
+				continue
+			}
+
+			modified, size = info.ModTime(), info.Size()
+
+			debugf("%s was modified", configPath)
+			requestReload()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// confirmDownload asks whether to download jobs, showing how many firmwares and how much data they are, if they are at
+// least -confirm-over and allthefirmwares is running interactively (and -yes isn't set). It returns false if the
+// answer was no.
+func confirmDownload(jobs []downloadJob) bool {
+	if assumeYes || confirmOver == 0 || len(jobs) == 0 || !isTerminal(os.Stdin) {
+		return true
+	}
+
+	size := uint64(0)
+	devices := make(map[string]bool)
+
+	for _, job := range jobs {
+		size += job.Firmware.Filesize
+		devices[job.Device.Identifier] = true
+	}
+
+	if size < confirmOver {
+		return true
+	}
+
+	summary := fmt.Sprintf("About to download %d firmware(s) for %d device(s), %s", len(jobs), len(devices), humanize.Bytes(size))
+
+	if free, err := poolFree(); err == nil && destination == nil {
+		summary += fmt.Sprintf(", with %s free", humanize.Bytes(free))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s. Continue? [y/N] ", summary)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// rescanRequested wakes the daemon to start a new run immediately
+var rescanRequested = make(chan struct{}, 1)
+
+// requestRescan asks the daemon to start a new run, if one isn't already pending.
+func requestRescan() {
+	select {
+	case rescanRequested <- struct{}{}:
+	default:
+	}
+}
+
+// controlHandler returns the handler for the daemon's control API.
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentStatus.snapshot())
+	})
+
+	mux.HandleFunc("/api/queue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentStatus.remainingQueue())
+	})
+
+	mux.HandleFunc("/api/progress", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentStatus.snapshot().Active)
+	})
+
+	mux.HandleFunc("/api/events", eventsHandler)
+
+	mux.HandleFunc("/api/coverage", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentStatus.getCoverage())
+	})
+
+	mux.HandleFunc("/api/calendar.ics", calendarHandler)
+
+	mux.HandleFunc("/api/failures", func(w http.ResponseWriter, r *http.Request) {
+		failures, err := loadFailures()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// most recent first
+		sort.Slice(failures, func(i, j int) bool {
+			return failures[i].LastAttempt.After(failures[j].LastAttempt)
+		})
+
+		if failures == nil {
+			failures = []failedDownload{}
+		}
+
+		writeJSON(w, http.StatusOK, failures)
+	})
+
+	mux.HandleFunc("/api/rescan", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		requestRescan()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "rescan requested"})
+	}))
+
+	mux.HandleFunc("/api/pause", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		setPaused("requested via control API", true)
+		writeJSON(w, http.StatusOK, currentStatus.snapshot())
+	}))
+
+	mux.HandleFunc("/api/resume", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		setPaused("requested via control API", false)
+		writeJSON(w, http.StatusOK, currentStatus.snapshot())
+	}))
+
+	mux.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, selectedDevices())
+
+		case http.MethodPost:
+			var body struct {
+				Identifier string `json:"identifier"`
+			}
+
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Identifier) == "" {
+				http.Error(w, "expected a JSON body with an identifier", http.StatusBadRequest)
+				return
+			}
+
+			if len(selectedDevices()) == 0 {
+				writeJSON(w, http.StatusOK, map[string]string{"status": "all devices are already selected"})
+				return
+			}
+
+			if !addDevice(strings.TrimSpace(body.Identifier)) {
+				writeJSON(w, http.StatusOK, map[string]string{"status": "device already selected"})
+				return
+			}
+
+			infof("Added device %s via control API", body.Identifier)
+			requestRescan()
+
+			writeJSON(w, http.StatusCreated, map[string]string{"status": "device added"})
+
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.Handle("/", dashboardHandler())
+
+	return mux
+}
+
+func postOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		debugf("Unable to write response, err: %s", err)
+	}
+}
+
+// startControlServer serves the control API on -listen, if set, over TLS if a certificate is given.
+func startControlServer() {
+	if listenAddress == "" {
+		return
+	}
+
+	loadHTTPAuthFromEnvironment()
+
+	if !httpAuthConfigured() {
+		warnf("The control API on %s is unauthenticated, consider -api-token or -api-user/-api-password", listenAddress)
+	}
+
+	mux := http.NewServeMux()
+
+	// health checks come from orchestrators and monitoring, which shouldn't need credentials
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.Handle("/", requireAuth(controlHandler()))
+
+	handler := http.Handler(mux)
+
+	go func() {
+		err := listenAndServe("control API", handler)
+
+		errorf("Control API stopped, err: %s", err)
+	}()
+}
+
+// listenAndServe serves handler on -listen, over TLS if -tls-cert and -tls-key are given.
+func listenAndServe(name string, handler http.Handler) error {
+	if tlsCertificate != "" || tlsKey != "" {
+		infof("Serving %s on https://%s", name, listenAddress)
+		return http.ListenAndServeTLS(listenAddress, tlsCertificate, tlsKey, handler)
+	}
+
+	infof("Serving %s on http://%s", name, listenAddress)
+
+	return http.ListenAndServe(listenAddress, handler)
+}
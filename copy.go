@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dustin/go-humanize"
+)
+
+// bufferSize is the parsed value of -buffer-size
+var bufferSize = 1024 * 1024
+
+// parseBufferSize parses the -buffer-size flag.
+func parseBufferSize() error {
+	size, err := humanize.ParseBytes(bufferSizeValue)
+
+	if err != nil || size < 4096 || size > 256*1024*1024 {
+		return fmt.Errorf("invalid -buffer-size: %s, it must be between 4KiB and 256MiB", bufferSizeValue)
+	}
+
+	bufferSize = int(size)
+
+	return nil
+}
+
+// progressWriter writes to w, reporting progress to callback after each write, and stopping the copy
+// when a shutdown is requested, the download is skipped or paused, or more than limit (if set) would be written.
+type progressWriter struct {
+	w          io.Writer
+	downloaded int64
+	total      int64
+	limit      int64
+	callback   func(n int, downloaded, total int64)
+
+	// skip is the skipGeneration when the download started
+	skip uint64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.limit > 0 && p.downloaded+int64(len(b)) > p.limit {
+		return 0, errOversized
+	}
+
+	downloadRate.wait(len(b))
+	diskRate.wait(len(b))
+
+	n, err := p.w.Write(b)
+
+	if err != nil {
+		return n, err
+	}
+
+	p.downloaded += int64(n)
+
+	if p.callback != nil {
+		p.callback(n, p.downloaded, p.total)
+	}
+
+	if shutdownRequested() {
+		return n, errShutdown
+	} else if skipRequested(p.skip) {
+		return n, errSkipped
+	} else if isPaused() {
+		return n, errPaused
+	}
+
+	return n, nil
+}
+
+// readAheadBuffers is how many -buffer-size buffers copyReadAhead reads ahead into
+const readAheadBuffers = 4
+
+// readAheadChunk is a buffer read by copyReadAhead
+type readAheadChunk struct {
+	b   []byte
+	n   int
+	err error
+}
+
+// copyReadAhead copies src to dst like io.Copy, but reads src in another goroutine, up to readAheadBuffers buffers
+// ahead, so that reading (e.g. from disk) and writing (e.g. hashing) overlap rather than taking turns.
+func copyReadAhead(dst io.Writer, src io.Reader) (int64, error) {
+	free := make(chan []byte, readAheadBuffers)
+	filled := make(chan readAheadChunk, readAheadBuffers)
+	done := make(chan struct{})
+
+	defer close(done)
+
+	for i := 0; i < readAheadBuffers; i++ {
+		free <- make([]byte, bufferSize)
+	}
+
+	go func() {
+		defer close(filled)
+
+		for {
+			var b []byte
+
+			select {
+			case b = <-free:
+			case <-done:
+				return
+			}
+
+			n, err := io.ReadFull(src, b)
+			diskRate.wait(n)
+
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+
+			select {
+			case filled <- readAheadChunk{b: b, n: n, err: err}:
+			case <-done:
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	written := int64(0)
+
+	for chunk := range filled {
+		if chunk.n > 0 {
+			n, err := dst.Write(chunk.b[:chunk.n])
+			written += int64(n)
+
+			// e.g. verifying a large firmware on a slow disk is progress to the watchdog
+			markAlive()
+
+			if err != nil {
+				return written, err
+			}
+		}
+
+		if chunk.err == io.EOF {
+			break
+		} else if chunk.err != nil {
+			return written, chunk.err
+		}
+
+		free <- chunk.b
+	}
+
+	return written, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// coverageFirmware is a firmware in the coverage report, whose Status is present, missing or unsigned (missing, and
+// no longer signed, so it can't be restored even if it's downloaded)
+type coverageFirmware struct {
+	Identifier, Name, Version, BuildID string
+	Status                             string
+	Signed                             bool
+	Size                               uint64
+	Path                               string
+	Verified                           time.Time
+}
+
+// coverageDevice is a device's row of the coverage matrix
+type coverageDevice struct {
+	Identifier, Name         string
+	Present, Total           int
+	PresentSize, MissingSize uint64
+	OldestVerified           string
+	Cells                    []coverageCell
+
+	cells          map[string]*coverageCell
+	oldestVerified time.Time
+}
+
+// coverageCell is the firmwares of a device with a version, of which the best status is shown
+type coverageCell struct {
+	Status, Title string
+}
+
+// coverageReport is what the HTML coverage report is rendered from
+type coverageReport struct {
+	Generated              string
+	Versions               []string
+	Devices                []*coverageDevice
+	Present, Total         int
+	PresentSize, TotalSize uint64
+}
+
+// coverageStatusRank orders statuses, so that a cell shows the best of its firmwares
+var coverageStatusRank = map[string]int{"unsigned": 1, "missing": 2, "present": 3}
+
+// reportCommand writes a coverage report of the firmwares in the catalog matching the flags: whether each has been
+// downloaded, its size and when it last passed verification, as CSV, or as an HTML matrix of devices and versions if
+// -o ends in .html.
+func reportCommand() error {
+	firmwares, err := coverageFirmwares()
+
+	if err != nil {
+		return err
+	}
+
+	if len(firmwares) == 0 {
+		return errors.New("no firmwares in the catalog match the flags, run download (or download -c) first")
+	}
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+
+	if ext := strings.ToLower(filepath.Ext(exportOutput)); ext == ".html" || ext == ".htm" {
+		err = writeCoverageHTML(w, firmwares)
+	} else {
+		err = writeCoverageCSV(w, firmwares)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// coverageFirmwares returns the firmwares of the catalog matching the flags, by device, newest first.
+func coverageFirmwares() ([]coverageFirmware, error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	records, err := loadDownloadRecords()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read download records: %s, err: %s", downloadRecordsPath(), err)
+	}
+
+	var firmwares []coverageFirmware
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+
+		if !deviceSelected(device.Identifier) || !deviceAttributesSelected(&device.BaseDevice) {
+			continue
+		}
+
+		sortFirmwares(device)
+
+		for index := range device.Firmwares {
+			fw := &device.Firmwares[index]
+
+			if !firmwareSelected(index, fw) {
+				continue
+			}
+
+			path, err := firmwarePath(fw, &device.BaseDevice)
+
+			if err != nil {
+				return nil, err
+			}
+
+			present, err := firmwareStored(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			status := "present"
+
+			if !present && fw.Signed {
+				status = "missing"
+			} else if !present {
+				status = "unsigned"
+			}
+
+			firmwares = append(firmwares, coverageFirmware{
+				Identifier: device.Identifier,
+				Name:       device.Name,
+				Version:    fw.Version,
+				BuildID:    fw.BuildID,
+				Status:     status,
+				Signed:     fw.Signed,
+				Size:       fw.Filesize,
+				Path:       path,
+				Verified:   records[filepath.Clean(path)].Verified,
+			})
+		}
+	}
+
+	return firmwares, nil
+}
+
+// writeCoverageCSV writes a row for each firmware.
+func writeCoverageCSV(w io.Writer, firmwares []coverageFirmware) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"identifier", "name", "version", "buildid", "status", "signed", "size", "path", "last_verified"}); err != nil {
+		return err
+	}
+
+	for _, fw := range firmwares {
+		verified := ""
+
+		if !fw.Verified.IsZero() {
+			verified = fw.Verified.UTC().Format(time.RFC3339)
+		}
+
+		row := []string{
+			fw.Identifier, fw.Name, fw.Version, fw.BuildID, fw.Status, strconv.FormatBool(fw.Signed),
+			strconv.FormatUint(fw.Size, 10), fw.Path, verified,
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Funcs(template.FuncMap{"bytes": humanize.Bytes}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Firmware archive coverage</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+	table { border-collapse: collapse; margin-bottom: 24px; font-size: 13px; }
+	th, td { text-align: left; padding: 4px 12px 4px 0; white-space: nowrap; }
+	table.matrix th.version { writing-mode: vertical-rl; transform: rotate(180deg); padding: 4px 2px; font-weight: normal; }
+	table.matrix td.cell { width: 14px; height: 14px; padding: 0; border: 1px solid #fff; }
+	.present { background: #3a9d5d; }
+	.missing { background: #d9534f; }
+	.unsigned { background: #f0ad4e; }
+	.legend span { display: inline-block; width: 12px; height: 12px; margin: 0 4px 0 12px; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>Firmware archive coverage</h1>
+<p>Generated {{.Generated}}: {{.Present}} of {{.Total}} firmwares downloaded ({{bytes .PresentSize}} of {{bytes .TotalSize}}).</p>
+<h2>Devices</h2>
+<table>
+<tr><th>Device</th><th>Downloaded</th><th>Size</th><th>Missing</th><th>Oldest verification</th></tr>
+{{range .Devices}}<tr><td>{{.Name}} ({{.Identifier}})</td><td>{{.Present}} of {{.Total}}</td><td>{{bytes .PresentSize}}</td><td>{{bytes .MissingSize}}</td><td>{{.OldestVerified}}</td></tr>
+{{end}}</table>
+<h2>Versions</h2>
+<p class="legend"><span class="present"></span>downloaded<span class="missing"></span>missing<span class="unsigned"></span>missing, no longer signed</p>
+<table class="matrix">
+<tr><th></th>{{range .Versions}}<th class="version">{{.}}</th>{{end}}</tr>
+{{range .Devices}}<tr><th>{{.Name}}</th>{{range .Cells}}<td class="cell {{.Status}}" title="{{.Title}}"></td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeCoverageHTML writes a matrix of the devices and versions, and a summary of each device.
+func writeCoverageHTML(w io.Writer, firmwares []coverageFirmware) error {
+	report := coverageReport{Generated: time.Now().Format("2006-01-02 15:04 MST")}
+
+	var device *coverageDevice
+	versions := make(map[string]bool)
+
+	for _, fw := range firmwares {
+		if device == nil || device.Identifier != fw.Identifier {
+			device = &coverageDevice{Identifier: fw.Identifier, Name: fw.Name, cells: make(map[string]*coverageCell)}
+			report.Devices = append(report.Devices, device)
+		}
+
+		device.Total++
+		report.Total++
+		report.TotalSize += fw.Size
+
+		verified := "never"
+
+		if !fw.Verified.IsZero() {
+			verified = fw.Verified.Format("2006-01-02")
+		}
+
+		if fw.Status == "present" {
+			device.Present++
+			device.PresentSize += fw.Size
+			report.Present++
+			report.PresentSize += fw.Size
+
+			if fw.Verified.IsZero() {
+				device.OldestVerified = "never"
+			} else if device.OldestVerified != "never" && (device.oldestVerified.IsZero() || fw.Verified.Before(device.oldestVerified)) {
+				device.oldestVerified = fw.Verified
+				device.OldestVerified = verified
+			}
+		} else {
+			device.MissingSize += fw.Size
+		}
+
+		title := fmt.Sprintf("%s %s (%s): %s, %s", fw.Identifier, fw.Version, fw.BuildID, fw.Status, humanize.Bytes(fw.Size))
+
+		if fw.Status == "present" {
+			title += ", last verified " + verified
+		}
+
+		versions[fw.Version] = true
+		cell, ok := device.cells[fw.Version]
+
+		if !ok {
+			device.cells[fw.Version] = &coverageCell{Status: fw.Status, Title: title}
+			continue
+		}
+
+		cell.Title += "\n" + title
+
+		if coverageStatusRank[fw.Status] > coverageStatusRank[cell.Status] {
+			cell.Status = fw.Status
+		}
+	}
+
+	for version := range versions {
+		report.Versions = append(report.Versions, version)
+	}
+
+	sort.Slice(report.Versions, func(i, j int) bool {
+		return compareVersions(report.Versions[i], report.Versions[j]) > 0
+	})
+
+	for _, device := range report.Devices {
+		for _, version := range report.Versions {
+			if cell, ok := device.cells[version]; ok {
+				device.Cells = append(device.Cells, *cell)
+			} else {
+				device.Cells = append(device.Cells, coverageCell{})
+			}
+		}
+	}
+
+	return coverageTemplate.Execute(w, report)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// hostCredential is how download requests to a host are authenticated: with basic auth, or a bearer token
+type hostCredential struct {
+	scheme, user, password, token string
+}
+
+// downloadCredentials are the credentials for each host (lower case, with its port if one is given) read from
+// -credentials-file and ALLTHEFIRMWARES_CREDENTIALS
+var downloadCredentials map[string]hostCredential
+
+// loadDownloadCredentials reads the credentials for authenticated mirrors from -credentials-file and the
+// ALLTHEFIRMWARES_CREDENTIALS environment variable, whose entries take precedence. Each entry is on its own line (or, in
+// the environment variable, separated by semicolons), as "host basic user:password" or "host bearer token".
+func loadDownloadCredentials() error {
+	credentials := make(map[string]hostCredential)
+
+	if credentialsFile != "" {
+		b, err := os.ReadFile(credentialsFile)
+
+		if err != nil {
+			return fmt.Errorf("unable to read -credentials-file: %s, err: %s", credentialsFile, err)
+		}
+
+		if err := parseCredentials(string(b), "\n", credentials); err != nil {
+			return fmt.Errorf("invalid -credentials-file: %s, %s", credentialsFile, err)
+		}
+	}
+
+	if err := parseCredentials(os.Getenv("ALLTHEFIRMWARES_CREDENTIALS"), ";", credentials); err != nil {
+		return fmt.Errorf("invalid ALLTHEFIRMWARES_CREDENTIALS, %s", err)
+	}
+
+	downloadCredentials = credentials
+
+	return nil
+}
+
+// parseCredentials adds the entries of value, separated by separator, to credentials. Blank entries and those starting
+// with # are ignored. Errors never include the secrets.
+func parseCredentials(value, separator string, credentials map[string]hostCredential) error {
+	for i, entry := range strings.Split(value, separator) {
+		entry = strings.TrimSpace(entry)
+
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		fields := strings.Fields(entry)
+
+		if len(fields) != 3 {
+			return fmt.Errorf("entry %d: expected a host, basic or bearer, and the credentials", i+1)
+		}
+
+		host := strings.ToLower(fields[0])
+		credential := hostCredential{scheme: strings.ToLower(fields[1])}
+
+		switch credential.scheme {
+		case "basic":
+			var ok bool
+
+			if credential.user, credential.password, ok = cutString(fields[2], ":"); !ok {
+				return fmt.Errorf("entry %d (%s): expected user:password", i+1, host)
+			}
+		case "bearer":
+			credential.token = fields[2]
+		default:
+			return fmt.Errorf("entry %d (%s): unknown scheme: %s, use basic or bearer", i+1, host, fields[1])
+		}
+
+		credentials[host] = credential
+	}
+
+	return nil
+}
+
+// cutString splits s around the first instance of sep, as strings.Cut does.
+func cutString(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
+
+// credentialTransport authenticates requests to the hosts which have credentials, e.g. internal mirrors given with
+// -mirrors. Requests are matched by host, so credentials are never sent to another host a request is redirected to.
+type credentialTransport struct {
+	next http.RoundTripper
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credential, ok := downloadCredentials[strings.ToLower(req.URL.Host)]
+
+	if !ok {
+		credential, ok = downloadCredentials[strings.ToLower(req.URL.Hostname())]
+	}
+
+	if !ok || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+
+	if credential.scheme == "basic" {
+		req.SetBasicAuth(credential.user, credential.password)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+credential.token)
+	}
+
+	return t.next.RoundTrip(req)
+}
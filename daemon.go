@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// daemonCommand downloads (or checks, w/ -c) firmwares repeatedly, every -interval or according to -schedule, as a
+// Windows service if it was started as one (see install-service).
+func daemonCommand() error {
+	if runningAsService() {
+		return runService(runDaemon)
+	}
+
+	return runDaemon()
+}
+
+func runDaemon() error {
+	schedule, err := parseDaemonSchedule()
+
+	if err != nil {
+		return err
+	}
+
+	if configPath != "" {
+		if err := watchConfig(10 * time.Second); err != nil {
+			return err
+		}
+
+		handleReloadSignal()
+	}
+
+	// there's nobody to answer
+	assumeYes = true
+
+	startControlServer()
+	startWatchdog()
+	sdNotify("READY=1")
+
+	for {
+		select {
+		case <-reloadRequested:
+			reloadConfig()
+
+			if s, err := parseDaemonSchedule(); err == nil {
+				schedule = s
+			}
+		default:
+		}
+
+		resetRunLimits()
+		markAlive()
+		sdNotify("STATUS=Checking for new firmwares")
+
+		if currentReport == nil {
+			startRunReport("daemon")
+		}
+
+		if currentTrace == nil {
+			startRunTrace("daemon")
+		}
+
+		// a failed run shouldn't stop the daemon, the next one may succeed
+		err := downloadCommand()
+
+		if err != nil {
+			errorf("Download run failed, err: %s", err)
+		}
+
+		writeRunReport(err)
+		endRunTrace(err)
+
+		if shutdownRequested() {
+			sdNotify("STOPPING=1")
+			return nil
+		}
+
+		next := time.Now().Add(daemonInterval)
+
+		if schedule != nil {
+			if next = schedule.next(time.Now()); next.IsZero() {
+				return errors.New("schedule never runs again")
+			}
+		}
+
+		infof("Next run at %s", next.Format("2006-01-02 15:04"))
+		sdNotify("STATUS=Idle, next run at " + next.Format("2006-01-02 15:04"))
+		currentStatus.setPhase("idle")
+		currentStatus.setNextRun(next)
+
+		waitForNextRun(next)
+	}
+}
+
+// parseDaemonSchedule parses -schedule, returning nil if the daemon runs every -interval instead.
+func parseDaemonSchedule() (*cronSchedule, error) {
+	if daemonSchedule != "" {
+		return parseCronSchedule(daemonSchedule)
+	} else if daemonInterval <= 0 {
+		return nil, errors.New("-interval must be positive")
+	}
+
+	return nil, nil
+}
+
+// waitForNextRun sleeps until next, or until a rescan or reload is requested.
+func waitForNextRun(next time.Time) {
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		markAlive()
+
+		select {
+		case <-timer.C:
+			return
+		case <-rescanRequested:
+			infof("Rescan requested")
+			return
+		case <-reloadRequested:
+			// the reload is applied before the run it starts
+			requestReload()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// desktopNotificationTitle is the title of desktop notifications
+const desktopNotificationTitle = "allthefirmwares"
+
+// desktopNotifier returns a notifier which shows desktop notifications when the download queue completes or a download
+// fails (w/ -desktop-notify), if allthefirmwares is running interactively.
+func desktopNotifier() (notifier, bool) {
+	if !isTerminal(os.Stdin) {
+		debugf("Not running interactively, desktop notifications are disabled")
+		return notifier{}, false
+	}
+
+	return notifier{
+		name:   "desktop",
+		events: map[string]bool{"queue_completed": true, "download_failed": true},
+		send: func(_ event, message string) error {
+			return desktopNotificationCommand(message).Run()
+		},
+	}, true
+}
+
+// desktopNotificationCommand returns the command which shows a desktop notification on this platform.
+func desktopNotificationCommand(message string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("osascript", "-e", "display notification "+appleScriptQuote(message)+" with title "+appleScriptQuote(desktopNotificationTitle))
+	case "windows":
+		script := `Add-Type -AssemblyName System.Windows.Forms
+$n = New-Object System.Windows.Forms.NotifyIcon
+$n.Icon = [System.Drawing.SystemIcons]::Information
+$n.Visible = $true
+$n.ShowBalloonTip(10000, $env:NOTIFY_TITLE, $env:NOTIFY_MESSAGE, 'Info')
+Start-Sleep -Seconds 10
+$n.Dispose()`
+
+		// the title and message are passed in the environment, so they needn't be quoted for PowerShell
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+desktopNotificationTitle, "NOTIFY_MESSAGE="+message)
+
+		return cmd
+	default:
+		return exec.Command("notify-send", desktopNotificationTitle, message)
+	}
+}
+
+// appleScriptQuote quotes s as an AppleScript string.
+func appleScriptQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+var (
+	devicesMu sync.Mutex
+
+	// addedDevices are devices added while running, e.g. via the control API
+	addedDevices []string
+)
+
+// selectedDevices returns the devices given with -i and added while running. An empty list means all devices.
+func selectedDevices() []string {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	identifiers := []string{}
+
+	for _, part := range strings.Split(specifiedDevice, ",") {
+		part = strings.TrimSpace(part)
+
+		// identifiers contain commas themselves, e.g. iPhone10,3, so a part which is just a number ends the previous one
+		if _, err := strconv.Atoi(part); err == nil && len(identifiers) > 0 {
+			identifiers[len(identifiers)-1] += "," + part
+		} else if part != "" {
+			identifiers = append(identifiers, part)
+		}
+	}
+
+	return append(identifiers, addedDevices...)
+}
+
+// deviceSelected reports whether firmwares for the device with the given identifier should be downloaded.
+func deviceSelected(identifier string) bool {
+	identifiers := selectedDevices()
+
+	if len(identifiers) == 0 {
+		return true
+	}
+
+	for _, i := range identifiers {
+		if i == identifier {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addDevice adds a device to those selected. It returns false if the device was already selected.
+func addDevice(identifier string) bool {
+	if deviceSelected(identifier) {
+		return false
+	}
+
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	addedDevices = append(addedDevices, identifier)
+
+	return true
+}
+
+// deviceAttributeFilter is a condition on an attribute of a device, from -device-filter
+type deviceAttributeFilter struct {
+	attribute string
+
+	// patterns are glob patterns, any of which the attribute may match
+	patterns []string
+}
+
+// deviceFilters is the parsed value of -device-filter
+var deviceFilters []deviceAttributeFilter
+
+// deviceAttributes returns the values of a device's attribute which filters are matched against. Chip IDs can be
+// matched in decimal or hex, e.g. 32784 or 0x8010.
+func deviceAttributes(device *api.BaseDevice, attribute string) []string {
+	switch attribute {
+	case "identifier":
+		return []string{device.Identifier}
+	case "name":
+		return []string{device.Name}
+	case "boardconfig":
+		return []string{device.BoardConfig}
+	case "platform":
+		return []string{device.Platform}
+	case "cpid":
+		return []string{strconv.Itoa(device.CPID), fmt.Sprintf("0x%x", device.CPID)}
+	case "bdid":
+		return []string{strconv.Itoa(device.BDID), fmt.Sprintf("0x%x", device.BDID)}
+	}
+
+	return nil
+}
+
+// parseDeviceFilters parses -device-filter, e.g. "boardconfig=d*ap,platform=t8010|t8011".
+func parseDeviceFilters() error {
+	deviceFilters = nil
+
+	if deviceFilterValue == "" {
+		return nil
+	}
+
+	for _, part := range strings.Split(deviceFilterValue, ",") {
+		parts := strings.SplitN(part, "=", 2)
+
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid -device-filter: %s, expected e.g. boardconfig=d*ap,platform=t8010", deviceFilterValue)
+		}
+
+		f := deviceAttributeFilter{attribute: strings.ToLower(strings.TrimSpace(parts[0]))}
+
+		if deviceAttributes(&api.BaseDevice{}, f.attribute) == nil {
+			return fmt.Errorf("unknown -device-filter attribute: %s, use identifier, name, boardconfig, platform, cpid or bdid", parts[0])
+		}
+
+		for _, pattern := range strings.Split(parts[1], "|") {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid -device-filter pattern: %s, err: %s", pattern, err)
+			}
+
+			f.patterns = append(f.patterns, pattern)
+		}
+
+		deviceFilters = append(deviceFilters, f)
+	}
+
+	return nil
+}
+
+// deviceAttributesSelected reports whether the device matches every -device-filter (case insensitively).
+func deviceAttributesSelected(device *api.BaseDevice) bool {
+	for _, f := range deviceFilters {
+		if !f.matches(device) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f deviceAttributeFilter) matches(device *api.BaseDevice) bool {
+	for _, value := range deviceAttributes(device, f.attribute) {
+		for _, pattern := range f.patterns {
+			if ok, _ := path.Match(pattern, strings.ToLower(value)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
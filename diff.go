@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// diffEntry is a firmware which differs between the archive and upstream
+type diffEntry struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	Path       string `json:"path,omitempty"`
+
+	// Local and Upstream are the changed value (SHA1 or signing status), when it has changed
+	Local    string `json:"local,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// archiveDiff is the difference between the archive and upstream
+type archiveDiff struct {
+	// Missing are firmwares matching the flags upstream which haven't been downloaded
+	Missing []diffEntry `json:"missing"`
+
+	// Removed are firmwares in the catalog which are no longer listed upstream
+	Removed []diffEntry `json:"removed"`
+
+	// Extra are files in the archive which aren't any firmware listed upstream
+	Extra []string `json:"extra"`
+
+	ChecksumChanged []diffEntry `json:"checksum_changed"`
+	SigningChanged  []diffEntry `json:"signing_changed"`
+}
+
+func newDiffEntry(fw *api.Firmware, path string) diffEntry {
+	return diffEntry{Identifier: fw.Identifier, Version: fw.Version, BuildID: fw.BuildID, Path: path}
+}
+
+func signingState(signed bool) string {
+	if signed {
+		return "signed"
+	}
+
+	return "unsigned"
+}
+
+// diffArchive compares the archive and the catalog (as of the last run) with the firmware information upstream.
+func diffArchive() (*archiveDiff, error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	known := catalog.firmwares()
+
+	diff := &archiveDiff{}
+	upstream := make(map[string]bool)
+	paths := make(map[string]bool)
+
+	for i, device := range selected {
+		if information[i] == nil {
+			return nil, fmt.Errorf("unable to retrieve firmwares for %s", device.Identifier)
+		}
+
+		for index, ipsw := range information[i].Firmwares {
+			upstream[pinKey(ipsw.Identifier, ipsw.BuildID)] = true
+
+			path, err := firmwarePath(&ipsw, &selected[i])
+
+			if err != nil {
+				return nil, err
+			}
+
+			paths[filepath.Clean(path)] = true
+
+			if old, ok := known[pinKey(ipsw.Identifier, ipsw.BuildID)]; ok {
+				if old.SHA1Sum != ipsw.SHA1Sum {
+					entry := newDiffEntry(&ipsw, path)
+					entry.Local, entry.Upstream = old.SHA1Sum, ipsw.SHA1Sum
+					diff.ChecksumChanged = append(diff.ChecksumChanged, entry)
+				}
+
+				if old.Signed != ipsw.Signed {
+					entry := newDiffEntry(&ipsw, path)
+					entry.Local, entry.Upstream = signingState(old.Signed), signingState(ipsw.Signed)
+					diff.SigningChanged = append(diff.SigningChanged, entry)
+				}
+			}
+
+			if !firmwareSelected(index, &ipsw) {
+				continue
+			}
+
+			present, err := firmwareStored(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !present {
+				diff.Missing = append(diff.Missing, newDiffEntry(&ipsw, path))
+			}
+		}
+	}
+
+	for _, device := range catalog.Devices {
+		if !deviceSelected(device.Identifier) || !deviceAttributesSelected(&device.BaseDevice) {
+			continue
+		}
+
+		for _, fw := range device.Firmwares {
+			if !upstream[pinKey(fw.Identifier, fw.BuildID)] {
+				diff.Removed = append(diff.Removed, newDiffEntry(&fw, ""))
+			}
+		}
+	}
+
+	// with -i, the files of other devices would all look like extras
+	if specifiedDevice == "" {
+		err := walkArchive(func(path string, info os.FileInfo) error {
+			if !paths[filepath.Clean(path)] {
+				diff.Extra = append(diff.Extra, path)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+// diffCommand reports how the archive differs from upstream, as text or (w/ -json) JSON.
+func diffCommand() error {
+	diff, err := diffArchive()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(diff)
+	}
+
+	for _, entry := range diff.Missing {
+		fmt.Fprintf(out, "+ %s %s (%s) upstream, not downloaded\n", entry.Identifier, entry.Version, entry.BuildID)
+	}
+
+	for _, entry := range diff.Removed {
+		fmt.Fprintf(out, "- %s %s (%s) no longer listed upstream\n", entry.Identifier, entry.Version, entry.BuildID)
+	}
+
+	for _, path := range diff.Extra {
+		fmt.Fprintf(out, "? %s is not a firmware listed upstream\n", path)
+	}
+
+	for _, entry := range diff.ChecksumChanged {
+		fmt.Fprintf(out, "! %s %s (%s) SHA1 changed from %s to %s\n", entry.Identifier, entry.Version, entry.BuildID, entry.Local, entry.Upstream)
+	}
+
+	for _, entry := range diff.SigningChanged {
+		fmt.Fprintf(out, "~ %s %s (%s) is now %s\n", entry.Identifier, entry.Version, entry.BuildID, entry.Upstream)
+	}
+
+	infof("%d missing, %d removed upstream, %d extra, %d checksum change(s), %d signing change(s)",
+		len(diff.Missing), len(diff.Removed), len(diff.Extra), len(diff.ChecksumChanged), len(diff.SigningChanged))
+
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import (
+	"syscall"
+)
+
+// diskFree returns the number of bytes available to unprivileged users on the filesystem containing path.
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// diskFullErrors are the errors writing to a full filesystem fails with
+var diskFullErrors = []error{syscall.ENOSPC}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!windows
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space is not supported on " + runtime.GOOS)
+}
+
+var diskFullErrors []error
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// lowSpaceReason is why downloads are paused while the download root is low on space (w/ -min-free)
+const lowSpaceReason = "low on disk space"
+
+// spaceCheckInterval is how often free space is checked while downloading (w/ -min-free)
+const spaceCheckInterval = 10 * time.Second
+
+var (
+	// lowOnSpace is whether downloads are paused for lack of space
+	lowOnSpace   bool
+	lowOnSpaceMu sync.Mutex
+)
+
+// spaceEvent is the data of the disk_space_low and disk_space_recovered events
+type spaceEvent struct {
+	Root    string `json:"root"`
+	Free    string `json:"free"`
+	MinFree string `json:"min_free"`
+}
+
+// isDiskFull returns whether err is from writing to a full disk.
+func isDiskFull(err error) bool {
+	for _, diskFull := range diskFullErrors {
+		if errors.Is(err, diskFull) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// startSpaceMonitor pauses downloads while less than -min-free is free on every root of the pool, and resumes them
+// once enough has been freed, until stop is closed.
+func startSpaceMonitor(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(spaceCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			checkFreeSpace()
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// checkFreeSpace pauses or resumes downloads depending on the free space of the root of the pool with the most.
+func checkFreeSpace() {
+	var root string
+	var free uint64
+
+	for _, r := range poolRoots() {
+		f, err := diskFree(r)
+
+		if err != nil {
+			debugf("Unable to check free space: %s, err: %s", r, err)
+			continue
+		}
+
+		if root == "" || f > free {
+			root, free = r, f
+		}
+	}
+
+	if root != "" {
+		setLowOnSpace(root, free, free < minFreeSpace)
+	}
+}
+
+// setLowOnSpace pauses downloads if low, and resumes them if not, notifying when that changes.
+func setLowOnSpace(root string, free uint64, low bool) {
+	lowOnSpaceMu.Lock()
+	changed := lowOnSpace != low
+	lowOnSpace = low
+	lowOnSpaceMu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	data := spaceEvent{Root: root, Free: humanize.Bytes(free), MinFree: humanize.Bytes(minFreeSpace)}
+
+	if low {
+		warnf("Only %s is free on %s, pausing downloads until at least %s is (-min-free)", data.Free, root, data.MinFree)
+		publishEvent("disk_space_low", data)
+	} else {
+		infof("%s is free on %s again", data.Free, root)
+		publishEvent("disk_space_recovered", data)
+	}
+
+	setPaused(lowSpaceReason, low)
+}
+
+// pauseForSpace pauses downloads after writing to path failed because its disk is full, until the space monitor
+// finds enough has been freed, returning whether it did (only w/ -min-free).
+func pauseForSpace(path string, err error) bool {
+	if minFreeSpace == 0 || !isDiskFull(err) {
+		return false
+	}
+
+	directory := filepath.Dir(path)
+	warnf("Unable to write to %s, the disk is full", path)
+
+	free, _ := diskFree(directory)
+	setLowOnSpace(directory, free, true)
+
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// downloadRecord is the checksum and size a firmware had upstream when it was downloaded, when it last passed
+// verification, and when it was last checked, with the result (pass or fail)
+type downloadRecord struct {
+	SHA1       string    `json:"sha1,omitempty"`
+	MD5        string    `json:"md5,omitempty"`
+	Size       uint64    `json:"size"`
+	Downloaded time.Time `json:"downloaded"`
+	Verified   time.Time `json:"verified"`
+	Checked    time.Time `json:"checked"`
+	Result     string    `json:"result,omitempty"`
+}
+
+// downloadRecordsMu serialises updating the download records
+var downloadRecordsMu sync.Mutex
+
+func downloadRecordsPath() string {
+	return filepath.Join(stateDirectory(), "downloaded.json")
+}
+
+// loadDownloadRecords reads the download records, by path, which are empty if they haven't been written yet.
+func loadDownloadRecords() (map[string]downloadRecord, error) {
+	records := make(map[string]downloadRecord)
+
+	if err := readJSONFile(downloadRecordsPath(), &records); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// recordDownload records the checksum and size of a job's firmware, for -refresh-changed.
+func recordDownload(job *downloadJob) {
+	downloadRecordsMu.Lock()
+	defer downloadRecordsMu.Unlock()
+
+	records, err := loadDownloadRecords()
+
+	if err != nil {
+		warnf("Unable to read download records: %s, err: %s", downloadRecordsPath(), err)
+		return
+	}
+
+	// downloads are verified as they finish
+	now := time.Now()
+
+	records[filepath.Clean(job.Path)] = downloadRecord{
+		SHA1:       job.Firmware.SHA1Sum,
+		MD5:        job.Firmware.MD5Sum,
+		Size:       job.Firmware.Filesize,
+		Downloaded: now,
+		Verified:   now,
+		Checked:    now,
+		Result:     "pass",
+	}
+
+	if err := writeJSONFile(downloadRecordsPath(), records); err != nil {
+		warnf("Unable to write download records: %s, err: %s", downloadRecordsPath(), err)
+	}
+}
+
+// recordVerified records that a job's firmware was checked, and whether it passed verification, recording its checksum
+// and size too if it was downloaded before downloads were recorded.
+func recordVerified(job *downloadJob, passed bool) {
+	downloadRecordsMu.Lock()
+	defer downloadRecordsMu.Unlock()
+
+	records, err := loadDownloadRecords()
+
+	if err != nil {
+		warnf("Unable to read download records: %s, err: %s", downloadRecordsPath(), err)
+		return
+	}
+
+	path := filepath.Clean(job.Path)
+	record, ok := records[path]
+
+	if !ok {
+		record = downloadRecord{SHA1: job.Firmware.SHA1Sum, MD5: job.Firmware.MD5Sum, Size: job.Firmware.Filesize}
+
+		if info, err := os.Stat(job.Path); err == nil {
+			record.Downloaded = info.ModTime()
+		}
+	}
+
+	record.Checked, record.Result = time.Now(), "fail"
+
+	if passed {
+		record.Verified, record.Result = record.Checked, "pass"
+	}
+
+	records[path] = record
+
+	if err := writeJSONFile(downloadRecordsPath(), records); err != nil {
+		warnf("Unable to write download records: %s, err: %s", downloadRecordsPath(), err)
+	}
+}
+
+// changedUpstream reports whether the checksum or size of fw, downloaded to path, differs from that recorded when it was
+// downloaded or, for firmwares downloaded before downloads were recorded, in the catalog.
+func changedUpstream(records map[string]downloadRecord, catalog map[string]*api.Firmware, fw *api.Firmware, path string) bool {
+	var sha1Sum, md5Sum string
+	var size uint64
+
+	if record, ok := records[filepath.Clean(path)]; ok {
+		sha1Sum, md5Sum, size = record.SHA1, record.MD5, record.Size
+	} else if old, ok := catalog[pinKey(fw.Identifier, fw.BuildID)]; ok {
+		sha1Sum, md5Sum, size = old.SHA1Sum, old.MD5Sum, old.Filesize
+	} else {
+		return false
+	}
+
+	// checksums and sizes which are unknown on either side can't have changed
+	changed := func(old, new string) bool {
+		return old != "" && new != "" && !strings.EqualFold(old, new)
+	}
+
+	return changed(sha1Sum, fw.SHA1Sum) || changed(md5Sum, fw.MD5Sum) || (size > 0 && fw.Filesize > 0 && size != fw.Filesize)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestEntry is a notification waiting to be sent in the next digest
+type digestEntry struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+}
+
+// emailDigest is the notifications since the last digest was sent, kept in the state directory so that
+// a digest can cover several runs, e.g. from cron
+type emailDigest struct {
+	LastSent time.Time     `json:"last_sent"`
+	Entries  []digestEntry `json:"entries"`
+}
+
+// digestMu serializes access to the digest file
+var digestMu sync.Mutex
+
+func digestPath() string {
+	return filepath.Join(stateDirectory(), "email-digest.json")
+}
+
+// emailNotifier returns a notifier which emails each message to -email-to, or (w/ -email-digest) adds it to the digest.
+func emailNotifier() (notifier, error) {
+	if smtpServer == "" || emailFrom == "" {
+		return notifier{}, errors.New("-smtp-server and -email-from must be set to send email (w/ -email-to)")
+	}
+
+	if smtpPassword == "" {
+		smtpPassword = os.Getenv("ALLTHEFIRMWARES_SMTP_PASSWORD")
+	}
+
+	if emailDigestInterval > 0 {
+		return notifier{name: "email", send: func(e event, message string) error {
+			return addToDigest(digestEntry{Time: e.Time, Type: e.Type, Message: message})
+		}}, nil
+	}
+
+	return notifier{name: "email", send: func(e event, message string) error {
+		return sendEmail("allthefirmwares: "+message, message+"\n")
+	}}, nil
+}
+
+// sendEmail sends an email to -email-to through -smtp-server, authenticating if -smtp-user is set.
+func sendEmail(subject, body string) error {
+	var auth smtp.Auth
+
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(smtpServer)
+
+		if err != nil {
+			return fmt.Errorf("invalid -smtp-server: %s, err: %s", smtpServer, err)
+		}
+
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+
+	var to []string
+
+	for _, address := range strings.Split(emailTo, ",") {
+		to = append(to, strings.TrimSpace(address))
+	}
+
+	var message bytes.Buffer
+
+	fmt.Fprintf(&message, "From: %s\r\n", emailFrom)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(smtpServer, auth, emailFrom, to, message.Bytes())
+}
+
+func addToDigest(entry digestEntry) error {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+
+	var digest emailDigest
+
+	if err := readJSONFile(digestPath(), &digest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if digest.LastSent.IsZero() {
+		// the first digest is sent one interval after notifications began
+		digest.LastSent = time.Now()
+	}
+
+	digest.Entries = append(digest.Entries, entry)
+
+	return writeJSONFile(digestPath(), digest)
+}
+
+// sendDigestIfDue emails the digest once -email-digest has passed since the last one, if anything has happened since.
+func sendDigestIfDue() {
+	if emailTo == "" || emailDigestInterval <= 0 {
+		return
+	}
+
+	digestMu.Lock()
+	defer digestMu.Unlock()
+
+	var digest emailDigest
+
+	if err := readJSONFile(digestPath(), &digest); err != nil {
+		if !os.IsNotExist(err) {
+			warnf("Unable to read email digest: %s, err: %s", digestPath(), err)
+		}
+
+		return
+	}
+
+	if len(digest.Entries) == 0 || time.Since(digest.LastSent) < emailDigestInterval {
+		return
+	}
+
+	counts := make(map[string]int)
+
+	var body bytes.Buffer
+
+	for _, entry := range digest.Entries {
+		counts[entry.Type]++
+		fmt.Fprintf(&body, "%s  %s\n", entry.Time.Format("2006-01-02 15:04"), entry.Message)
+	}
+
+	subject := fmt.Sprintf("allthefirmwares: %d downloaded, %d failed, %d failed verification since %s",
+		counts["download_completed"], counts["download_failed"], counts["verification_failed"], digest.LastSent.Format("2006-01-02 15:04"))
+
+	if err := sendEmail(subject, body.String()); err != nil {
+		warnf("Unable to send email digest, err: %s", err)
+		return
+	}
+
+	digest.LastSent = time.Now()
+	digest.Entries = nil
+
+	if err := writeJSONFile(digestPath(), digest); err != nil {
+		warnf("Unable to write email digest: %s, err: %s", digestPath(), err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+// estimateEntry is how much is missing for a device or major version
+type estimateEntry struct {
+	Name      string `json:"name"`
+	Firmwares int    `json:"firmwares"`
+	Size      uint64 `json:"size"`
+}
+
+// downloadEstimate is how much would be downloaded with the current flags
+type downloadEstimate struct {
+	Firmwares int    `json:"firmwares"`
+	Size      uint64 `json:"size"`
+	Free      uint64 `json:"free,omitempty"`
+
+	Devices       []estimateEntry `json:"devices"`
+	MajorVersions []estimateEntry `json:"major_versions"`
+}
+
+// estimateEntries totals the jobs by key, largest first.
+func estimateEntries(jobs []downloadJob, key func(job *downloadJob) string) []estimateEntry {
+	totals := make(map[string]*estimateEntry)
+	entries := []estimateEntry{}
+
+	for i := range jobs {
+		name := key(&jobs[i])
+
+		if totals[name] == nil {
+			totals[name] = &estimateEntry{Name: name}
+		}
+
+		totals[name].Firmwares++
+		totals[name].Size += jobs[i].Firmware.Filesize
+	}
+
+	for _, entry := range totals {
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+// estimateCommand prints how much is missing with the current flags, per device and per major version, as a table or
+// (w/ -json) JSON.
+func estimateCommand() error {
+	// estimating shouldn't move anything
+	reuseExisting = ""
+
+	infof("Gathering IPSW information...")
+
+	jobs, err := planDownloads(false)
+
+	if err != nil {
+		return err
+	}
+
+	estimate := downloadEstimate{Firmwares: len(jobs)}
+
+	for _, job := range jobs {
+		estimate.Size += job.Firmware.Filesize
+	}
+
+	if destination == nil {
+		estimate.Free, _ = poolFree()
+	}
+
+	estimate.Devices = estimateEntries(jobs, func(job *downloadJob) string {
+		if job.Device.Name == "" {
+			return job.Device.Identifier
+		}
+
+		return job.Device.Name + " (" + job.Device.Identifier + ")"
+	})
+
+	estimate.MajorVersions = estimateEntries(jobs, func(job *downloadJob) string {
+		return strings.SplitN(job.Firmware.Version, ".", 2)[0]
+	})
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(estimate)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Missing:\t%d firmware(s), %s\n", estimate.Firmwares, humanize.Bytes(estimate.Size))
+
+	if estimate.Free > 0 {
+		fmt.Fprintf(w, "Free:\t%s\n", humanize.Bytes(estimate.Free))
+	}
+
+	fmt.Fprintln(w, "\nDevice\tFirmwares\tSize")
+
+	for _, entry := range estimate.Devices {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", entry.Name, entry.Firmwares, humanize.Bytes(entry.Size))
+	}
+
+	fmt.Fprintln(w, "\nMajor version\tFirmwares\tSize")
+
+	for _, entry := range estimate.MajorVersions {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", entry.Name, entry.Firmwares, humanize.Bytes(entry.Size))
+	}
+
+	return w.Flush()
+}
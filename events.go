@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// event is something which happened while running, e.g. a download completing
+type event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// eventBroker fans events out to all subscribers, dropping events for subscribers which can't keep up
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan event]bool
+}
+
+var events = &eventBroker{subscribers: make(map[chan event]bool)}
+
+func (b *eventBroker) subscribe() chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan event, 64)
+	b.subscribers[c] = true
+
+	return c
+}
+
+func (b *eventBroker) unsubscribe(c chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, c)
+}
+
+func (b *eventBroker) publish(e event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// downloadEvent describes the firmware an event is about
+type downloadEvent struct {
+	Identifier string  `json:"identifier"`
+	Device     string  `json:"device"`
+	Version    string  `json:"version"`
+	BuildID    string  `json:"buildid"`
+	Signed     bool    `json:"signed"`
+	Path       string  `json:"path"`
+	Size       uint64  `json:"size"`
+	Error      string  `json:"error,omitempty"`
+	Duration   float64 `json:"duration_seconds,omitempty"`
+}
+
+// queueSummary describes a completed download queue
+type queueSummary struct {
+	Queued     int `json:"queued"`
+	Downloaded int `json:"downloaded"`
+	Failed     int `json:"failed"`
+}
+
+func newDownloadEvent(device *api.BaseDevice, fw *api.Firmware, path string) downloadEvent {
+	return downloadEvent{
+		Identifier: device.Identifier,
+		Device:     device.Name,
+		Version:    fw.Version,
+		BuildID:    fw.BuildID,
+		Signed:     fw.Signed,
+		Path:       path,
+		Size:       fw.Filesize,
+	}
+}
+
+// publishEvent sends an event of the given type to all subscribers.
+func publishEvent(eventType string, data interface{}) {
+	events.publish(event{Type: eventType, Time: time.Now(), Data: data})
+}
+
+// eventsHandler streams events as Server-Sent Events, along with a "progress" event containing the status every second.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	c := events.subscribe()
+	defer events.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		var e event
+
+		select {
+		case <-r.Context().Done():
+			return
+		case e = <-c:
+		case now := <-ticker.C:
+			e = event{Type: "progress", Time: now, Data: currentStatus.snapshot()}
+		}
+
+		b, err := json.Marshal(e)
+
+		if err != nil {
+			debugf("Unable to encode event, err: %s", err)
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
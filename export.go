@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// exportFormats writes a download plan in each format supported by export -format
+var exportFormats = map[string]func(w io.Writer, jobs []downloadJob) error{
+	"aria2": exportAria2,
+	"urls":  exportURLs,
+}
+
+// exportCommand writes the firmwares which would be downloaded to -o (or stdout), for other tools to download.
+func exportCommand() error {
+	if _, ok := metadataFormats[exportFormat]; ok {
+		return exportMetadata()
+	}
+
+	write, ok := exportFormats[exportFormat]
+
+	if !ok {
+		return fmt.Errorf("unknown export format: %s", exportFormat)
+	}
+
+	infof("Gathering IPSW information...")
+
+	jobs, err := planDownloads(false)
+
+	if err != nil {
+		return err
+	}
+
+	sortJobs(jobs)
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+
+	if err := write(w, jobs); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	infof("Exported %d firmware(s)", len(jobs))
+
+	return signOutput(out)
+}
+
+// exportMetadata writes the complete information of every firmware matching the flags, and whether it has been
+// downloaded, to -o (or stdout) in a -format of metadataFormats.
+func exportMetadata() error {
+	infof("Gathering IPSW information...")
+
+	records, err := firmwareRecords()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+
+	if err := metadataFormats[exportFormat](w, records); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	infof("Exported %d firmware(s)", len(records))
+
+	return signOutput(out)
+}
+
+// createOutput creates the -o file, or returns stdout if it isn't set.
+func createOutput() (*os.File, error) {
+	if exportOutput == "" {
+		// closing stdout is harmless once everything is written
+		return os.Stdout, nil
+	}
+
+	return os.Create(exportOutput)
+}
+
+// signOutput closes the -o file and signs it (w/ -sign).
+func signOutput(out *os.File) error {
+	if signMethod == "" {
+		return nil
+	} else if exportOutput == "" {
+		warnf("Only files can be signed, not stdout, use -o")
+		return nil
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return signFile(exportOutput)
+}
+
+// exportAria2 writes an aria2c input file (for aria2c -i), which checks each firmware's SHA1.
+func exportAria2(w io.Writer, jobs []downloadJob) error {
+	for _, job := range jobs {
+		_, err := fmt.Fprintf(w, "%s\n  dir=%s\n  out=%s\n  checksum=sha-1=%s\n", job.Firmware.URL, filepath.Dir(job.Path), filepath.Base(job.Path), job.Firmware.SHA1Sum)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// remoteZip reads a zip file over HTTP with Range requests, so that only its central directory and the members
+// extracted are downloaded. Reads continue from where the last one ended on the same response, so a member is
+// downloaded with a single request.
+type remoteZip struct {
+	url  string
+	size int64
+	body io.ReadCloser
+	pos  int64
+}
+
+func (r *remoteZip) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil || off != r.pos {
+		if err := r.open(off); err != nil {
+			return 0, err
+		}
+	}
+
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := io.ReadFull(r.body, p)
+	r.pos += int64(n)
+
+	downloadRate.wait(n)
+
+	if err == nil && r.pos == r.size {
+		err = io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%s ended after %d bytes", r.url, r.pos)
+	}
+
+	if shutdownRequested() {
+		err = errShutdown
+	}
+
+	return n, err
+}
+
+// open requests the zip from off to its end.
+func (r *remoteZip) open(off int64) error {
+	r.close()
+
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+
+	resp, err := downloadClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("%s doesn't support range requests, status: %s", r.url, resp.Status)
+	}
+
+	r.body, r.pos = resp.Body, off
+
+	return nil
+}
+
+func (r *remoteZip) close() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// extractCommand extracts the -members of every firmware matching the flags to -extract-to, from the downloaded copy
+// if there is one, otherwise reading just those members from Apple with Range requests.
+func extractCommand() error {
+	var patterns []string
+
+	for _, pattern := range strings.Split(extractMembers, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid -members pattern: %s, err: %s", pattern, err)
+			}
+
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return errors.New("-members must be given, e.g. -members BuildManifest.plist,kernelcache.*")
+	}
+
+	infof("Gathering IPSW information...")
+
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+	extracted := make(map[string]bool)
+	failed := 0
+
+	for i := range selected {
+		if information[i] == nil {
+			continue
+		}
+
+		for index := range information[i].Firmwares {
+			fw := &information[i].Firmwares[index]
+
+			if !firmwareSelected(index, fw) || extracted[fw.URL] {
+				continue
+			}
+
+			extracted[fw.URL] = true
+
+			if shutdownRequested() {
+				return errShutdown
+			}
+
+			if err := extractFirmware(fw, &selected[i], patterns); err != nil {
+				errorf("Unable to extract from %s %s (%s), err: %s", fw.Identifier, fw.Version, fw.BuildID, err)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to extract from %d firmware(s)", failed)
+	}
+
+	return nil
+}
+
+// extractFirmware extracts the members of fw matching patterns to a directory of -extract-to named after it.
+func extractFirmware(fw *api.Firmware, device *api.BaseDevice, patterns []string) error {
+	var archive *zip.Reader
+
+	downloadPath, err := firmwarePath(fw, device)
+
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(downloadPath); err == nil && destination == nil {
+		local, err := os.Open(downloadPath)
+
+		if err != nil {
+			return err
+		}
+
+		defer local.Close()
+
+		if archive, err = zip.NewReader(local, info.Size()); err != nil {
+			return err
+		}
+	} else {
+		status, size, err := linkStatus(fw.URL)
+
+		if err != nil {
+			return err
+		} else if status != http.StatusOK || size < 0 {
+			return fmt.Errorf("unable to get the size of %s, status: %d", fw.URL, status)
+		}
+
+		remote := &remoteZip{url: fw.URL, size: size}
+		defer remote.close()
+
+		if archive, err = zip.NewReader(remote, size); err != nil {
+			return err
+		}
+	}
+
+	name := strings.TrimSuffix(path.Base(fw.URL), path.Ext(fw.URL))
+	directory := filepath.Join(extractDirectory, name)
+	matched := 0
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") || !memberMatches(file.Name, patterns) {
+			continue
+		}
+
+		matched++
+
+		if err := extractMember(file, directory); err != nil {
+			return fmt.Errorf("%s: %s", file.Name, err)
+		}
+	}
+
+	if matched == 0 {
+		warnf("No members of %s %s (%s) match -members", fw.Identifier, fw.Version, fw.BuildID)
+	}
+
+	return nil
+}
+
+// memberMatches reports whether the name of a zip member, or its base name, matches any of patterns.
+func memberMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractMember extracts a zip member beneath directory, unless it already has been. Its CRC-32 is checked as it is
+// read.
+func extractMember(file *zip.File, directory string) error {
+	target := filepath.Join(directory, filepath.FromSlash(path.Clean("/"+file.Name)))
+
+	if info, err := os.Stat(target); err == nil && uint64(info.Size()) == file.UncompressedSize64 {
+		skipf("%s has already been extracted", target)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), dirMode); err != nil {
+		return err
+	}
+
+	r, err := file.Open()
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	tmp := target + partialSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMode)
+
+	if err != nil {
+		return err
+	}
+
+	bar := newProgressBar(int64(file.UncompressedSize64), file.Name)
+	bar.Start()
+
+	_, err = io.Copy(io.MultiWriter(out, bar), r)
+
+	bar.Finish()
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+
+	successf("Extracted %s", target)
+
+	return nil
+}
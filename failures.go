@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// failedDownload is a download which could not be completed, kept so that it can be retried later
+type failedDownload struct {
+	downloadJob
+
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+}
+
+// failuresMu serialises updating the failure queue, which concurrent downloads (w/ -j) record their failures in
+var failuresMu sync.Mutex
+
+func failuresPath() string {
+	return filepath.Join(stateDirectory(), "failures.json")
+}
+
+// loadFailures reads the failure queue. A missing queue is treated as empty.
+func loadFailures() ([]failedDownload, error) {
+	var failures []failedDownload
+
+	err := readJSONFile(failuresPath(), &failures)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return failures, err
+}
+
+// recordFailure adds a job to the failure queue, or updates its entry if it is already queued.
+func recordFailure(job *downloadJob, downloadErr error, attempts int) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	failures, err := loadFailures()
+
+	if err != nil {
+		warnf("Unable to read failure queue: %s, err: %s", failuresPath(), err)
+		return
+	}
+
+	failure := failedDownload{downloadJob: *job}
+
+	for i := range failures {
+		if failures[i].Path == job.Path {
+			failure.Attempts = failures[i].Attempts
+			failures = append(failures[:i], failures[i+1:]...)
+			break
+		}
+	}
+
+	failure.Error = downloadErr.Error()
+	failure.Attempts += attempts
+	failure.LastAttempt = time.Now()
+
+	failures = append(failures, failure)
+
+	if err := writeJSONFile(failuresPath(), failures); err != nil {
+		warnf("Unable to write failure queue: %s, err: %s", failuresPath(), err)
+	}
+}
+
+// clearFailure removes a job from the failure queue, if present.
+func clearFailure(job *downloadJob) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	failures, err := loadFailures()
+
+	if err != nil {
+		warnf("Unable to read failure queue: %s, err: %s", failuresPath(), err)
+		return
+	}
+
+	for i := range failures {
+		if failures[i].Path != job.Path {
+			continue
+		}
+
+		failures = append(failures[:i], failures[i+1:]...)
+
+		if err := writeJSONFile(failuresPath(), failures); err != nil {
+			warnf("Unable to write failure queue: %s, err: %s", failuresPath(), err)
+		}
+
+		return
+	}
+}
+
+// retryCommand re-attempts every download in the failure queue, without enumerating firmwares again.
+func retryCommand() error {
+	failures, err := loadFailures()
+
+	if err != nil {
+		return err
+	}
+
+	if len(failures) == 0 {
+		infof("No failed downloads to retry")
+		return nil
+	}
+
+	infof("Retrying %d failed download(s)", len(failures))
+
+	succeeded := 0
+
+	for _, failure := range failures {
+		if runLimitReached() {
+			break
+		}
+
+		job := failure.downloadJob
+
+		if stored, _ := firmwareStored(job.Path); stored {
+			skipf("Skipping %s, already exists", job.Path)
+			clearFailure(&job)
+			continue
+		}
+
+		infof("Retrying %s (previously failed %d time(s): %s)", job.Path, failure.Attempts, failure.Error)
+
+		atomic.AddInt64(&downloadsStarted, 1)
+
+		if err := attemptDownload(&job); err == nil {
+			succeeded++
+		}
+	}
+
+	infof("%d of %d failed download(s) succeeded", succeeded, len(failures))
+
+	return nil
+}
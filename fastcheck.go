@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fastChecksumSuffix is the suffix of the sidecar written beside each verified firmware w/ -fast-checksums
+const fastChecksumSuffix = ".fastcheck"
+
+// castagnoli is the CRC-32C table, which most CPUs compute in hardware
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newFastHash returns a hash which computes the CRC-32C of a firmware.
+func newFastHash() hash.Hash32 {
+	return crc32.New(castagnoli)
+}
+
+// fastChecksum is the CRC-32C of a firmware which passed full verification, so that it can be rechecked cheaply
+type fastChecksum struct {
+	CRC32C  string    `json:"crc32c"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Algorithm and Checksum are the checksum the firmware was verified against
+	Algorithm string    `json:"algorithm"`
+	Checksum  string    `json:"checksum"`
+	Verified  time.Time `json:"verified"`
+}
+
+// writeFastChecksum writes the sidecar of the firmware at path, which has been verified against expected.
+func writeFastChecksum(path string, crc uint32, expected firmwareChecksum) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	return writeJSONFile(path+fastChecksumSuffix, fastChecksum{
+		CRC32C:    fmt.Sprintf("%08x", crc),
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Algorithm: expected.algorithm,
+		Checksum:  expected.expected,
+		Verified:  time.Now(),
+	})
+}
+
+// readFastChecksum reads the sidecar of the firmware at path, returning false if there isn't one, or it's out of date:
+// the file has been modified since, or its checksum has changed upstream.
+func readFastChecksum(path string, expected firmwareChecksum) (*fastChecksum, bool) {
+	var sidecar fastChecksum
+
+	if err := readJSONFile(path+fastChecksumSuffix, &sidecar); err != nil {
+		if !os.IsNotExist(err) {
+			warnf("Unable to read fast checksum: %s, err: %s", path+fastChecksumSuffix, err)
+		}
+
+		return nil, false
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil || info.Size() != sidecar.Size || !info.ModTime().Equal(sidecar.ModTime) {
+		return nil, false
+	}
+
+	if sidecar.Algorithm != expected.algorithm || !strings.EqualFold(sidecar.Checksum, expected.expected) {
+		return nil, false
+	}
+
+	return &sidecar, true
+}
+
+// fastVerify computes the CRC-32C of the file at location, writing its contents to progress as it is read, and
+// reports whether it matches the sidecar.
+func fastVerify(location string, sidecar *fastChecksum, progress io.Writer) (bool, error) {
+	file, err := os.Open(location)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer file.Close()
+
+	h := newFastHash()
+
+	if _, err := copyReadAhead(io.MultiWriter(h, progress), file); err != nil {
+		return false, err
+	}
+
+	return fmt.Sprintf("%08x", h.Sum32()) == sidecar.CRC32C, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// trackedPaths returns the paths, under the current templates, of every firmware in the catalog or listed upstream,
+// for every device. incomplete is set if the firmwares of some devices couldn't be retrieved.
+func trackedPaths() (paths map[string]bool, incomplete bool, err error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	devices := catalog.Devices
+
+	baseDevices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		if len(devices) == 0 {
+			return nil, false, fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+		}
+
+		warnf("Unable to retrieve firmware information, only the firmwares in the catalog are tracked, err: %s", err)
+		incomplete = true
+	} else {
+		information, errs := fetchDeviceInformation(baseDevices)
+
+		if len(errs) > 0 {
+			warnf("Could not get firmwares for %d of %d device(s), only those in the catalog are tracked", len(errs), len(baseDevices))
+			incomplete = true
+		}
+
+		for _, device := range information {
+			if device != nil {
+				devices = append(devices, *device)
+			}
+		}
+	}
+
+	paths = make(map[string]bool)
+
+	for i := range devices {
+		for j := range devices[i].Firmwares {
+			path, err := firmwarePath(&devices[i].Firmwares[j], &devices[i].BaseDevice)
+
+			if err != nil {
+				return nil, false, err
+			}
+
+			paths[filepath.Clean(path)] = true
+		}
+	}
+
+	return paths, incomplete, nil
+}
+
+// gcCommand lists the files in the download root which aren't any firmware tracked in the catalog or upstream under
+// the current templates, and (w/ -gc remove or -gc move) removes them or moves them aside to -gc-dir.
+func gcCommand() error {
+	if gcAction != "list" && gcAction != "remove" && gcAction != "move" {
+		return fmt.Errorf("unknown -gc: %s, use list, remove or move", gcAction)
+	}
+
+	if destination != nil {
+		return errors.New("only local files can be garbage collected, not those in -dest")
+	}
+
+	paths, incomplete, err := trackedPaths()
+
+	if err != nil {
+		return err
+	}
+
+	if incomplete && gcAction != "list" && !dryRun {
+		return errors.New("not removing or moving files, as not every device's firmwares could be retrieved")
+	}
+
+	root := downloadRoot()
+	moveTo := gcDirectory
+
+	if moveTo == "" {
+		moveTo = filepath.Join(stateDirectory(), "gc", time.Now().Format("2006-01-02T15-04-05"))
+	}
+
+	var untracked []string
+	var size int64
+
+	err = walkArchive(func(path string, info os.FileInfo) error {
+		// torrents, fast checksums and chunklists are written beside the firmware they're for
+		if paths[filepath.Clean(path)] || paths[filepath.Clean(strings.TrimSuffix(path, ".torrent"))] || paths[filepath.Clean(strings.TrimSuffix(path, fastChecksumSuffix))] || paths[filepath.Clean(strings.TrimSuffix(path, chunklistSuffix))] {
+			return nil
+		}
+
+		// SHSH blobs can't be saved again once Apple stops signing their firmware
+		if strings.HasSuffix(path, ".shsh2") {
+			return nil
+		}
+
+		// the static index is written by the index command
+		if filepath.Clean(path) == filepath.Join(downloadRoot(), "index.html") {
+			return nil
+		}
+
+		// don't collect what has already been collected, if -gc-dir is in the download root
+		if rel, err := filepath.Rel(moveTo, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return nil
+		}
+
+		untracked = append(untracked, path)
+		size += info.Size()
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, path := range untracked {
+		if gcAction == "list" || dryRun {
+			fmt.Println(path)
+			continue
+		}
+
+		if err := collectFile(path, root, moveTo); err != nil {
+			errorf("Unable to %s %s, err: %s", gcAction, path, err)
+			failed++
+		}
+	}
+
+	switch {
+	case gcAction == "list" || dryRun:
+		infof("%d untracked file(s), %s", len(untracked), humanize.Bytes(uint64(size)))
+	case gcAction == "move":
+		infof("Moved %d untracked file(s) to %s, %d could not be moved", len(untracked)-failed, moveTo, failed)
+	default:
+		infof("Removed %d untracked file(s), %d could not be removed", len(untracked)-failed, failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to %s %d file(s)", gcAction, failed)
+	}
+
+	return nil
+}
+
+// collectFile removes the file at path or (w/ -gc move) moves it to the same path relative to root under moveTo, then
+// removes any directories it leaves empty.
+func collectFile(path, root, moveTo string) error {
+	if gcAction == "move" {
+		rel, err := filepath.Rel(root, path)
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(moveTo, rel)
+
+		if err := makeDirectory(filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		if err := os.Rename(path, target); err != nil {
+			return err
+		}
+
+		infof("Moved %s to %s", path, target)
+		auditf("move", path, target, "untracked, collected by gc")
+
+		return removeWithEmptyParents(path, root)
+	}
+
+	if trashDirectory != "" {
+		infof("Moving %s to the trash", path)
+	} else {
+		infof("Removing %s", path)
+	}
+
+	trashed, err := removeOrTrash(path, root)
+
+	if err != nil {
+		return err
+	}
+
+	auditf("delete", path, trashed, "untracked, collected by gc")
+
+	return nil
+}
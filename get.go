@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// resolveFirmware returns the firmware of device matching build (if given) and the -version and -signed flags,
+// preferring one whose version is exactly -version, then the newest.
+func resolveFirmware(device *api.Device, build string) (*api.Firmware, error) {
+	var candidates []*api.Firmware
+
+	for i := range device.Firmwares {
+		fw := &device.Firmwares[i]
+
+		if build != "" && !strings.EqualFold(fw.BuildID, build) {
+			continue
+		}
+
+		if !versionSelected(fw.Version) || (downloadSigned && !fw.Signed) {
+			continue
+		}
+
+		candidates = append(candidates, fw)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no firmware for %s matches the flags", device.Identifier)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].UploadDate.Time.After(candidates[j].UploadDate.Time)
+	})
+
+	for _, fw := range candidates {
+		for _, pattern := range strings.Split(versionPatterns, ",") {
+			if fw.Version == strings.TrimSpace(pattern) {
+				return fw, nil
+			}
+		}
+	}
+
+	return candidates[0], nil
+}
+
+// getCommand downloads (or checks, w/ -c) one firmware of the device given with -i, the newest matching -version
+// (or the build given as an argument), only retrieving that device's firmwares.
+func getCommand() error {
+	identifiers := selectedDevices()
+
+	if len(identifiers) != 1 || flag.NArg() > 1 {
+		return errors.New("usage: get -i IDENTIFIER [-version VERSION] [BUILD]")
+	}
+
+	device, err := ipswClient.DeviceInformation(identifiers[0])
+
+	if err != nil {
+		return fmt.Errorf("unable to retrieve firmwares for %s, err: %s", identifiers[0], err)
+	}
+
+	fw, err := resolveFirmware(device, flag.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	downloadPath, err := firmwarePath(fw, &device.BaseDevice)
+
+	if err != nil {
+		return err
+	}
+
+	job := downloadJob{Device: device.BaseDevice, Firmware: *fw, Path: downloadPath}
+
+	stored, err := firmwareStored(downloadPath)
+
+	if err != nil {
+		return fmt.Errorf("unable to read download path: %s, err: %s", downloadPath, err)
+	}
+
+	if stored && !verifyIntegrity {
+		skipf("%s %s (%s) has already been downloaded to %s", device.Name, fw.Version, fw.BuildID, downloadPath)
+		return nil
+	} else if !stored && verifyIntegrity {
+		return fmt.Errorf("%s %s (%s) hasn't been downloaded", device.Name, fw.Version, fw.BuildID)
+	}
+
+	if verifyIntegrity {
+		return verifyJob(&job, newVerifyProgress([]downloadJob{job}))
+	}
+
+	infof("Resolved %s %s (%s), %s", device.Name, fw.Version, fw.BuildID, fw.URL)
+
+	processJobs([]downloadJob{job})
+
+	if stored, _ := firmwareStored(downloadPath); !stored {
+		return fmt.Errorf("unable to download %s %s (%s)", device.Name, fw.Version, fw.BuildID)
+	}
+
+	return nil
+}
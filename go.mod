@@ -1,10 +1,13 @@
 module github.com/cj123/allthefirmwares
 
+go 1.27.1
+
 require (
 	github.com/cheggaaa/pb v1.0.20
 	github.com/cj123/go-ipsw v0.0.0-20180310204258-405f13915924
 	github.com/dustin/go-humanize v0.0.0-20171111073723-bb3d318650d4
-	github.com/mattn/go-runewidth v0.0.2 // indirect
-	golang.org/x/sys v0.0.0-20180115085844-fff93fa7cd27 // indirect
-	gopkg.in/guregu/null.v3 v3.3.0 // indirect
+	golang.org/x/sys v0.0.0-20180115085844-fff93fa7cd27
+	gopkg.in/guregu/null.v3 v3.3.0
 )
+
+require github.com/mattn/go-runewidth v0.0.2 // indirect
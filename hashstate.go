@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// hashStateInterval is how often the hash state of a download is saved, so that it can be resumed without re-reading
+// much of the partial download if allthefirmwares is killed
+const hashStateInterval = 256 * 1024 * 1024
+
+// hashState is the state of the hash of the first Offset bytes of a partial download
+type hashState struct {
+	Offset int64  `json:"offset"`
+	State  []byte `json:"state"`
+}
+
+// hashStatePath is where the hash state of the partial download at location is saved. It ends in partialSuffix, so it
+// is ignored like the partial download itself.
+func hashStatePath(location string) string {
+	return strings.TrimSuffix(location, partialSuffix) + ".sha1state" + partialSuffix
+}
+
+// saveHashState saves the state of h, which has hashed the first offset bytes of the partial download at location.
+func saveHashState(location string, h hash.Hash, offset int64) {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+
+	if !ok {
+		return
+	}
+
+	state, err := marshaler.MarshalBinary()
+
+	if err != nil {
+		return
+	}
+
+	if err := writeJSONFile(hashStatePath(location), hashState{Offset: offset, State: state}); err != nil {
+		debugf("Unable to save hash state: %s, err: %s", hashStatePath(location), err)
+	}
+}
+
+// removeHashState removes the saved hash state of the partial download at location, if any.
+func removeHashState(location string) {
+	if err := os.Remove(hashStatePath(location)); err != nil && !os.IsNotExist(err) {
+		warnf("Unable to remove hash state: %s, err: %s", hashStatePath(location), err)
+	}
+}
+
+// resumeHash hashes the partial download in file into h, returning its size. Rather than re-reading the whole file, the
+// saved hash state is restored (if there is one) and only what was written after it was saved is read.
+// file is left positioned at its end.
+func resumeHash(file *os.File, location string, h hash.Hash) (int64, error) {
+	var state hashState
+
+	if err := readJSONFile(hashStatePath(location), &state); err == nil {
+		info, err := file.Stat()
+		unmarshaler, ok := h.(encoding.BinaryUnmarshaler)
+
+		if err == nil && ok && state.Offset <= info.Size() && unmarshaler.UnmarshalBinary(state.State) == nil {
+			if _, err := file.Seek(state.Offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+
+			n, err := io.Copy(io.MultiWriter(h, aliveWriter{}), file)
+
+			debugf("Restored the hash of %s at %d bytes, read %d more", location, state.Offset, n)
+
+			return state.Offset + n, err
+		}
+
+		h.Reset()
+	}
+
+	return io.Copy(io.MultiWriter(h, aliveWriter{}), file)
+}
+
+// hashStateWriter saves the hash state of a download every hashStateInterval bytes. It must be written to after the
+// download's file and hash, so that both include what it's written.
+type hashStateWriter struct {
+	location string
+	h        hash.Hash
+	flush    func() error
+	offset   int64
+	saved    int64
+}
+
+func (w *hashStateWriter) Write(b []byte) (int, error) {
+	w.offset += int64(len(b))
+
+	if w.offset-w.saved >= hashStateInterval {
+		// the state may only be saved once what it has hashed is on disk
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+
+		saveHashState(w.location, w.h, w.offset)
+		w.saved = w.offset
+	}
+
+	return len(b), nil
+}
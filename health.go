@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minimumHealthyDiskFree is the free space below which the download root is reported as degraded
+const minimumHealthyDiskFree = 1 << 30
+
+var (
+	startedAt = time.Now()
+
+	apiProbeMu     sync.Mutex
+	apiProbeTime   time.Time
+	apiProbeError  error
+	apiProbeClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// healthReport is the response of /healthz
+type healthReport struct {
+	Status string `json:"status"`
+
+	APIReachable bool   `json:"api_reachable"`
+	APIError     string `json:"api_error,omitempty"`
+
+	DiskFreeBytes uint64 `json:"disk_free_bytes"`
+	DiskError     string `json:"disk_error,omitempty"`
+
+	LastSuccessfulPoll time.Time `json:"last_successful_poll"`
+}
+
+// probeAPI checks that the firmware API is reachable, caching the result for a minute.
+func probeAPI() error {
+	apiProbeMu.Lock()
+	defer apiProbeMu.Unlock()
+
+	if time.Since(apiProbeTime) < time.Minute {
+		return apiProbeError
+	}
+
+	resp, err := apiProbeClient.Head(ipswClient.probeURL())
+
+	if err == nil {
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			err = &statusError{status: resp.Status, code: resp.StatusCode}
+		}
+	}
+
+	apiProbeTime, apiProbeError = time.Now(), err
+
+	return err
+}
+
+// healthHandler reports whether the daemon is healthy. It responds 503 if there has been no successful poll
+// of the API within -health-max-poll-age, and reports "degraded" if the API is unreachable or disk space is low.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{
+		Status:             "ok",
+		APIReachable:       true,
+		LastSuccessfulPoll: currentStatus.getLastSuccessfulPoll(),
+	}
+
+	if err := probeAPI(); err != nil {
+		report.Status = "degraded"
+		report.APIReachable = false
+		report.APIError = err.Error()
+	}
+
+	free, err := poolFree()
+
+	if err != nil {
+		report.Status = "degraded"
+		report.DiskError = err.Error()
+	} else if report.DiskFreeBytes = free; free < minimumHealthyDiskFree {
+		report.Status = "degraded"
+	}
+
+	lastPoll := report.LastSuccessfulPoll
+
+	if lastPoll.IsZero() {
+		lastPoll = startedAt
+	}
+
+	if healthMaxPollAge > 0 && time.Since(lastPoll) > healthMaxPollAge {
+		report.Status = "unhealthy"
+		writeJSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
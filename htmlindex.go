@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staticIndex is what the static index is rendered from
+type staticIndex struct {
+	Generated string
+	Devices   []indexDevice
+}
+
+var staticIndexTemplate = template.Must(template.New("static").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>allthefirmwares</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+	table { border-collapse: collapse; margin-bottom: 24px; font-size: 14px; }
+	th, td { text-align: left; padding: 4px 12px 4px 0; }
+	td.sha1 { font-family: Menlo, Consolas, monospace; font-size: 12px; color: #666; }
+	h2 { font-size: 16px; margin-bottom: 4px; }
+	nav a { margin-right: 8px; white-space: nowrap; }
+</style>
+</head>
+<body>
+<h1>allthefirmwares</h1>
+<p>Generated {{.Generated}}</p>
+<nav>{{range .Devices}}<a href="#{{.Identifier}}">{{.Name}}</a> {{end}}</nav>
+{{range .Devices}}
+<h2 id="{{.Identifier}}">{{.Name}} ({{.Identifier}})</h2>
+<table>
+<tr><th>Version</th><th>Build</th><th>Size</th><th>SHA1</th><th></th></tr>
+{{range .Firmwares}}<tr><td><a href="{{.URL}}">{{.Version}}</a></td><td>{{.BuildID}}</td><td>{{.Size}}</td><td class="sha1">{{.SHA1}}</td><td>{{if .Signed}}signed{{end}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No firmwares have been downloaded yet, or no download run has recorded the firmware catalog.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// indexCommand writes a static HTML index of the downloaded firmwares of each device, newest first, with relative links
+// to them, to index.html in the download root (or -o), so that the archive can be browsed from any web server.
+func indexCommand() error {
+	if destination != nil {
+		return errors.New("only local files can be indexed, not those in -dest")
+	}
+
+	output := exportOutput
+
+	if output == "" {
+		output = filepath.Join(downloadRoot(), "index.html")
+	}
+
+	directory := filepath.Dir(output)
+
+	devices, err := archiveIndex(func(path string) (string, error) {
+		rel, err := filepath.Rel(directory, path)
+
+		if err != nil {
+			return "", err
+		}
+
+		// so that e.g. a colon in the first directory isn't taken for a scheme
+		if !strings.HasPrefix(rel, "..") {
+			rel = "./" + filepath.ToSlash(rel)
+		}
+
+		u := url.URL{Path: filepath.ToSlash(rel)}
+
+		return u.EscapedPath(), nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+
+	if err := staticIndexTemplate.Execute(&b, staticIndex{Generated: time.Now().Format("2006-01-02 15:04"), Devices: devices}); err != nil {
+		return err
+	}
+
+	if err := makeDirectory(directory); err != nil {
+		return err
+	}
+
+	tmp := output + ".tmp"
+
+	if err := os.WriteFile(tmp, b.Bytes(), fileMode); err != nil {
+		return err
+	}
+
+	if err := applyPermissions(tmp, fileMode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, output); err != nil {
+		return err
+	}
+
+	firmwares := 0
+
+	for _, device := range devices {
+		firmwares += len(device.Firmwares)
+	}
+
+	successf("Wrote an index of %d firmware(s) for %d device(s) to %s", firmwares, len(devices), output)
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// tracingTransport logs each request's connection setup, response and transfer (w/ -debug-http)
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// traced wraps a transport with a tracingTransport if -debug-http is set.
+func traced(next http.RoundTripper) http.RoundTripper {
+	if !debugHTTP {
+		return next
+	}
+
+	return &tracingTransport{next: next}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	since := func() time.Duration { return time.Since(start).Round(time.Millisecond) }
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			infof("HTTP %s %s: connected to %s (reused: %t) after %s", req.Method, req.URL, info.Conn.RemoteAddr(), info.Reused, since())
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			infof("HTTP %s %s: resolved %v (err: %v) after %s", req.Method, req.URL, info.Addrs, info.Err, since())
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				infof("HTTP %s %s: unable to connect to %s, err: %s", req.Method, req.URL, addr, err)
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				infof("HTTP %s %s: TLS handshake failed after %s, err: %s", req.Method, req.URL, since(), err)
+			}
+		},
+		GotFirstResponseByte: func() {
+			infof("HTTP %s %s: first response byte after %s", req.Method, req.URL, since())
+		},
+	}
+
+	if rng := req.Header.Get("Range"); rng != "" {
+		infof("HTTP %s %s (Range: %s)", req.Method, req.URL, rng)
+	} else {
+		infof("HTTP %s %s", req.Method, req.URL)
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+
+	if err != nil {
+		infof("HTTP %s %s: failed after %s, err: %s", req.Method, req.URL, since(), err)
+		return nil, err
+	}
+
+	if location := resp.Header.Get("Location"); location != "" {
+		infof("HTTP %s %s: %s, redirected to %s", req.Method, req.URL, resp.Status, location)
+	} else {
+		infof("HTTP %s %s: %s (Content-Length: %d) after %s", req.Method, req.URL, resp.Status, resp.ContentLength, since())
+	}
+
+	resp.Body = &tracedBody{ReadCloser: resp.Body, req: req, start: start}
+
+	return resp, nil
+}
+
+// tracedBody logs how much of a response body was read, and how long it took, when it is closed
+type tracedBody struct {
+	io.ReadCloser
+
+	req   *http.Request
+	start time.Time
+	read  uint64
+	err   error
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += uint64(n)
+
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	duration := time.Since(b.start)
+
+	if b.err != nil {
+		infof("HTTP %s %s: read %s in %s, err: %s", b.req.Method, b.req.URL, humanize.Bytes(b.read), duration.Round(time.Millisecond), b.err)
+	} else {
+		infof("HTTP %s %s: read %s in %s (%s/s)", b.req.Method, b.req.URL, humanize.Bytes(b.read), duration.Round(time.Millisecond), humanize.Bytes(uint64(float64(b.read)/duration.Seconds())))
+	}
+
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// ignoreRule is a line of the -ignore file, whose terms a firmware must all match to be ignored
+type ignoreRule struct {
+	terms  []string
+	reason string
+}
+
+var (
+	// ignoreRules are the rules of the -ignore file, which is read again when it's modified
+	ignoreRules    []ignoreRule
+	ignoreModified time.Time
+	ignoreMu       sync.Mutex
+)
+
+// loadIgnoreList reads the -ignore file, if it has been modified since it was last read.
+func loadIgnoreList() error {
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+
+	if ignoreFilePath == "" {
+		ignoreRules, ignoreModified = nil, time.Time{}
+		return nil
+	}
+
+	info, err := os.Stat(ignoreFilePath)
+
+	if err != nil {
+		return fmt.Errorf("unable to read ignore file: %s, err: %s", ignoreFilePath, err)
+	}
+
+	if info.ModTime().Equal(ignoreModified) && ignoreRules != nil {
+		return nil
+	}
+
+	rules, err := readIgnoreFile(ignoreFilePath)
+
+	if err != nil {
+		return fmt.Errorf("unable to read ignore file: %s, err: %s", ignoreFilePath, err)
+	}
+
+	ignoreRules, ignoreModified = rules, info.ModTime()
+	debugf("Read %d rule(s) from %s", len(rules), ignoreFilePath)
+
+	return nil
+}
+
+// readIgnoreFile parses an ignore file: a rule per line, of device identifiers (or globs), build IDs and versions
+// (or -version style patterns) separated by spaces, optionally followed by # and the reason.
+func readIgnoreFile(p string) ([]ignoreRule, error) {
+	f, err := os.Open(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text, reason := scanner.Text(), ""
+
+		if i := strings.Index(text, "#"); i >= 0 {
+			text, reason = text[:i], strings.TrimSpace(text[i+1:])
+		}
+
+		terms := strings.Fields(text)
+
+		if len(terms) == 0 {
+			continue
+		}
+
+		for _, term := range terms {
+			if _, err := path.Match(term, ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern: %s, err: %s", line, term, err)
+			}
+		}
+
+		rules = append(rules, ignoreRule{terms: terms, reason: reason})
+	}
+
+	return rules, scanner.Err()
+}
+
+// ignoreTermMatches reports whether a term is the firmware's device identifier (or a glob matching it), its build ID, or a
+// version pattern matching its version.
+func ignoreTermMatches(term string, fw *api.Firmware) bool {
+	if strings.EqualFold(term, fw.BuildID) || versionMatches(fw.Version, term) {
+		return true
+	}
+
+	matched, _ := path.Match(term, fw.Identifier)
+
+	return matched
+}
+
+// ignored returns whether the firmware matches a rule of the -ignore file, and the rule's reason.
+func ignored(fw *api.Firmware) (bool, string) {
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+
+rules:
+	for _, rule := range ignoreRules {
+		for _, term := range rule.terms {
+			if !ignoreTermMatches(term, fw) {
+				continue rules
+			}
+		}
+
+		return true, rule.reason
+	}
+
+	return false, ""
+}
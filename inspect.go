@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// inspectMember is a file inside an IPSW
+type inspectMember struct {
+	Name           string    `json:"name"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressed_size"`
+	Modified       time.Time `json:"modified"`
+}
+
+// inspectIdentity is a build identity of a BuildManifest: a device class and the kind of restore it's for
+type inspectIdentity struct {
+	DeviceClass     string `json:"device_class"`
+	Variant         string `json:"variant"`
+	RestoreBehavior string `json:"restore_behavior,omitempty"`
+	OS              string `json:"os,omitempty"`
+	KernelCache     string `json:"kernelcache,omitempty"`
+	Components      int    `json:"components"`
+}
+
+// ipswInspection is what inspect lists of an IPSW
+type ipswInspection struct {
+	Path         string            `json:"path"`
+	Size         uint64            `json:"size"`
+	Version      string            `json:"version,omitempty"`
+	BuildID      string            `json:"buildid,omitempty"`
+	ProductTypes []string          `json:"product_types,omitempty"`
+	Identities   []inspectIdentity `json:"identities,omitempty"`
+	Members      []inspectMember   `json:"members"`
+}
+
+// inspectCommand lists the files inside an IPSW, and what its BuildManifest says it is, as text or (w/ -json) JSON.
+// The IPSW is the file given as an argument, or the downloaded firmware of the device given with -i matching -version
+// (or the build given as an argument).
+func inspectCommand() error {
+	path, err := inspectPath()
+
+	if err != nil {
+		return err
+	}
+
+	inspection, err := inspectIPSW(path)
+
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(inspection)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Path:\t%s\n", inspection.Path)
+	fmt.Fprintf(w, "Size:\t%s\n", humanize.Bytes(inspection.Size))
+
+	if inspection.Version != "" {
+		fmt.Fprintf(w, "Version:\t%s (%s)\n", inspection.Version, inspection.BuildID)
+	}
+
+	if len(inspection.ProductTypes) > 0 {
+		fmt.Fprintf(w, "Devices:\t%s\n", strings.Join(inspection.ProductTypes, ", "))
+	}
+
+	if len(inspection.Identities) > 0 {
+		fmt.Fprintln(w, "\nDevice class\tVariant\tRestore\tOS\tKernel cache\tComponents")
+
+		for _, identity := range inspection.Identities {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", identity.DeviceClass, identity.Variant, identity.RestoreBehavior,
+				identity.OS, identity.KernelCache, identity.Components)
+		}
+	}
+
+	total := uint64(0)
+
+	for _, member := range inspection.Members {
+		total += member.Size
+	}
+
+	fmt.Fprintf(w, "\n%d file(s), %s uncompressed\n", len(inspection.Members), humanize.Bytes(total))
+	fmt.Fprintln(w, "Size\tCompressed\tModified\tName")
+
+	for _, member := range inspection.Members {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", humanize.Bytes(member.Size), humanize.Bytes(member.CompressedSize),
+			member.Modified.Format("2006-01-02 15:04"), member.Name)
+	}
+
+	return w.Flush()
+}
+
+// inspectPath returns the IPSW to inspect: the file given as an argument, otherwise the downloaded firmware of the
+// device given with -i.
+func inspectPath() (string, error) {
+	if flag.NArg() == 1 {
+		if info, err := os.Stat(flag.Arg(0)); err == nil && !info.IsDir() {
+			return flag.Arg(0), nil
+		}
+	}
+
+	identifiers := selectedDevices()
+
+	if len(identifiers) != 1 || flag.NArg() > 1 {
+		return "", errors.New("usage: inspect FILE, or inspect -i IDENTIFIER [-version VERSION] [BUILD]")
+	}
+
+	if destination != nil {
+		return "", errors.New("only local files can be inspected, not those in -dest")
+	}
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return "", fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+
+		if device.Identifier != identifiers[0] {
+			continue
+		}
+
+		fw, err := resolveFirmware(device, flag.Arg(0))
+
+		if err != nil {
+			return "", err
+		}
+
+		path, err := firmwarePath(fw, &device.BaseDevice)
+
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("%s %s (%s) hasn't been downloaded to %s", device.Name, fw.Version, fw.BuildID, path)
+		}
+
+		return path, nil
+	}
+
+	return "", fmt.Errorf("%s isn't in the catalog, run download (or download -c) first", identifiers[0])
+}
+
+// inspectIPSW reads the members of the IPSW at path, and its BuildManifest.
+func inspectIPSW(path string) (*ipswInspection, error) {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to open IPSW: %s, err: %s", path, err)
+	}
+
+	defer archive.Close()
+
+	inspection := &ipswInspection{Path: path, Members: []inspectMember{}}
+
+	if info, err := os.Stat(path); err == nil {
+		inspection.Size = uint64(info.Size())
+	}
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		inspection.Members = append(inspection.Members, inspectMember{
+			Name:           file.Name,
+			Size:           file.UncompressedSize64,
+			CompressedSize: file.CompressedSize64,
+			Modified:       file.Modified,
+		})
+
+		if file.Name != "BuildManifest.plist" {
+			continue
+		}
+
+		manifest, err := decodeBuildManifest(file)
+
+		if err != nil {
+			warnf("Unable to read the BuildManifest of %s, err: %s", path, err)
+			continue
+		}
+
+		inspectBuildManifest(inspection, manifest)
+	}
+
+	sort.Slice(inspection.Members, func(i, j int) bool {
+		return inspection.Members[i].Name < inspection.Members[j].Name
+	})
+
+	return inspection, nil
+}
+
+// inspectBuildManifest adds the version, devices and build identities of a BuildManifest to an inspection.
+func inspectBuildManifest(inspection *ipswInspection, manifest map[string]interface{}) {
+	inspection.Version, _ = manifest["ProductVersion"].(string)
+	inspection.BuildID, _ = manifest["ProductBuildVersion"].(string)
+
+	productTypes, _ := manifest["SupportedProductTypes"].([]interface{})
+
+	for _, v := range productTypes {
+		if productType, ok := v.(string); ok {
+			inspection.ProductTypes = append(inspection.ProductTypes, productType)
+		}
+	}
+
+	identities, _ := manifest["BuildIdentities"].([]interface{})
+
+	for _, v := range identities {
+		identity, _ := v.(map[string]interface{})
+		info, _ := identity["Info"].(map[string]interface{})
+		components, _ := identity["Manifest"].(map[string]interface{})
+
+		componentPath := func(name string) string {
+			component, _ := components[name].(map[string]interface{})
+			info, _ := component["Info"].(map[string]interface{})
+			path, _ := info["Path"].(string)
+
+			return path
+		}
+
+		result := inspectIdentity{OS: componentPath("OS"), KernelCache: componentPath("KernelCache"), Components: len(components)}
+		result.DeviceClass, _ = info["DeviceClass"].(string)
+		result.Variant, _ = info["Variant"].(string)
+		result.RestoreBehavior, _ = info["RestoreBehavior"].(string)
+
+		inspection.Identities = append(inspection.Identities, result)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+)
+
+// ioPriorities are the values of -io-priority, as ionice classes and levels: normal is derived from the CPU nice
+// value, as it is by default, low is the lowest best-effort level, and idle only uses the disk when nothing else is
+var ioPriorities = map[string]uintptr{
+	"normal": 0,
+	"low":    ioprioClassBestEffort<<ioprioClassShift | 7,
+	"idle":   ioprioClassIdle << ioprioClassShift,
+}
+
+// setIOPriority sets the I/O priority of every thread, which threads started later inherit.
+func setIOPriority(priority string) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+
+		if err != nil {
+			continue
+		}
+
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioPriorities[priority]); errno != 0 && errno != syscall.ESRCH {
+			return errno
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func setIOPriority(priority string) error {
+	return errors.New("I/O priorities are not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"syscall"
+)
+
+const (
+	processModeBackgroundBegin = 0x00100000
+	processModeBackgroundEnd   = 0x00200000
+)
+
+var procSetPriorityClass = modkernel32.NewProc("SetPriorityClass")
+
+// setIOPriority puts the process in background mode, which lowers its I/O (and memory) priority, for low or idle.
+func setIOPriority(priority string) error {
+	mode := uintptr(processModeBackgroundBegin)
+
+	if priority == "normal" {
+		mode = processModeBackgroundEnd
+	}
+
+	process, err := syscall.GetCurrentProcess()
+
+	if err != nil {
+		return err
+	}
+
+	if r, _, err := procSetPriorityClass.Call(uintptr(process), mode); r == 0 {
+		return err
+	}
+
+	return nil
+}
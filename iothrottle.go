@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+)
+
+// diskRate limits the combined speed of writing downloads to disk and reading firmwares to verify or copy them
+// (w/ -io-limit)
+var diskRate = &rateLimiter{}
+
+// appliedIOPriority is the -io-priority which was last applied
+var appliedIOPriority = "normal"
+
+// setupIOThrottle applies -io-limit and -io-priority.
+func setupIOThrottle() error {
+	limit, err := parseRate(ioLimit)
+
+	if err != nil {
+		return fmt.Errorf("invalid -io-limit: %s, err: %s", ioLimit, err)
+	}
+
+	if ioPriority != "normal" && ioPriority != "low" && ioPriority != "idle" {
+		return fmt.Errorf("unknown -io-priority: %s, use normal, low or idle", ioPriority)
+	}
+
+	diskRate.setRate(limit)
+
+	if ioPriority == appliedIOPriority {
+		return nil
+	}
+
+	if err := setIOPriority(ioPriority); err != nil {
+		warnf("Unable to set the I/O priority to %s, err: %s", ioPriority, err)
+		return nil
+	}
+
+	appliedIOPriority = ioPriority
+	debugf("Set the I/O priority to %s", ioPriority)
+
+	return nil
+}
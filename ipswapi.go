@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// ipswAPIHandler serves the catalog through the parts of the ipsw.me v4 API which describe IPSWs, so that other tools can use
+// the mirror in place of ipsw.me. Downloaded firmwares' URLs point at the mirror, and the rest at Apple.
+func ipswAPIHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v4"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "devices":
+		devices := make([]api.BaseDevice, 0, len(catalog.Devices))
+
+		for _, device := range catalog.Devices {
+			devices = append(devices, device.BaseDevice)
+		}
+
+		writeJSON(w, http.StatusOK, devices)
+		return
+	case len(parts) == 2 && parts[0] == "device" && r.URL.Query().Get("type") != "ota":
+		if device := catalogDevice(catalog, parts[1]); device != nil {
+			for i := range device.Firmwares {
+				mirrorFirmware(r, &device.Firmwares[i], &device.BaseDevice)
+			}
+
+			writeJSON(w, http.StatusOK, device)
+			return
+		}
+	case len(parts) == 3 && parts[0] == "ipsw":
+		if device := catalogDevice(catalog, parts[1]); device != nil {
+			for _, fw := range device.Firmwares {
+				if fw.BuildID == parts[2] {
+					mirrorFirmware(r, &fw, &device.BaseDevice)
+					writeJSON(w, http.StatusOK, fw)
+					return
+				}
+			}
+		}
+	case len(parts) == 2 && parts[0] == "ipsw":
+		firmwares := []api.Firmware{}
+
+		for _, device := range catalog.Devices {
+			for _, fw := range device.Firmwares {
+				if fw.Version == parts[1] {
+					mirrorFirmware(r, &fw, &device.BaseDevice)
+					firmwares = append(firmwares, fw)
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, firmwares)
+		return
+	case len(parts) == 4 && parts[0] == "ipsw" && parts[1] == "download":
+		if device := catalogDevice(catalog, parts[2]); device != nil {
+			for _, fw := range device.Firmwares {
+				if fw.BuildID == parts[3] {
+					mirrorFirmware(r, &fw, &device.BaseDevice)
+					http.Redirect(w, r, fw.URL, http.StatusFound)
+					return
+				}
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+}
+
+// catalogDevice returns the catalog's entry for a device, or nil if it isn't in the catalog.
+func catalogDevice(catalog *firmwareCatalog, identifier string) *api.Device {
+	for i := range catalog.Devices {
+		if strings.EqualFold(catalog.Devices[i].Identifier, identifier) {
+			return &catalog.Devices[i]
+		}
+	}
+
+	return nil
+}
+
+// mirrorFirmware points a firmware's URL at the mirror's copy, if it has been downloaded.
+func mirrorFirmware(r *http.Request, fw *api.Firmware, device *api.BaseDevice) {
+	path, err := firmwarePath(fw, device)
+
+	if err != nil {
+		return
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	u, err := fileURL(path)
+
+	if err != nil {
+		return
+	}
+
+	scheme := "http"
+
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	fw.URL = scheme + "://" + r.Host + u
+}
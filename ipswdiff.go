@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ipswFile is a member of an IPSW
+type ipswFile struct {
+	size  uint64
+	crc32 uint32
+}
+
+// ipswComponent is a component of a build identity in a BuildManifest, e.g. the KernelCache
+type ipswComponent struct {
+	digest, path string
+}
+
+// ipswContents is what diff-ipsw compares of an IPSW: its members, and the components of each of its build identities
+type ipswContents struct {
+	path, version, build string
+	files                map[string]ipswFile
+
+	// components are by build identity (device class and variant), then by name
+	components map[string]map[string]ipswComponent
+}
+
+// ipswChange is a difference between two IPSWs: a file (member), component or build identity which was added,
+// removed or changed
+type ipswChange struct {
+	Type   string `json:"type"`
+	Change string `json:"change"`
+	Name   string `json:"name"`
+
+	// Identities are the build identities a component changed in
+	Identities []string `json:"identities,omitempty"`
+	Old        string   `json:"old,omitempty"`
+	New        string   `json:"new,omitempty"`
+}
+
+// ipswDiffSide is one of the IPSWs compared
+type ipswDiffSide struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	BuildID string `json:"buildid"`
+}
+
+// ipswDiff is the difference between two IPSWs
+type ipswDiff struct {
+	Old     ipswDiffSide `json:"old"`
+	New     ipswDiffSide `json:"new"`
+	Changes []ipswChange `json:"changes"`
+}
+
+// diffIPSWCommand compares the contents of the two IPSWs given as arguments, by their members and the digests of the
+// components in their BuildManifests, as text or (w/ -json) JSON.
+func diffIPSWCommand() error {
+	if flag.NArg() != 2 {
+		return errors.New("give the two IPSWs to compare, e.g. diff-ipsw old.ipsw new.ipsw")
+	}
+
+	old, err := readIPSWContents(flag.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	new, err := readIPSWContents(flag.Arg(1))
+
+	if err != nil {
+		return err
+	}
+
+	diff := diffIPSWs(old, new)
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(diff)
+	}
+
+	fmt.Fprintf(out, "--- %s %s (%s)\n+++ %s %s (%s)\n", diff.Old.Path, diff.Old.Version, diff.Old.BuildID, diff.New.Path, diff.New.Version, diff.New.BuildID)
+
+	counts := make(map[string]int)
+	signs := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+
+	for _, change := range diff.Changes {
+		counts[change.Change]++
+
+		line := fmt.Sprintf("%s %s %s", signs[change.Change], change.Type, change.Name)
+
+		if len(change.Identities) > 0 {
+			line += " [" + strings.Join(change.Identities, ", ") + "]"
+		}
+
+		switch change.Change {
+		case "added":
+			line += ": " + change.New
+		case "removed":
+			line += ": " + change.Old
+		default:
+			line += ": " + change.Old + " -> " + change.New
+		}
+
+		fmt.Fprintln(out, line)
+	}
+
+	infof("%d added, %d removed, %d changed", counts["added"], counts["removed"], counts["changed"])
+
+	return nil
+}
+
+// readIPSWContents reads the members of the IPSW at path, and its BuildManifest.
+func readIPSWContents(path string) (*ipswContents, error) {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to open IPSW: %s, err: %s", path, err)
+	}
+
+	defer archive.Close()
+
+	contents := &ipswContents{path: path, files: make(map[string]ipswFile), components: make(map[string]map[string]ipswComponent)}
+	var manifest *zip.File
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		contents.files[file.Name] = ipswFile{size: file.UncompressedSize64, crc32: file.CRC32}
+
+		if file.Name == "BuildManifest.plist" {
+			manifest = file
+		}
+	}
+
+	if manifest == nil {
+		warnf("%s has no BuildManifest.plist, only comparing its files", path)
+		return contents, nil
+	}
+
+	root, err := decodeBuildManifest(manifest)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read BuildManifest of %s, err: %s", path, err)
+	}
+
+	contents.version, _ = root["ProductVersion"].(string)
+	contents.build, _ = root["ProductBuildVersion"].(string)
+
+	identities, _ := root["BuildIdentities"].([]interface{})
+
+	for _, v := range identities {
+		identity, _ := v.(map[string]interface{})
+		info, _ := identity["Info"].(map[string]interface{})
+		deviceClass, _ := info["DeviceClass"].(string)
+		variant, _ := info["Variant"].(string)
+
+		name := strings.TrimSpace(deviceClass + " " + variant)
+
+		// identities can share a device class and variant, e.g. for different board IDs
+		for i := 2; contents.components[name] != nil; i++ {
+			name = fmt.Sprintf("%s %s #%d", deviceClass, variant, i)
+		}
+
+		components := make(map[string]ipswComponent)
+		contents.components[name] = components
+
+		manifest, _ := identity["Manifest"].(map[string]interface{})
+
+		for component, v := range manifest {
+			entry, _ := v.(map[string]interface{})
+			digest, _ := entry["Digest"].(string)
+			info, _ := entry["Info"].(map[string]interface{})
+			path, _ := info["Path"].(string)
+
+			components[component] = ipswComponent{digest: manifestDigest(digest), path: path}
+		}
+	}
+
+	return contents, nil
+}
+
+// manifestDigest returns the hex of a digest in a BuildManifest, which is base64 encoded data.
+func manifestDigest(data string) string {
+	data = strings.Join(strings.Fields(data), "")
+
+	b, err := base64.StdEncoding.DecodeString(data)
+
+	if err != nil {
+		return data
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (f ipswFile) String() string {
+	return fmt.Sprintf("%s, CRC-32 %08x", humanize.Bytes(f.size), f.crc32)
+}
+
+func (c ipswComponent) String() string {
+	digest := c.digest
+
+	if digest == "" {
+		digest = "no digest"
+	}
+
+	if c.path == "" {
+		return digest
+	}
+
+	return fmt.Sprintf("%s (%s)", digest, c.path)
+}
+
+// diffIPSWs compares two IPSWs. Changes to a component which are the same in several build identities are reported
+// once, listing the identities.
+func diffIPSWs(old, new *ipswContents) *ipswDiff {
+	diff := &ipswDiff{
+		Old:     ipswDiffSide{Path: old.path, Version: old.version, BuildID: old.build},
+		New:     ipswDiffSide{Path: new.path, Version: new.version, BuildID: new.build},
+		Changes: []ipswChange{},
+	}
+
+	for _, name := range unionKeys(old.files, new.files) {
+		o, inOld := old.files[name]
+		n, inNew := new.files[name]
+
+		switch {
+		case !inOld:
+			diff.Changes = append(diff.Changes, ipswChange{Type: "file", Change: "added", Name: name, New: n.String()})
+		case !inNew:
+			diff.Changes = append(diff.Changes, ipswChange{Type: "file", Change: "removed", Name: name, Old: o.String()})
+		case o != n:
+			diff.Changes = append(diff.Changes, ipswChange{Type: "file", Change: "changed", Name: name, Old: o.String(), New: n.String()})
+		}
+	}
+
+	var components []ipswChange
+	merged := make(map[string]int)
+
+	for _, identity := range unionKeys(old.components, new.components) {
+		o, inOld := old.components[identity]
+		n, inNew := new.components[identity]
+
+		if !inOld {
+			diff.Changes = append(diff.Changes, ipswChange{Type: "identity", Change: "added", Name: identity, New: fmt.Sprintf("%d components", len(n))})
+			continue
+		} else if !inNew {
+			diff.Changes = append(diff.Changes, ipswChange{Type: "identity", Change: "removed", Name: identity, Old: fmt.Sprintf("%d components", len(o))})
+			continue
+		}
+
+		for _, name := range unionKeys(o, n) {
+			oc, inOld := o[name]
+			nc, inNew := n[name]
+
+			change := ipswChange{Type: "component", Name: name}
+
+			switch {
+			case !inOld:
+				change.Change, change.New = "added", nc.String()
+			case !inNew:
+				change.Change, change.Old = "removed", oc.String()
+			case oc != nc:
+				change.Change, change.Old, change.New = "changed", oc.String(), nc.String()
+			default:
+				continue
+			}
+
+			key := strings.Join([]string{change.Change, name, change.Old, change.New}, "\x00")
+
+			if i, ok := merged[key]; ok {
+				components[i].Identities = append(components[i].Identities, identity)
+				continue
+			}
+
+			merged[key] = len(components)
+			change.Identities = []string{identity}
+			components = append(components, change)
+		}
+	}
+
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+
+	diff.Changes = append(diff.Changes, components...)
+
+	return diff
+}
+
+// unionKeys returns the keys of two maps of the same type, sorted.
+func unionKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+
+	add := func(m interface{}) {
+		switch m := m.(type) {
+		case map[string]ipswFile:
+			for k := range m {
+				seen[k] = true
+			}
+		case map[string]ipswComponent:
+			for k := range m {
+				seen[k] = true
+			}
+		case map[string]map[string]ipswComponent:
+			for k := range m {
+				seen[k] = true
+			}
+		}
+	}
+
+	add(a)
+	add(b)
+
+	for k := range seen {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
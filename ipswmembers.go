@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// ipswMemberHandler serves the members of downloaded IPSWs at /{identifier}/{version or build}/{member}, read from the
+// IPSW as they're requested, with range requests. Members are found by their path in the IPSW, their base name, a glob
+// of either, or the start of their base name, e.g. kernelcache for kernelcache.release.iphone14. Without a member, the
+// IPSW's members are listed.
+func ipswMemberHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+
+	if len(parts) == 2 {
+		parts = append(parts, "")
+	}
+
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	device := catalogDevice(catalog, parts[0])
+
+	if device == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	downloadPath := storedFirmwarePath(device, parts[1])
+
+	if downloadPath == "" {
+		http.Error(w, fmt.Sprintf("%s %s hasn't been downloaded", device.Identifier, parts[1]), http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(downloadPath)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	archive, err := zip.NewReader(f, info.Size())
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read %s, err: %s", path.Base(downloadPath), err), http.StatusInternalServerError)
+		return
+	}
+
+	if parts[2] == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		for _, file := range archive.File {
+			if !strings.HasSuffix(file.Name, "/") {
+				fmt.Fprintln(w, file.Name)
+			}
+		}
+
+		return
+	}
+
+	matches := findMembers(archive.File, parts[2])
+
+	switch len(matches) {
+	case 0:
+		http.NotFound(w, r)
+	case 1:
+		serveMember(w, r, f, matches[0])
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMultipleChoices)
+
+		for _, file := range matches {
+			u := url.URL{Path: "/" + device.Identifier + "/" + parts[1] + "/" + file.Name}
+			fmt.Fprintln(w, u.EscapedPath())
+		}
+	}
+}
+
+// storedFirmwarePath returns the path of the newest downloaded firmware of device with the version or build given, or
+// "" if none has been downloaded.
+func storedFirmwarePath(device *api.Device, versionOrBuild string) string {
+	var newest *api.Firmware
+	var newestPath string
+
+	for i := range device.Firmwares {
+		fw := &device.Firmwares[i]
+
+		if fw.Version != versionOrBuild && !strings.EqualFold(fw.BuildID, versionOrBuild) {
+			continue
+		}
+
+		downloadPath, err := firmwarePath(fw, &device.BaseDevice)
+
+		if err != nil {
+			continue
+		}
+
+		if _, err := os.Stat(downloadPath); err != nil {
+			continue
+		}
+
+		if newest == nil || firmwareDate(fw).After(firmwareDate(newest)) {
+			newest, newestPath = fw, downloadPath
+		}
+	}
+
+	return newestPath
+}
+
+// findMembers returns the members of an IPSW called name: the one at that path, or else those whose base name is name,
+// which match it as a glob, or whose base name starts with it, in that order of preference.
+func findMembers(files []*zip.File, name string) []*zip.File {
+	tests := []func(file *zip.File) bool{
+		func(file *zip.File) bool {
+			return file.Name == name
+		},
+		func(file *zip.File) bool {
+			return path.Base(file.Name) == name
+		},
+		func(file *zip.File) bool {
+			return memberMatches(file.Name, []string{name})
+		},
+		func(file *zip.File) bool {
+			return strings.HasPrefix(path.Base(file.Name), name+".")
+		},
+	}
+
+	for _, test := range tests {
+		var matches []*zip.File
+
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name, "/") && test(file) {
+				matches = append(matches, file)
+			}
+		}
+
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+
+	return nil
+}
+
+// serveMember serves a member of the IPSW ipsw, with range requests.
+func serveMember(w http.ResponseWriter, r *http.Request, ipsw io.ReaderAt, file *zip.File) {
+	var content io.ReadSeeker
+
+	if file.Method == zip.Store {
+		// uncompressed members are read straight from the IPSW
+		offset, err := file.DataOffset()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		content = io.NewSectionReader(ipsw, offset, int64(file.UncompressedSize64))
+	} else {
+		member := &memberReader{file: file, size: int64(file.UncompressedSize64)}
+		defer member.Close()
+
+		content = member
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", path.Base(file.Name)))
+
+	http.ServeContent(w, r, path.Base(file.Name), file.Modified, content)
+}
+
+// memberReader reads a compressed member of a zip from any offset, by decompressing it from the start and discarding
+// what comes before the offset, reopening it to seek backwards.
+type memberReader struct {
+	file *zip.File
+	size int64
+
+	r io.ReadCloser
+
+	// offset is where the next read is from, and position where r is
+	offset, position int64
+}
+
+func (m *memberReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += m.offset
+	case io.SeekEnd:
+		offset += m.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	m.offset = offset
+
+	return offset, nil
+}
+
+func (m *memberReader) Read(p []byte) (int, error) {
+	if m.offset >= m.size {
+		return 0, io.EOF
+	}
+
+	if m.r == nil || m.offset < m.position {
+		m.Close()
+
+		r, err := m.file.Open()
+
+		if err != nil {
+			return 0, err
+		}
+
+		m.r, m.position = r, 0
+	}
+
+	if m.offset > m.position {
+		n, err := io.CopyN(io.Discard, m.r, m.offset-m.position)
+		m.position += n
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := m.r.Read(p)
+	m.offset += int64(n)
+	m.position += int64(n)
+
+	return n, err
+}
+
+func (m *memberReader) Close() error {
+	if m.r == nil {
+		return nil
+	}
+
+	err := m.r.Close()
+	m.r = nil
+
+	return err
+}
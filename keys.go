@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// keysPauseReason is why downloads are paused when p is pressed
+const keysPauseReason = "paused from the keyboard"
+
+var (
+	// skipGeneration is incremented when s is pressed, which skips the downloads started before it
+	skipGeneration uint64
+
+	errSkipped = errors.New("download skipped")
+
+	// keyPresses are the keys read from the terminal, by a goroutine started once, as reads of stdin can't be cancelled
+	keyPresses    = make(chan byte)
+	keyReaderOnce sync.Once
+
+	// restoreTerminal undoes setKeyMode while keyboard controls are active, so it can be undone on exit
+	restoreTerminal   func()
+	restoreTerminalMu sync.Mutex
+)
+
+// skipRequested reports whether the download which started at generation has since been skipped.
+func skipRequested(generation uint64) bool {
+	return atomic.LoadUint64(&skipGeneration) != generation
+}
+
+// startKeyboardControls reads keys from the terminal while scheduler's jobs are downloaded, w/ -keys: p pauses or
+// resumes downloads, s skips the current downloads (re-queueing them at the end) and q stops once the current downloads
+// finish, saving the rest of the queue for the next run. The returned function stops reading them.
+func startKeyboardControls(scheduler *jobScheduler) func() {
+	if !keyboardControls || !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return func() {}
+	}
+
+	restore, err := setKeyMode(os.Stdin)
+
+	if err != nil {
+		debugf("Unable to read keys from the terminal, err: %s", err)
+		return func() {}
+	}
+
+	restoreTerminalMu.Lock()
+	restoreTerminal = restore
+	restoreTerminalMu.Unlock()
+
+	keyReaderOnce.Do(func() {
+		go func() {
+			b := make([]byte, 1)
+
+			for {
+				if n, err := os.Stdin.Read(b); err != nil {
+					return
+				} else if n == 1 {
+					keyPresses <- b[0]
+				}
+			}
+		}()
+	})
+
+	infof("Press p to pause or resume, s to skip the current download(s) until the end of the queue, or q to stop after them")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case key := <-keyPresses:
+				handleKey(key, scheduler)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+
+		resetTerminal()
+		setPaused(keysPauseReason, false)
+	}
+}
+
+// handleKey acts on a key pressed during a run.
+func handleKey(key byte, scheduler *jobScheduler) {
+	switch key {
+	case 'p', 'P':
+		pauseMu.Lock()
+		paused := pauseReasons[keysPauseReason]
+		pauseMu.Unlock()
+
+		setPaused(keysPauseReason, !paused)
+	case 's', 'S':
+		infof("Skipping the current download(s), they will be retried at the end of the queue")
+		atomic.AddUint64(&skipGeneration, 1)
+	case 'q', 'Q':
+		infof("Stopping once the current download(s) finish, the rest of the queue will be resumed by the next run")
+		scheduler.stopAfterCurrent()
+	}
+}
+
+// resetTerminal restores the terminal's mode, if keyboard controls changed it.
+func resetTerminal() {
+	restoreTerminalMu.Lock()
+	defer restoreTerminalMu.Unlock()
+
+	if restoreTerminal != nil {
+		restoreTerminal()
+		restoreTerminal = nil
+	}
+}
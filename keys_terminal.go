@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// setKeyMode makes the terminal f pass on each key as it's pressed, without echoing it, leaving output and signals
+// (such as Ctrl-C) as they are. It returns a function which restores the terminal's mode.
+func setKeyMode(f *os.File) (func(), error) {
+	var old syscall.Termios
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlReadTermios, uintptr(unsafe.Pointer(&old))); errno != 0 {
+		return nil, errno
+	}
+
+	mode := old
+	mode.Lflag &^= syscall.ICANON | syscall.ECHO
+	mode.Cc[syscall.VMIN] = 1
+	mode.Cc[syscall.VTIME] = 0
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlWriteTermios, uintptr(unsafe.Pointer(&mode))); errno != 0 {
+		return nil, errno
+	}
+
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlWriteTermios, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package main
+
+import "os"
+
+// setKeyMode leaves the terminal as it is, so keys are read once Enter is pressed.
+func setKeyMode(f *os.File) (func(), error) {
+	return func() {}, nil
+}
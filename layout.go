@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// layoutPresets are the -layout presets: where the firmwares are stored beneath the user's home directory (or -d, if
+// given), and the directory template beneath that
+var layoutPresets = map[string]struct{ root, directory string }{
+	// iTunes, and Finder since macOS 10.15, look for firmwares in a directory per device family
+	"itunes": {"Library/iTunes", "{{.ITunesDirectory}}"},
+
+	// Apple Configurator caches firmwares in one directory
+	"configurator": {"Library/Group Containers/K36BKF7T3D.group.com.apple.configurator/Library/Caches/Firmware", ""},
+}
+
+// presetDirectoryTemplate is the directory template of the -layout preset, or empty without one
+var presetDirectoryTemplate string
+
+// directoryTemplate returns the directory template firmwares are stored with: that of the -layout preset, or -d.
+func directoryTemplate() string {
+	if presetDirectoryTemplate != "" {
+		return presetDirectoryTemplate
+	}
+
+	return downloadDirectoryTemplate
+}
+
+// applyLayoutPreset applies the -layout preset, which stores firmwares with Apple's filenames where iTunes, Finder or
+// Apple Configurator look for them, beneath the user's home directory unless -d is given.
+func applyLayoutPreset() error {
+	presetDirectoryTemplate = ""
+
+	if layoutPreset == "" {
+		return nil
+	}
+
+	preset, ok := layoutPresets[layoutPreset]
+
+	if !ok {
+		return fmt.Errorf("unknown -layout: %s, use itunes or configurator", layoutPreset)
+	}
+
+	if filenameTemplate != "" || prefixIdentifier {
+		return fmt.Errorf("-layout %s needs Apple's filenames, so can't be used with -filename or -prefix-identifier", layoutPreset)
+	}
+
+	root := downloadDirectoryTemplate
+
+	if root == flag.Lookup("d").DefValue {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return fmt.Errorf("unable to find the home directory for -layout %s, use -d, err: %s", layoutPreset, err)
+		}
+
+		root = filepath.Join(home, filepath.FromSlash(preset.root))
+	}
+
+	presetDirectoryTemplate = filepath.Join(root, preset.directory)
+
+	// the root is where the firmwares are stored, even if it has no template actions
+	if preset.directory == "" {
+		presetDirectoryTemplate += string(filepath.Separator)
+	}
+
+	return nil
+}
+
+// checkLayout validates the -content-addressed and -reuse flags.
+func checkLayout() error {
+	if reuseExisting != "" && reuseExisting != "move" && reuseExisting != "link" {
+		return fmt.Errorf("unknown -reuse: %s, use move or link", reuseExisting)
+	}
+
+	if !contentAddressed {
+		return nil
+	}
+
+	if linkType != "symlink" && linkType != "hardlink" {
+		return fmt.Errorf("unknown link type: %s", linkType)
+	}
+
+	if destinationURL != "" {
+		return errors.New("-content-addressed can't be used with -dest")
+	}
+
+	return nil
+}
+
+// storedAsObject reports whether a firmware is stored in objects/, which firmwares without a known SHA1 can't be.
+func storedAsObject(fw *api.Firmware) bool {
+	return contentAddressed && fw.SHA1Sum != ""
+}
+
+// objectPath returns where a firmware is stored w/ -content-addressed, i.e. objects/ab/cdef.../file.ipsw beneath the download root.
+// Firmwares shared by several devices are only stored once.
+func objectPath(fw *api.Firmware) string {
+	return filepath.Join(downloadRoot(), "objects", fw.SHA1Sum[:2], fw.SHA1Sum[2:], filepath.Base(fw.URL))
+}
+
+// jobDownloadPath returns where job's firmware is downloaded to: its path, or the object it is a view of
+// (w/ -content-addressed).
+func jobDownloadPath(job *downloadJob) string {
+	if storedAsObject(&job.Firmware) {
+		return objectPath(&job.Firmware)
+	}
+
+	return job.Path
+}
+
+// linkObject links path, in the -d directory tree, to the object it is a view of.
+func linkObject(object, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if linkType == "hardlink" {
+		if err := os.Link(object, path); err != nil {
+			return err
+		}
+
+		auditf("link", path, object, "-content-addressed view")
+
+		return nil
+	}
+
+	// relative links keep working if the download root is moved
+	target, err := filepath.Rel(filepath.Dir(path), object)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+
+	auditf("link", path, object, "-content-addressed view")
+
+	return nil
+}
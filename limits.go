@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	// latestLimit is the number of the latest firmwares of each device to download, from -latest or -l, or 0 for all
+	latestLimit int
+
+	// maxBytes is the parsed value of -max-bytes, or 0 if downloads are unlimited
+	maxBytes uint64
+
+	// maxFileSize is the parsed value of -max-file-size, or 0 if firmwares of any size are downloaded
+	maxFileSize uint64
+
+	// maxArchiveSize is the parsed value of -max-archive-size, or 0 if the archive isn't pruned
+	maxArchiveSize uint64
+
+	// minFreeSpace is the parsed value of -min-free, or 0 if free space isn't monitored
+	minFreeSpace uint64
+
+	// downloadsStarted is the number of downloads attempted in this run, counted against -max-files
+	downloadsStarted int64
+
+	// runStartDownloadedSize is the value of downloadedSize when this run started
+	runStartDownloadedSize uint64
+
+	// confirmOver is the parsed value of -confirm-over, or 0 if downloads are never confirmed
+	confirmOver uint64
+
+	// hostConcurrency is the parsed value of -host-concurrency, the most downloads to run at once from each host,
+	// and defaultHostConcurrency that for hosts it doesn't list (0 for no limit)
+	hostConcurrency        map[string]int
+	defaultHostConcurrency int
+)
+
+// parseLimits parses the flags which limit how much is downloaded in a run.
+func parseLimits() error {
+	if downloadWorkers < 1 {
+		return fmt.Errorf("invalid -j: %d, at least one firmware must be downloaded at once", downloadWorkers)
+	}
+
+	if err := parseHostConcurrency(); err != nil {
+		return err
+	}
+
+	if latestCount < 0 {
+		return fmt.Errorf("invalid -latest: %d, it must not be negative", latestCount)
+	}
+
+	latestLimit = latestCount
+
+	if downloadLatest && latestLimit == 0 {
+		latestLimit = 1
+	}
+
+	maxBytes = 0
+
+	if maxBytesValue != "" {
+		b, err := humanize.ParseBytes(maxBytesValue)
+
+		if err != nil {
+			return fmt.Errorf("invalid -max-bytes: %s, err: %s", maxBytesValue, err)
+		}
+
+		maxBytes = b
+	}
+
+	confirmOver = 0
+
+	if confirmOverValue != "" && confirmOverValue != "0" {
+		b, err := humanize.ParseBytes(confirmOverValue)
+
+		if err != nil {
+			return fmt.Errorf("invalid -confirm-over: %s, err: %s", confirmOverValue, err)
+		}
+
+		confirmOver = b
+	}
+
+	maxFileSize = 0
+
+	if maxFileSizeValue != "" {
+		b, err := humanize.ParseBytes(maxFileSizeValue)
+
+		if err != nil {
+			return fmt.Errorf("invalid -max-file-size: %s, err: %s", maxFileSizeValue, err)
+		}
+
+		maxFileSize = b
+	}
+
+	maxArchiveSize = 0
+
+	if maxArchiveSizeValue != "" && maxArchiveSizeValue != "0" {
+		b, err := humanize.ParseBytes(maxArchiveSizeValue)
+
+		if err != nil {
+			return fmt.Errorf("invalid -max-archive-size: %s, err: %s", maxArchiveSizeValue, err)
+		}
+
+		maxArchiveSize = b
+	}
+
+	minFreeSpace = 0
+
+	if minFreeValue != "" && minFreeValue != "0" {
+		b, err := humanize.ParseBytes(minFreeValue)
+
+		if err != nil {
+			return fmt.Errorf("invalid -min-free: %s, err: %s", minFreeValue, err)
+		}
+
+		minFreeSpace = b
+	}
+
+	return nil
+}
+
+// parseHostConcurrency parses -host-concurrency, e.g. "2" or "appldnld.apple.com=1,updates.cdn-apple.com=2,4".
+func parseHostConcurrency() error {
+	hostConcurrency = make(map[string]int)
+	defaultHostConcurrency = 0
+
+	if hostConcurrencyValue == "" {
+		return nil
+	}
+
+	for _, entry := range strings.Split(hostConcurrencyValue, ",") {
+		host, value := "", strings.TrimSpace(entry)
+
+		if i := strings.LastIndex(value, "="); i >= 0 {
+			host, value = strings.ToLower(strings.TrimSpace(value[:i])), strings.TrimSpace(value[i+1:])
+		}
+
+		limit, err := strconv.Atoi(value)
+
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid -host-concurrency: %s, use e.g. 2 or appldnld.apple.com=1,4", entry)
+		}
+
+		if host == "" {
+			defaultHostConcurrency = limit
+		} else {
+			hostConcurrency[host] = limit
+		}
+	}
+
+	return nil
+}
+
+// runLimitReached reports whether this run has downloaded everything it is allowed to (w/ -max-bytes or -max-files).
+func runLimitReached() bool {
+	if maxBytes > 0 && atomic.LoadUint64(&downloadedSize)-runStartDownloadedSize >= maxBytes {
+		infof("Reached the download limit of %s, not starting any more downloads", humanize.Bytes(maxBytes))
+		return true
+	}
+
+	if maxFiles > 0 && atomic.LoadInt64(&downloadsStarted) >= int64(maxFiles) {
+		infof("Reached the limit of %d file(s), not starting any more downloads", maxFiles)
+		return true
+	}
+
+	return false
+}
+
+// resetRunLimits starts counting towards -max-bytes and -max-files again, for a new run in daemon mode.
+func resetRunLimits() {
+	atomic.StoreInt64(&downloadsStarted, 0)
+	runStartDownloadedSize = atomic.LoadUint64(&downloadedSize)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+	"github.com/dustin/go-humanize"
+)
+
+// linkCheckResult is a firmware whose URL link-check found to be dead, changed or unreachable
+type linkCheckResult struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	URL        string `json:"url"`
+
+	// Status is dead, changed (in size) or error (if it couldn't be checked)
+	Status       string `json:"status"`
+	HTTPStatus   int    `json:"http_status,omitempty"`
+	Size         uint64 `json:"size,omitempty"`
+	UpstreamSize int64  `json:"upstream_size,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	// Downloaded is whether the archive has a copy, at Path, which can't be replaced if the link is dead
+	Downloaded bool   `json:"downloaded"`
+	Path       string `json:"path,omitempty"`
+}
+
+// linkCheckReport is what the last link-check found
+type linkCheckReport struct {
+	Checked time.Time         `json:"checked"`
+	Links   int               `json:"links"`
+	Results []linkCheckResult `json:"results"`
+}
+
+func linkCheckPath() string {
+	return filepath.Join(stateDirectory(), "linkcheck.json")
+}
+
+// linkCheckCommand requests (HEAD) the URL of every firmware in the catalog of the devices selected, at most
+// -link-check-rate a second, and reports those which are dead or have changed size, and whether the archive has a copy
+// of each, since those copies can no longer be downloaded again.
+func linkCheckCommand() error {
+	if linkCheckRate <= 0 {
+		return errors.New("-link-check-rate must be positive")
+	}
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	var firmwares []*api.Firmware
+	var devices []*api.BaseDevice
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+
+		if !deviceSelected(device.Identifier) {
+			continue
+		}
+
+		for j := range device.Firmwares {
+			if device.Firmwares[j].URL != "" {
+				firmwares = append(firmwares, &device.Firmwares[j])
+				devices = append(devices, &device.BaseDevice)
+			}
+		}
+	}
+
+	if len(firmwares) == 0 {
+		return errors.New("no firmwares in the catalog to check, run download (or download -c) first")
+	}
+
+	infof("Checking %d link(s) at up to %g a second", len(firmwares), linkCheckRate)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / linkCheckRate))
+	defer ticker.Stop()
+
+	workers := downloadWorkers
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	results := make([]*linkCheckResult, len(firmwares))
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				results[i] = checkLink(firmwares[i], devices[i])
+			}
+		}()
+	}
+
+	checked := 0
+
+	for i := range firmwares {
+		if shutdownRequested() {
+			break
+		}
+
+		<-ticker.C
+		indexes <- i
+		checked++
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	report := linkCheckReport{Checked: time.Now(), Links: checked}
+
+	for _, result := range results {
+		if result != nil {
+			report.Results = append(report.Results, *result)
+		}
+	}
+
+	if err := writeJSONFile(linkCheckPath(), report); err != nil {
+		warnf("Unable to save link check: %s, err: %s", linkCheckPath(), err)
+	}
+
+	if err := writeLinkCheck(report); err != nil {
+		return err
+	}
+
+	if shutdownRequested() {
+		return errShutdown
+	}
+
+	return nil
+}
+
+// checkLink requests the URL of fw, returning nil if it's fine.
+func checkLink(fw *api.Firmware, device *api.BaseDevice) *linkCheckResult {
+	result := &linkCheckResult{Identifier: fw.Identifier, Version: fw.Version, BuildID: fw.BuildID, URL: fw.URL, Size: fw.Filesize}
+
+	if path, err := firmwarePath(fw, device); err == nil {
+		result.Downloaded, result.Path = linkCheckDownloaded(path)
+	}
+
+	status, size, err := linkStatus(fw.URL)
+
+	switch {
+	case err != nil:
+		result.Status, result.Error = "error", err.Error()
+	case status == http.StatusNotFound || status == http.StatusGone || status == http.StatusForbidden:
+		result.Status, result.HTTPStatus = "dead", status
+	case status < 200 || status > 299:
+		result.Status, result.HTTPStatus = "error", status
+		result.Error = fmt.Sprintf("unexpected response status: %d %s", status, http.StatusText(status))
+	case size >= 0 && fw.Filesize > 0 && uint64(size) != fw.Filesize:
+		result.Status, result.HTTPStatus, result.UpstreamSize = "changed", status, size
+	default:
+		debugf("%s %s (%s) is available: %s", fw.Identifier, fw.Version, fw.BuildID, fw.URL)
+		return nil
+	}
+
+	return result
+}
+
+// linkStatus returns the response status and size of url, requesting just its first byte if the server doesn't
+// support HEAD. The size is -1 if it isn't known.
+func linkStatus(url string) (int, int64, error) {
+	resp, err := downloadClient.Head(url)
+
+	if err != nil {
+		return 0, -1, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+		return resp.StatusCode, resp.ContentLength, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return 0, -1, err
+	}
+
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err = downloadClient.Do(req)
+
+	if err != nil {
+		return 0, -1, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.StatusCode, resp.ContentLength, nil
+	}
+
+	// e.g. bytes 0-0/5234567890
+	total := resp.Header.Get("Content-Range")
+
+	if i := strings.LastIndex(total, "/"); i >= 0 {
+		if size, err := strconv.ParseInt(total[i+1:], 10, 64); err == nil {
+			return http.StatusOK, size, nil
+		}
+	}
+
+	return http.StatusOK, -1, nil
+}
+
+// linkCheckDownloaded returns whether the firmware at path has been downloaded, locally or to -dest, and where.
+func linkCheckDownloaded(path string) (bool, string) {
+	if destination != nil {
+		name, err := storageName(path)
+
+		if err != nil {
+			return false, ""
+		}
+
+		exists, err := destination.exists(name)
+
+		return err == nil && exists, destination.String() + "/" + name
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return false, ""
+	}
+
+	return true, path
+}
+
+// writeLinkCheck writes what link-check found to -o, as text or (w/ -json) JSON.
+func writeLinkCheck(report linkCheckReport) error {
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	}
+
+	dead, changed, failed, lost := 0, 0, 0, 0
+
+	for _, result := range report.Results {
+		name := fmt.Sprintf("%s %s (%s)", result.Identifier, result.Version, result.BuildID)
+
+		switch result.Status {
+		case "dead":
+			dead++
+
+			if result.Downloaded {
+				fmt.Fprintf(out, "x %s is gone upstream (%d), keep %s, it can't be downloaded again: %s\n", name, result.HTTPStatus, result.Path, result.URL)
+			} else {
+				lost++
+				fmt.Fprintf(out, "x %s is gone upstream (%d) and wasn't downloaded: %s\n", name, result.HTTPStatus, result.URL)
+			}
+		case "changed":
+			changed++
+			fmt.Fprintf(out, "! %s changed size upstream from %s to %s: %s\n", name, humanize.Bytes(result.Size), humanize.Bytes(uint64(result.UpstreamSize)), result.URL)
+		default:
+			failed++
+			fmt.Fprintf(out, "? %s couldn't be checked, err: %s: %s\n", name, result.Error, result.URL)
+		}
+	}
+
+	infof("Checked %d link(s): %d dead (%d of which weren't downloaded), %d changed size, %d couldn't be checked", report.Links, dead, lost, changed, failed)
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockSuffix is appended to the path of a firmware to give the path of its lock file (w/ -lock-files)
+const lockSuffix = ".lock"
+
+var errLocked = errors.New("locked by another process")
+
+// fileLock is an exclusive advisory lock on a file, held until released or the process exits
+type fileLock struct {
+	file *os.File
+}
+
+// acquireLock locks the file at path, creating it if needed. If wait is false and another process
+// holds the lock, errLocked is returned.
+func acquireLock(path string, wait bool) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lockFile(file, wait); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// the previous holder may have removed the file (see unlockDownload) after we opened it, so the lock is only
+		// ours if the file locked is still the one at path, otherwise a new one is created and locked
+		current, err := lockedFileCurrent(file, path)
+
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return nil, err
+		}
+
+		if current {
+			return &fileLock{file: file}, nil
+		}
+
+		unlockFile(file)
+		file.Close()
+	}
+}
+
+// lockedFileCurrent reports whether file is still the file at path.
+func lockedFileCurrent(file *os.File, path string) (bool, error) {
+	opened, err := file.Stat()
+
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(opened, info), nil
+}
+
+func (l *fileLock) release() error {
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+func instanceLockPath() string {
+	return filepath.Join(stateDirectory(), "lock")
+}
+
+// acquireInstanceLock ensures only one instance runs against the download root at a time,
+// optionally waiting for the running instance to finish.
+func acquireInstanceLock(wait bool) (*fileLock, error) {
+	path := instanceLockPath()
+
+	lock, err := acquireLock(path, false)
+
+	if err == errLocked {
+		b, _ := os.ReadFile(path)
+		pid := strings.TrimSpace(string(b))
+
+		if pid == "" {
+			pid = "unknown"
+		}
+
+		if !wait {
+			return nil, fmt.Errorf("another instance (pid %s) is already running for %s, use -wait to wait for it to finish", pid, downloadRoot())
+		}
+
+		infof("Waiting for another instance (pid %s) running for %s to finish", pid, downloadRoot())
+
+		lock, err = acquireLock(path, true)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// record who holds the lock, for the benefit of anyone waiting on it
+	if err := lock.file.Truncate(0); err == nil {
+		lock.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return lock, nil
+}
+
+// lockDownload takes the lock for downloading to path, if per-file locking is enabled.
+// errLocked is returned if another instance is downloading the same file.
+func lockDownload(path string) (*fileLock, error) {
+	if !lockFiles {
+		return nil, nil
+	}
+
+	return acquireLock(path+lockSuffix, false)
+}
+
+// unlockDownload releases a lock taken by lockDownload.
+func unlockDownload(lock *fileLock, path string) {
+	if lock == nil {
+		return
+	}
+
+	// removed while it's still held, so that an instance which opened it meanwhile notices and locks a new file (on
+	// Windows, open files can't be removed, so it's left in place)
+	os.Remove(path + lockSuffix)
+
+	if err := lock.release(); err != nil {
+		warnf("Unable to release lock: %s, err: %s", path+lockSuffix, err)
+	}
+}
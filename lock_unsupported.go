@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly,!windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+func lockFile(f *os.File, wait bool) error {
+	return errors.New("file locking is not supported on " + runtime.GOOS)
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// logLevel is the severity of a log message
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var (
+	logger          = log.New(os.Stderr, "", log.LstdFlags)
+	minimumLogLevel = levelInfo
+
+	logLevelNames = map[string]logLevel{
+		"debug": levelDebug,
+		"info":  levelInfo,
+		"warn":  levelWarn,
+		"error": levelError,
+	}
+
+	logLevelPrefixes = map[logLevel]string{
+		levelDebug: "[DEBUG] ",
+		levelInfo:  "[INFO] ",
+		levelWarn:  "[WARN] ",
+		levelError: "[ERROR] ",
+	}
+
+	// journaldPrefixes are the sd-daemon(3) priority prefixes understood by journald
+	journaldPrefixes = map[logLevel]string{
+		levelDebug: "<7>",
+		levelInfo:  "<6>",
+		levelWarn:  "<4>",
+		levelError: "<3>",
+	}
+
+	// sysLogger, if set, receives all log messages instead of logger
+	sysLogger func(level logLevel, message string) error
+
+	// useColor is set when logging to a terminal which should receive colored output
+	useColor bool
+
+	logLevelColors = map[logLevel]string{
+		levelWarn:  colorYellow,
+		levelError: colorRed,
+	}
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// setupLogging applies the log level and log output flags to the logger.
+func setupLogging() error {
+	var err error
+
+	level, ok := logLevelNames[strings.ToLower(logLevelName)]
+
+	if !ok {
+		return fmt.Errorf("unknown log level: %s", logLevelName)
+	}
+
+	minimumLogLevel = level
+
+	if logToSyslog {
+		sysLogger, err = openSyslog()
+
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog, err: %s", err)
+		}
+
+		return nil
+	}
+
+	if logJournald {
+		// journald timestamps messages itself
+		logger.SetFlags(0)
+	}
+
+	if logFile == "" {
+		useColor = !noColor && !logJournald && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+
+		return nil
+	}
+
+	maxSize, err := humanize.ParseBytes(logFileMaxSize)
+
+	if err != nil {
+		return fmt.Errorf("invalid log file size: %s, err: %s", logFileMaxSize, err)
+	}
+
+	w, err := newRotatingWriter(logFile, int64(maxSize), logFileMaxBackups)
+
+	if err != nil {
+		return err
+	}
+
+	logger.SetOutput(w)
+
+	return nil
+}
+
+// isTerminal reports whether f is a character device, e.g. an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func logf(level logLevel, format string, args ...interface{}) {
+	logColorf(level, logLevelColors[level], format, args...)
+}
+
+// logColorf logs a message which is shown in the given color on terminals.
+func logColorf(level logLevel, color string, format string, args ...interface{}) {
+	if level < minimumLogLevel {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+
+	if sysLogger != nil {
+		if err := sysLogger(level, message); err == nil {
+			return
+		}
+	}
+
+	if logJournald {
+		logger.Print(journaldPrefixes[level] + message)
+	} else if useColor && color != "" {
+		logger.Print(color + logLevelPrefixes[level] + message + colorReset)
+	} else {
+		logger.Print(logLevelPrefixes[level] + message)
+	}
+}
+
+func debugf(format string, args ...interface{}) {
+	logf(levelDebug, format, args...)
+}
+
+func infof(format string, args ...interface{}) {
+	logf(levelInfo, format, args...)
+}
+
+// successf logs a message about something which completed successfully, e.g. a verified file.
+func successf(format string, args ...interface{}) {
+	logColorf(levelInfo, colorGreen, format, args...)
+}
+
+// skipf logs a message about something which was skipped.
+func skipf(format string, args ...interface{}) {
+	logColorf(levelDebug, colorYellow, format, args...)
+}
+
+func warnf(format string, args ...interface{}) {
+	logf(levelWarn, format, args...)
+}
+
+func errorf(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+}
+
+func fatalf(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+	os.Exit(1)
+}
+
+// rotatingWriter is an io.Writer which writes to a file, moving it aside once it grows beyond maxSize.
+// At most maxBackups old files are kept, named file.1 (newest) to file.N (oldest).
+type rotatingWriter struct {
+	mu sync.Mutex
+
+	filename   string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newRotatingWriter(filename string, maxSize int64, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+
+	return nil
+}
+
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.maxBackups > 0 {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			// older backups may not exist yet
+			os.Rename(fmt.Sprintf("%s.%d", w.filename, i), fmt.Sprintf("%s.%d", w.filename, i+1))
+		}
+
+		if err := os.Rename(w.filename, w.filename+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.filename); err != nil {
+		return err
+	}
+
+	return w.open()
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
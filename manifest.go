@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// manifestProductTypes reads the SupportedProductTypes (device identifiers) from the BuildManifest.plist of the IPSW at path.
+func manifestProductTypes(path string) ([]string, error) {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if file.Name != "BuildManifest.plist" {
+			continue
+		}
+
+		r, err := file.Open()
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+
+		return plistStringArray(r, "SupportedProductTypes")
+	}
+
+	return nil, errors.New("no BuildManifest.plist")
+}
+
+// decodeBuildManifest decodes the BuildManifest.plist member of an IPSW.
+func decodeBuildManifest(file *zip.File) (map[string]interface{}, error) {
+	r, err := file.Open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	plist, err := decodePlist(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := plist.(map[string]interface{})
+
+	if !ok {
+		return nil, errors.New("not a dictionary")
+	}
+
+	return root, nil
+}
+
+// plistStringArray reads the array of strings with the given key from an XML property list.
+func plistStringArray(r io.Reader, key string) ([]string, error) {
+	decoder := xml.NewDecoder(r)
+
+	var values []string
+	var lastKey, element string
+	inArray := false
+
+	for {
+		token, err := decoder.Token()
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in manifest", key)
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name.Local
+
+			if element == "array" && lastKey == key {
+				inArray = true
+			}
+		case xml.EndElement:
+			if inArray && t.Name.Local == "array" {
+				return values, nil
+			}
+
+			if t.Name.Local != "key" {
+				lastKey = ""
+			}
+
+			element = ""
+		case xml.CharData:
+			switch {
+			case element == "key":
+				lastKey = strings.TrimSpace(string(t))
+			case inArray && element == "string":
+				values = append(values, strings.TrimSpace(string(t)))
+			}
+		}
+	}
+}
+
+// checkBuildManifest checks that the IPSW of job is for the device it is filed under.
+func checkBuildManifest(job *downloadJob) error {
+	productTypes, err := manifestProductTypes(job.Path)
+
+	if err != nil {
+		return fmt.Errorf("unable to read BuildManifest, err: %s", err)
+	}
+
+	for _, productType := range productTypes {
+		if productType == job.Device.Identifier {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("filed under %s, but is for %s", job.Device.Identifier, strings.Join(productTypes, ", "))
+}
+
+// checkZipCRCs reads every member of the IPSW at path, which checks its CRC-32, showing progress after prefix.
+func checkZipCRCs(path, prefix string) error {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer archive.Close()
+
+	total := uint64(0)
+
+	for _, file := range archive.File {
+		total += file.UncompressedSize64
+	}
+
+	bar := newProgressBar(int64(total), filepath.Base(path)+" (CRC)").Prefix(prefix + "CRC ")
+	bar.Start()
+	defer bar.Finish()
+
+	for _, file := range archive.File {
+		if err := checkZipMember(file, bar); err != nil {
+			return fmt.Errorf("%s: %s", file.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func checkZipMember(file *zip.File, progress io.Writer) error {
+	r, err := file.Open()
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	// the reader returns zip.ErrChecksum at the end of the member if its CRC-32 doesn't match
+	_, err = io.Copy(io.MultiWriter(progress, aliveWriter{}), r)
+
+	return err
+}
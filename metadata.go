@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// metadataFormats writes firmware metadata in each format supported by export -format, besides the download plan formats
+var metadataFormats = map[string]func(w io.Writer, records []firmwareRecord) error{
+	"json":    exportMetadataJSON,
+	"csv":     exportMetadataCSV,
+	"sha1sum": exportSHA1Sums,
+	"ical":    exportCalendar,
+}
+
+// firmwareRecord is the complete information about a firmware and its device, and whether it has been downloaded
+type firmwareRecord struct {
+	Device   api.BaseDevice `json:"device"`
+	Firmware api.Firmware   `json:"firmware"`
+	Path     string         `json:"path"`
+	Present  bool           `json:"present"`
+}
+
+// firmwareRecords retrieves every firmware matching the flags, whether it has been downloaded or not.
+func firmwareRecords() ([]firmwareRecord, error) {
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	var records []firmwareRecord
+
+	for i := range selected {
+		if information[i] == nil {
+			return nil, fmt.Errorf("unable to retrieve firmwares for %s", selected[i].Identifier)
+		}
+
+		for index, ipsw := range information[i].Firmwares {
+			if !firmwareSelected(index, &ipsw) {
+				continue
+			}
+
+			path, err := firmwarePath(&ipsw, &selected[i])
+
+			if err != nil {
+				return nil, err
+			}
+
+			present, err := firmwareStored(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			records = append(records, firmwareRecord{Device: selected[i], Firmware: ipsw, Path: path, Present: present})
+		}
+	}
+
+	return records, nil
+}
+
+func exportMetadataJSON(w io.Writer, records []firmwareRecord) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(records)
+}
+
+// exportSHA1Sums writes a checksum manifest of the downloaded firmwares, in the format of sha1sum, with paths relative
+// to the download root, so that a copy of the archive can be checked with sha1sum -c.
+func exportSHA1Sums(w io.Writer, records []firmwareRecord) error {
+	for _, r := range records {
+		if !r.Present || r.Firmware.SHA1Sum == "" {
+			continue
+		}
+
+		path := r.Path
+
+		if _, rel, ok := poolRelative(path); ok {
+			path = rel
+		}
+
+		if _, err := fmt.Fprintf(w, "%s  %s\n", r.Firmware.SHA1Sum, filepath.ToSlash(path)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func exportMetadataCSV(w io.Writer, records []firmwareRecord) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{
+		"identifier", "name", "boardconfig", "platform", "cpid", "bdid",
+		"version", "buildid", "sha1", "md5", "size", "url", "uploaddate", "releasedate", "signed",
+		"path", "present",
+	}
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	formatDate := func(t time.Time, valid bool) string {
+		if !valid {
+			return ""
+		}
+
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	for _, r := range records {
+		d, fw := r.Device, r.Firmware
+
+		row := []string{
+			d.Identifier, d.Name, d.BoardConfig, d.Platform, strconv.Itoa(d.CPID), strconv.Itoa(d.BDID),
+			fw.Version, fw.BuildID, fw.SHA1Sum, fw.MD5Sum, strconv.FormatUint(fw.Filesize, 10), fw.URL,
+			formatDate(fw.UploadDate.Time, fw.UploadDate.Valid), formatDate(fw.ReleaseDate.Time, fw.ReleaseDate.Valid), strconv.FormatBool(fw.Signed),
+			r.Path, strconv.FormatBool(r.Present),
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
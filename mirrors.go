@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// probeSize is how much of a firmware is downloaded from each candidate URL to find the fastest (w/ -fastest-mirror)
+	probeSize = 1 << 20
+
+	probeTimeout = 5 * time.Second
+)
+
+// candidateURLs returns the URLs rawURL can be downloaded from, in the order to try them: rawURL, the other Apple CDN
+// hostnames (w/ -cdn-fallback) and the same path on each of -mirrors. With -fastest-mirror, they are probed and
+// ordered fastest first.
+func candidateURLs(rawURL string) []string {
+	urls := firmwareURLs(rawURL)
+
+	if mirrors != "" {
+		if u, err := url.Parse(rawURL); err == nil {
+			seen := make(map[string]bool)
+
+			for _, candidate := range urls {
+				seen[candidate] = true
+			}
+
+			for _, mirror := range strings.Split(mirrors, ",") {
+				candidate := strings.TrimSuffix(strings.TrimSpace(mirror), "/") + u.EscapedPath()
+
+				if strings.TrimSpace(mirror) != "" && !seen[candidate] {
+					seen[candidate] = true
+					urls = append(urls, candidate)
+				}
+			}
+		}
+	}
+
+	if fastestMirror && len(urls) > 1 {
+		urls = rankBySpeed(urls)
+	}
+
+	return urls
+}
+
+// probeResult is how fast the start of a firmware downloaded from a candidate URL
+type probeResult struct {
+	url            string
+	bytesPerSecond float64
+	err            error
+}
+
+// rankBySpeed downloads the start of the firmware from each URL at once, returning them fastest first. URLs which
+// failed are tried last, in their original order.
+func rankBySpeed(urls []string) []string {
+	results := make([]probeResult, len(urls))
+
+	var wg sync.WaitGroup
+
+	for i, u := range urls {
+		wg.Add(1)
+
+		go func(i int, u string) {
+			defer wg.Done()
+
+			results[i] = probeURL(u)
+		}(i, u)
+	}
+
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].err == nil) != (results[j].err == nil) {
+			return results[i].err == nil
+		}
+
+		return results[i].bytesPerSecond > results[j].bytesPerSecond
+	})
+
+	ranked := make([]string, len(results))
+
+	for i, result := range results {
+		if result.err != nil {
+			debugf("Probe of %s failed, err: %s", result.url, result.err)
+		} else {
+			debugf("Probe of %s: %s/s", result.url, humanize.Bytes(uint64(result.bytesPerSecond)))
+		}
+
+		ranked[i] = result.url
+	}
+
+	if results[0].err == nil && ranked[0] != urls[0] {
+		infof("Downloading from %s, the fastest of %d candidate(s) (%s/s)", ranked[0], len(urls), humanize.Bytes(uint64(results[0].bytesPerSecond)))
+	}
+
+	return ranked
+}
+
+// probeURL measures how fast the first probeSize bytes of u download, including connecting.
+func probeURL(u string) probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	request, err := http.NewRequest("GET", u, nil)
+
+	if err != nil {
+		return probeResult{url: u, err: err}
+	}
+
+	request = request.WithContext(ctx)
+	request.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeSize-1))
+
+	started := time.Now()
+
+	resp, err := downloadClient.Do(request)
+
+	if err != nil {
+		return probeResult{url: u, err: err}
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return probeResult{url: u, err: &statusError{status: resp.Status, code: resp.StatusCode}}
+	}
+
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, probeSize))
+
+	// a probe which timed out still shows how fast the URL is
+	if n == 0 && err != nil {
+		return probeResult{url: u, err: err}
+	}
+
+	return probeResult{url: u, bytesPerSecond: float64(n) / time.Since(started).Seconds()}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// mirrorCopy is the copy of a firmware on a mirror, which is verified by verify-mirror
+type mirrorCopy struct {
+	device *api.BaseDevice
+	fw     *api.Firmware
+	url    string
+}
+
+// mirrorReader reads a firmware from a mirror, at most -rate, until a shutdown is requested.
+type mirrorReader struct {
+	r io.Reader
+}
+
+func (m *mirrorReader) Read(p []byte) (int, error) {
+	if shutdownRequested() {
+		return 0, errShutdown
+	}
+
+	n, err := m.r.Read(p)
+
+	downloadRate.wait(n)
+
+	return n, err
+}
+
+// verifyMirrorCommand streams each firmware matching the flags from the mirror given as an argument, an HTTP server
+// laid out as the download root, e.g. that of serve at /files, and checks its checksum against the API's without
+// storing it.
+func verifyMirrorCommand() error {
+	base := strings.TrimSuffix(flag.Arg(0), "/")
+
+	if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New("give the mirror's URL, e.g. verify-mirror https://mirror.example.com/ipsw")
+	}
+
+	firmwares, err := mirrorCopies(base)
+
+	if err != nil {
+		return err
+	}
+
+	if len(firmwares) == 0 {
+		return errors.New("no firmwares in the catalog match the flags, run download (or download -c) first")
+	}
+
+	defer func() {
+		if err := writeVerifyReport(); err != nil {
+			errorf("Unable to write verification report: %s, err: %s", verifyReportPath, err)
+		}
+	}()
+
+	infof("Verifying %d firmware(s) on %s", len(firmwares), base)
+
+	jobs := make([]downloadJob, len(firmwares))
+
+	for i, m := range firmwares {
+		jobs[i] = downloadJob{Firmware: *m.fw}
+	}
+
+	progress := newVerifyProgress(jobs)
+	failed := 0
+
+	for _, m := range firmwares {
+		if shutdownRequested() {
+			return errShutdown
+		}
+
+		result := verifyMirrorFirmware(m, progress.prefix())
+		recordVerifyResult(result)
+		progress.fileVerified(m.fw.Filesize)
+
+		if result.Result != "pass" && result.Result != "unverifiable" {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d firmware(s) on %s are missing or didn't verify", failed, len(firmwares), base)
+	}
+
+	return nil
+}
+
+// mirrorCopies returns the copies beneath base of the firmwares of the catalog matching the flags, once each.
+func mirrorCopies(base string) ([]mirrorCopy, error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	var firmwares []mirrorCopy
+	seen := make(map[string]bool)
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+
+		if !deviceSelected(device.Identifier) || !deviceAttributesSelected(&device.BaseDevice) {
+			continue
+		}
+
+		sortFirmwares(device)
+
+		for index := range device.Firmwares {
+			fw := &device.Firmwares[index]
+
+			if !firmwareSelected(index, fw) {
+				continue
+			}
+
+			path, err := firmwarePath(fw, &device.BaseDevice)
+
+			if err != nil {
+				return nil, err
+			}
+
+			name, err := storageName(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			u := url.URL{Path: "/" + name}
+			location := base + u.EscapedPath()
+
+			if seen[location] {
+				continue
+			}
+
+			seen[location] = true
+			firmwares = append(firmwares, mirrorCopy{device: &device.BaseDevice, fw: fw, url: location})
+		}
+	}
+
+	return firmwares, nil
+}
+
+// verifyMirrorFirmware downloads a firmware from the mirror, hashing it as it arrives.
+func verifyMirrorFirmware(m mirrorCopy, prefix string) verifyResult {
+	result := newVerifyResult(m.device, m.fw, m.url, "pass")
+	expected, ok := checksumFor(m.fw)
+
+	if !ok {
+		warnf("%s can't be verified, it has no SHA1 or MD5", m.url)
+		result.Result = "unverifiable"
+
+		return result
+	}
+
+	resp, err := downloadClient.Get(m.url)
+
+	if err != nil {
+		errorf("Unable to download %s, err: %s", m.url, err)
+		result.Result, result.Error = "error", err.Error()
+
+		return result
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		errorf("%s is missing from the mirror", m.url)
+		result.Result = "missing"
+
+		return result
+	} else if resp.StatusCode != http.StatusOK {
+		errorf("Unable to download %s, status: %s", m.url, resp.Status)
+		result.Result, result.Error = "error", resp.Status
+
+		return result
+	}
+
+	size := resp.ContentLength
+
+	if size < 0 {
+		size = int64(m.fw.Filesize)
+	}
+
+	bar := newProgressBar(size, m.url).Prefix(prefix)
+	bar.Start()
+
+	h := expected.new()
+	n, err := io.Copy(io.MultiWriter(h, bar), &mirrorReader{r: resp.Body})
+
+	bar.Finish()
+
+	if err != nil {
+		errorf("Unable to download %s, err: %s", m.url, err)
+		result.Result, result.Error = "error", err.Error()
+
+		return result
+	}
+
+	result.Actual = hex.EncodeToString(h.Sum(nil))
+
+	if !strings.EqualFold(result.Actual, expected.expected) {
+		errorf("%s did not verify successfully, its %s is %s (%d bytes), not %s", m.url, strings.ToUpper(expected.algorithm), result.Actual, n, expected.expected)
+		result.Result = "fail"
+
+		return result
+	}
+
+	successf("%s verified successfully", m.url)
+	result.Time = time.Now()
+
+	return result
+}
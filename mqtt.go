@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// mqttKeepAlive is how often the broker expects to hear from us
+const mqttKeepAlive = 60 * time.Second
+
+// mqttClient is a minimal MQTT 3.1.1 client, which only publishes messages (at QoS 0)
+type mqttClient struct {
+	broker         *url.URL
+	user, password string
+	clientID       string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+var (
+	// mqttEvents receives the events which are published, until flushMQTT
+	mqttEvents chan event
+	mqttDone   chan struct{}
+)
+
+// mqttString encodes s as an MQTT length-prefixed string.
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+
+	return append(b, s...)
+}
+
+// mqttPacket encodes a packet with the given fixed header byte and body.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+
+	// the remaining length is encoded 7 bits at a time, least significant first
+	length := len(body)
+
+	for {
+		b := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			b |= 0x80
+		}
+
+		packet = append(packet, b)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+// connect connects to the broker, if not already connected. c.mu must be held.
+func (c *mqttClient) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	switch c.broker.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", c.broker.Host)
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.broker.Host, http.DefaultTransport.(*http.Transport).TLSClientConfig)
+	default:
+		return fmt.Errorf("unknown MQTT broker scheme: %s", c.broker.Scheme)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	flags := byte(0x02) // clean session
+	payload := mqttString(c.clientID)
+
+	if c.user != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(c.user)...)
+
+		if c.password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(c.password)...)
+		}
+	}
+
+	body := append(mqttString("MQTT"), 4, flags, 0, 0)
+	binary.BigEndian.PutUint16(body[len(body)-2:], uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	connack := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if connack[0] != 0x20 || connack[3] != 0 {
+		conn.Close()
+		return fmt.Errorf("connection refused by broker (code %d)", connack[3])
+	}
+
+	conn.SetDeadline(time.Time{})
+	c.conn = conn
+
+	// responses (to pings) are discarded, but must be read. Once the connection fails, the next publish reconnects.
+	go func() {
+		io.Copy(io.Discard, conn)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.conn == conn {
+			c.conn = nil
+		}
+	}()
+
+	return nil
+}
+
+// write sends a packet, reconnecting once if the connection has failed.
+func (c *mqttClient) write(packet []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err := c.connect(); err != nil {
+			return err
+		}
+
+		c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+
+		_, err := c.conn.Write(packet)
+
+		if err == nil || attempt > 0 {
+			return err
+		}
+
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	header := byte(0x30)
+
+	if retain {
+		header |= 0x01
+	}
+
+	return c.write(mqttPacket(header, append(mqttString(topic), payload...)))
+}
+
+func (c *mqttClient) ping() error {
+	c.mu.Lock()
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+
+	return c.write(mqttPacket(0xc0, nil))
+}
+
+func (c *mqttClient) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Write(mqttPacket(0xe0, nil))
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// startMQTT publishes every event (except progress) as JSON to -mqtt-topic/<event type> on -mqtt-broker, if set.
+func startMQTT() error {
+	if mqttBroker == "" {
+		return nil
+	}
+
+	broker, err := url.Parse(mqttBroker)
+
+	if err != nil || broker.Host == "" {
+		return errors.New("invalid -mqtt-broker, it must be a URL such as tcp://broker:1883 or ssl://broker:8883")
+	}
+
+	if mqttPassword == "" {
+		mqttPassword = os.Getenv("ALLTHEFIRMWARES_MQTT_PASSWORD")
+	}
+
+	hostname, _ := os.Hostname()
+
+	client := &mqttClient{
+		broker:   broker,
+		user:     mqttUser,
+		password: mqttPassword,
+		clientID: fmt.Sprintf("allthefirmwares-%s-%d", hostname, os.Getpid()),
+	}
+
+	mqttEvents = events.subscribe()
+	mqttDone = make(chan struct{})
+
+	go func() {
+		defer close(mqttDone)
+		defer client.disconnect()
+
+		ticker := time.NewTicker(mqttKeepAlive / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case e, ok := <-mqttEvents:
+				if !ok {
+					return
+				}
+
+				b, err := json.Marshal(e)
+
+				if err != nil {
+					debugf("Unable to encode event, err: %s", err)
+					continue
+				}
+
+				// the latest run status is retained, so that dashboards see it as soon as they subscribe
+				if err := client.publish(mqttTopic+"/"+e.Type, b, e.Type == "phase" || e.Type == "queue_completed"); err != nil {
+					warnf("Unable to publish %s event to MQTT broker, err: %s", e.Type, err)
+				}
+			case <-ticker.C:
+				if err := client.ping(); err != nil {
+					debugf("Unable to ping MQTT broker, err: %s", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// flushMQTT stops publishing events, waiting (for a while) for the events already queued to be published.
+func flushMQTT() {
+	if mqttEvents == nil {
+		return
+	}
+
+	events.unsubscribe(mqttEvents)
+	close(mqttEvents)
+
+	select {
+	case <-mqttDone:
+	case <-time.After(time.Minute):
+		warnf("Timed out publishing events to MQTT broker")
+	}
+}
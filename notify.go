@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultNotifyTemplates are the messages sent for each type of event, unless -notify-template is set
+var defaultNotifyTemplates = map[string]string{
+	"download_completed":   `Downloaded {{.Data.Device}} {{.Data.Version}} ({{.Data.BuildID}})`,
+	"download_failed":      `Unable to download {{.Data.Device}} {{.Data.Version}} ({{.Data.BuildID}}): {{.Data.Error}}`,
+	"verification_failed":  `{{.Data.Device}} {{.Data.Version}} ({{.Data.BuildID}}) failed verification: {{.Data.Path}}`,
+	"signing_closed":       `Apple stopped signing {{.Data.Device}} {{.Data.Version}} ({{.Data.BuildID}})`,
+	"signing_opened":       `Apple started signing {{.Data.Device}} {{.Data.Version}} ({{.Data.BuildID}})`,
+	"queue_completed":      `Finished downloading, {{.Data.Downloaded}} of {{.Data.Queued}} firmware(s) downloaded, {{.Data.Failed}} failed`,
+	"disk_space_low":       `Downloads paused, only {{.Data.Free}} is free on {{.Data.Root}}`,
+	"disk_space_recovered": `Downloads resumed, {{.Data.Free}} is free on {{.Data.Root}}`,
+	"firmware_pruned":      `Pruned {{.Data.Firmware}} ({{.Data.Size}}) to keep the archive within -max-archive-size`,
+}
+
+// notifier sends notification messages somewhere, e.g. to a chat service's webhook
+type notifier struct {
+	name string
+	send func(e event, message string) error
+
+	// events are the types of event sent, or nil for the -notify-events
+	events map[string]bool
+}
+
+// webhookNotifier sends messages to a webhook, as the JSON encoding of payload.
+func webhookNotifier(name, url string, payload func(message string) interface{}) notifier {
+	return notifier{name: name, send: func(_ event, message string) error {
+		return postWebhook(url, payload(message))
+	}}
+}
+
+var (
+	notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+	// notifyEvents receives the events which are notified, until flushNotifications
+	notifyEvents chan event
+	notifyDone   chan struct{}
+)
+
+// startNotifiers sends the -notify-events to the -slack-webhook, -discord-webhook and Telegram chat, if set.
+func startNotifiers() error {
+	var notifiers []notifier
+
+	if slackWebhook != "" {
+		notifiers = append(notifiers, webhookNotifier("Slack", slackWebhook, func(message string) interface{} {
+			return map[string]string{"text": message}
+		}))
+	}
+
+	if discordWebhook != "" {
+		notifiers = append(notifiers, webhookNotifier("Discord", discordWebhook, func(message string) interface{} {
+			return map[string]string{"content": message}
+		}))
+	}
+
+	if telegramToken == "" {
+		telegramToken = os.Getenv("ALLTHEFIRMWARES_TELEGRAM_TOKEN")
+	}
+
+	if telegramToken != "" || telegramChat != "" {
+		if telegramToken == "" || telegramChat == "" {
+			return errors.New("-telegram-token and -telegram-chat must be used together")
+		}
+
+		notifiers = append(notifiers, webhookNotifier("Telegram", "https://api.telegram.org/bot"+telegramToken+"/sendMessage", func(message string) interface{} {
+			return map[string]string{"chat_id": telegramChat, "text": message}
+		}))
+	}
+
+	if emailTo != "" {
+		n, err := emailNotifier()
+
+		if err != nil {
+			return err
+		}
+
+		notifiers = append(notifiers, n)
+	}
+
+	if notifyExec != "" {
+		n, err := execNotifier(notifyExec)
+
+		if err != nil {
+			return err
+		}
+
+		notifiers = append(notifiers, n)
+	}
+
+	if desktopNotify {
+		if n, ok := desktopNotifier(); ok {
+			notifiers = append(notifiers, n)
+		}
+	}
+
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	notifyEventSet := make(map[string]bool)
+
+	for _, eventType := range strings.Split(notifyEventTypes, ",") {
+		eventType = strings.TrimSpace(eventType)
+
+		if _, ok := defaultNotifyTemplates[eventType]; !ok {
+			return fmt.Errorf("unknown -notify-events event: %s", eventType)
+		}
+
+		notifyEventSet[eventType] = true
+	}
+
+	templates := make(map[string]*template.Template)
+
+	for eventType, text := range defaultNotifyTemplates {
+		if notifyTemplate != "" {
+			text = notifyTemplate
+		}
+
+		t, err := template.New(eventType).Option("missingkey=zero").Parse(text)
+
+		if err != nil {
+			return fmt.Errorf("invalid -notify-template, err: %s", err)
+		}
+
+		templates[eventType] = t
+	}
+
+	notifyEvents = events.subscribe()
+	notifyDone = make(chan struct{})
+
+	notify := func(e event) {
+		t, ok := templates[e.Type]
+
+		if !ok {
+			return
+		}
+
+		var message bytes.Buffer
+
+		if err := t.Execute(&message, e); err != nil {
+			warnf("Unable to create %s notification, err: %s", e.Type, err)
+			return
+		}
+
+		for _, n := range notifiers {
+			if (n.events == nil && !notifyEventSet[e.Type]) || (n.events != nil && !n.events[e.Type]) {
+				continue
+			}
+
+			if err := n.send(e, message.String()); err != nil {
+				warnf("Unable to send %s notification, err: %s", n.name, err)
+			}
+		}
+	}
+
+	go func() {
+		defer close(notifyDone)
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case e, ok := <-notifyEvents:
+				if !ok {
+					sendDigestIfDue()
+					return
+				}
+
+				notify(e)
+			case <-ticker.C:
+				sendDigestIfDue()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// postWebhook posts the JSON encoding of payload to url.
+func postWebhook(url string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(b))
+
+	if urlErr, ok := err.(*neturl.Error); ok {
+		// webhook URLs (and Telegram's) contain secrets, so they're left out of errors
+		return urlErr.Err
+	} else if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// flushNotifications stops notifying (and publishing events to MQTT), waiting (for a while) for the notifications already queued to be sent.
+func flushNotifications() {
+	flushMQTT()
+
+	if notifyEvents == nil {
+		return
+	}
+
+	events.unsubscribe(notifyEvents)
+	close(notifyEvents)
+
+	select {
+	case <-notifyDone:
+	case <-time.After(time.Minute):
+		warnf("Timed out sending notifications")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// jobOrders are the orderings which can be chosen with -order, as "less" functions
+var jobOrders = map[string]func(a, b *downloadJob) bool{
+	"newest": func(a, b *downloadJob) bool {
+		return firmwareDate(&a.Firmware).After(firmwareDate(&b.Firmware))
+	},
+	"oldest": func(a, b *downloadJob) bool {
+		return firmwareDate(&a.Firmware).Before(firmwareDate(&b.Firmware))
+	},
+	"smallest": func(a, b *downloadJob) bool {
+		return a.Firmware.Filesize < b.Firmware.Filesize
+	},
+	"largest": func(a, b *downloadJob) bool {
+		return a.Firmware.Filesize > b.Firmware.Filesize
+	},
+}
+
+// firmwareDate is when a firmware was released, or uploaded if its release date is unknown.
+func firmwareDate(fw *api.Firmware) time.Time {
+	if fw.ReleaseDate.Valid {
+		return fw.ReleaseDate.Time
+	}
+
+	return fw.UploadDate.Time
+}
+
+// checkOrder checks -order, before anything is planned.
+func checkOrder() error {
+	if _, ok := jobOrders[downloadOrder]; !ok && downloadOrder != "device" {
+		return fmt.Errorf("invalid -order: %s, use device, newest, oldest, smallest or largest", downloadOrder)
+	}
+
+	return nil
+}
+
+// sortJobs orders the download queue according to -order (checked by checkOrder), moving signed firmwares first
+// (w/ -signed-first). The "device" order leaves jobs grouped by device, as planned.
+func sortJobs(jobs []downloadJob) {
+	if less, ok := jobOrders[downloadOrder]; ok {
+		sort.SliceStable(jobs, func(i, j int) bool {
+			return less(&jobs[i], &jobs[j])
+		})
+	}
+
+	if signedFirst {
+		// signing windows can close while older firmwares download
+		sort.SliceStable(jobs, func(i, j int) bool {
+			return jobs[i].Firmware.Signed && !jobs[j].Firmware.Signed
+		})
+	}
+}
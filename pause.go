@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	pauseMu   sync.Mutex
+	pauseCond = sync.NewCond(&pauseMu)
+
+	// pauseReasons are why downloads are currently paused; downloads only run when there are none
+	pauseReasons = make(map[string]bool)
+
+	errPaused = errors.New("downloads paused")
+)
+
+// setPaused pauses or resumes downloads for the given reason. Downloads resume once nothing is pausing them.
+func setPaused(reason string, p bool) {
+	pauseMu.Lock()
+	changed := pauseReasons[reason] != p
+
+	if p {
+		pauseReasons[reason] = true
+	} else {
+		delete(pauseReasons, reason)
+	}
+
+	stillPaused := len(pauseReasons) > 0
+
+	pauseCond.Broadcast()
+	pauseMu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	publishEvent("paused", map[string]interface{}{"reason": reason, "paused": p, "still_paused": stillPaused})
+
+	if p {
+		infof("Pausing downloads (%s)", reason)
+	} else if stillPaused {
+		infof("Downloads remain paused for other reasons, despite no longer being %s", reason)
+	} else {
+		infof("Resuming downloads")
+	}
+}
+
+func isPaused() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	return len(pauseReasons) > 0
+}
+
+// waitWhilePaused blocks until downloads are resumed or a shutdown is requested.
+func waitWhilePaused() {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	for len(pauseReasons) > 0 && !shutdownRequested() {
+		pauseCond.Wait()
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handlePauseSignals pauses downloads on SIGUSR1 and resumes them on SIGUSR2.
+func handlePauseSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range c {
+			setPaused("requested by SIGUSR1", sig == syscall.SIGUSR1)
+		}
+	}()
+}
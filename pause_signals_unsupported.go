@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// handlePauseSignals does nothing, as there are no SIGUSR1/SIGUSR2 signals on this platform.
+func handlePauseSignals() {}
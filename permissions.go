@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// dirMode and fileMode are the parsed values of -dir-mode and -file-mode
+	dirMode  os.FileMode = 0700
+	fileMode os.FileMode = 0600
+
+	// ownerUID and ownerGID are the parsed value of -owner, or -1 to leave them unchanged
+	ownerUID, ownerGID = -1, -1
+)
+
+// parsePermissions parses the -dir-mode, -file-mode and -owner flags.
+func parsePermissions() error {
+	mode, err := strconv.ParseUint(dirModeValue, 8, 32)
+
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid -dir-mode: %s, it must be octal, e.g. 0750", dirModeValue)
+	}
+
+	dirMode = os.FileMode(mode)
+
+	mode, err = strconv.ParseUint(fileModeValue, 8, 32)
+
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid -file-mode: %s, it must be octal, e.g. 0640", fileModeValue)
+	}
+
+	fileMode = os.FileMode(mode)
+
+	if ownerValue == "" {
+		return nil
+	}
+
+	if runtime.GOOS == "windows" {
+		return errors.New("-owner isn't supported on Windows")
+	}
+
+	userName, groupName := ownerValue, ""
+
+	if i := strings.Index(ownerValue, ":"); i >= 0 {
+		userName, groupName = ownerValue[:i], ownerValue[i+1:]
+	}
+
+	if userName != "" {
+		if ownerUID, err = lookupID(userName, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown -owner user: %s, err: %s", userName, err)
+		}
+	}
+
+	if groupName != "" {
+		if ownerGID, err = lookupID(groupName, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown -owner group: %s, err: %s", groupName, err)
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		warnf("Not running as root, changing the owner of downloaded files may fail")
+	}
+
+	return nil
+}
+
+// lookupID returns name as a number if it is numeric, or looks it up otherwise.
+func lookupID(name string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// makeDirectory creates a directory and any missing parents with -dir-mode (regardless of the umask) and -owner.
+func makeDirectory(path string) error {
+	var missing []string
+
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+
+		missing = append(missing, dir)
+	}
+
+	if err := os.MkdirAll(path, dirMode); err != nil {
+		return err
+	}
+
+	for _, dir := range missing {
+		if err := applyPermissions(dir, dirMode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyPermissions sets the mode of a file or directory which allthefirmwares created, and its owner w/ -owner.
+func applyPermissions(path string, mode os.FileMode) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if ownerUID == -1 && ownerGID == -1 {
+		return nil
+	}
+
+	return os.Lchown(path, ownerUID, ownerGID)
+}
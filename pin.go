@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// pinnedFirmware is an exact firmware listed in a pin file
+type pinnedFirmware struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	SHA1       string `json:"sha1"`
+	Size       uint64 `json:"size"`
+	URL        string `json:"url"`
+}
+
+// pinFile is the contents of a -pin file
+type pinFile struct {
+	Firmwares []pinnedFirmware `json:"firmwares"`
+}
+
+// pinSet is the firmwares of a pin file, by identifier and build
+type pinSet map[string]pinnedFirmware
+
+func pinKey(identifier, buildID string) string {
+	return identifier + "/" + buildID
+}
+
+// loadPins reads the -pin file, returning nil if it isn't set.
+func loadPins() (pinSet, error) {
+	if pinFilePath == "" {
+		return nil, nil
+	}
+
+	var file pinFile
+
+	if err := readJSONFile(pinFilePath, &file); err != nil {
+		return nil, fmt.Errorf("unable to read pin file: %s, err: %s", pinFilePath, err)
+	}
+
+	pins := make(pinSet)
+
+	for _, pin := range file.Firmwares {
+		pins[pinKey(pin.Identifier, pin.BuildID)] = pin
+	}
+
+	return pins, nil
+}
+
+// allows reports whether a firmware is pinned, with the same checksum. All firmwares are allowed without a pin file.
+func (p pinSet) allows(fw *api.Firmware) bool {
+	if p == nil {
+		return true
+	}
+
+	pin, ok := p[pinKey(fw.Identifier, fw.BuildID)]
+
+	if !ok {
+		skipf("Skipping %s (%s), not pinned", fw.Identifier, fw.BuildID)
+		return false
+	}
+
+	if pin.SHA1 != fw.SHA1Sum {
+		errorf("Skipping %s (%s), its SHA1 has changed from the pinned %s to %s", fw.Identifier, fw.BuildID, pin.SHA1, fw.SHA1Sum)
+		return false
+	}
+
+	return true
+}
+
+// selectedFirmwares retrieves all firmwares matching the flags, whether they have been downloaded or not.
+func selectedFirmwares() ([]pinnedFirmware, error) {
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	var firmwares []pinnedFirmware
+
+	for i := range selected {
+		if information[i] == nil {
+			return nil, fmt.Errorf("unable to retrieve firmwares for %s", selected[i].Identifier)
+		}
+
+		for index, ipsw := range information[i].Firmwares {
+			if !firmwareSelected(index, &ipsw) {
+				continue
+			}
+
+			firmwares = append(firmwares, pinnedFirmware{
+				Identifier: ipsw.Identifier,
+				Version:    ipsw.Version,
+				BuildID:    ipsw.BuildID,
+				SHA1:       ipsw.SHA1Sum,
+				Size:       ipsw.Filesize,
+				URL:        ipsw.URL,
+			})
+		}
+	}
+
+	sort.Slice(firmwares, func(i, j int) bool {
+		return pinKey(firmwares[i].Identifier, firmwares[i].BuildID) < pinKey(firmwares[j].Identifier, firmwares[j].BuildID)
+	})
+
+	return firmwares, nil
+}
+
+// pinCommand writes the firmwares matching the flags to the -pin file, so that other mirrors can download exactly them.
+func pinCommand() error {
+	if pinFilePath == "" {
+		return errors.New("-pin must be set to the file to write")
+	}
+
+	firmwares, err := selectedFirmwares()
+
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSONFile(pinFilePath, pinFile{Firmwares: firmwares}); err != nil {
+		return err
+	}
+
+	infof("Pinned %d firmware(s) in %s", len(firmwares), pinFilePath)
+
+	return nil
+}
+
+// pinCheckCommand compares the -pin file with the firmwares matching the flags upstream,
+// failing if they have drifted apart, e.g. for a CI job.
+func pinCheckCommand() error {
+	pins, err := loadPins()
+
+	if err != nil {
+		return err
+	} else if pins == nil {
+		return errors.New("-pin must be set to the file to check")
+	}
+
+	firmwares, err := selectedFirmwares()
+
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	upstream := make(map[string]bool)
+
+	for _, fw := range firmwares {
+		key := pinKey(fw.Identifier, fw.BuildID)
+		upstream[key] = true
+
+		pin, ok := pins[key]
+
+		if !ok {
+			warnf("%s %s (%s) is not pinned", fw.Identifier, fw.Version, fw.BuildID)
+			drifted++
+		} else if pin.SHA1 != fw.SHA1 {
+			warnf("%s %s (%s) has changed, SHA1 pinned: %s, upstream: %s", fw.Identifier, fw.Version, fw.BuildID, pin.SHA1, fw.SHA1)
+			drifted++
+		}
+	}
+
+	for key, pin := range pins {
+		if !upstream[key] {
+			warnf("%s %s (%s) is pinned, but no longer matches upstream", pin.Identifier, pin.Version, pin.BuildID)
+			drifted++
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d firmware(s) differ from %s", drifted, pinFilePath)
+	}
+
+	successf("All %d pinned firmware(s) match upstream", len(pins))
+
+	return nil
+}
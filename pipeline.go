@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// downloadPipelined downloads the firmwares matching the flags as each device's are retrieved (w/ -pipeline), rather
+// than retrieving every device's first. Jobs are ordered by -order within each device, and downloads aren't confirmed,
+// since how much there is to download isn't known until the end.
+func downloadPipelined() error {
+	if reuseExisting != "" {
+		warnf("-reuse can't be used with -pipeline, existing copies will not be reused")
+	}
+
+	span := startSpan("enumerate", nil)
+
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		span.finish(err)
+		return fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	totalFirmwareCount, totalFirmwareSize, totalDeviceCount = 0, 0, 0
+
+	planner, err := newDownloadPlanner(false)
+
+	if err != nil {
+		span.finish(err)
+		return err
+	}
+
+	selected := selectDevices(devices)
+
+	scheduler := newJobScheduler(nil)
+	scheduler.open = true
+
+	infof("Downloading firmwares for %d device(s) as they are retrieved", len(selected))
+
+	planned := make(chan struct{})
+
+	go func() {
+		defer close(planned)
+		defer scheduler.close()
+
+		errs := streamDeviceInformation(selected, func(i int, deviceInformation *api.Device) {
+			sortFirmwares(deviceInformation)
+
+			jobs := planner.plan(&selected[i], deviceInformation)
+
+			sortJobs(jobs)
+
+			currentReport.plan(jobs)
+			scheduler.add(jobs)
+		})
+
+		span.finish(nil)
+
+		if len(errs) > 0 {
+			errorf("Could not get firmwares for %d of %d device(s), their firmwares will not be downloaded in this run", len(errs), len(selected))
+		}
+
+		planner.finish()
+	}()
+
+	runJobs(scheduler, nil)
+
+	// the catalog is written once every device has been planned
+	<-planned
+
+	if err := planner.collisionError(); err != nil {
+		return err
+	}
+
+	if err := pruneArchive(); err != nil {
+		errorf("%s", err)
+	}
+
+	return snapshotArchive()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// pluginRequest is written as JSON to the stdin of an exec plugin, which is run with the action as its only argument.
+type pluginRequest struct {
+	Action string `json:"action"`
+
+	// Name is the name a firmware is stored under (exists and store)
+	Name string `json:"name,omitempty"`
+
+	// Path is the local file to store (store)
+	Path     string         `json:"path,omitempty"`
+	Firmware *downloadEvent `json:"firmware,omitempty"`
+	SHA1     string         `json:"sha1,omitempty"`
+
+	// Event and Message are the event being notified and its message (notify)
+	Event   *event `json:"event,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// pluginResponse is read as JSON from the stdout of an exec plugin. Plugins which succeed needn't write anything,
+// except for exists.
+type pluginResponse struct {
+	Exists bool   `json:"exists"`
+	Error  string `json:"error"`
+}
+
+// runPlugin runs the plugin with request, returning its response. Plugins fail by exiting non-zero or by responding with
+// an error.
+func runPlugin(ctx context.Context, command string, request pluginRequest) (*pluginResponse, error) {
+	b, err := json.Marshal(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, command, request.Action)
+	cmd.Stdin = bytes.NewReader(b)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s: %s", err, message)
+		}
+
+		return nil, err
+	}
+
+	response := &pluginResponse{}
+
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, response); err != nil {
+			return nil, fmt.Errorf("invalid response from %s %s, err: %s", command, request.Action, err)
+		}
+	}
+
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	return response, nil
+}
+
+// execStorage stores firmwares with an exec plugin, set with -dest exec:/path/to/plugin.
+// The plugin is run for each file, with the action "exists" or "store".
+type execStorage struct {
+	command string
+}
+
+func newExecStorage(u *url.URL) (storage, error) {
+	command := u.Opaque
+
+	if command == "" {
+		command = u.Path
+	}
+
+	if command == "" {
+		return nil, errors.New("no plugin given, use exec:/path/to/plugin")
+	}
+
+	command, err := exec.LookPath(command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &execStorage{command: command}, nil
+}
+
+func (s *execStorage) String() string {
+	return "exec:" + s.command
+}
+
+func (s *execStorage) exists(name string) (bool, error) {
+	response, err := runPlugin(context.Background(), s.command, pluginRequest{Action: "exists", Name: name})
+
+	if err != nil {
+		return false, err
+	}
+
+	return response.Exists, nil
+}
+
+// store passes the local file to the plugin, which is expected to check it arrived intact (e.g. against sha1).
+func (s *execStorage) store(path, name string, job *downloadJob) error {
+	firmware := newDownloadEvent(&job.Device, &job.Firmware, job.Path)
+
+	defer keepAlive()()
+
+	_, err := runPlugin(context.Background(), s.command, pluginRequest{
+		Action:   "store",
+		Name:     name,
+		Path:     path,
+		Firmware: &firmware,
+		SHA1:     job.Firmware.SHA1Sum,
+	})
+
+	return err
+}
+
+// execNotifier sends the -notify-events to the -notify-exec plugin, with the action "notify".
+func execNotifier(command string) (notifier, error) {
+	command, err := exec.LookPath(command)
+
+	if err != nil {
+		return notifier{}, fmt.Errorf("invalid -notify-exec, err: %s", err)
+	}
+
+	return notifier{name: command, send: func(e event, message string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		_, err := runPlugin(ctx, command, pluginRequest{Action: "notify", Event: &e, Message: message})
+
+		return err
+	}}, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// poolRoute sends the firmwares of devices whose identifier matches pattern to root (w/ -pool-route)
+type poolRoute struct {
+	pattern, root string
+}
+
+// poolPlacement is a firmware placed on a root in this run, which hasn't finished downloading yet
+type poolPlacement struct {
+	root string
+	size uint64
+}
+
+var (
+	// poolExtraRoots and poolRoutes are the parsed values of -pool and -pool-route
+	poolExtraRoots []string
+	poolRoutes     []poolRoute
+
+	// poolMu guards poolPlacements, where each firmware downloaded to the pool is, by its path relative to the root, and
+	// poolPending, where those being downloaded are going
+	poolMu         sync.Mutex
+	poolPlacements map[string]string
+	poolPending    map[string]poolPlacement
+)
+
+// parsePool parses -pool, -pool-route, e.g. "iPad*=/mnt/disk2,AppleTV*=/mnt/disk3", and -pool-balance.
+func parsePool() error {
+	poolExtraRoots, poolRoutes = nil, nil
+
+	poolMu.Lock()
+	poolPlacements, poolPending = nil, make(map[string]poolPlacement)
+	poolMu.Unlock()
+
+	if poolBalance != "fill" && poolBalance != "free" {
+		return fmt.Errorf("invalid -pool-balance: %s, use fill or free", poolBalance)
+	}
+
+	add := func(root string) {
+		root = filepath.Clean(root)
+
+		for _, existing := range poolRoots() {
+			if existing == root {
+				return
+			}
+		}
+
+		poolExtraRoots = append(poolExtraRoots, root)
+	}
+
+	for _, root := range strings.Split(poolValue, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			add(root)
+		}
+	}
+
+	for _, entry := range strings.Split(poolRouteValue, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("invalid -pool-route: %s, expected e.g. iPad*=/mnt/disk2", entry)
+		}
+
+		route := poolRoute{pattern: strings.ToLower(strings.TrimSpace(parts[0])), root: filepath.Clean(strings.TrimSpace(parts[1]))}
+
+		if _, err := path.Match(route.pattern, ""); err != nil {
+			return fmt.Errorf("invalid -pool-route pattern: %s, err: %s", parts[0], err)
+		}
+
+		poolRoutes = append(poolRoutes, route)
+		add(route.root)
+	}
+
+	if len(poolExtraRoots) == 0 {
+		return nil
+	}
+
+	// objects and snapshots are hardlinked, which can't span disks
+	if contentAddressed {
+		return errors.New("-pool can't be used with -content-addressed")
+	} else if snapshotDir != "" {
+		return errors.New("-pool can't be used with -snapshot-dir")
+	}
+
+	return nil
+}
+
+// poolRoots returns the roots firmwares are downloaded to: the download root, followed by those added by -pool and
+// -pool-route.
+func poolRoots() []string {
+	return append([]string{downloadRoot()}, poolExtraRoots...)
+}
+
+func poolPlacementsPath() string {
+	return filepath.Join(stateDirectory(), "pool.json")
+}
+
+// loadPoolPlacements reads where each firmware in the pool is, once. The caller holds poolMu.
+func loadPoolPlacements() map[string]string {
+	if poolPlacements != nil {
+		return poolPlacements
+	}
+
+	poolPlacements = make(map[string]string)
+
+	if err := readJSONFile(poolPlacementsPath(), &poolPlacements); err != nil && !os.IsNotExist(err) {
+		warnf("Unable to read pool placements: %s, err: %s", poolPlacementsPath(), err)
+	}
+
+	return poolPlacements
+}
+
+// poolPath returns where in the pool the firmware laid out at path, beneath the download root, is: the root recorded
+// when it was downloaded, the root it (or its partial download) is found on, or else the root it's routed to by
+// -pool-route or chosen by -pool-balance.
+func poolPath(p string, fw *api.Firmware, device *api.BaseDevice) string {
+	if len(poolExtraRoots) == 0 {
+		return p
+	}
+
+	roots := poolRoots()
+	rel, err := filepath.Rel(roots[0], p)
+
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return p
+	}
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	inPool := func(root string) bool {
+		for _, r := range roots {
+			if r == root {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if root, ok := loadPoolPlacements()[rel]; ok && inPool(root) {
+		return filepath.Join(root, rel)
+	}
+
+	if placement, ok := poolPending[rel]; ok && inPool(placement.root) {
+		return filepath.Join(placement.root, rel)
+	}
+
+	for _, root := range roots {
+		for _, candidate := range []string{filepath.Join(root, rel), filepath.Join(root, rel) + partialSuffix} {
+			if _, err := os.Stat(candidate); err == nil {
+				return filepath.Join(root, rel)
+			}
+		}
+	}
+
+	root := choosePoolRoot(fw, device)
+	poolPending[rel] = poolPlacement{root: root, size: fw.Filesize}
+
+	return filepath.Join(root, rel)
+}
+
+// choosePoolRoot returns the root a firmware which isn't in the pool yet is downloaded to. With -pool-balance fill, it's
+// the first with enough space for it, and with free, the one with the most space. The caller holds poolMu.
+func choosePoolRoot(fw *api.Firmware, device *api.BaseDevice) string {
+	for _, route := range poolRoutes {
+		if ok, _ := path.Match(route.pattern, strings.ToLower(device.Identifier)); ok {
+			return route.root
+		}
+	}
+
+	roots := poolRoots()
+	best, bestFree := roots[0], int64(-1)
+
+	for _, root := range roots {
+		free, err := diskFree(root)
+
+		if err != nil {
+			debugf("Unable to get the free space of pool root %s, err: %s", root, err)
+			continue
+		}
+
+		// firmwares which are still being downloaded will take up more space
+		available := int64(free)
+
+		for _, placement := range poolPending {
+			if placement.root == root {
+				available -= int64(placement.size)
+			}
+		}
+
+		if poolBalance == "fill" && available >= int64(fw.Filesize) {
+			return root
+		}
+
+		if available > bestFree {
+			best, bestFree = root, available
+		}
+	}
+
+	return best
+}
+
+// recordPlacement records which root of the pool the firmware at p was downloaded to.
+func recordPlacement(p string) {
+	if len(poolExtraRoots) == 0 {
+		return
+	}
+
+	root, rel, ok := poolRelative(p)
+
+	if !ok {
+		return
+	}
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	placements := loadPoolPlacements()
+	placements[rel] = root
+	delete(poolPending, rel)
+
+	if err := writeJSONFile(poolPlacementsPath(), placements); err != nil {
+		warnf("Unable to write pool placements: %s, err: %s", poolPlacementsPath(), err)
+	}
+}
+
+// poolRelative returns the root of the pool p is beneath, and p relative to it. Roots may be nested, so the deepest
+// is used.
+func poolRelative(p string) (root, rel string, ok bool) {
+	for _, r := range poolRoots() {
+		if relative, err := filepath.Rel(r, p); err == nil && !strings.HasPrefix(relative, "..") && (!ok || len(r) > len(root)) {
+			root, rel, ok = r, relative, true
+		}
+	}
+
+	return root, rel, ok
+}
+
+// poolFree returns the combined free space of the roots of the pool.
+func poolFree() (uint64, error) {
+	var total uint64
+
+	for _, root := range poolRoots() {
+		free, err := diskFree(root)
+
+		if err != nil {
+			return 0, err
+		}
+
+		total += free
+	}
+
+	return total, nil
+}
+
+// poolFileSystem serves files from whichever root of the pool has them
+type poolFileSystem struct{}
+
+func (poolFileSystem) Open(name string) (http.File, error) {
+	var err error
+
+	for _, root := range poolRoots() {
+		var f http.File
+
+		if f, err = http.Dir(root).Open(name); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoSpace is returned by allocate when there isn't enough space on the volume for the file.
+var errNoSpace = errors.New("not enough free space")
+
+// preallocateFile reserves size bytes of disk space for a file (creating it if necessary), without changing its length,
+// so that the file isn't fragmented and running out of space is noticed before the download starts.
+// Only errNoSpace is returned, other errors (e.g. filesystems which can't preallocate) are just logged.
+func preallocateFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, fileMode)
+
+	if err != nil {
+		debugf("Unable to preallocate %s, err: %s", path, err)
+		return nil
+	}
+
+	defer file.Close()
+
+	if err := allocate(file, size); err == errNoSpace {
+		return err
+	} else if err != nil {
+		debugf("Unable to preallocate %s, err: %s", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocFlKeepSize is FALLOC_FL_KEEP_SIZE, which allocates space without changing the file size,
+// so resuming from the end of a partial download still works
+const fallocFlKeepSize = 0x1
+
+// allocate reserves size bytes of disk space for file.
+func allocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocFlKeepSize, 0, size)
+
+	if err == syscall.ENOSPC {
+		return errNoSpace
+	}
+
+	return err
+}
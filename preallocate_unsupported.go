@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// allocate isn't supported on this platform.
+func allocate(file *os.File, size int64) error {
+	return errors.New("preallocation isn't supported on this platform")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fileAllocationInfo = 5
+
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+var procSetFileInformationByHandle = modkernel32.NewProc("SetFileInformationByHandle")
+
+// allocate reserves size bytes of disk space for file, setting its allocation size rather than its end of file,
+// so resuming from the end of a partial download still works.
+func allocate(file *os.File, size int64) error {
+	allocationSize := size
+
+	r1, _, err := procSetFileInformationByHandle.Call(
+		file.Fd(),
+		fileAllocationInfo,
+		uintptr(unsafe.Pointer(&allocationSize)),
+		unsafe.Sizeof(allocationSize),
+	)
+
+	if r1 != 0 {
+		return nil
+	}
+
+	if err == errorDiskFull || err == errorHandleDiskFull {
+		return errNoSpace
+	}
+
+	return err
+}
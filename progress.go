@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/dustin/go-humanize"
+)
+
+// progressEvery and progressStep are the parsed value of -progress-interval, how often progress is logged by time, or
+// by percentage
+var (
+	progressEvery = 30 * time.Second
+	progressStep  float64
+)
+
+// parseProgress parses -progress and -progress-interval, e.g. 30s or 10%.
+func parseProgress() error {
+	switch progressMode {
+	case "auto", "bar", "log":
+	default:
+		return fmt.Errorf("invalid -progress: %s, use auto, bar or log", progressMode)
+	}
+
+	if strings.HasSuffix(progressInterval, "%") {
+		step, err := strconv.ParseFloat(strings.TrimSuffix(progressInterval, "%"), 64)
+
+		if err != nil || step <= 0 || step > 100 {
+			return fmt.Errorf("invalid -progress-interval: %s, expected e.g. 30s or 10%%", progressInterval)
+		}
+
+		progressEvery, progressStep = 0, step
+
+		return nil
+	}
+
+	every, err := time.ParseDuration(progressInterval)
+
+	if err != nil || every <= 0 {
+		return fmt.Errorf("invalid -progress-interval: %s, expected e.g. 30s or 10%%", progressInterval)
+	}
+
+	progressEvery, progressStep = every, 0
+
+	return nil
+}
+
+// logProgress reports whether progress is logged rather than drawn as bars, which is when stdout isn't a terminal
+// (unless -progress is set).
+func logProgress() bool {
+	switch progressMode {
+	case "bar":
+		return false
+	case "log":
+		return true
+	}
+
+	return !isTerminal(os.Stdout)
+}
+
+// newProgressBar returns a progress bar of total bytes for name. When progress is logged, the bar isn't drawn, and
+// a line with its percentage, speed and time left is logged every -progress-interval instead.
+func newProgressBar(total int64, name string) *pb.ProgressBar {
+	bar := pb.New64(total).SetUnits(pb.U_BYTES)
+
+	if !logProgress() {
+		return bar
+	}
+
+	bar.NotPrint = true
+	bar.Callback = newProgressLogger(bar, name).update
+
+	return bar
+}
+
+// progressLogger logs the progress of a bar periodically
+type progressLogger struct {
+	mu   sync.Mutex
+	bar  *pb.ProgressBar
+	name string
+
+	// started is set once the bar has been drawn for the first time, lastLogged and lastValue when, and at what value,
+	// a line was last logged
+	started    bool
+	lastLogged time.Time
+	lastValue  int64
+}
+
+func newProgressLogger(bar *pb.ProgressBar, name string) *progressLogger {
+	return &progressLogger{bar: bar, name: name}
+}
+
+// update is called each time the bar would be drawn.
+func (l *progressLogger) update(string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	current, total := l.bar.Get(), l.bar.Total
+
+	if !l.started {
+		// the first line is logged once there's something to say
+		l.started, l.lastLogged, l.lastValue = true, now, current
+		return
+	}
+
+	done := total > 0 && current >= total
+
+	if !done {
+		if progressStep > 0 {
+			if total == 0 || int(percentOf(current, total)/progressStep) == int(percentOf(l.lastValue, total)/progressStep) {
+				return
+			}
+		} else if now.Sub(l.lastLogged) < progressEvery {
+			return
+		}
+	} else if current == l.lastValue {
+		return
+	}
+
+	speed := 0.0
+
+	if elapsed := now.Sub(l.lastLogged).Seconds(); elapsed > 0 {
+		speed = float64(current-l.lastValue) / elapsed
+	}
+
+	line := fmt.Sprintf("%s: %.1f%% (%s of %s) at %s/s", l.name, percentOf(current, total), humanize.Bytes(uint64(current)), humanize.Bytes(uint64(total)), humanize.Bytes(uint64(speed)))
+
+	if !done && speed > 0 {
+		line += fmt.Sprintf(", %s left", time.Duration(float64(total-current)/speed*float64(time.Second)).Round(time.Second))
+	}
+
+	infof("%s", line)
+
+	l.lastLogged, l.lastValue = now, current
+}
+
+// percentOf returns the percentage current is of total.
+func percentOf(current, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+
+	return float64(current) / float64(total) * 100
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// runTotals is the sum of the queues processed since allthefirmwares started
+var runTotals queueSummary
+
+func (s *queueSummary) add(other *queueSummary) {
+	s.Queued += other.Queued
+	s.Downloaded += other.Downloaded
+	s.Failed += other.Failed
+}
+
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+// pushMetrics pushes metrics about the run of command, which started at started, to -pushgateway, if set.
+// The metrics replace those of the same name pushed by the previous run from this host, so the last success is kept.
+func pushMetrics(command string, started time.Time, runErr error) {
+	if pushgatewayURL == "" {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+
+	var metrics bytes.Buffer
+
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&metrics, "# HELP allthefirmwares_%s %s\n# TYPE allthefirmwares_%s gauge\nallthefirmwares_%s{command=%q} %v\n", name, help, name, name, command, value)
+	}
+
+	success := 1
+
+	if runErr != nil || runTotals.Failed > 0 {
+		success = 0
+	}
+
+	gauge("last_run_timestamp_seconds", "When the last run finished.", time.Now().Unix())
+	gauge("last_run_duration_seconds", "How long the last run took.", time.Since(started).Seconds())
+	gauge("last_run_success", "Whether the last run succeeded, without any failed downloads.", success)
+	gauge("last_run_downloaded_bytes", "The bytes downloaded by the last run.", atomic.LoadUint64(&downloadedSize))
+	gauge("last_run_queued_firmwares", "The firmwares queued for download by the last run.", runTotals.Queued)
+	gauge("last_run_downloaded_firmwares", "The firmwares downloaded by the last run.", runTotals.Downloaded)
+	gauge("last_run_failed_firmwares", "The firmwares which the last run failed to download.", runTotals.Failed)
+
+	if success == 1 {
+		gauge("last_success_timestamp_seconds", "When the last successful run finished.", time.Now().Unix())
+	}
+
+	url := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/" + neturl.PathEscape(pushgatewayJob)
+
+	if hostname != "" {
+		url += "/instance/" + neturl.PathEscape(hostname)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, &metrics)
+
+	if err != nil {
+		warnf("Unable to push metrics to the Pushgateway, err: %s", err)
+		return
+	}
+
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := pushClient.Do(req)
+
+	if err != nil {
+		warnf("Unable to push metrics to the Pushgateway, err: %s", err)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		warnf("Unable to push metrics to the Pushgateway, unexpected response status: %s", resp.Status)
+		return
+	}
+
+	debugf("Pushed metrics to the Pushgateway")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// rateLimiter limits the combined speed of all downloads, letting them run ahead by up to a second's worth of data
+type rateLimiter struct {
+	mu sync.Mutex
+
+	// rate is the most bytes per second downloaded, or 0 for no limit
+	rate int64
+
+	// allowance is how many bytes may be downloaded before waiting, which is negative while downloads are ahead
+	allowance float64
+	last      time.Time
+}
+
+var downloadRate = &rateLimiter{}
+
+// setRate changes the limit, taking effect for downloads already running.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate == l.rate {
+		return
+	}
+
+	l.rate, l.allowance, l.last = rate, 0, time.Now()
+}
+
+// current returns the limit.
+func (l *rateLimiter) current() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// wait blocks until n more bytes may be downloaded.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+
+	l.allowance += now.Sub(l.last).Seconds() * float64(l.rate)
+	l.last = now
+
+	if l.allowance > float64(l.rate) {
+		l.allowance = float64(l.rate)
+	}
+
+	l.allowance -= float64(n)
+	delay := time.Duration(-l.allowance / float64(l.rate) * float64(time.Second))
+
+	l.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-shutdown:
+		}
+	}
+}
+
+// parseRate parses a rate such as 5MB (per second), or 0 or unlimited for no limit.
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/s")
+
+	if s == "" || s == "unlimited" {
+		return 0, nil
+	}
+
+	rate, err := humanize.ParseBytes(s)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(rate), nil
+}
+
+// formatRate formats a rate for logging.
+func formatRate(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+
+	return humanize.Bytes(uint64(rate)) + "/s"
+}
+
+// rateProfile is the rate used during a daily window of local time
+type rateProfile struct {
+	window timeWindow
+	rate   int64
+}
+
+// parseRateSchedule parses -rate-schedule, e.g. "01:00-07:00=unlimited,09:00-18:00=2MB".
+func parseRateSchedule(s string) ([]rateProfile, error) {
+	var profiles []rateProfile
+
+	for _, part := range strings.Split(s, ",") {
+		parts := strings.SplitN(part, "=", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -rate-schedule: %s, expected e.g. 01:00-07:00=unlimited,09:00-18:00=2MB", s)
+		}
+
+		w, err := parseTimeWindow(parts[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		rate, err := parseRate(parts[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid -rate-schedule rate: %s, err: %s", parts[1], err)
+		}
+
+		profiles = append(profiles, rateProfile{window: w, rate: rate})
+	}
+
+	return profiles, nil
+}
+
+// scheduledRate returns the rate of the first profile containing t, or fallback if none do.
+func scheduledRate(profiles []rateProfile, t time.Time, fallback int64) int64 {
+	for _, profile := range profiles {
+		if profile.window.contains(t) {
+			return profile.rate
+		}
+	}
+
+	return fallback
+}
+
+// startRateLimit limits the speed of downloads to -limit-rate, or the rate of the -rate-schedule window it's in,
+// changing it as the windows start and end.
+func startRateLimit() error {
+	limit, err := parseRate(limitRate)
+
+	if err != nil {
+		return fmt.Errorf("invalid -limit-rate: %s, err: %s", limitRate, err)
+	}
+
+	if rateSchedule == "" {
+		downloadRate.setRate(limit)
+		return nil
+	}
+
+	profiles, err := parseRateSchedule(rateSchedule)
+
+	if err != nil {
+		return err
+	}
+
+	apply := func(now time.Time) {
+		rate := scheduledRate(profiles, now, limit)
+
+		if rate != downloadRate.current() {
+			infof("Limiting downloads to %s (-rate-schedule)", formatRate(rate))
+			downloadRate.setRate(rate)
+		}
+	}
+
+	apply(time.Now())
+
+	go func() {
+		for now := range time.Tick(30 * time.Second) {
+			apply(now)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// rcloneStorage stores firmwares in any rclone remote, set with -dest rclone:remote:path.
+// It runs the rclone binary, so remotes are configured with rclone config (or RCLONE_* environment variables).
+type rcloneStorage struct {
+	remote string
+}
+
+func newRcloneStorage(u *url.URL) (storage, error) {
+	if !strings.Contains(u.Opaque, ":") {
+		return nil, errors.New("no remote given, use rclone:remote:path")
+	}
+
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return nil, err
+	}
+
+	return &rcloneStorage{remote: strings.TrimSuffix(u.Opaque, "/")}, nil
+}
+
+func (s *rcloneStorage) String() string {
+	return "rclone:" + s.remote
+}
+
+func (s *rcloneStorage) path(name string) string {
+	if strings.HasSuffix(s.remote, ":") {
+		return s.remote + name
+	}
+
+	return s.remote + "/" + name
+}
+
+// run runs rclone, returning its output and exit code along with any error.
+func (s *rcloneStorage) run(args ...string) ([]byte, int, error) {
+	return s.runWithInput(nil, args...)
+}
+
+func (s *rcloneStorage) runWithInput(stdin io.Reader, args ...string) ([]byte, int, error) {
+	cmd := exec.Command("rclone", args...)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, exitErr.ExitCode(), fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), 0, err
+}
+
+func (s *rcloneStorage) exists(name string) (bool, error) {
+	out, code, err := s.run("lsjson", "--files-only", s.path(name))
+
+	// rclone exits with 3 if the directory, or 4 if the file, is not found
+	if code == 3 || code == 4 {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// remotes without directories, e.g. S3, list nothing instead
+	var files []struct {
+		Name string
+	}
+
+	if err := json.Unmarshal(out, &files); err != nil {
+		return false, err
+	}
+
+	return len(files) > 0, nil
+}
+
+// store copies the file with rclone, which checks its hash against the uploaded object where the remote supports it.
+func (s *rcloneStorage) store(path, name string, job *downloadJob) error {
+	defer keepAlive()()
+
+	_, _, err := s.run("copyto", path, s.path(name))
+
+	return err
+}
+
+// storeStream uploads r beside name with rclone rcat, then moves it into place if check passes.
+// Remotes which can't upload streams of unknown size are buffered to local disk by rclone.
+func (s *rcloneStorage) storeStream(r io.Reader, name string, job *downloadJob, check func() error) error {
+	partial := s.path(name + partialSuffix)
+
+	if _, _, err := s.runWithInput(r, "rcat", partial); err != nil {
+		return err
+	}
+
+	if err := check(); err != nil {
+		if _, _, err := s.run("deletefile", partial); err != nil {
+			warnf("Unable to remove partial upload: %s, err: %s", partial, err)
+		}
+
+		return err
+	}
+
+	_, _, err := s.run("moveto", partial, s.path(name))
+
+	return err
+}
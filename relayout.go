@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// relayoutCommand moves the firmwares of the selected devices from where -old-d and -old-filename put them to where
+// -d and -filename put them, e.g. after changing the templates. With -dry-run, it only logs what it would move.
+func relayoutCommand() error {
+	if oldDirectoryTemplate == "" {
+		return errors.New("-old-d must be set to the download directory template the firmwares were downloaded with")
+	}
+
+	if destination != nil {
+		return errors.New("only local files can be moved, not those in -dest")
+	}
+
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	// movedTo is where each file has been moved, so that firmwares shared by several devices are linked to it
+	movedTo := make(map[string]string)
+	moved, skipped := 0, 0
+
+	for i := range selected {
+		if information[i] == nil {
+			return fmt.Errorf("unable to retrieve firmwares for %s", selected[i].Identifier)
+		}
+
+		for _, ipsw := range information[i].Firmwares {
+			oldPath, err := layoutPath(oldDirectoryTemplate, oldFilenameTemplate, &ipsw, &selected[i])
+
+			if err != nil {
+				return fmt.Errorf("invalid old template, err: %s", err)
+			}
+
+			newPath, err := firmwarePath(&ipsw, &selected[i])
+
+			if err != nil {
+				return err
+			}
+
+			if filepath.Clean(oldPath) == filepath.Clean(newPath) {
+				continue
+			}
+
+			if _, err := os.Lstat(newPath); err == nil {
+				debugf("%s is already in place", newPath)
+				continue
+			}
+
+			source := oldPath
+
+			if _, err := os.Lstat(oldPath); os.IsNotExist(err) {
+				if source = movedTo[oldPath]; source == "" {
+					continue
+				}
+			} else if err != nil {
+				return err
+			}
+
+			if dryRun {
+				infof("Would move %s to %s", source, newPath)
+				moved++
+				continue
+			}
+
+			if err := relayoutFirmware(source, newPath, oldPath != source, &ipsw); err != nil {
+				errorf("Unable to move %s to %s, err: %s", source, newPath, err)
+				skipped++
+				continue
+			}
+
+			movedTo[oldPath] = newPath
+			moved++
+		}
+	}
+
+	if dryRun {
+		infof("Would move %d firmware(s)", moved)
+	} else {
+		infof("Moved %d firmware(s), %d could not be moved", moved, skipped)
+	}
+
+	return nil
+}
+
+// relayoutFirmware moves (or, if link is set, hardlinks) the file at source to path, checking that it is the firmware first.
+func relayoutFirmware(source, path string, link bool, fw *api.Firmware) error {
+	if contentAddressed && storedAsObject(fw) {
+		// the old path is a link to the object, which just needs linking to from the new path instead
+		if err := makeDirectory(filepath.Dir(path)); err != nil {
+			return err
+		}
+
+		if err := linkObject(objectPath(fw), path); err != nil {
+			return err
+		}
+
+		if link {
+			return nil
+		}
+
+		if err := removeWithEmptyParents(source, templateRoot(oldDirectoryTemplate)); err != nil {
+			return err
+		}
+
+		auditf("delete", source, "", "relayout to the -d template, replaced by "+path)
+
+		return nil
+	}
+
+	info, err := os.Stat(source)
+
+	if err != nil {
+		return err
+	}
+
+	if fw.Filesize > 0 && uint64(info.Size()) != fw.Filesize {
+		return fmt.Errorf("it is %d bytes, but the firmware is %d bytes", info.Size(), fw.Filesize)
+	}
+
+	if verifyIntegrity && !link {
+		if expected, ok := checksumFor(fw); ok {
+			if _, matches, err := verify(source, expected, io.Discard); err != nil {
+				return err
+			} else if !matches {
+				return errors.New("its checksum is incorrect")
+			}
+		}
+	}
+
+	if err := makeDirectory(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if link {
+		if err := os.Link(source, path); err != nil {
+			return err
+		}
+
+		infof("Linked %s to %s", path, source)
+		auditf("link", path, source, "relayout to the -d template")
+
+		return nil
+	}
+
+	if err := os.Rename(source, path); err != nil {
+		return err
+	}
+
+	infof("Moved %s to %s", source, path)
+	auditf(moveOperation(source, path), source, path, "relayout to the -d template")
+
+	return removeWithEmptyParents(source, templateRoot(oldDirectoryTemplate))
+}
+
+// removeWithEmptyParents removes the file at path (if it still exists), then any directories it leaves empty, up to root.
+func removeWithEmptyParents(path, root string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for directory := filepath.Dir(path); ; directory = filepath.Dir(directory) {
+		if rel, err := filepath.Rel(root, directory); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return nil
+		}
+
+		// fails, leaving the directory alone, if it isn't empty
+		if os.Remove(directory) != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// handleReloadSignal does nothing, as there is no SIGHUP on this platform, -config is reloaded when it is modified.
+func handleReloadSignal() {}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// reportFile is what happened to one planned firmware, in the run report
+type reportFile struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	Path       string `json:"path"`
+	Size       uint64 `json:"size"`
+
+	// Outcome is not_attempted, downloaded, failed, skipped, interrupted, verified, verification_failed or unverifiable
+	Outcome  string  `json:"outcome"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type reportTotals struct {
+	Planned            int    `json:"planned"`
+	PlannedBytes       uint64 `json:"planned_bytes"`
+	Downloaded         int    `json:"downloaded"`
+	DownloadedBytes    uint64 `json:"downloaded_bytes"`
+	Failed             int    `json:"failed"`
+	Skipped            int    `json:"skipped"`
+	Interrupted        int    `json:"interrupted"`
+	NotAttempted       int    `json:"not_attempted"`
+	Verified           int    `json:"verified"`
+	VerificationFailed int    `json:"verification_failed"`
+	Unverifiable       int    `json:"unverifiable"`
+}
+
+// runReport describes a run, for automation wrapping allthefirmwares
+type runReport struct {
+	Command  string       `json:"command"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Duration float64      `json:"duration_seconds"`
+	Error    string       `json:"error,omitempty"`
+	Files    []reportFile `json:"files"`
+	Totals   reportTotals `json:"totals"`
+
+	mu sync.Mutex
+
+	// index is the position of each file in Files, by path
+	index map[string]int
+}
+
+// currentReport is the report of the run in progress, if -report is set
+var currentReport *runReport
+
+// startRunReport starts recording a new run report of command, if -report is set.
+func startRunReport(command string) {
+	if reportPath == "" {
+		return
+	}
+
+	currentReport = &runReport{
+		Command: command,
+		Started: time.Now(),
+		Files:   []reportFile{},
+		index:   make(map[string]int),
+	}
+}
+
+// plan adds the jobs about to be processed to the report.
+func (r *runReport) plan(jobs []downloadJob) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range jobs {
+		if _, ok := r.index[job.Path]; ok {
+			continue
+		}
+
+		r.index[job.Path] = len(r.Files)
+		r.Files = append(r.Files, reportFile{
+			Identifier: job.Device.Identifier,
+			Version:    job.Firmware.Version,
+			BuildID:    job.Firmware.BuildID,
+			Path:       job.Path,
+			Size:       job.Firmware.Filesize,
+			Outcome:    "not_attempted",
+		})
+	}
+}
+
+// record sets the outcome of a planned job.
+func (r *runReport) record(job *downloadJob, outcome string, duration time.Duration, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index[job.Path]
+
+	if !ok {
+		return
+	}
+
+	r.Files[i].Outcome = outcome
+	r.Files[i].Duration = duration.Seconds()
+
+	if err != nil {
+		r.Files[i].Error = err.Error()
+	}
+}
+
+// writeRunReport finishes the current run report and writes it to -report.
+func writeRunReport(runErr error) {
+	r := currentReport
+	currentReport = nil
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now()
+	r.Duration = r.Finished.Sub(r.Started).Seconds()
+
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+
+	for _, file := range r.Files {
+		r.Totals.Planned++
+		r.Totals.PlannedBytes += file.Size
+
+		switch file.Outcome {
+		case "downloaded":
+			r.Totals.Downloaded++
+			r.Totals.DownloadedBytes += file.Size
+		case "failed":
+			r.Totals.Failed++
+		case "skipped":
+			r.Totals.Skipped++
+		case "interrupted":
+			r.Totals.Interrupted++
+		case "not_attempted":
+			r.Totals.NotAttempted++
+		case "verified":
+			r.Totals.Verified++
+		case "verification_failed":
+			r.Totals.VerificationFailed++
+		case "unverifiable":
+			r.Totals.Unverifiable++
+		}
+	}
+
+	if err := writeJSONFile(reportPath, r); err != nil {
+		warnf("Unable to write run report: %s, err: %s", reportPath, err)
+	} else if err := signFile(reportPath); err != nil {
+		warnf("%s", err)
+	}
+}
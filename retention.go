@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+	"github.com/dustin/go-humanize"
+)
+
+// retentionFile is a file of the archive, and the firmwares stored in it, which are several if they're shared by
+// several devices
+type retentionFile struct {
+	paths   []string
+	object  string
+	name    string
+	version string
+	size    uint64
+	signed  bool
+	newest  time.Time
+	stored  bool
+}
+
+// prunedEvent is the data of the firmware_pruned event
+type prunedEvent struct {
+	Firmware string   `json:"firmware"`
+	Paths    []string `json:"paths"`
+	Size     string   `json:"size"`
+}
+
+// retentionPlan is which files of the archive are kept within -max-archive-size
+type retentionPlan struct {
+	files map[string]*retentionFile
+	keep  map[string]bool
+
+	// order is the files, signed first, then the newest unsigned ones
+	order []string
+}
+
+// retentionKey returns the file a firmware is stored in: its object w/ -content-addressed, otherwise its path.
+func retentionKey(fw *api.Firmware, path string) string {
+	if storedAsObject(fw) {
+		return objectPath(fw)
+	}
+
+	return filepath.Clean(path)
+}
+
+// planRetention decides which of the firmwares in the catalog which have been downloaded, or match the flags, are
+// kept within -max-archive-size: every signed firmware, then the newest unsigned ones which fit. It returns nil
+// without -max-archive-size.
+func planRetention() (*retentionPlan, error) {
+	if maxArchiveSize == 0 || destination != nil {
+		return nil, nil
+	}
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	plan := &retentionPlan{files: make(map[string]*retentionFile), keep: make(map[string]bool)}
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+		selected := deviceSelected(device.Identifier) && deviceAttributesSelected(&device.BaseDevice)
+
+		sortFirmwares(device)
+
+		for index := range device.Firmwares {
+			fw := &device.Firmwares[index]
+
+			path, err := firmwarePath(fw, &device.BaseDevice)
+
+			if err != nil {
+				return nil, err
+			}
+
+			stored, err := firmwareStored(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !stored && !(selected && firmwareSelected(index, fw)) {
+				continue
+			}
+
+			key := retentionKey(fw, path)
+			file, ok := plan.files[key]
+
+			if !ok {
+				file = &retentionFile{name: fmt.Sprintf("%s %s (%s)", fw.Identifier, fw.Version, fw.BuildID), version: fw.Version}
+				plan.files[key] = file
+
+				if storedAsObject(fw) {
+					file.object = key
+				}
+			}
+
+			file.paths = append(file.paths, path)
+			file.signed = file.signed || fw.Signed
+			file.stored = file.stored || stored
+
+			if fw.Filesize > file.size {
+				file.size = fw.Filesize
+			}
+
+			if date := firmwareDate(fw); date.After(file.newest) {
+				file.newest = date
+			}
+		}
+	}
+
+	for key := range plan.files {
+		plan.order = append(plan.order, key)
+	}
+
+	keys := plan.order
+
+	// signed firmwares first, then the newest unsigned ones
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := plan.files[keys[i]], plan.files[keys[j]]
+
+		if a.signed != b.signed {
+			return a.signed
+		}
+
+		if !a.newest.Equal(b.newest) {
+			return a.newest.After(b.newest)
+		}
+
+		return compareVersions(a.version, b.version) > 0
+	})
+
+	total := uint64(0)
+
+	for _, key := range keys {
+		file := plan.files[key]
+
+		// unsigned firmwares are pruned oldest first, so none older than one which doesn't fit is kept either
+		if !file.signed && total+file.size > maxArchiveSize {
+			break
+		}
+
+		plan.keep[key] = true
+		total += file.size
+	}
+
+	if total > maxArchiveSize {
+		warnf("The signed firmwares alone take %s, more than -max-archive-size (%s), which are never pruned", humanize.Bytes(total), humanize.Bytes(maxArchiveSize))
+	}
+
+	return plan, nil
+}
+
+// allows reports whether the firmware at path is kept, which new firmwares are.
+func (r *retentionPlan) allows(fw *api.Firmware, path string) bool {
+	key := retentionKey(fw, path)
+
+	if _, ok := r.files[key]; !ok {
+		return true
+	}
+
+	return r.keep[key]
+}
+
+// pruneArchive removes the downloaded firmwares which aren't kept within -max-archive-size, oldest unsigned first,
+// along with the fast checksums, chunklists and torrents beside them.
+func pruneArchive() error {
+	plan, err := planRetention()
+
+	if err != nil || plan == nil {
+		return err
+	}
+
+	removed, failed := 0, 0
+	freed := uint64(0)
+
+	// oldest first
+	for i := len(plan.order) - 1; i >= 0; i-- {
+		key := plan.order[i]
+		file := plan.files[key]
+
+		if !file.stored || plan.keep[key] {
+			continue
+		}
+
+		if dryRun {
+			infof("Would prune %s (%s), unsigned and beyond -max-archive-size", file.name, humanize.Bytes(file.size))
+			removed++
+			freed += file.size
+
+			continue
+		}
+
+		infof("Pruning %s (%s), unsigned and beyond -max-archive-size", file.name, humanize.Bytes(file.size))
+
+		if err := removeRetentionFile(file); err != nil {
+			errorf("Unable to prune %s, err: %s", file.name, err)
+			failed++
+
+			continue
+		}
+
+		publishEvent("firmware_pruned", prunedEvent{Firmware: file.name, Paths: file.paths, Size: humanize.Bytes(file.size)})
+
+		removed++
+		freed += file.size
+	}
+
+	if removed > 0 && trashDirectory != "" && !dryRun {
+		infof("Pruned %d firmware(s), moving %s to the trash, which empty-trash frees", removed, humanize.Bytes(freed))
+	} else if removed > 0 {
+		infof("Pruned %d firmware(s), freeing %s", removed, humanize.Bytes(freed))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to prune %d firmware(s)", failed)
+	}
+
+	return nil
+}
+
+// removeRetentionFile removes a pruned file from each path it's stored at, and the files beside them.
+func removeRetentionFile(file *retentionFile) error {
+	paths := file.paths
+
+	if file.object != "" {
+		paths = append(paths, file.object)
+	}
+
+	for _, path := range paths {
+		root, _, ok := poolRelative(path)
+
+		if !ok {
+			root = downloadRoot()
+		}
+
+		for _, suffix := range []string{fastChecksumSuffix, chunklistSuffix, ".torrent"} {
+			if _, err := removeOrTrash(path+suffix, root); err != nil {
+				return err
+			}
+		}
+
+		trashed, err := removeOrTrash(path, root)
+
+		if err != nil {
+			return err
+		}
+
+		auditf("prune", path, trashed, "beyond -max-archive-size")
+	}
+
+	return nil
+}
+
+// pruneCommand removes the downloaded firmwares which aren't kept within -max-archive-size, as downloads do when
+// they finish.
+func pruneCommand() error {
+	if maxArchiveSize == 0 {
+		return errors.New("-max-archive-size must be given")
+	}
+
+	if destination != nil {
+		return errors.New("only local files can be pruned, not those in -dest")
+	}
+
+	return pruneArchive()
+}
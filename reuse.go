@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// reuseExistingCopies looks for a copy of each job's firmware elsewhere in the download root, e.g. left there by an old
+// -d template, and moves or links it into place (w/ -reuse) rather than downloading it again. current are the paths of
+// the firmwares matching the flags, which are linked rather than moved. It returns the jobs which still need downloading.
+func reuseExistingCopies(jobs []downloadJob, current map[string]bool) []downloadJob {
+	if reuseExisting == "" || destination != nil || contentAddressed || len(jobs) == 0 {
+		return jobs
+	}
+
+	bySize := make(map[int64][]string)
+
+	err := walkArchive(func(path string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			bySize[info.Size()] = append(bySize[info.Size()], path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		warnf("Unable to search for existing copies of firmwares, err: %s", err)
+		return jobs
+	}
+
+	var remaining []downloadJob
+
+	for _, job := range jobs {
+		// firmwares which have changed upstream are replaced where they are
+		if !job.Replace && reuseExistingCopy(&job, bySize, current) {
+			totalFirmwareCount--
+			totalFirmwareSize -= job.Firmware.Filesize
+			continue
+		}
+
+		remaining = append(remaining, job)
+	}
+
+	return remaining
+}
+
+// reuseExistingCopy moves or links a file of the same size and checksum as job's firmware to its path, if there is one.
+func reuseExistingCopy(job *downloadJob, bySize map[int64][]string, current map[string]bool) bool {
+	expected, ok := checksumFor(&job.Firmware)
+
+	if !ok || job.Firmware.Filesize == 0 {
+		return false
+	}
+
+	size := int64(job.Firmware.Filesize)
+	candidates := append([]string(nil), bySize[size]...)
+	name := filepath.Base(job.Path)
+
+	// files with the same name are the most likely to be copies
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return filepath.Base(candidates[i]) == name && filepath.Base(candidates[j]) != name
+	})
+
+	for _, candidate := range candidates {
+		if filepath.Clean(candidate) == filepath.Clean(job.Path) {
+			continue
+		}
+
+		debugf("Checking whether %s (%s) is a copy of %s", candidate, humanize.Bytes(uint64(size)), name)
+
+		if _, matches, err := verify(candidate, expected, io.Discard); err != nil || !matches {
+			continue
+		}
+
+		if err := makeDirectory(filepath.Dir(job.Path)); err != nil {
+			errorf("Unable to create download directory: %s, err: %s", filepath.Dir(job.Path), err)
+			return false
+		}
+
+		move := reuseExisting == "move" && !current[filepath.Clean(candidate)]
+
+		var err error
+
+		if move {
+			err = os.Rename(candidate, job.Path)
+		} else {
+			err = os.Link(candidate, job.Path)
+		}
+
+		if err != nil {
+			warnf("Unable to reuse %s for %s, err: %s", candidate, job.Path, err)
+			return false
+		}
+
+		if move {
+			infof("Moved existing copy %s to %s", candidate, job.Path)
+			auditf(moveOperation(candidate, job.Path), candidate, job.Path, "reused an existing copy instead of downloading it")
+
+			// it can't be reused again, but the file now at job.Path can
+			for i, path := range bySize[size] {
+				if path == candidate {
+					bySize[size][i] = job.Path
+				}
+			}
+		} else {
+			infof("Linked existing copy %s to %s", candidate, job.Path)
+			auditf("link", job.Path, candidate, "reused an existing copy instead of downloading it")
+		}
+
+		return true
+	}
+
+	return false
+}
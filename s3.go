@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of each part of a multipart upload. S3 allows at most 10,000 parts, i.e. 160GB.
+	s3PartSize = 16 << 20
+
+	// s3PartAttempts is how many times uploading a part is attempted
+	s3PartAttempts = 3
+)
+
+// s3Storage stores firmwares in an S3 bucket (or an S3 compatible service), set with -dest s3://bucket/prefix.
+// Credentials are given by AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN, the region by AWS_REGION,
+// and AWS_ENDPOINT_URL may be set to use another service, e.g. MinIO.
+type s3Storage struct {
+	bucket, prefix, region string
+	endpoint               *url.URL
+	accessKey, secretKey   string
+	sessionToken           string
+	pathStyle              bool
+}
+
+func newS3Storage(u *url.URL) (storage, error) {
+	s := &s3Storage{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if s.bucket == "" {
+		return nil, errors.New("no bucket given, use s3://bucket/prefix")
+	}
+
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+
+	endpoint := "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com"
+
+	if e := os.Getenv("AWS_ENDPOINT_URL"); e != "" {
+		// other services generally only support path style requests
+		endpoint, s.pathStyle = strings.TrimSuffix(e, "/"), true
+	}
+
+	var err error
+
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL, err: %s", err)
+	}
+
+	return s, nil
+}
+
+func (s *s3Storage) String() string {
+	return "s3://" + joinStorageName(s.bucket, s.prefix)
+}
+
+func (s *s3Storage) key(name string) string {
+	return joinStorageName(s.prefix, name)
+}
+
+// s3Error is an error response from S3
+type s3Error struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("unexpected response status: %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("unexpected response status: %d (%s)", e.StatusCode, e.Code)
+}
+
+// s3Escape escapes s as AWS Signature Version 4 requires, optionally leaving slashes as they are.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// do makes a signed request for key, returning the response body, or an *s3Error if the response was not successful.
+func (s *s3Storage) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, []byte, error) {
+	path := s.endpoint.Path + "/" + key
+
+	if s.pathStyle {
+		path = s.endpoint.Path + "/" + s.bucket + "/" + key
+	}
+
+	var queryParts []string
+
+	for k, values := range query {
+		for _, v := range values {
+			queryParts = append(queryParts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+
+	sort.Strings(queryParts)
+
+	u := *s.endpoint
+	u.Path, u.RawPath, u.RawQuery = path, s3Escape(path, true), strings.Join(queryParts, "&")
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	s.sign(req, body, time.Now())
+
+	resp, err := storageClient.Do(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var errorBody struct {
+		XMLName xml.Name
+		Code    string
+	}
+
+	// CompleteMultipartUpload can fail after responding 200 OK
+	if xml.Unmarshal(b, &errorBody) == nil && errorBody.XMLName.Local == "Error" || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &s3Error{StatusCode: resp.StatusCode, Code: errorBody.Code}
+	}
+
+	return resp, b, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header to req.
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-md5" || k == "content-type" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+s.secretKey), date), s.region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func (s *s3Storage) exists(name string) (bool, error) {
+	_, _, err := s.do(http.MethodHead, s.key(name), nil, nil, nil)
+
+	if s3Err, ok := err.(*s3Error); ok && s3Err.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// s3Part is a part of a multipart upload
+type s3Part struct {
+	PartNumber int
+	ETag       string
+	Size       int64 `xml:",omitempty"`
+}
+
+// pendingUpload finds a multipart upload of key left by an interrupted run, returning its ID and the parts already uploaded.
+func (s *s3Storage) pendingUpload(key string) (string, map[int]s3Part, error) {
+	_, b, err := s.do(http.MethodGet, "", url.Values{"uploads": {""}, "prefix": {key}}, nil, nil)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	var uploads struct {
+		Uploads []struct {
+			Key       string
+			UploadID  string `xml:"UploadId"`
+			Initiated time.Time
+		} `xml:"Upload"`
+	}
+
+	if err := xml.Unmarshal(b, &uploads); err != nil {
+		return "", nil, err
+	}
+
+	uploadID := ""
+	var initiated time.Time
+
+	for _, upload := range uploads.Uploads {
+		if upload.Key == key && upload.Initiated.After(initiated) {
+			uploadID, initiated = upload.UploadID, upload.Initiated
+		}
+	}
+
+	if uploadID == "" {
+		return "", nil, nil
+	}
+
+	parts := make(map[int]s3Part)
+	marker := "0"
+
+	for {
+		_, b, err := s.do(http.MethodGet, key, url.Values{"uploadId": {uploadID}, "part-number-marker": {marker}}, nil, nil)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		var list struct {
+			Parts                []s3Part `xml:"Part"`
+			IsTruncated          bool
+			NextPartNumberMarker string
+		}
+
+		if err := xml.Unmarshal(b, &list); err != nil {
+			return "", nil, err
+		}
+
+		for _, part := range list.Parts {
+			parts[part.PartNumber] = part
+		}
+
+		if !list.IsTruncated {
+			break
+		}
+
+		marker = list.NextPartNumberMarker
+	}
+
+	return uploadID, parts, nil
+}
+
+func (s *s3Storage) store(path, name string, job *downloadJob) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return s.upload(file, name, job, nil)
+}
+
+func (s *s3Storage) storeStream(r io.Reader, name string, job *downloadJob, check func() error) error {
+	return s.upload(r, name, job, check)
+}
+
+// upload stores r with a multipart upload, completing it if check (if any) passes. Each part is retried on its own,
+// and parts already uploaded by an interrupted upload of the same file are reused if their MD5 matches.
+func (s *s3Storage) upload(r io.Reader, name string, job *downloadJob, check func() error) error {
+	key := s.key(name)
+
+	uploadID, uploaded, err := s.pendingUpload(key)
+
+	if err != nil {
+		warnf("Unable to find previous uploads of %s, err: %s", name, err)
+	}
+
+	if uploadID == "" {
+		header := http.Header{
+			"Content-Type":          {"application/octet-stream"},
+			"X-Amz-Meta-Sha1":       {job.Firmware.SHA1Sum},
+			"X-Amz-Meta-Buildid":    {job.Firmware.BuildID},
+			"X-Amz-Meta-Identifier": {job.Firmware.Identifier},
+		}
+
+		_, b, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+
+		if err != nil {
+			return fmt.Errorf("unable to start upload, err: %s", err)
+		}
+
+		var initiated struct {
+			UploadID string `xml:"UploadId"`
+		}
+
+		if err := xml.Unmarshal(b, &initiated); err != nil {
+			return err
+		}
+
+		uploadID = initiated.UploadID
+	} else {
+		infof("Resuming upload of %s, %d part(s) already uploaded", name, len(uploaded))
+	}
+
+	buf := make([]byte, s3PartSize)
+
+	var parts []s3Part
+	var size int64
+
+	for number := 1; ; number++ {
+		if shutdownRequested() {
+			return errShutdown
+		}
+
+		n, err := io.ReadFull(r, buf)
+
+		if err == io.EOF && number > 1 {
+			break
+		} else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		part := buf[:n]
+		partMD5 := md5.Sum(part)
+		etag := `"` + hex.EncodeToString(partMD5[:]) + `"`
+		size += int64(n)
+
+		parts = append(parts, s3Part{PartNumber: number, ETag: etag})
+
+		if previous, ok := uploaded[number]; ok && previous.ETag == etag {
+			debugf("Part %d of %s was already uploaded", number, name)
+		} else if err := s.uploadPart(key, uploadID, number, part, partMD5[:]); err != nil {
+			return err
+		}
+
+		markAlive()
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	if check != nil {
+		if err := check(); err != nil {
+			if _, _, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil); err != nil {
+				warnf("Unable to abort upload of %s, err: %s", name, err)
+			}
+
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body); err != nil {
+		return fmt.Errorf("unable to complete upload, err: %s", err)
+	}
+
+	resp, _, err := s.do(http.MethodHead, key, nil, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.ContentLength != size {
+		return fmt.Errorf("uploaded object is %d bytes, expected %d", resp.ContentLength, size)
+	}
+
+	return nil
+}
+
+// uploadPart uploads a part, which S3 checks against its MD5, retrying if it fails.
+func (s *s3Storage) uploadPart(key, uploadID string, number int, part, partMD5 []byte) error {
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(partMD5)}}
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+
+	for attempt := 1; ; attempt++ {
+		_, _, err := s.do(http.MethodPut, key, query, header, part)
+
+		if err == nil {
+			return nil
+		} else if attempt == s3PartAttempts {
+			return fmt.Errorf("unable to upload part %d, err: %s", number, err)
+		}
+
+		warnf("Unable to upload part %d of %s, retrying, err: %s", number, key, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sampleFraction is the parsed value of -sample, the fraction of firmwares to verify in each run, or 0 for all
+var sampleFraction float64
+
+// parseSample parses -sample, e.g. 10%, and checks -sample-count.
+func parseSample() error {
+	sampleFraction = 0
+
+	if sampleCount < 0 {
+		return fmt.Errorf("invalid -sample-count: %d, it must not be negative", sampleCount)
+	}
+
+	if sampleValue == "" {
+		return nil
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(sampleValue), "%"), 64)
+
+	if err != nil || percent <= 0 || percent > 100 {
+		return fmt.Errorf("invalid -sample: %s, use a percentage, e.g. 10%%", sampleValue)
+	}
+
+	if sampleCount > 0 {
+		return fmt.Errorf("-sample and -sample-count can't both be given")
+	}
+
+	sampleFraction = percent / 100
+
+	return nil
+}
+
+// sampleJobs returns a random sample of the jobs to verify (w/ -sample or -sample-count), so that each run checks
+// part of an archive which is too large to verify entirely.
+func sampleJobs(jobs []downloadJob) []downloadJob {
+	n := sampleCount
+
+	if sampleFraction > 0 {
+		n = int(math.Ceil(sampleFraction * float64(len(jobs))))
+	}
+
+	if n <= 0 || n >= len(jobs) {
+		return jobs
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	r.Shuffle(len(jobs), func(i, j int) {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	})
+
+	sample := jobs[:n]
+
+	totalFirmwareCount, totalFirmwareSize = 0, 0
+
+	for _, job := range sample {
+		totalFirmwareCount++
+		totalFirmwareSize += job.Firmware.Filesize
+	}
+
+	infof("Verifying a random sample of %d of %d firmware(s)", n, len(jobs))
+
+	return sample
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// jobScheduler hands out the jobs of a queue to the -j workers in order, except that jobs are passed over while their
+// device already has -device-concurrency downloads in progress, their host has as many as -host-concurrency allows, or
+// the same firmware is being downloaded for another device, until one of those finishes.
+type jobScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	jobs []downloadJob
+
+	// pending are the indices of the jobs which haven't been started, in order
+	pending  []int
+	finished []bool
+
+	// active is the number of jobs in progress for each device, activeHosts for each host, and downloading the URLs
+	// being downloaded
+	active      map[string]int
+	activeHosts map[string]int
+	downloading map[string]bool
+
+	lastDevice string
+
+	// running is the number of jobs in progress, and limit the most which may be, w/ -adaptive, or 0 for as many as
+	// there are workers
+	running, limit int
+
+	// open is set while more jobs may be added, w/ -pipeline
+	open bool
+
+	stopped, interrupted bool
+}
+
+func newJobScheduler(jobs []downloadJob) *jobScheduler {
+	s := &jobScheduler{
+		jobs:        jobs,
+		pending:     make([]int, len(jobs)),
+		finished:    make([]bool, len(jobs)),
+		active:      make(map[string]int),
+		activeHosts: make(map[string]int),
+		downloading: make(map[string]bool),
+	}
+
+	s.cond = sync.NewCond(&s.mu)
+
+	for i := range jobs {
+		s.pending[i] = i
+	}
+
+	return s
+}
+
+// add queues more jobs, which must be followed by close once there are no more.
+func (s *jobScheduler) add(jobs []downloadJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range jobs {
+		s.pending = append(s.pending, len(s.jobs))
+		s.jobs = append(s.jobs, job)
+		s.finished = append(s.finished, false)
+	}
+
+	currentStatus.setQueue(append([]downloadJob(nil), s.jobs...))
+	s.cond.Broadcast()
+}
+
+// close records that no more jobs will be added.
+func (s *jobScheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.open = false
+	s.cond.Broadcast()
+}
+
+// count returns the number of jobs queued.
+func (s *jobScheduler) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.jobs)
+}
+
+// next returns the index of the next job to process (and a copy of the job), waiting for one to become available if
+// need be, or -1 if there are none left or the queue was stopped, by a shutdown or by reaching -max-bytes or -max-files.
+func (s *jobScheduler) next() (int, *downloadJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if !s.stopped && shutdownRequested() {
+			s.stopped, s.interrupted = true, true
+		}
+
+		if !s.stopped && !verifyIntegrity && len(s.pending) > 0 && runLimitReached() {
+			s.stopped = true
+		}
+
+		if s.stopped || (len(s.pending) == 0 && !s.open) {
+			return -1, nil
+		}
+
+		// w/ -adaptive, jobs wait until fewer than the limit are in progress
+		if s.limit > 0 && s.running >= s.limit {
+			s.cond.Wait()
+			continue
+		}
+
+		for k, i := range s.pending {
+			job := &s.jobs[i]
+			device := job.Device.Identifier
+
+			// imported firmwares have no device
+			if device != "" && deviceConcurrency > 0 && s.active[device] >= deviceConcurrency {
+				continue
+			}
+
+			host := jobHost(job)
+
+			if limit := hostLimit(host); limit > 0 && s.activeHosts[host] >= limit {
+				continue
+			}
+
+			if s.downloading[job.Firmware.URL] {
+				continue
+			}
+
+			s.pending = append(s.pending[:k], s.pending[k+1:]...)
+			s.running++
+
+			// counted here, under the lock, so that workers can't start more than -max-files between them
+			if !verifyIntegrity {
+				atomic.AddInt64(&downloadsStarted, 1)
+			}
+
+			s.active[device]++
+			s.activeHosts[host]++
+			s.downloading[job.Firmware.URL] = true
+
+			currentStatus.setPosition(i)
+			s.logDevice(i)
+
+			// jobs may be added while this one is processed, so it can't be referred to in s.jobs
+			copied := *job
+
+			return i, &copied
+		}
+
+		s.cond.Wait()
+	}
+}
+
+// logDevice logs how many firmwares there are for the device of job i when downloads move on to it (w/ -order device).
+func (s *jobScheduler) logDevice(i int) {
+	job := &s.jobs[i]
+
+	if verifyIntegrity || downloadOrder != "device" || job.Device.Identifier == "" || job.Device.Identifier == s.lastDevice {
+		return
+	}
+
+	count := 1
+
+	for count < len(s.jobs)-i && s.jobs[i+count].Device.Identifier == job.Device.Identifier {
+		count++
+	}
+
+	infof("Downloading %d firmwares for %s", count, job.Device.Name)
+	s.lastDevice = job.Device.Identifier
+}
+
+// done records that job i has been processed, or if it was interrupted (by a shutdown), that it was stopped.
+func (s *jobScheduler) done(i int, interrupted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := &s.jobs[i]
+
+	s.running--
+	s.active[job.Device.Identifier]--
+	s.activeHosts[jobHost(job)]--
+	delete(s.downloading, job.Firmware.URL)
+
+	if interrupted {
+		s.stopped, s.interrupted = true, true
+	} else {
+		s.finished[i] = true
+	}
+
+	s.cond.Broadcast()
+}
+
+// stopAfterCurrent stops handing out jobs, so the run ends once those in progress finish, saving the rest for the next
+// run as if it had been interrupted.
+func (s *jobScheduler) stopAfterCurrent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped, s.interrupted = true, true
+	s.cond.Broadcast()
+}
+
+// setLimit changes the most jobs which may be in progress at once, or 0 for as many as there are workers.
+func (s *jobScheduler) setLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	s.cond.Broadcast()
+}
+
+// saturated reports whether as many jobs are in progress as the limit allows, and there are more waiting to start.
+func (s *jobScheduler) saturated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.running >= s.limit && len(s.pending) > 0
+}
+
+// jobHost returns the (lower case) hostname job's firmware is downloaded from.
+func jobHost(job *downloadJob) string {
+	u, err := url.Parse(job.Firmware.URL)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// hostLimit returns the most downloads from host to run at once, or 0 for no limit.
+func hostLimit(host string) int {
+	if limit, ok := hostConcurrency[host]; ok {
+		return limit
+	}
+
+	return defaultHostConcurrency
+}
+
+// remaining returns the jobs which weren't processed, in order.
+func (s *jobScheduler) remaining() []downloadJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []downloadJob
+
+	for i, job := range s.jobs {
+		if !s.finished[i] {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
+
+// run processes the jobs with the given number of workers, returning whether it was interrupted by a shutdown.
+// process returns whether the job it was given was interrupted.
+func (s *jobScheduler) run(workers int, process func(job *downloadJob) bool) bool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				waitWhilePaused()
+
+				i, job := s.next()
+
+				if i < 0 {
+					return
+				}
+
+				s.done(i, process(job))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return s.interrupted
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/cj123/go-ipsw/api"
+	"github.com/dustin/go-humanize"
+)
+
+// searchResult is a firmware matching a search, with whether it has been downloaded
+type searchResult struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	Size       uint64 `json:"size"`
+	Signed     bool   `json:"signed"`
+	Path       string `json:"path"`
+
+	// Status is downloaded, partial (partly downloaded) or missing
+	Status string `json:"status"`
+
+	score int
+	fw    *api.Firmware
+}
+
+// searchWords splits s into lower case words, at anything other than letters, digits and dots.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.'
+	})
+}
+
+// withinOneEdit reports whether a and b differ by at most one inserted, removed or changed character, or two swapped
+// adjacent characters.
+func withinOneEdit(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	if len(b)-len(a) > 1 {
+		return false
+	}
+
+	i := 0
+
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+
+	if len(a) == len(b) {
+		if i == len(a) || a[i+1:] == b[i+1:] {
+			return true
+		}
+
+		return i+1 < len(a) && a[i] == b[i+1] && a[i+1] == b[i] && a[i+2:] == b[i+2:]
+	}
+
+	return a[i:] == b[i+1:]
+}
+
+// matchScore scores how well token matches any of the words: 3 for an exact match, 2 for a prefix and 1 for a word
+// which is one typo away (for tokens of 4 or more characters), or 0 if it doesn't match.
+func matchScore(token string, words []string) int {
+	best := 0
+
+	for _, word := range words {
+		switch {
+		case word == token:
+			return 3
+		case strings.HasPrefix(word, token):
+			best = 2
+		case best == 0 && len(token) >= 4 && withinOneEdit(token, word):
+			best = 1
+		}
+	}
+
+	return best
+}
+
+// deviceWords are the words of a device's name and identifier, e.g. iphone, 12, pro, iphone13 and 3.
+func deviceWords(device *api.BaseDevice) []string {
+	return append(searchWords(device.Name), searchWords(device.Identifier)...)
+}
+
+// searchScore scores how well every token matches the device or firmware, or returns 0 if any token doesn't match.
+func searchScore(tokens []string, device *api.BaseDevice, fw *api.Firmware) int {
+	words := append(deviceWords(device), searchWords(fw.Version)...)
+	words = append(words, searchWords(fw.BuildID)...)
+
+	total := 0
+
+	for _, token := range tokens {
+		score := matchScore(token, words)
+
+		if score == 0 {
+			return 0
+		}
+
+		total += score
+	}
+
+	return total
+}
+
+// searchDevices retrieves the firmwares of the devices which any of the tokens match, or of every device if none do.
+// If the API can't be reached, the catalog is searched instead.
+func searchDevices(tokens []string) ([]api.Device, error) {
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		warnf("Unable to retrieve firmware information, searching the catalog instead, err: %s", err)
+
+		catalog, err := loadCatalog()
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+		}
+
+		return catalog.Devices, nil
+	}
+
+	var matching []api.BaseDevice
+
+	for i := range devices {
+		for _, token := range tokens {
+			if matchScore(token, deviceWords(&devices[i])) > 0 {
+				matching = append(matching, devices[i])
+				break
+			}
+		}
+	}
+
+	if len(matching) == 0 {
+		matching = devices
+	}
+
+	information, _ := fetchDeviceInformation(matching)
+
+	var result []api.Device
+
+	for _, device := range information {
+		if device != nil {
+			result = append(result, *device)
+		}
+	}
+
+	return result, nil
+}
+
+// searchCommand prints the firmwares fuzzily matching every word of the query, e.g. "iphone 12 14.2", with whether
+// they have been downloaded, as a table or (w/ -json) JSON.
+func searchCommand() error {
+	tokens := searchWords(strings.Join(flag.Args(), " "))
+
+	if len(tokens) == 0 {
+		return errors.New("usage: search QUERY, e.g. search \"iphone 12 14.2\"")
+	}
+
+	devices, err := searchDevices(tokens)
+
+	if err != nil {
+		return err
+	}
+
+	var results []searchResult
+
+	for i := range devices {
+		for j := range devices[i].Firmwares {
+			fw := &devices[i].Firmwares[j]
+			score := searchScore(tokens, &devices[i].BaseDevice, fw)
+
+			if score == 0 {
+				continue
+			}
+
+			result := searchResult{
+				Name:       devices[i].Name,
+				Identifier: devices[i].Identifier,
+				Version:    fw.Version,
+				BuildID:    fw.BuildID,
+				Size:       fw.Filesize,
+				Signed:     fw.Signed,
+				Status:     "missing",
+				score:      score,
+				fw:         fw,
+			}
+
+			if result.Path, err = firmwarePath(fw, &devices[i].BaseDevice); err != nil {
+				return err
+			}
+
+			if stored, err := firmwareStored(result.Path); err == nil && stored {
+				result.Status = "downloaded"
+			} else if _, err := os.Stat(result.Path + partialSuffix); err == nil {
+				result.Status = "partial"
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+
+		if results[i].Identifier != results[j].Identifier {
+			return results[i].Identifier < results[j].Identifier
+		}
+
+		return results[i].fw.UploadDate.Time.After(results[j].fw.UploadDate.Time)
+	})
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		if results == nil {
+			results = []searchResult{}
+		}
+
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(results)
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(out, "No firmwares match")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Device\tIdentifier\tVersion\tBuild\tSize\tSigned\tStatus")
+
+	for _, r := range results {
+		signed := ""
+
+		if r.Signed {
+			signed = "yes"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Identifier, r.Version, r.BuildID, humanize.Bytes(r.Size), signed, r.Status)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// version is the release allthefirmwares was built from, set by the Makefile
+	version = "dev"
+
+	// updatePublicKey is the base64 ed25519 key which release checksums must be signed with, set by the Makefile
+	// if releases are signed. Without it, self-update only checks the downloaded binary against the release's checksums.
+	updatePublicKey = ""
+
+	releasesURL = "https://api.github.com/repos/cj123/allthefirmwares/releases/latest"
+)
+
+const (
+	releaseChecksumsName = "SHA256SUMS"
+	releaseSignatureName = "SHA256SUMS.sig"
+)
+
+// release is a GitHub release
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the release's asset called name.
+func (r *release) assetURL(name string) (string, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+
+	return "", fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+// releaseBinaryName returns the name of the release binary for this platform, as built by the Makefile.
+func releaseBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "allthefirmwares-windows-x32.exe"
+	}
+
+	return fmt.Sprintf("allthefirmwares-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// fetchRelease downloads a release asset (or the release information) into memory.
+func fetchRelease(url string) ([]byte, error) {
+	resp, err := downloadClient.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// releaseChecksum returns the SHA256 of the file called name in a SHA256SUMS file.
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		// sha256sum marks binary files with a *
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s has no checksum for %s", releaseChecksumsName, name)
+}
+
+// parseRelease parses a release version, e.g. v1.2, or one from git describe, e.g. v1.2-3-gabc1234, 3 commits after
+// v1.2, into the version and the number of commits after it.
+func parseRelease(release string) (string, int, bool) {
+	release = strings.TrimPrefix(release, "v")
+	commits := 0
+
+	if parts := strings.Split(release, "-"); len(parts) >= 3 && strings.HasPrefix(parts[len(parts)-1], "g") {
+		n, err := strconv.Atoi(parts[len(parts)-2])
+
+		if err != nil {
+			return "", 0, false
+		}
+
+		release, commits = strings.Join(parts[:len(parts)-2], "-"), n
+	}
+
+	for _, part := range strings.Split(release, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return "", 0, false
+		}
+	}
+
+	return release, commits, true
+}
+
+// compareReleases compares two release versions, as compareVersions does, counting builds made after a release as
+// newer than it. ok is false if either isn't a release version, e.g. for dev builds.
+func compareReleases(a, b string) (comparison int, ok bool) {
+	aVersion, aCommits, aOK := parseRelease(a)
+	bVersion, bCommits, bOK := parseRelease(b)
+
+	if !aOK || !bOK {
+		return 0, false
+	}
+
+	if comparison = compareVersions(aVersion, bVersion); comparison == 0 {
+		comparison = aCommits - bCommits
+	}
+
+	return comparison, true
+}
+
+// selfUpdateCommand replaces the running binary with the latest release, if it is newer (or w/ -allow-downgrade, if
+// it is any different).
+func selfUpdateCommand() error {
+	b, err := fetchRelease(releasesURL)
+
+	if err != nil {
+		return fmt.Errorf("unable to check for releases, err: %s", err)
+	}
+
+	var latest release
+
+	if err := json.Unmarshal(b, &latest); err != nil {
+		return fmt.Errorf("unable to parse release information, err: %s", err)
+	}
+
+	switch comparison, ok := compareReleases(latest.TagName, version); {
+	case ok && comparison == 0:
+		infof("allthefirmwares %s is the latest release", version)
+		return nil
+	case allowDowngrade:
+	case !ok:
+		return fmt.Errorf("unable to tell whether %s is newer than this build (%s), use -allow-downgrade to replace it anyway", latest.TagName, version)
+	case comparison < 0:
+		infof("allthefirmwares %s is newer than the latest release, %s, use -allow-downgrade to replace it anyway", version, latest.TagName)
+		return nil
+	}
+
+	infof("Updating allthefirmwares from %s to %s", version, latest.TagName)
+
+	binaryName := releaseBinaryName()
+
+	binaryURL, err := latest.assetURL(binaryName)
+
+	if err != nil {
+		return err
+	}
+
+	checksumsURL, err := latest.assetURL(releaseChecksumsName)
+
+	if err != nil {
+		return err
+	}
+
+	checksums, err := fetchRelease(checksumsURL)
+
+	if err != nil {
+		return fmt.Errorf("unable to download %s, err: %s", releaseChecksumsName, err)
+	}
+
+	if updatePublicKey != "" {
+		if err := verifyReleaseSignature(&latest, checksums); err != nil {
+			return err
+		}
+	} else {
+		warnf("This build has no release signing key, so the update is only checked against the release's checksums")
+	}
+
+	expected, err := releaseChecksum(checksums, binaryName)
+
+	if err != nil {
+		return err
+	}
+
+	binary, err := fetchRelease(binaryURL)
+
+	if err != nil {
+		return fmt.Errorf("unable to download %s, err: %s", binaryName, err)
+	}
+
+	if sum := sha256.Sum256(binary); hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("%s failed checksum (wanted: %s, got: %s)", binaryName, expected, hex.EncodeToString(sum[:]))
+	}
+
+	if err := replaceExecutable(binary); err != nil {
+		return fmt.Errorf("unable to replace allthefirmwares, err: %s", err)
+	}
+
+	successf("Updated allthefirmwares to %s", latest.TagName)
+
+	return nil
+}
+
+// verifyReleaseSignature checks the release's signature of its checksums with updatePublicKey.
+func verifyReleaseSignature(r *release, checksums []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("this build's release signing key is invalid")
+	}
+
+	signatureURL, err := r.assetURL(releaseSignatureName)
+
+	if err != nil {
+		return err
+	}
+
+	signature, err := fetchRelease(signatureURL)
+
+	if err != nil {
+		return fmt.Errorf("unable to download %s, err: %s", releaseSignatureName, err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), checksums, signature) {
+		return fmt.Errorf("the signature of release %s is invalid, not updating", r.TagName)
+	}
+
+	return nil
+}
+
+// replaceExecutable atomically replaces the running binary with binary.
+func replaceExecutable(binary []byte) error {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+
+	// the new binary is written beside the old one, so it can be renamed into place
+	tmp := executable + ".new"
+
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(binary); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		// a running executable can't be replaced on Windows, but it can be renamed out of the way
+		old := executable + ".old"
+		os.Remove(old)
+
+		if err := os.Rename(executable, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, executable); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
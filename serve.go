@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// serveCommand serves the download tree over HTTP, with an index of the downloaded firmwares grouped by device,
+// and an ipsw.me compatible API.
+func serveCommand() error {
+	if destination != nil {
+		return errors.New("only local files can be served, not those in -dest")
+	}
+
+	if listenAddress == "" {
+		listenAddress = ":8080"
+	}
+
+	loadHTTPAuthFromEnvironment()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", archiveIndexHandler)
+	mux.HandleFunc("/v4/", ipswAPIHandler)
+	mux.HandleFunc("/calendar.ics", calendarHandler)
+	mux.Handle("/files/", http.StripPrefix("/files", archiveFileHandler(http.FileServer(poolFileSystem{}))))
+
+	return listenAndServe("archive", requireAuth(mux))
+}
+
+// archiveFileHandler hides state and partial downloads from the file server.
+func archiveFileHandler(fileServer http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(part, ".") || strings.HasSuffix(part, partialSuffix) || strings.HasSuffix(part, lockSuffix) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// fileURL returns the URL path the archive file server serves a downloaded file at.
+func fileURL(path string) (string, error) {
+	_, rel, ok := poolRelative(path)
+
+	if !ok {
+		return "", fmt.Errorf("%s is not in the download root", path)
+	}
+
+	u := url.URL{Path: "/files/" + filepath.ToSlash(rel)}
+
+	return u.EscapedPath(), nil
+}
+
+type indexFirmware struct {
+	Version, BuildID, Size, URL, SHA1 string
+	Signed                            bool
+}
+
+type indexDevice struct {
+	Identifier, Name string
+	Firmwares        []indexFirmware
+}
+
+// archiveIndex returns the downloaded firmwares of each device in the catalog, newest first, linked to with the URL
+// link returns for their path.
+func archiveIndex(link func(path string) (string, error)) ([]indexDevice, error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []indexDevice
+
+	for _, device := range catalog.Devices {
+		entry := indexDevice{Identifier: device.Identifier, Name: device.Name}
+
+		sort.Slice(device.Firmwares, func(i, j int) bool {
+			return firmwareDate(&device.Firmwares[i]).After(firmwareDate(&device.Firmwares[j]))
+		})
+
+		for i := range device.Firmwares {
+			fw := &device.Firmwares[i]
+
+			path, err := firmwarePath(fw, &device.BaseDevice)
+
+			if err != nil {
+				continue
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+
+			u, err := link(path)
+
+			if err != nil {
+				continue
+			}
+
+			entry.Firmwares = append(entry.Firmwares, indexFirmware{
+				Version: fw.Version,
+				BuildID: fw.BuildID,
+				Size:    humanize.Bytes(fw.Filesize),
+				URL:     u,
+				SHA1:    fw.SHA1Sum,
+				Signed:  fw.Signed,
+			})
+		}
+
+		if len(entry.Firmwares) > 0 {
+			devices = append(devices, entry)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+
+	return devices, nil
+}
+
+var archiveIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>allthefirmwares</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+	table { border-collapse: collapse; margin-bottom: 24px; font-size: 14px; }
+	th, td { text-align: left; padding: 4px 12px 4px 0; }
+	h2 { font-size: 16px; margin-bottom: 4px; }
+</style>
+</head>
+<body>
+<h1>allthefirmwares</h1>
+<p><a href="/files/">Browse all files</a></p>
+{{range .}}
+<h2 id="{{.Identifier}}">{{.Name}} ({{.Identifier}})</h2>
+<table>
+<tr><th>Version</th><th>Build</th><th>Size</th><th></th></tr>
+{{range .Firmwares}}<tr><td><a href="{{.URL}}">{{.Version}}</a></td><td>{{.BuildID}}</td><td>{{.Size}}</td><td>{{if .Signed}}signed{{end}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No firmwares have been downloaded yet, or no download run has recorded the firmware catalog.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// archiveIndexHandler lists the downloaded firmwares of each device in the catalog, newest first. Other paths are
+// members of downloaded IPSWs.
+func archiveIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		ipswMemberHandler(w, r)
+		return
+	}
+
+	devices, err := archiveIndex(fileURL)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := archiveIndexTemplate.Execute(w, devices); err != nil {
+		errorf("Unable to render archive index, err: %s", err)
+	}
+}
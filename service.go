@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// serviceName is the name the daemon is installed as a service under
+const serviceName = "allthefirmwares"
+
+// servicePathFlags are the flags which hold paths, which are made absolute when installing the service, since it
+// doesn't run in the current directory
+var servicePathFlags = map[string]bool{
+	"d": true, "config": true, "log-file": true, "state-dir": true, "pin": true, "report": true, "status-file": true,
+	"throughput-log": true, "verify-report": true, "ca-cert": true, "client-cert": true, "client-key": true,
+	"metadata-token-file": true, "tls-cert": true, "tls-key": true, "gc-dir": true, "snapshot-dir": true,
+	"pool": true, "pool-route": true,
+}
+
+// serviceArguments returns the arguments the service runs the daemon with: the flags given on the command line (those
+// from -config are read by the daemon itself, so that it can reload them), and always -d, with their paths made
+// absolute.
+func serviceArguments() ([]string, error) {
+	args := []string{"daemon"}
+	given := false
+
+	var err error
+
+	flag.Visit(func(f *flag.Flag) {
+		// -dry-run is for install-service itself
+		if !commandLineFlags[f.Name] || f.Name == "dry-run" || err != nil {
+			return
+		}
+
+		value := f.Value.String()
+
+		if servicePathFlags[f.Name] {
+			value, err = absoluteFlagPaths(f.Name, value)
+		}
+
+		if f.Name == "d" {
+			given = true
+		}
+
+		args = append(args, "-"+f.Name+"="+value)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// -layout presets store firmwares beneath the home directory unless -d is given
+	if !given && layoutPreset == "" {
+		d, err := filepath.Abs(downloadDirectoryTemplate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, "-d="+d)
+	}
+
+	return args, nil
+}
+
+// absoluteFlagPaths makes the paths in the value of the named flag absolute: the whole value, each of the paths of
+// -pool, or the path of each route of -pool-route.
+func absoluteFlagPaths(name, value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	if name != "pool" && name != "pool-route" {
+		return filepath.Abs(value)
+	}
+
+	parts := strings.Split(value, ",")
+
+	for i, part := range parts {
+		prefix, path := "", strings.TrimSpace(part)
+
+		if name == "pool-route" {
+			if j := strings.Index(path, "="); j >= 0 {
+				prefix, path = path[:j+1], path[j+1:]
+			}
+		}
+
+		abs, err := filepath.Abs(path)
+
+		if err != nil {
+			return "", err
+		}
+
+		parts[i] = prefix + abs
+	}
+
+	return strings.Join(parts, ","), nil
+}
+
+// writeServiceFile writes a service definition to path, or only prints it (w/ -dry-run).
+func writeServiceFile(path string, content []byte) error {
+	if dryRun {
+		infof("Would write %s:", path)
+		os.Stdout.Write(content)
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0644)
+}
+
+// runServiceManager runs a command of the service manager, e.g. systemctl, or only logs it (w/ -dry-run).
+func runServiceManager(name string, args ...string) error {
+	if dryRun {
+		infof("Would run %s %s", name, strings.Join(args, " "))
+		return nil
+	}
+
+	var output bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to run %s %s: %s, err: %s", name, strings.Join(args, " "), strings.TrimSpace(output.String()), err)
+	}
+
+	return nil
+}
+
+// serviceExecutable returns the absolute path of the running executable, which the service runs.
+func serviceExecutable() (string, error) {
+	exe, err := os.Executable()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(exe)
+}
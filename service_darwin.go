@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// launchdLabel is the label of the launchd job
+const launchdLabel = "com.github.cj123." + serviceName
+
+func runningAsService() bool {
+	return false
+}
+
+func runService(run func() error) error {
+	return run()
+}
+
+// launchdPaths returns where the job is installed, as a daemon as root, otherwise as an agent of the user, and where
+// its output is logged to if it's not logging to -log-file.
+func launchdPaths() (string, string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist"), filepath.Join("/Library/Logs", serviceName+".log"), nil
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return filepath.Join(home, "Library/LaunchAgents", launchdLabel+".plist"), filepath.Join(home, "Library/Logs", serviceName+".log"), nil
+}
+
+// plistString writes a string element of a property list to b.
+func plistString(b *bytes.Buffer, indent, s string) {
+	b.WriteString(indent + "<string>")
+	xml.EscapeText(b, []byte(s))
+	b.WriteString("</string>\n")
+}
+
+// installServiceCommand writes a launchd job running the daemon with the flags given on the command line, and (re)loads
+// it.
+func installServiceCommand() error {
+	exe, err := serviceExecutable()
+
+	if err != nil {
+		return err
+	}
+
+	args, err := serviceArguments()
+
+	if err != nil {
+		return err
+	}
+
+	path, logPath, err := launchdPaths()
+
+	if err != nil {
+		return err
+	}
+
+	workingDirectory, err := os.Getwd()
+
+	if err != nil {
+		return err
+	}
+
+	var plist bytes.Buffer
+
+	plist.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+`)
+	plistString(&plist, "\t", launchdLabel)
+	plist.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+
+	for _, arg := range append([]string{exe}, args...) {
+		plistString(&plist, "\t\t", arg)
+	}
+
+	plist.WriteString("\t</array>\n\t<key>WorkingDirectory</key>\n")
+	plistString(&plist, "\t", workingDirectory)
+
+	if logFile == "" {
+		for _, key := range []string{"StandardOutPath", "StandardErrorPath"} {
+			plist.WriteString("\t<key>" + key + "</key>\n")
+			plistString(&plist, "\t", logPath)
+		}
+	}
+
+	// restarted if it fails, but not once it's been stopped
+	plist.WriteString(`	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+</dict>
+</plist>
+`)
+
+	if _, err := os.Stat(path); err == nil {
+		if err := runServiceManager("launchctl", "unload", path); err != nil {
+			warnf("%s", err)
+		}
+	}
+
+	if err := writeServiceFile(path, plist.Bytes()); err != nil {
+		return fmt.Errorf("unable to write %s, err: %s", path, err)
+	}
+
+	if err := runServiceManager("launchctl", "load", "-w", path); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		successf("Installed and started the %s service: %s", serviceName, path)
+	}
+
+	return nil
+}
+
+// uninstallServiceCommand unloads and removes the launchd job.
+func uninstallServiceCommand() error {
+	path, _, err := launchdPaths()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("the %s service isn't installed: %s, err: %s", serviceName, path, err)
+	}
+
+	if err := runServiceManager("launchctl", "unload", "-w", path); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		successf("Uninstalled the %s service", serviceName)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runningAsService() bool {
+	return false
+}
+
+func runService(run func() error) error {
+	return run()
+}
+
+// systemdUnitPath returns where the unit is installed: with the system's units as root, otherwise with the user's, and
+// whether it's a user unit.
+func systemdUnitPath() (string, bool, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/etc/systemd/system", serviceName+".service"), false, nil
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return filepath.Join(home, ".config/systemd/user", serviceName+".service"), true, nil
+}
+
+// systemctl runs systemctl, for the user's units if user.
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+
+	return runServiceManager("systemctl", args...)
+}
+
+// systemdQuote quotes an argument of ExecStart, escaping the specifiers and variables systemd would expand.
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// installServiceCommand writes a systemd unit running the daemon with the flags given on the command line, enables it
+// and (re)starts it.
+func installServiceCommand() error {
+	exe, err := serviceExecutable()
+
+	if err != nil {
+		return err
+	}
+
+	args, err := serviceArguments()
+
+	if err != nil {
+		return err
+	}
+
+	// the journal keeps the logs
+	if logFile == "" && !logJournald {
+		args = append(args, "-journald")
+	}
+
+	path, user, err := systemdUnitPath()
+
+	if err != nil {
+		return err
+	}
+
+	workingDirectory, err := os.Getwd()
+
+	if err != nil {
+		return err
+	}
+
+	command := []string{systemdQuote(exe)}
+
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+
+	var unit bytes.Buffer
+
+	fmt.Fprintf(&unit, "[Unit]\nDescription=Downloads new Apple firmwares\nWants=network-online.target\nAfter=network-online.target\n\n")
+	fmt.Fprintf(&unit, "[Service]\nType=notify\nExecStart=%s\n", strings.Join(command, " "))
+
+	if configPath != "" {
+		fmt.Fprintf(&unit, "ExecReload=/bin/kill -HUP $MAINPID\n")
+	}
+
+	fmt.Fprintf(&unit, "WorkingDirectory=%s\nRestart=on-failure\nRestartSec=1min\n\n", workingDirectory)
+
+	if user {
+		fmt.Fprintf(&unit, "[Install]\nWantedBy=default.target\n")
+	} else {
+		fmt.Fprintf(&unit, "[Install]\nWantedBy=multi-user.target\n")
+	}
+
+	if err := writeServiceFile(path, unit.Bytes()); err != nil {
+		return fmt.Errorf("unable to write %s, err: %s", path, err)
+	}
+
+	for _, command := range [][]string{{"daemon-reload"}, {"enable", serviceName}, {"restart", serviceName}} {
+		if err := systemctl(user, command...); err != nil {
+			return err
+		}
+	}
+
+	if user {
+		warnf("The service is a user unit, which only runs while you're logged in, unless lingering is enabled: loginctl enable-linger")
+	}
+
+	if !dryRun {
+		successf("Installed and started the %s service: %s", serviceName, path)
+	}
+
+	return nil
+}
+
+// uninstallServiceCommand stops, disables and removes the systemd unit.
+func uninstallServiceCommand() error {
+	path, user, err := systemdUnitPath()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("the %s service isn't installed: %s, err: %s", serviceName, path, err)
+	}
+
+	if err := systemctl(user, "disable", "--now", serviceName); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	if err := systemctl(user, "daemon-reload"); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		successf("Uninstalled the %s service", serviceName)
+	}
+
+	return nil
+}
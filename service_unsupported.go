@@ -0,0 +1,22 @@
+//go:build !windows && !linux && !darwin
+// +build !windows,!linux,!darwin
+
+package main
+
+import "errors"
+
+func runningAsService() bool {
+	return false
+}
+
+func runService(run func() error) error {
+	return run()
+}
+
+func installServiceCommand() error {
+	return errors.New("services can only be installed on Linux, macOS and Windows")
+}
+
+func uninstallServiceCommand() error {
+	return errors.New("services can only be uninstalled on Linux, macOS and Windows")
+}
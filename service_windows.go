@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runningAsService returns whether allthefirmwares was started by the service control manager.
+func runningAsService() bool {
+	interactive, err := svc.IsAnInteractiveSession()
+
+	return err == nil && !interactive
+}
+
+// serviceHandler runs the daemon as a Windows service. Stopping the service stops downloads gracefully, like an
+// interrupt, and pausing it pauses them.
+type serviceHandler struct {
+	run func() error
+	err error
+}
+
+// runService runs the daemon, with run, as the Windows service serviceName.
+func runService(run func() error) error {
+	h := &serviceHandler{run: run}
+
+	if err := svc.Run(serviceName, h); err != nil {
+		return err
+	}
+
+	return h.err
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+	changes <- svc.Status{State: svc.StartPending}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- h.run()
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case err := <-done:
+			h.err = err
+			changes <- svc.Status{State: svc.StopPending}
+
+			if err != nil {
+				return true, 1
+			}
+
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Pause:
+				setPaused("service paused", true)
+				changes <- svc.Status{State: svc.Paused, Accepts: accepts}
+			case svc.Continue:
+				setPaused("service paused", false)
+				changes <- svc.Status{State: svc.Running, Accepts: accepts}
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(time.Minute / time.Millisecond)}
+
+				// between runs there's nothing to stop
+				if atomic.LoadInt32(&gracefulShutdown) == 0 {
+					return false, 0
+				}
+
+				infof("Stopping after the current chunk")
+				requestShutdown()
+				h.err = <-done
+
+				return false, 0
+			}
+		}
+	}
+}
+
+// installServiceCommand installs the daemon, with the flags given on the command line, as a Windows service which
+// starts automatically, and starts it.
+func installServiceCommand() error {
+	exe, err := serviceExecutable()
+
+	if err != nil {
+		return err
+	}
+
+	args, err := serviceArguments()
+
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		infof("Would install the %s service: %s %s", serviceName, exe, strings.Join(args, " "))
+		return nil
+	}
+
+	m, err := mgr.Connect()
+
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service control manager (run as an administrator), err: %s", err)
+	}
+
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("the %s service is already installed, uninstall it first (uninstall-service)", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceName,
+		Description: "Downloads new Apple firmwares",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+
+	if err != nil {
+		return fmt.Errorf("unable to install the %s service, err: %s", serviceName, err)
+	}
+
+	defer s.Close()
+
+	if logFile == "" {
+		warnf("The service's output isn't kept, use -log-file to keep its logs")
+	}
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("unable to start the %s service, err: %s", serviceName, err)
+	}
+
+	successf("Installed and started the %s service: %s %s", serviceName, exe, strings.Join(args, " "))
+
+	return nil
+}
+
+// uninstallServiceCommand stops and removes the Windows service.
+func uninstallServiceCommand() error {
+	m, err := mgr.Connect()
+
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service control manager (run as an administrator), err: %s", err)
+	}
+
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+
+	if err != nil {
+		return fmt.Errorf("the %s service isn't installed, err: %s", serviceName, err)
+	}
+
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		if _, err := s.Control(svc.Stop); err != nil {
+			warnf("Unable to stop the %s service, err: %s", serviceName, err)
+		}
+	}
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("unable to uninstall the %s service, err: %s", serviceName, err)
+	}
+
+	successf("Uninstalled the %s service", serviceName)
+
+	return nil
+}
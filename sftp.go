@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// sftpStorage stores firmwares on an SSH server, set with -dest sftp://user@host:port/path.
+// It runs the OpenSSH sftp client in batch mode, so authentication uses ssh keys, an agent, or ~/.ssh/config.
+type sftpStorage struct {
+	host, port, root string
+}
+
+func newSFTPStorage(u *url.URL) (storage, error) {
+	if u.Hostname() == "" {
+		return nil, errors.New("no host given, use sftp://user@host/path")
+	}
+
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return nil, err
+	}
+
+	s := &sftpStorage{
+		host: u.Hostname(),
+		port: u.Port(),
+		root: strings.TrimSuffix(u.Path, "/"),
+	}
+
+	if u.User != nil {
+		s.host = u.User.Username() + "@" + s.host
+	}
+
+	return s, nil
+}
+
+func (s *sftpStorage) String() string {
+	return "sftp://" + s.host + s.root
+}
+
+func (s *sftpStorage) remotePath(name string) string {
+	if s.root == "" {
+		return name
+	}
+
+	return s.root + "/" + name
+}
+
+// sftpQuote quotes an argument to an sftp batch command.
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// run runs sftp with the given batch commands, returning their output. Commands prefixed with "-" may fail.
+func (s *sftpStorage) run(commands ...string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+
+	cmd := exec.Command("sftp", append(args, s.host)...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// size returns the size of the remote file, and whether it exists.
+func (s *sftpStorage) size(remote string) (int64, bool, error) {
+	out, err := s.run("-ls -l " + sftpQuote(remote))
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		// batch mode echoes each command
+		if strings.HasPrefix(line, "sftp>") || !strings.HasSuffix(line, path.Base(remote)) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 5 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+
+		if err != nil {
+			return 0, false, fmt.Errorf("unable to parse file listing: %s", line)
+		}
+
+		return size, true, nil
+	}
+
+	return 0, false, nil
+}
+
+func (s *sftpStorage) exists(name string) (bool, error) {
+	_, exists, err := s.size(s.remotePath(name))
+
+	return exists, err
+}
+
+// store uploads the file beside name then renames it into place. An upload interrupted by a dropped connection
+// is resumed from where it stopped by the next attempt.
+func (s *sftpStorage) store(localPath, name string, job *downloadJob) error {
+	info, err := os.Stat(localPath)
+
+	if err != nil {
+		return err
+	}
+
+	remote := s.remotePath(name)
+	partial := remote + partialSuffix
+
+	var commands []string
+
+	// the directories are created where the file is put, beneath the root, or the login directory if there isn't one
+	directory := ""
+
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+
+		directory = path.Join(directory, part)
+		commands = append(commands, "-mkdir "+sftpQuote(s.remotePath(directory)))
+	}
+
+	uploaded, exists, err := s.size(partial)
+
+	if err != nil {
+		return err
+	}
+
+	if exists && uploaded <= info.Size() {
+		infof("Resuming upload of %s from %s", name, humanize.Bytes(uint64(uploaded)))
+		commands = append(commands, "reput "+sftpQuote(localPath)+" "+sftpQuote(partial))
+	} else {
+		commands = append(commands, "put "+sftpQuote(localPath)+" "+sftpQuote(partial))
+	}
+
+	commands = append(commands, "-rm "+sftpQuote(remote), "rename "+sftpQuote(partial)+" "+sftpQuote(remote))
+
+	stop := keepAlive()
+	_, err = s.run(commands...)
+	stop()
+
+	if err != nil {
+		return err
+	}
+
+	size, exists, err := s.size(remote)
+
+	if err != nil {
+		return err
+	} else if !exists || size != info.Size() {
+		return fmt.Errorf("uploaded file is %d bytes, expected %d", size, info.Size())
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// shardIndex and shardCount are the parsed value of -shard, this host's shard of the queue (from 0) and how many there
+// are, or 0 if the queue isn't sharded
+var shardIndex, shardCount uint32
+
+// parseShard parses -shard, e.g. 2/4 for the second of four shards.
+func parseShard() error {
+	shardIndex, shardCount = 0, 0
+
+	if shardValue == "" {
+		return nil
+	}
+
+	var index, count uint32
+
+	if n, err := fmt.Sscanf(shardValue, "%d/%d", &index, &count); err != nil || n != 2 || count == 0 || index < 1 || index > count {
+		return fmt.Errorf("invalid -shard: %s, expected e.g. 2/4 for the second of four shards", shardValue)
+	}
+
+	shardIndex, shardCount = index-1, count
+
+	infof("Only handling shard %d of %d", index, count)
+
+	return nil
+}
+
+// inShard reports whether the firmware at url belongs to this host's shard. Firmwares are assigned by a hash of their
+// URL, so every host assigns them the same way whatever else they have planned, and firmwares shared by several
+// devices belong to the same shard.
+func inShard(url string) bool {
+	if shardCount == 0 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(url))
+
+	return h.Sum32()%shardCount == shardIndex
+}
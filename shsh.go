@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shshTarget is a device whose SHSH blobs are saved for the signed firmwares downloaded for it (w/ -shsh)
+type shshTarget struct {
+	identifier  string
+	ecid        uint64
+	boardConfig string
+}
+
+// shshTargets is the parsed value of -shsh
+var shshTargets []shshTarget
+
+// parseECID parses an ECID, which is hexadecimal if it has a 0x prefix or any of the letters a-f, and decimal otherwise.
+func parseECID(s string) (uint64, error) {
+	s = strings.ToLower(s)
+
+	if strings.HasPrefix(s, "0x") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	} else if strings.ContainsAny(s, "abcdef") {
+		return strconv.ParseUint(s, 16, 64)
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parseSHSHTargets parses -shsh, e.g. "iPhone10,3=0x1a2b3c4d5e,iPad7,5=5482657301265:j71bap".
+func parseSHSHTargets() error {
+	shshTargets = nil
+
+	if shshDevices == "" {
+		return nil
+	}
+
+	var entries []string
+	entry := ""
+
+	// identifiers contain commas themselves, e.g. iPhone10,3, so parts are joined until an = is found
+	for _, part := range strings.Split(shshDevices, ",") {
+		entry += part
+
+		if strings.Contains(part, "=") {
+			entries = append(entries, strings.TrimSpace(entry))
+			entry = ""
+		} else {
+			entry += ","
+		}
+	}
+
+	if entry != "" {
+		return fmt.Errorf("invalid -shsh: %s, expected e.g. iPhone10,3=0x1a2b3c4d5e", shshDevices)
+	}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		target := shshTarget{identifier: strings.TrimSpace(parts[0])}
+		ecid := strings.SplitN(parts[1], ":", 2)
+
+		var err error
+
+		if target.ecid, err = parseECID(strings.TrimSpace(ecid[0])); err != nil || target.ecid == 0 {
+			return fmt.Errorf("invalid -shsh ECID: %s", ecid[0])
+		}
+
+		if len(ecid) == 2 {
+			target.boardConfig = strings.TrimSpace(ecid[1])
+		}
+
+		shshTargets = append(shshTargets, target)
+	}
+
+	if _, err := exec.LookPath("tsschecker"); err != nil {
+		return fmt.Errorf("-shsh requires tsschecker, err: %s", err)
+	}
+
+	return nil
+}
+
+// shshSaved reports whether blobs have been saved for the target's build in directory. tsschecker names them
+// ECID_IDENTIFIER_BOARDCONFIG_VERSION-BUILD_APNONCE.shsh2.
+func shshSaved(directory string, target shshTarget, buildID string) bool {
+	pattern := fmt.Sprintf("%d_%s_*-%s_*.shsh2", target.ecid, target.identifier, buildID)
+	matches, _ := filepath.Glob(filepath.Join(directory, pattern))
+
+	return len(matches) > 0
+}
+
+// extractBuildManifest copies the BuildManifest.plist of the IPSW at path to a temporary file, so that tsschecker
+// needn't download it. The caller removes the file.
+func extractBuildManifest(path string) (string, error) {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if file.Name != "BuildManifest.plist" {
+			continue
+		}
+
+		r, err := file.Open()
+
+		if err != nil {
+			return "", err
+		}
+
+		defer r.Close()
+
+		out, err := ioutil.TempFile("", "BuildManifest-*.plist")
+
+		if err != nil {
+			return "", err
+		}
+
+		defer out.Close()
+
+		if _, err := io.Copy(out, r); err != nil {
+			os.Remove(out.Name())
+			return "", err
+		}
+
+		return out.Name(), nil
+	}
+
+	return "", fmt.Errorf("no BuildManifest.plist in %s", path)
+}
+
+// saveSHSHBlobs saves the SHSH blobs of each -shsh device job is for beside the IPSW with tsschecker, if Apple is signing
+// it and they haven't been saved already. Failures are logged, since they shouldn't fail the download.
+func saveSHSHBlobs(job *downloadJob) {
+	if !job.Firmware.Signed {
+		return
+	}
+
+	directory := filepath.Dir(job.Path)
+	manifest := ""
+
+	for _, target := range shshTargets {
+		if target.identifier != job.Device.Identifier || shshSaved(directory, target, job.Firmware.BuildID) {
+			continue
+		}
+
+		if manifest == "" {
+			if path, err := extractBuildManifest(job.Path); err == nil {
+				manifest = path
+				defer os.Remove(manifest)
+			} else {
+				debugf("Unable to read BuildManifest, tsschecker will download it, err: %s", err)
+			}
+		}
+
+		args := []string{"-d", target.identifier, "-e", strconv.FormatUint(target.ecid, 10), "--buildid", job.Firmware.BuildID, "-s", "--save-path", directory}
+
+		if target.boardConfig != "" {
+			args = append(args, "-B", target.boardConfig)
+		}
+
+		if shshGenerator != "" {
+			args = append(args, "-g", shshGenerator)
+		}
+
+		if manifest != "" {
+			args = append(args, "-m", manifest)
+		}
+
+		var output bytes.Buffer
+
+		cmd := exec.Command("tsschecker", args...)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+
+		err := cmd.Run()
+
+		if err == nil && !shshSaved(directory, target, job.Firmware.BuildID) {
+			err = errors.New("no blobs were saved")
+		}
+
+		if err != nil {
+			errorf("Unable to save SHSH blobs for %s %s (%s), ECID %X, err: %s: %s", target.identifier, job.Firmware.Version, job.Firmware.BuildID, target.ecid, err, lastLine(output.String()))
+			continue
+		}
+
+		successf("Saved SHSH blobs for %s %s (%s), ECID %X", target.identifier, job.Firmware.Version, job.Firmware.BuildID, target.ecid)
+	}
+}
+
+// lastLine returns the last non-empty line of s, which is usually why a command failed.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// partialSuffix is appended to the path of a firmware while it is being downloaded
+const partialSuffix = ".part"
+
+var (
+	// shutdown is closed when a graceful shutdown has been requested
+	shutdown     = make(chan struct{})
+	shutdownOnce sync.Once
+
+	// gracefulShutdown is non-zero while an interrupt should stop downloads cleanly rather than exit immediately
+	gracefulShutdown int32
+
+	errShutdown = errors.New("shutdown requested")
+)
+
+// handleSignals catches SIGINT and SIGTERM. While downloading, the first signal requests a graceful shutdown
+// which saves the remaining queue; otherwise (or on a second signal) the process exits immediately.
+func handleSignals() {
+	handlePauseSignals()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		requested := false
+
+		for range c {
+			fmt.Println()
+
+			if requested || atomic.LoadInt32(&gracefulShutdown) == 0 {
+				resetTerminal()
+				infof("Downloaded %v", humanize.Bytes(atomic.LoadUint64(&downloadedSize)))
+				os.Exit(0)
+			}
+
+			requested = true
+			infof("Stopping after the current chunk, interrupt again to exit immediately")
+			requestShutdown()
+		}
+	}()
+}
+
+// requestShutdown requests a graceful shutdown, once.
+func requestShutdown() {
+	shutdownOnce.Do(func() {
+		// under pauseMu, so that the wakeup can't fall between waitWhilePaused checking for a shutdown and waiting
+		pauseMu.Lock()
+		close(shutdown)
+		pauseCond.Broadcast()
+		pauseMu.Unlock()
+	})
+}
+
+func shutdownRequested() bool {
+	select {
+	case <-shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// resumeState is the download queue remaining when a run was stopped
+type resumeState struct {
+	Jobs []downloadJob `json:"jobs"`
+
+	// PartialOffsets are the number of bytes downloaded of each partially downloaded file, by path
+	PartialOffsets map[string]int64 `json:"partial_offsets"`
+
+	SavedAt time.Time `json:"saved_at"`
+}
+
+func resumeStatePath() string {
+	return filepath.Join(stateDirectory(), "resume.json")
+}
+
+// saveResumeState persists the remaining jobs so that the next run continues with them.
+func saveResumeState(jobs []downloadJob) {
+	state := resumeState{
+		Jobs:           jobs,
+		PartialOffsets: make(map[string]int64),
+		SavedAt:        time.Now(),
+	}
+
+	for _, job := range jobs {
+		if info, err := os.Stat(job.Path + partialSuffix); err == nil {
+			state.PartialOffsets[job.Path] = info.Size()
+		}
+	}
+
+	if err := writeJSONFile(resumeStatePath(), state); err != nil {
+		errorf("Unable to save download queue: %s, err: %s", resumeStatePath(), err)
+		return
+	}
+
+	infof("Downloaded %v, saved %d remaining download(s) to %s", humanize.Bytes(atomic.LoadUint64(&downloadedSize)), len(jobs), resumeStatePath())
+}
+
+// loadResumeState returns the jobs saved by a previous run, if any, and removes the saved state.
+// Partial downloads are truncated to their saved offsets, discarding anything written after the state was saved.
+func loadResumeState() ([]downloadJob, error) {
+	var state resumeState
+
+	err := readJSONFile(resumeStatePath(), &state)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for path, offset := range state.PartialOffsets {
+		partialPath := path + partialSuffix
+
+		if info, err := os.Stat(partialPath); err == nil && info.Size() > offset {
+			if err := os.Truncate(partialPath, offset); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := os.Remove(resumeStatePath()); err != nil {
+		return nil, err
+	}
+
+	return state.Jobs, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// signers are the programs -sign can sign files with, and the extension of their signatures
+var signers = map[string]string{
+	"gpg":      ".asc",
+	"minisign": ".minisig",
+}
+
+// checkSigning checks that the -sign program is supported and installed.
+func checkSigning() error {
+	if signMethod == "" {
+		return nil
+	}
+
+	if _, ok := signers[signMethod]; !ok {
+		return fmt.Errorf("unknown -sign: %s, use gpg or minisign", signMethod)
+	}
+
+	if _, err := exec.LookPath(signMethod); err != nil {
+		return fmt.Errorf("unable to sign with %s, err: %s", signMethod, err)
+	}
+
+	return nil
+}
+
+// signFile writes a detached signature of the file at path beside it (w/ -sign), as path.asc with gpg or
+// path.minisig with minisign, with the -sign-key and the passphrase in ALLTHEFIRMWARES_SIGN_PASSPHRASE, if set.
+func signFile(path string) error {
+	if signMethod == "" {
+		return nil
+	}
+
+	signature := path + signers[signMethod]
+	passphrase, hasPassphrase := os.LookupEnv("ALLTHEFIRMWARES_SIGN_PASSPHRASE")
+
+	var args []string
+
+	switch signMethod {
+	case "gpg":
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", signature}
+
+		if signKey != "" {
+			args = append(args, "--local-user", signKey)
+		}
+
+		if hasPassphrase {
+			args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "0")
+		}
+	case "minisign":
+		args = []string{"-S", "-x", signature}
+
+		if signKey != "" {
+			args = append(args, "-s", signKey)
+		}
+
+		args = append(args, "-m")
+	}
+
+	var output bytes.Buffer
+
+	cmd := exec.Command(signMethod, append(args, path)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if hasPassphrase {
+		cmd.Stdin = strings.NewReader(passphrase + "\n")
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to sign %s with %s: %s, err: %s", path, signMethod, strings.TrimSpace(output.String()), err)
+	}
+
+	infof("Signed %s: %s", path, signature)
+
+	return nil
+}
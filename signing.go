@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// signingChange is when a firmware was seen to be signed or unsigned, having been otherwise (or unknown) before
+type signingChange struct {
+	Time   time.Time `json:"time"`
+	Signed bool      `json:"signed"`
+}
+
+// signingHistory is the signing changes of each firmware, by identifier and build, oldest first
+type signingHistory map[string][]signingChange
+
+func signingHistoryPath() string {
+	return filepath.Join(stateDirectory(), "signing-history.json")
+}
+
+// loadSigningHistory reads the signing history, which is empty if it hasn't been written yet.
+func loadSigningHistory() (signingHistory, error) {
+	history := make(signingHistory)
+
+	if err := readJSONFile(signingHistoryPath(), &history); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// recordSigningChanges adds the firmwares whose signing status has changed since it was last recorded to the history.
+func recordSigningChanges(devices []api.Device) error {
+	history, err := loadSigningHistory()
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	changed := false
+
+	for _, device := range devices {
+		for _, fw := range device.Firmwares {
+			key := pinKey(fw.Identifier, fw.BuildID)
+			changes := history[key]
+
+			if len(changes) > 0 && changes[len(changes)-1].Signed == fw.Signed {
+				continue
+			}
+
+			// the first time a firmware is seen isn't a change
+			if len(changes) > 0 {
+				eventType := "signing_closed"
+
+				if fw.Signed {
+					eventType = "signing_opened"
+				}
+
+				publishEvent(eventType, newDownloadEvent(&device.BaseDevice, &fw, ""))
+			}
+
+			history[key] = append(changes, signingChange{Time: now, Signed: fw.Signed})
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return writeJSONFile(signingHistoryPath(), history)
+}
+
+// signingStatus is the current signing status of a firmware, and its history since allthefirmwares began recording it
+type signingStatus struct {
+	Identifier string          `json:"identifier"`
+	Version    string          `json:"version"`
+	BuildID    string          `json:"buildid"`
+	Signed     bool            `json:"signed"`
+	Since      time.Time       `json:"since"`
+	History    []signingChange `json:"history"`
+}
+
+// signingCommand writes the signing status of every firmware of the selected devices to -o (or stdout),
+// as CSV or (w/ -json) JSON including the history of signing changes.
+func signingCommand() error {
+	devices, err := ipswClient.Devices(false)
+
+	if err != nil {
+		return fmt.Errorf("unable to retrieve firmware information, err: %s", err)
+	}
+
+	selected, information := fetchSelectedDevices(devices)
+
+	var fetched []api.Device
+
+	for i := range selected {
+		if information[i] == nil {
+			return fmt.Errorf("unable to retrieve firmwares for %s", selected[i].Identifier)
+		}
+
+		fetched = append(fetched, *information[i])
+	}
+
+	if err := updateCatalog(fetched); err != nil {
+		warnf("Unable to write firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	history, err := loadSigningHistory()
+
+	if err != nil {
+		return err
+	}
+
+	var statuses []signingStatus
+
+	for _, device := range fetched {
+		for _, fw := range device.Firmwares {
+			status := signingStatus{
+				Identifier: fw.Identifier,
+				Version:    fw.Version,
+				BuildID:    fw.BuildID,
+				Signed:     fw.Signed,
+				History:    history[pinKey(fw.Identifier, fw.BuildID)],
+			}
+
+			if len(status.History) > 0 {
+				status.Since = status.History[len(status.History)-1].Time
+			}
+
+			statuses = append(statuses, status)
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Identifier < statuses[j].Identifier
+	})
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(statuses)
+	}
+
+	w := csv.NewWriter(out)
+
+	if err := w.Write([]string{"identifier", "version", "buildid", "signed", "since"}); err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		since := ""
+
+		if !status.Since.IsZero() {
+			since = status.Since.UTC().Format(time.RFC3339)
+		}
+
+		if err := w.Write([]string{status.Identifier, status.Version, status.BuildID, strconv.FormatBool(status.Signed), since}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+)
+
+// errOversized is returned when more of a file is downloaded than expected, e.g. when an error page is being saved
+var errOversized = errors.New("downloaded more than the expected size")
+
+// sizeTolerance is the parsed value of -size-tolerance, or -1 if sizes aren't checked
+var sizeTolerance int64
+
+// parseSizeTolerance parses the -size-tolerance flag.
+func parseSizeTolerance() error {
+	if sizeToleranceValue == "off" {
+		sizeTolerance = -1
+		return nil
+	}
+
+	tolerance, err := humanize.ParseBytes(sizeToleranceValue)
+
+	if err != nil {
+		return fmt.Errorf("invalid -size-tolerance: %s, err: %s", sizeToleranceValue, err)
+	}
+
+	sizeTolerance = int64(tolerance)
+
+	return nil
+}
+
+// checkContentLength returns an error if the size of a file reported by the server (the offset the download
+// resumes from and the response's Content-Length) differs from the size given by the API by more than -size-tolerance.
+func checkContentLength(offset, contentLength, expectedSize int64) error {
+	if sizeTolerance < 0 || contentLength < 0 || expectedSize <= 0 {
+		return nil
+	}
+
+	size := offset + contentLength
+	difference := size - expectedSize
+
+	if difference < 0 {
+		difference = -difference
+	}
+
+	if difference > sizeTolerance {
+		return fmt.Errorf("the server's file size (%s) doesn't match the expected size (%s)", humanize.Bytes(uint64(size)), humanize.Bytes(uint64(expectedSize)))
+	}
+
+	return nil
+}
+
+// maximumSize returns the most of a file which may be downloaded before the download is aborted, or 0 if it isn't limited.
+func maximumSize(expectedSize int64) int64 {
+	if sizeTolerance < 0 || expectedSize <= 0 {
+		return 0
+	}
+
+	return expectedSize + sizeTolerance
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotTimeFormat names snapshot directories, avoiding colons so they are valid on Windows
+const snapshotTimeFormat = "2006-01-02T15-04-05"
+
+// snapshotArchive creates a dated snapshot of the download root in -snapshot-dir, made of hardlinks to the archive, and
+// points its "latest" link at it, so that consumers can copy a consistent view while downloads continue.
+// Runs which downloaded nothing don't create a snapshot, unless there isn't one yet.
+func snapshotArchive() error {
+	if snapshotDir == "" || verifyIntegrity || shutdownRequested() {
+		return nil
+	}
+
+	latest := filepath.Join(snapshotDir, "latest")
+
+	if _, err := os.Stat(latest); err == nil && atomic.LoadInt64(&downloadsStarted) == 0 {
+		return nil
+	}
+
+	name := time.Now().Format(snapshotTimeFormat)
+	snapshot := filepath.Join(snapshotDir, name)
+	tmp := snapshot + ".tmp"
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	root := downloadRoot()
+	files := 0
+
+	err := walkArchive(func(path string, info os.FileInfo) error {
+		rel, err := filepath.Rel(root, path)
+
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if path, err = filepath.EvalSymlinks(path); err != nil {
+				return err
+			}
+		}
+
+		target := filepath.Join(tmp, rel)
+
+		if err := makeDirectory(filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		files++
+
+		return os.Link(path, target)
+	})
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, snapshot); err != nil {
+		return err
+	}
+
+	// replace the latest link atomically
+	if err := os.Symlink(name, latest+".tmp"); err != nil {
+		return err
+	}
+
+	if err := os.Rename(latest+".tmp", latest); err != nil {
+		return err
+	}
+
+	infof("Created snapshot %s of %d file(s)", snapshot, files)
+
+	return pruneSnapshots()
+}
+
+// pruneSnapshots removes all but the newest -snapshot-keep snapshots.
+func pruneSnapshots() error {
+	if snapshotKeep <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(snapshotDir)
+
+	if err != nil {
+		return err
+	}
+
+	var snapshots []string
+
+	for _, entry := range entries {
+		if _, err := time.Parse(snapshotTimeFormat, entry.Name()); err == nil && entry.IsDir() {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+
+	sort.Strings(snapshots)
+
+	for len(snapshots) > snapshotKeep {
+		infof("Removing old snapshot %s", snapshots[0])
+
+		if err := os.RemoveAll(filepath.Join(snapshotDir, snapshots[0])); err != nil {
+			return err
+		}
+
+		snapshots = snapshots[1:]
+	}
+
+	return nil
+}
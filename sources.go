@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// metadataSource is somewhere firmware information is retrieved from (see -source)
+type metadataSource interface {
+	fmt.Stringer
+
+	// Devices returns every device the source knows of
+	Devices(onlyShowDevicesWithKeys bool) ([]api.BaseDevice, error)
+
+	// DeviceInformation returns a device and its firmwares
+	DeviceInformation(identifier string) (*api.Device, error)
+
+	// probeURL is requested to check that the source is reachable
+	probeURL() string
+}
+
+// apiSource is an ipsw.me v4 compatible API
+type apiSource struct {
+	*api.IPSWClient
+	baseURL string
+}
+
+func (s *apiSource) String() string {
+	return s.baseURL
+}
+
+func (s *apiSource) probeURL() string {
+	return s.baseURL + "/devices"
+}
+
+// newAPISource returns the source for the API at baseURL, whose requests are made with transport.
+func newAPISource(baseURL string, transport http.RoundTripper) *apiSource {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return &apiSource{IPSWClient: api.NewIPSWClient(baseURL, &http.Client{Transport: metadataTransport(transport)}), baseURL: baseURL}
+}
+
+// metadataTransport retries requests made with transport, at most -api-rate a second, and revalidates their responses
+// rather than retrieving them again, unless fresh information is needed (w/ -refresh-checksums or -refresh-changed).
+func metadataTransport(transport http.RoundTripper) http.RoundTripper {
+	transport = &rateLimitTransport{next: transport}
+
+	if refreshChecksums || refreshChanged {
+		return &retryTransport{next: &noCacheTransport{next: transport}}
+	}
+
+	return newConditionalTransport(&retryTransport{next: transport})
+}
+
+// fallbackSource merges the information of several sources, in priority order: the devices any of them know of, and
+// the firmwares of each device any of them know of, with that of earlier sources taking precedence. It only fails if
+// every source does, so that e.g. an outage of ipsw.me doesn't stop new firmwares being found.
+type fallbackSource struct {
+	sources []metadataSource
+}
+
+func (s *fallbackSource) String() string {
+	names := make([]string, len(s.sources))
+
+	for i, source := range s.sources {
+		names[i] = source.String()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (s *fallbackSource) probeURL() string {
+	return s.sources[0].probeURL()
+}
+
+func (s *fallbackSource) Devices(onlyShowDevicesWithKeys bool) ([]api.BaseDevice, error) {
+	var devices []api.BaseDevice
+	var firstErr error
+
+	known := make(map[string]bool)
+	succeeded := false
+
+	for _, source := range s.sources {
+		sourceDevices, err := source.Devices(onlyShowDevicesWithKeys)
+
+		if err != nil {
+			warnf("Unable to retrieve devices from %s, err: %s", source, err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		succeeded = true
+
+		for _, device := range sourceDevices {
+			if !known[device.Identifier] {
+				known[device.Identifier] = true
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	if !succeeded {
+		return nil, firstErr
+	}
+
+	return devices, nil
+}
+
+func (s *fallbackSource) DeviceInformation(identifier string) (*api.Device, error) {
+	var merged *api.Device
+	var firstErr error
+
+	builds := make(map[string]bool)
+
+	for _, source := range s.sources {
+		device, err := source.DeviceInformation(identifier)
+
+		if err != nil {
+			// sources needn't know of every device
+			debugf("Unable to retrieve %s from %s, err: %s", identifier, source, err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		firmwares := device.Firmwares
+
+		if merged == nil {
+			merged = device
+			merged.Firmwares = nil
+		}
+
+		for _, fw := range firmwares {
+			if builds[fw.BuildID] {
+				continue
+			}
+
+			if fw.Identifier == "" {
+				fw.Identifier = identifier
+			}
+
+			builds[fw.BuildID] = true
+			merged.Firmwares = append(merged.Firmwares, fw)
+		}
+	}
+
+	if merged == nil {
+		return nil, firstErr
+	}
+
+	return merged, nil
+}
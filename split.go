@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// splitSuffix is appended to the path of a file split into parts (w/ -split-size) to give the path of its manifest
+	splitSuffix = ".split"
+
+	// fat32MaxFileSize is the largest file FAT32 can hold
+	fat32MaxFileSize = 1<<32 - 1
+)
+
+// splitAlgorithms are the checksums a split file's manifest can record for the whole file
+var splitAlgorithms = map[string]func() hash.Hash{
+	"sha1": sha1.New,
+	"md5":  md5.New,
+}
+
+// splitManifest describes a file split into parts, which are beside it, so that join can reassemble and verify it
+type splitManifest struct {
+	Name      string      `json:"name"`
+	Size      int64       `json:"size"`
+	Modified  time.Time   `json:"modified"`
+	Algorithm string      `json:"algorithm"`
+	Checksum  string      `json:"checksum"`
+	Parts     []splitPart `json:"parts"`
+}
+
+type splitPart struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	SHA1 string `json:"sha1"`
+}
+
+// splitLimit returns the parsed value of -split-size: the largest file sync copies whole, or 0 if files aren't split.
+func splitLimit() (int64, error) {
+	switch splitSizeValue {
+	case "", "0":
+		return 0, nil
+	case "fat32":
+		return fat32MaxFileSize, nil
+	}
+
+	b, err := humanize.ParseBytes(splitSizeValue)
+
+	if err != nil || b == 0 {
+		return 0, fmt.Errorf("invalid -split-size: %s, use a size, e.g. 2GiB, or fat32", splitSizeValue)
+	}
+
+	return int64(b), nil
+}
+
+// splitChanged reports whether the split copy of a file at dst is missing, or was made of a different size or
+// modification time of it.
+func splitChanged(info os.FileInfo, dst string) bool {
+	var manifest splitManifest
+
+	if err := readJSONFile(dst+splitSuffix, &manifest); err != nil {
+		return true
+	}
+
+	return manifest.Size != info.Size() || !sameModTime(manifest.Modified, info.ModTime())
+}
+
+// syncSplit copies a file to dst split into parts of at most limit bytes, dst.001, dst.002 and so on, checking a
+// firmware against its checksum as it's read and each part as it's written, then writes the manifest join reads.
+func syncSplit(file syncFile, dst string, limit int64) error {
+	if err := makeDirectory(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	// parts without a manifest are an incomplete copy
+	if err := os.Remove(dst + splitSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	expected, known := firmwareChecksum{algorithm: "sha1", new: sha1.New}, false
+
+	if file.firmware != nil {
+		if checksum, ok := checksumFor(file.firmware); ok {
+			expected, known = checksum, true
+		}
+	}
+
+	in, err := os.Open(file.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	h := expected.new()
+
+	bar := newProgressBar(file.info.Size(), filepath.Base(file.name))
+	bar.Start()
+
+	r := io.TeeReader(in, io.MultiWriter(h, bar))
+
+	manifest := splitManifest{
+		Name:      filepath.Base(dst),
+		Size:      file.info.Size(),
+		Modified:  file.info.ModTime(),
+		Algorithm: expected.algorithm,
+	}
+
+	for offset := int64(0); offset < manifest.Size; offset += limit {
+		size := manifest.Size - offset
+
+		if size > limit {
+			size = limit
+		}
+
+		path := fmt.Sprintf("%s.%03d", dst, len(manifest.Parts)+1)
+		part, err := writeSplitPart(io.LimitReader(r, size), path, size)
+
+		if err != nil {
+			bar.Finish()
+			return fmt.Errorf("%s: %s", filepath.Base(path), err)
+		}
+
+		manifest.Parts = append(manifest.Parts, part)
+	}
+
+	bar.Finish()
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+
+	if known && !strings.EqualFold(checksum, expected.expected) {
+		return fmt.Errorf("its %s is %s, not %s, so it is corrupt, verify it with -c -r", expected.algorithm, checksum, expected.expected)
+	}
+
+	manifest.Checksum = checksum
+
+	// parts left by an earlier copy of a larger file
+	for i := len(manifest.Parts) + 1; ; i++ {
+		if err := os.Remove(fmt.Sprintf("%s.%03d", dst, i)); err != nil {
+			break
+		}
+	}
+
+	if err := writeJSONFile(dst+splitSuffix, manifest); err != nil {
+		return err
+	}
+
+	return applyPermissions(dst+splitSuffix, fileMode)
+}
+
+// writeSplitPart writes the size bytes read from r to the part at path, reading it back to check it was written intact.
+func writeSplitPart(r io.Reader, path string, size int64) (splitPart, error) {
+	part := splitPart{Name: filepath.Base(path), Size: size}
+
+	partial := path + partialSuffix
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+
+	if err != nil {
+		return part, err
+	}
+
+	defer os.Remove(partial)
+
+	h := sha1.New()
+	n, err := copyReadAhead(io.MultiWriter(out, h), r)
+
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return part, err
+	}
+
+	part.SHA1 = hex.EncodeToString(h.Sum(nil))
+
+	if written, ok, err := verify(partial, firmwareChecksum{algorithm: "sha1", expected: part.SHA1, new: sha1.New}, io.Discard); err != nil {
+		return part, err
+	} else if !ok {
+		return part, fmt.Errorf("the part's sha1 is %s, not %s", written, part.SHA1)
+	}
+
+	if err := applyPermissions(partial, fileMode); err != nil {
+		return part, err
+	}
+
+	return part, os.Rename(partial, path)
+}
+
+// joinCommand reassembles the file split by sync -split-size whose manifest (its .split file) is given as an argument,
+// beside it or to -o, checking each part and the whole file against the manifest.
+func joinCommand() error {
+	manifestPath := flag.Arg(1)
+
+	if manifestPath == "" || !strings.HasSuffix(manifestPath, splitSuffix) {
+		return errors.New("join requires the .split file of a split file as an argument, e.g. join /mnt/usb/iPhone15,2_17.1_21B74_Restore.ipsw.split")
+	}
+
+	var manifest splitManifest
+
+	if err := readJSONFile(manifestPath, &manifest); err != nil {
+		return fmt.Errorf("unable to read %s, err: %s", manifestPath, err)
+	}
+
+	newHash, ok := splitAlgorithms[manifest.Algorithm]
+
+	if !ok {
+		return fmt.Errorf("unknown checksum in %s: %s", manifestPath, manifest.Algorithm)
+	}
+
+	output := exportOutput
+
+	if output == "" {
+		output = strings.TrimSuffix(manifestPath, splitSuffix)
+	}
+
+	directory := filepath.Dir(manifestPath)
+	partial := output + partialSuffix
+
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(partial)
+
+	whole := newHash()
+
+	bar := newProgressBar(manifest.Size, manifest.Name)
+	bar.Start()
+
+	for _, part := range manifest.Parts {
+		err = joinPart(io.MultiWriter(out, whole, bar), filepath.Join(directory, filepath.Base(part.Name)), &part)
+
+		if err != nil {
+			err = fmt.Errorf("%s: %s", part.Name, err)
+			break
+		}
+	}
+
+	bar.Finish()
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if checksum := hex.EncodeToString(whole.Sum(nil)); !strings.EqualFold(checksum, manifest.Checksum) {
+		return fmt.Errorf("the joined %s's %s is %s, not %s", manifest.Name, manifest.Algorithm, checksum, manifest.Checksum)
+	}
+
+	if err := os.Chtimes(partial, manifest.Modified, manifest.Modified); err != nil {
+		return err
+	}
+
+	if err := os.Rename(partial, output); err != nil {
+		return err
+	}
+
+	successf("Joined %d part(s) into %s (%s), its %s matches", len(manifest.Parts), output, humanize.Bytes(uint64(manifest.Size)), manifest.Algorithm)
+
+	return nil
+}
+
+// joinPart copies the part at path to w, checking its size and SHA-1 against the manifest.
+func joinPart(w io.Writer, path string, part *splitPart) error {
+	in, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	h := sha1.New()
+	n, err := copyReadAhead(io.MultiWriter(w, h), in)
+
+	if err != nil {
+		return err
+	}
+
+	if n != part.Size {
+		return fmt.Errorf("it is %d bytes, not %d", n, part.Size)
+	}
+
+	if checksum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(checksum, part.SHA1) {
+		return fmt.Errorf("its sha1 is %s, not %s, so it is corrupt", checksum, part.SHA1)
+	}
+
+	return nil
+}
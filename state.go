@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// downloadRoot returns the directory which all firmwares are downloaded beneath,
+// i.e. the download directory up to its first template action.
+func downloadRoot() string {
+	return templateRoot(directoryTemplate())
+}
+
+// templateRoot returns the directory template up to its first template action.
+func templateRoot(root string) string {
+
+	if i := strings.Index(root, "{{"); i >= 0 {
+		root = root[:i]
+
+		if !strings.HasSuffix(root, "/") && !strings.HasSuffix(root, string(filepath.Separator)) {
+			root = filepath.Dir(root)
+		}
+	}
+
+	if root == "" {
+		return "."
+	}
+
+	return filepath.Clean(root)
+}
+
+// stateDirectory returns the directory used to store state between runs.
+func stateDirectory() string {
+	if stateDir != "" {
+		return stateDir
+	}
+
+	return filepath.Join(downloadRoot(), ".allthefirmwares")
+}
+
+// readJSONFile decodes the JSON file at path into v.
+func readJSONFile(path string, v interface{}) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(v)
+}
+
+// writeJSONFile atomically replaces the file at path with the JSON encoding of v.
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+	"github.com/dustin/go-humanize"
+)
+
+// downloadStats are counters which persist across runs, in the catalog
+type downloadStats struct {
+	Since time.Time `json:"since"`
+
+	// TransferredBytes is everything downloaded, including failed and interrupted downloads
+	TransferredBytes uint64 `json:"transferred_bytes"`
+	Downloads        int    `json:"downloads"`
+	DownloadedBytes  uint64 `json:"downloaded_bytes"`
+
+	// Monthly is the bytes transferred in each month (of local time), e.g. 2017-09
+	Monthly map[string]uint64 `json:"monthly_bytes"`
+
+	// Daily is the bytes transferred on each day (of local time), e.g. 2017-09-19
+	Daily map[string]uint64 `json:"daily_bytes"`
+
+	Devices map[string]*deviceStats `json:"devices"`
+}
+
+// deviceStats is the download history of a device
+type deviceStats struct {
+	Downloads       int       `json:"downloads"`
+	DownloadedBytes uint64    `json:"downloaded_bytes"`
+	FirstDownloaded time.Time `json:"first_downloaded"`
+	LastDownloaded  time.Time `json:"last_downloaded"`
+	LastVersion     string    `json:"last_version"`
+	LastBuildID     string    `json:"last_buildid"`
+}
+
+// recordDownloadStats adds a download attempt, which transferred the given number of bytes, to the stats.
+func recordDownloadStats(device *api.BaseDevice, fw *api.Firmware, transferred uint64, completed bool) {
+	if transferred == 0 && !completed {
+		return
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		warnf("Unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+		return
+	}
+
+	stats := &catalog.Stats
+	now := time.Now()
+
+	if stats.Since.IsZero() {
+		stats.Since = now
+	}
+
+	if stats.Monthly == nil {
+		stats.Monthly = make(map[string]uint64)
+	}
+
+	if stats.Daily == nil {
+		stats.Daily = make(map[string]uint64)
+	}
+
+	if stats.Devices == nil {
+		stats.Devices = make(map[string]*deviceStats)
+	}
+
+	stats.TransferredBytes += transferred
+	stats.Monthly[now.Format("2006-01")] += transferred
+	stats.Daily[now.Format("2006-01-02")] += transferred
+
+	if completed {
+		stats.Downloads++
+		stats.DownloadedBytes += fw.Filesize
+
+		history, ok := stats.Devices[device.Identifier]
+
+		if !ok {
+			history = &deviceStats{FirstDownloaded: now}
+			stats.Devices[device.Identifier] = history
+		}
+
+		history.Downloads++
+		history.DownloadedBytes += fw.Filesize
+		history.LastDownloaded = now
+		history.LastVersion = fw.Version
+		history.LastBuildID = fw.BuildID
+	}
+
+	if err := writeJSONFile(catalogPath(), catalog); err != nil {
+		warnf("Unable to write firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+}
+
+// transferredByPeriod is the bytes transferred in each -period, by its name, e.g. 2017-09-19, 2017-W38 or 2017-09.
+func (s *downloadStats) transferredByPeriod() (map[string]uint64, error) {
+	if statsPeriod == "month" {
+		// months were counted before days were
+		return s.Monthly, nil
+	}
+
+	periods := make(map[string]uint64)
+
+	for day, transferred := range s.Daily {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid day in stats: %s", day)
+		}
+
+		switch statsPeriod {
+		case "day":
+			periods[day] += transferred
+		case "week":
+			year, week := t.ISOWeek()
+			periods[fmt.Sprintf("%d-W%02d", year, week)] += transferred
+		default:
+			return nil, fmt.Errorf("unknown -period: %s, use day, week or month", statsPeriod)
+		}
+	}
+
+	return periods, nil
+}
+
+// statsCommand writes the stats to -o (or stdout), as text or (w/ -json) JSON.
+func statsCommand() error {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return fmt.Errorf("unable to read firmware catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	stats := catalog.Stats
+
+	transferred, err := stats.transferredByPeriod()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput()
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(stats)
+	}
+
+	if stats.Since.IsZero() {
+		fmt.Fprintln(out, "Nothing has been downloaded yet")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Since:\t%s\n", stats.Since.Format("2006-01-02"))
+	fmt.Fprintf(w, "Transferred:\t%s\n", humanize.Bytes(stats.TransferredBytes))
+	fmt.Fprintf(w, "Downloaded:\t%d firmware(s), %s\n", stats.Downloads, humanize.Bytes(stats.DownloadedBytes))
+
+	periods := make([]string, 0, len(transferred))
+
+	for period := range transferred {
+		periods = append(periods, period)
+	}
+
+	sort.Strings(periods)
+
+	fmt.Fprintf(w, "\n%s\tTransferred\n", strings.Title(statsPeriod))
+
+	for _, period := range periods {
+		fmt.Fprintf(w, "%s\t%s\n", period, humanize.Bytes(transferred[period]))
+	}
+
+	identifiers := make([]string, 0, len(stats.Devices))
+
+	for identifier := range stats.Devices {
+		identifiers = append(identifiers, identifier)
+	}
+
+	sort.Strings(identifiers)
+
+	fmt.Fprintf(w, "\nDevice\tDownloads\tDownloaded\tLast downloaded\tLast firmware\n")
+
+	for _, identifier := range identifiers {
+		history := stats.Devices[identifier]
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s (%s)\n", identifier, history.Downloads, humanize.Bytes(history.DownloadedBytes),
+			history.LastDownloaded.Format("2006-01-02"), history.LastVersion, history.LastBuildID)
+	}
+
+	return w.Flush()
+}
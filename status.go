@@ -0,0 +1,234 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeDownload is the progress of a download which is in progress
+type activeDownload struct {
+	Identifier string    `json:"identifier"`
+	Version    string    `json:"version"`
+	BuildID    string    `json:"buildid"`
+	Path       string    `json:"path"`
+	Downloaded int64     `json:"downloaded"`
+	Total      int64     `json:"total"`
+	Started    time.Time `json:"started"`
+
+	// Percent is how much of the file has been downloaded, and BytesPerSecond the average speed of the download so far
+	Percent        float64 `json:"percent"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+
+	// startOffset is how much of the file had already been downloaded when this download started
+	startOffset int64
+}
+
+// runStatus tracks what the current run is doing, for reporting to anything observing it
+type runStatus struct {
+	mu sync.Mutex
+
+	phase      string
+	runStarted time.Time
+	nextRun    time.Time
+
+	queue    []downloadJob
+	position int
+
+	active map[string]*activeDownload
+
+	coverage []deviceCoverage
+
+	lastSuccessfulPoll time.Time
+}
+
+// deviceCoverage is which of a device's firmwares are downloaded, as of the last time downloads were planned
+type deviceCoverage struct {
+	Identifier string             `json:"identifier"`
+	Name       string             `json:"name"`
+	Firmwares  []firmwareCoverage `json:"firmwares"`
+}
+
+type firmwareCoverage struct {
+	Version string `json:"version"`
+	BuildID string `json:"buildid"`
+	Size    uint64 `json:"size"`
+	Signed  bool   `json:"signed"`
+	Present bool   `json:"present"`
+}
+
+var currentStatus = &runStatus{
+	phase:  "starting",
+	active: make(map[string]*activeDownload),
+}
+
+func (s *runStatus) setPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if phase == "planning" {
+		s.runStarted = time.Now()
+	}
+
+	s.phase = phase
+
+	tracePhase(phase)
+	publishEvent("phase", phase)
+}
+
+func (s *runStatus) setNextRun(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextRun = t
+}
+
+func (s *runStatus) setQueue(jobs []downloadJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue = jobs
+	s.position = 0
+}
+
+// setPosition records that the jobs before i in the queue have been processed.
+func (s *runStatus) setPosition(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.position = i
+}
+
+func (s *runStatus) startDownload(job *downloadJob, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active[job.Path] = &activeDownload{
+		Identifier:  job.Device.Identifier,
+		Version:     job.Firmware.Version,
+		BuildID:     job.Firmware.BuildID,
+		Path:        job.Path,
+		Downloaded:  offset,
+		Total:       int64(job.Firmware.Filesize),
+		Started:     time.Now(),
+		startOffset: offset,
+	}
+}
+
+func (s *runStatus) updateDownload(path string, downloaded int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.active[path]; ok {
+		d.Downloaded = downloaded
+	}
+}
+
+func (s *runStatus) finishDownload(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, path)
+}
+
+func (s *runStatus) setCoverage(coverage []deviceCoverage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.coverage = coverage
+}
+
+// setLastSuccessfulPoll records that firmware information was successfully retrieved from the API.
+func (s *runStatus) setLastSuccessfulPoll(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSuccessfulPoll = t
+}
+
+func (s *runStatus) getLastSuccessfulPoll() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSuccessfulPoll
+}
+
+func (s *runStatus) getCoverage() []deviceCoverage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.coverage == nil {
+		return []deviceCoverage{}
+	}
+
+	return s.coverage
+}
+
+// statusSnapshot is a point in time copy of the run status
+type statusSnapshot struct {
+	Phase           string           `json:"phase"`
+	Paused          bool             `json:"paused"`
+	PauseReasons    []string         `json:"pause_reasons"`
+	RunStarted      time.Time        `json:"run_started"`
+	NextRun         time.Time        `json:"next_run"`
+	DownloadedBytes uint64           `json:"downloaded_bytes"`
+	Queued          int              `json:"queued"`
+	Active          []activeDownload `json:"active"`
+}
+
+func (s *runStatus) snapshot() statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := statusSnapshot{
+		Phase:           s.phase,
+		RunStarted:      s.runStarted,
+		NextRun:         s.nextRun,
+		DownloadedBytes: atomic.LoadUint64(&downloadedSize),
+		Queued:          len(s.queue) - s.position,
+		Active:          make([]activeDownload, 0, len(s.active)),
+		PauseReasons:    []string{},
+	}
+
+	for _, d := range s.active {
+		active := *d
+
+		if d.Total > 0 {
+			active.Percent = float64(d.Downloaded) * 100 / float64(d.Total)
+		}
+
+		if elapsed := time.Since(d.Started).Seconds(); elapsed > 0 {
+			active.BytesPerSecond = float64(d.Downloaded-d.startOffset) / elapsed
+		}
+
+		snapshot.Active = append(snapshot.Active, active)
+	}
+
+	sort.Slice(snapshot.Active, func(i, j int) bool {
+		return snapshot.Active[i].Path < snapshot.Active[j].Path
+	})
+
+	pauseMu.Lock()
+	for reason := range pauseReasons {
+		snapshot.PauseReasons = append(snapshot.PauseReasons, reason)
+	}
+	pauseMu.Unlock()
+
+	sort.Strings(snapshot.PauseReasons)
+	snapshot.Paused = len(snapshot.PauseReasons) > 0
+
+	return snapshot
+}
+
+// remainingQueue returns the jobs which haven't been processed yet.
+func (s *runStatus) remainingQueue() []downloadJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.position >= len(s.queue) {
+		return []downloadJob{}
+	}
+
+	return append([]downloadJob(nil), s.queue[s.position:]...)
+}
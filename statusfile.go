@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// statusFile is the progress written to -status-file
+type statusFile struct {
+	statusSnapshot
+
+	Command  string    `json:"command"`
+	Updated  time.Time `json:"updated"`
+	Finished bool      `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	statusFileStop chan struct{}
+	statusFileDone sync.WaitGroup
+)
+
+func writeStatusFile(command string, finished bool, runErr error) {
+	status := statusFile{
+		statusSnapshot: currentStatus.snapshot(),
+		Command:        command,
+		Updated:        time.Now(),
+		Finished:       finished,
+	}
+
+	if runErr != nil {
+		status.Error = runErr.Error()
+	}
+
+	if err := writeJSONFile(statusFilePath, status); err != nil {
+		warnf("Unable to write status file: %s, err: %s", statusFilePath, err)
+	}
+}
+
+// startStatusFile writes the progress of the run to -status-file every -status-interval, so that it can be observed
+// without the control API.
+func startStatusFile(command string) {
+	if statusFilePath == "" {
+		return
+	}
+
+	statusFileStop = make(chan struct{})
+	statusFileDone.Add(1)
+
+	go func() {
+		defer statusFileDone.Done()
+
+		interval := statusInterval
+
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			writeStatusFile(command, false, nil)
+
+			select {
+			case <-ticker.C:
+			case <-statusFileStop:
+				return
+			}
+		}
+	}()
+}
+
+// endStatusFile stops writing the status file, then writes it a final time with the result of the run.
+func endStatusFile(command string, runErr error) {
+	if statusFileStop == nil {
+		return
+	}
+
+	close(statusFileStop)
+	statusFileDone.Wait()
+
+	writeStatusFile(command, true, runErr)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storage is a destination which downloaded firmwares are uploaded to, set with -dest.
+// Firmwares are downloaded beneath the download directory first, and stored under their path relative to the download root.
+type storage interface {
+	fmt.Stringer
+
+	// exists reports whether a file has been stored under name
+	exists(name string) (bool, error)
+
+	// store uploads the local file at path under name, checking that it arrived intact
+	store(path, name string, job *downloadJob) error
+}
+
+// storageBackends creates a storage for each supported -dest URL scheme
+var storageBackends = map[string]func(u *url.URL) (storage, error){
+	"azure":   newAzureStorage,
+	"exec":    newExecStorage,
+	"webdav":  newWebDAVStorage,
+	"webdavs": newWebDAVStorage,
+	"sftp":    newSFTPStorage,
+	"rclone":  newRcloneStorage,
+	"s3":      newS3Storage,
+}
+
+// destination is the storage parsed from -dest, or nil if firmwares are only kept locally
+var destination storage
+
+// openDestination parses -dest.
+func openDestination() error {
+	destination = nil
+
+	if destinationURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(destinationURL)
+
+	if err != nil {
+		return fmt.Errorf("invalid destination: %s, err: %s", destinationURL, err)
+	}
+
+	newStorage, ok := storageBackends[u.Scheme]
+
+	if !ok {
+		return fmt.Errorf("unsupported destination: %s", destinationURL)
+	}
+
+	destination, err = newStorage(u)
+
+	if err != nil {
+		return fmt.Errorf("unable to open destination: %s, err: %s", destinationURL, err)
+	}
+
+	if _, ok := destination.(streamingStorage); streamOnly && !ok {
+		warnf("%s doesn't support -stream, firmwares will be downloaded locally before uploading", destination)
+	}
+
+	return nil
+}
+
+// storageName returns the name which the firmware downloaded to path is stored under.
+func storageName(path string) (string, error) {
+	_, name, ok := poolRelative(path)
+
+	if !ok {
+		return "", fmt.Errorf("%s is not in the download root", path)
+	}
+
+	return filepath.ToSlash(name), nil
+}
+
+// firmwareStored reports whether the firmware at path has been downloaded, to -dest if set.
+func firmwareStored(path string) (bool, error) {
+	if destination == nil {
+		_, err := os.Stat(path)
+
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	name, err := storageName(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return destination.exists(name)
+}
+
+// uploadToDestination stores a downloaded firmware in -dest, removing the local copy unless -keep-local is set.
+func uploadToDestination(job *downloadJob) error {
+	name, err := storageName(job.Path)
+
+	if err != nil {
+		return err
+	}
+
+	infof("Uploading %s to %s", name, destination)
+
+	if err := destination.store(job.Path, name, job); err != nil {
+		return err
+	}
+
+	successf("%s uploaded to %s", name, destination)
+
+	if !keepLocal {
+		if err := os.Remove(job.Path); err != nil {
+			warnf("Unable to remove local copy: %s, err: %s", job.Path, err)
+		} else {
+			auditf("delete", job.Path, "", "uploaded to "+destination.String())
+		}
+	}
+
+	return nil
+}
+
+// joinStorageName joins a -dest path prefix and a storage name.
+func joinStorageName(prefix, name string) string {
+	prefix = strings.Trim(prefix, "/")
+
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "/" + name
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/dustin/go-humanize"
+)
+
+// streamingStorage is a storage which can store firmwares as they download, without staging them on local disk (w/ -stream)
+type streamingStorage interface {
+	storage
+
+	// storeStream stores the contents of r under name. check is called once r is exhausted, and the upload
+	// is discarded rather than made visible if it fails.
+	storeStream(r io.Reader, name string, job *downloadJob, check func() error) error
+}
+
+// streamReader hashes a streamed download and reports its progress
+type streamReader struct {
+	r    io.Reader
+	hash hash.Hash
+	bar  *pb.ProgressBar
+	path string
+
+	// limit is the most which may be read (if set), to abort streaming an error page rather than a firmware
+	limit int64
+
+	// skip is the skipGeneration when the stream started, and skipped is set if it has been skipped since
+	skip    uint64
+	skipped bool
+
+	downloaded int64
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	// the upload can't be paused without losing the download, so wait for the pause to end instead
+	waitWhilePaused()
+
+	if shutdownRequested() {
+		return 0, errShutdown
+	} else if skipRequested(s.skip) {
+		s.skipped = true
+		return 0, errSkipped
+	}
+
+	n, err := s.r.Read(p)
+
+	downloadRate.wait(n)
+
+	if s.limit > 0 && s.downloaded+int64(n) > s.limit {
+		return 0, errOversized
+	}
+
+	s.hash.Write(p[:n])
+	s.bar.Add(n)
+	s.downloaded += int64(n)
+
+	atomic.AddUint64(&downloadedSize, uint64(n))
+	currentStatus.updateDownload(s.path, s.downloaded)
+	markAlive()
+
+	return n, err
+}
+
+// streamDownload downloads a firmware straight into -dest, checking its SHA1 (or MD5) before the upload is completed.
+func streamDownload(job *downloadJob, attempt int) error {
+	ipsw, device := &job.Firmware, &job.Device
+	filename := filepath.Base(ipsw.URL)
+
+	name, err := storageName(job.Path)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := getWithFallback(ipsw.URL)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if err := checkContentLength(0, resp.ContentLength, int64(ipsw.Filesize)); err != nil {
+		return err
+	}
+
+	infof("Streaming %s to %s (%s)", filename, destination, humanize.Bytes(ipsw.Filesize))
+
+	bar := newProgressBar(int64(ipsw.Filesize), filename)
+	bar.Start()
+
+	currentStatus.startDownload(job, 0)
+	defer currentStatus.finishDownload(job.Path)
+
+	downloadEvent := newDownloadEvent(device, ipsw, job.Path)
+	publishEvent("download_started", downloadEvent)
+
+	startTime := time.Now()
+
+	expected, hasChecksum := checksumFor(ipsw)
+
+	if !hasChecksum {
+		expected.new = sha1.New
+	}
+
+	h := newAsyncHash(expected.new())
+	defer h.close()
+
+	reader := &streamReader{r: resp.Body, hash: h, bar: bar, path: job.Path, limit: maximumSize(int64(ipsw.Filesize)), skip: atomic.LoadUint64(&skipGeneration)}
+
+	err = destination.(streamingStorage).storeStream(reader, name, job, func() error {
+		if checksum := hex.EncodeToString(reader.hash.Sum(nil)); hasChecksum && !strings.EqualFold(checksum, expected.expected) {
+			errorf("File: %s failed checksum (wanted: %s, got: %s)", filename, expected.expected, checksum)
+			return errors.New("checksum incorrect")
+		}
+
+		return nil
+	})
+
+	bar.Finish()
+
+	recordDownloadStats(device, ipsw, uint64(reader.downloaded), err == nil)
+
+	if shutdownRequested() && err != nil {
+		// interrupted streams can't be resumed, the next run starts again
+		return errShutdown
+	} else if reader.skipped && err != nil {
+		return errSkipped
+	} else if err != nil {
+		errorf("Error while streaming %s, err: %s", filename, err)
+
+		downloadEvent.Error = err.Error()
+		publishEvent("download_failed", downloadEvent)
+
+		return err
+	}
+
+	duration := time.Since(startTime)
+
+	downloadEvent.Duration = duration.Seconds()
+	publishEvent("download_completed", downloadEvent)
+
+	transferred := uint64(reader.downloaded)
+
+	successf("%s (%s) streamed to %s in %s (%s/s)", filename, humanize.Bytes(ipsw.Filesize), destination, duration.Round(time.Second), humanize.Bytes(uint64(float64(transferred)/duration.Seconds())))
+
+	if throughputLogFile != "" {
+		if err := appendThroughputRecord(device, ipsw, transferred, duration, attempt); err != nil {
+			warnf("Unable to write to throughput log: %s, err: %s", throughputLogFile, err)
+		}
+	}
+
+	return nil
+}
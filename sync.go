@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+	"github.com/dustin/go-humanize"
+)
+
+// syncFile is a file in the archive which sync copies, with the firmware it is, if it's one in the catalog
+type syncFile struct {
+	path, name string
+	info       os.FileInfo
+	firmware   *api.Firmware
+}
+
+// catalogFirmwares returns the firmwares in the catalog, by the path they're downloaded to.
+func catalogFirmwares() (map[string]*api.Firmware, error) {
+	catalog, err := loadCatalog()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog: %s, err: %s", catalogPath(), err)
+	}
+
+	firmwares := make(map[string]*api.Firmware)
+
+	for i := range catalog.Devices {
+		device := &catalog.Devices[i]
+
+		for j := range device.Firmwares {
+			path, err := firmwarePath(&device.Firmwares[j], &device.BaseDevice)
+
+			if err != nil {
+				return nil, err
+			}
+
+			firmwares[filepath.Clean(path)] = &device.Firmwares[j]
+		}
+	}
+
+	return firmwares, nil
+}
+
+// syncCommand copies the files in the archive which are new or have changed to -to, a directory (e.g. on a backup disk)
+// or a -dest style URL, checking each copy, and summarises what was copied.
+func syncCommand() error {
+	if syncTarget == "" {
+		return errors.New("sync requires -to, a directory or a -dest style URL, e.g. -to /mnt/backup")
+	}
+
+	if destination != nil {
+		return errors.New("only local files can be synced, not those in -dest")
+	}
+
+	// other than a remote storage's URL, -to is a directory, which may be e.g. C:\backup on Windows
+	var remote storage
+
+	if u, err := url.Parse(syncTarget); err == nil {
+		if newStorage, ok := storageBackends[u.Scheme]; ok {
+			if remote, err = newStorage(u); err != nil {
+				return fmt.Errorf("unable to open -to: %s, err: %s", syncTarget, err)
+			}
+		}
+	}
+
+	limit, err := splitLimit()
+
+	if err != nil {
+		return err
+	} else if limit > 0 && remote != nil {
+		return errors.New("-split-size can only be used when syncing to a directory")
+	}
+
+	firmwares, err := catalogFirmwares()
+
+	if err != nil {
+		return err
+	}
+
+	var files []syncFile
+
+	err = walkArchive(func(path string, _ os.FileInfo) error {
+		// don't copy what has already been copied, if -to is in the download root
+		if rel, err := filepath.Rel(syncTarget, path); remote == nil && err == nil && !strings.HasPrefix(rel, "..") {
+			return nil
+		}
+
+		// links are copied as the files they point to
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return err
+		}
+
+		_, name, ok := poolRelative(path)
+
+		if !ok {
+			return nil
+		}
+
+		files = append(files, syncFile{path: path, name: name, info: info, firmware: firmwares[filepath.Clean(path)]})
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var copied, unchanged, failed int
+	var size uint64
+
+	for _, file := range files {
+		if shutdownRequested() {
+			return errShutdown
+		}
+
+		var changed bool
+
+		split := limit > 0 && file.info.Size() > limit
+
+		if split {
+			changed = splitChanged(file.info, filepath.Join(syncTarget, file.name))
+		} else if remote != nil {
+			exists, err := remote.exists(filepath.ToSlash(file.name))
+
+			if err != nil {
+				errorf("Unable to check for %s in %s, err: %s", file.name, remote, err)
+				failed++
+				continue
+			}
+
+			changed = !exists
+		} else {
+			changed = syncChanged(file.info, filepath.Join(syncTarget, file.name))
+		}
+
+		if !changed {
+			unchanged++
+			continue
+		}
+
+		if dryRun {
+			fmt.Println(file.name)
+			copied++
+			size += uint64(file.info.Size())
+			continue
+		}
+
+		if split {
+			err = syncSplit(file, filepath.Join(syncTarget, file.name), limit)
+		} else if remote != nil {
+			err = syncRemote(remote, file)
+		} else {
+			err = syncLocal(file, filepath.Join(syncTarget, file.name))
+		}
+
+		if err != nil {
+			errorf("Unable to sync %s, err: %s", file.name, err)
+			failed++
+			continue
+		}
+
+		successf("Synced %s", file.name)
+		copied++
+		size += uint64(file.info.Size())
+	}
+
+	target := syncTarget
+
+	if remote != nil {
+		target = remote.String()
+	}
+
+	if dryRun {
+		infof("Would sync %d file(s) (%s) to %s, %d unchanged", copied, humanize.Bytes(size), target, unchanged)
+	} else {
+		infof("Synced %d file(s) (%s) to %s, %d unchanged, %d failed", copied, humanize.Bytes(size), target, unchanged, failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be synced", failed)
+	}
+
+	return nil
+}
+
+// syncModTimeTolerance is how far apart the modification times of a file and its copy can be for them to be the same,
+// as filesystems round the times they store, FAT32 to 2 seconds
+const syncModTimeTolerance = 2 * time.Second
+
+// syncChanged reports whether the copy of a file at dst is missing, or differs from it in size or modification time.
+// Copies are given the modification time of the file they're of.
+func syncChanged(info os.FileInfo, dst string) bool {
+	copied, err := os.Stat(dst)
+
+	return err != nil || copied.Size() != info.Size() || !sameModTime(copied.ModTime(), info.ModTime())
+}
+
+// sameModTime reports whether two modification times are within syncModTimeTolerance of each other.
+func sameModTime(a, b time.Time) bool {
+	diff := a.Sub(b)
+
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= syncModTimeTolerance
+}
+
+// syncLocal copies a file to dst, checking a firmware against its checksum as it's read, so that corruption isn't
+// propagated, and reading the copy back to check it was written intact.
+func syncLocal(file syncFile, dst string) error {
+	if err := makeDirectory(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	expected, known := firmwareChecksum{algorithm: "sha1", new: sha1.New}, false
+
+	if file.firmware != nil {
+		if checksum, ok := checksumFor(file.firmware); ok {
+			expected, known = checksum, true
+		}
+	}
+
+	in, err := os.Open(file.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	partial := dst + partialSuffix
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(partial)
+
+	h := expected.new()
+
+	bar := newProgressBar(file.info.Size(), filepath.Base(file.name))
+	bar.Start()
+
+	_, err = copyReadAhead(io.MultiWriter(out, h, bar), in)
+
+	bar.Finish()
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+
+	if known && !strings.EqualFold(checksum, expected.expected) {
+		return fmt.Errorf("its %s is %s, not %s, so it is corrupt, verify it with -c -r", expected.algorithm, checksum, expected.expected)
+	}
+
+	expected.expected = checksum
+
+	if copiedChecksum, ok, err := verify(partial, expected, io.Discard); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the copy's %s is %s, not %s", expected.algorithm, copiedChecksum, checksum)
+	}
+
+	if err := applyPermissions(partial, fileMode); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(partial, file.info.ModTime(), file.info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Rename(partial, dst)
+}
+
+// syncRemote uploads a file to a remote storage, which checks that it arrived intact.
+func syncRemote(remote storage, file syncFile) error {
+	job := &downloadJob{Path: file.path, Firmware: api.Firmware{Filesize: uint64(file.info.Size())}}
+
+	if file.firmware != nil {
+		job.Firmware = *file.firmware
+	}
+
+	infof("Uploading %s to %s", file.name, remote)
+
+	return remote.store(file.path, filepath.ToSlash(file.name), job)
+}
@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"log/syslog"
+)
+
+// openSyslog connects to the local syslog daemon, returning a function which logs a message at the given level.
+func openSyslog() (func(level logLevel, message string) error, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "allthefirmwares")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(level logLevel, message string) error {
+		switch level {
+		case levelDebug:
+			return w.Debug(message)
+		case levelWarn:
+			return w.Warning(message)
+		case levelError:
+			return w.Err(message)
+		default:
+			return w.Info(message)
+		}
+	}, nil
+}
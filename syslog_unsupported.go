@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func openSyslog() (func(level logLevel, message string) error, error) {
+	return nil, errors.New("syslog is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// lastAlive is when the daemon last made progress, in Unix nanoseconds, used to decide whether to ping the watchdog
+var lastAlive int64
+
+// sdNotify sends a state change (e.g. "READY=1") to systemd, if the process was started by it with Type=notify.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+
+	if err != nil {
+		debugf("Unable to connect to systemd notify socket: %s, err: %s", socket, err)
+		return
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		debugf("Unable to notify systemd, err: %s", err)
+	}
+}
+
+// markAlive records that the daemon is making progress.
+func markAlive() {
+	atomic.StoreInt64(&lastAlive, time.Now().UnixNano())
+}
+
+// aliveWriter marks the daemon alive whenever it's written to, for long reads (e.g. hashing a firmware) which make
+// progress without downloading anything.
+type aliveWriter struct{}
+
+func (aliveWriter) Write(b []byte) (int, error) {
+	markAlive()
+	return len(b), nil
+}
+
+// keepAlive marks the daemon alive every few seconds until the function it returns is called, for work done by a
+// child process (e.g. an upload by sftp or rclone) whose progress can't be seen.
+func keepAlive() func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				markAlive()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// startWatchdog pings the systemd watchdog (if WatchdogSec is configured) for as long as the daemon keeps making progress,
+// so that systemd restarts it if it wedges.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	timeout := time.Duration(usec) * time.Microsecond
+
+	markAlive()
+
+	go func() {
+		for range time.Tick(timeout / 2) {
+			if isPaused() || time.Since(time.Unix(0, atomic.LoadInt64(&lastAlive))) < timeout {
+				sdNotify("WATCHDOG=1")
+			} else {
+				warnf("No progress for %s, no longer pinging the systemd watchdog", timeout)
+			}
+		}
+	}()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// traceSpan is an operation in a run's trace, e.g. a phase or the download of a firmware
+type traceSpan struct {
+	id, parentID      string
+	name              string
+	started, finished time.Time
+	attributes        map[string]string
+	err               error
+}
+
+// runTrace is the trace of a run, exported to -otlp-endpoint once the run is over
+type runTrace struct {
+	mu sync.Mutex
+
+	traceID string
+	root    *traceSpan
+	phase   *traceSpan
+	spans   []*traceSpan
+}
+
+var (
+	// currentTrace is the trace of the run in progress, if -otlp-endpoint is set
+	currentTrace *runTrace
+
+	telemetryClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// startRunTrace starts tracing a run of command, if -otlp-endpoint is set.
+func startRunTrace(command string) {
+	if otlpEndpoint == "" {
+		return
+	}
+
+	t := &runTrace{traceID: randomID(16)}
+	t.root = &traceSpan{id: randomID(8), name: command, started: time.Now()}
+	t.spans = []*traceSpan{t.root}
+
+	currentTrace = t
+}
+
+// tracePhase ends the span of the current phase of the run, and starts one for the given phase.
+func tracePhase(phase string) {
+	t := currentTrace
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.phase != nil {
+		t.phase.finished = time.Now()
+		t.phase = nil
+	}
+
+	if phase == "idle" {
+		return
+	}
+
+	t.phase = &traceSpan{id: randomID(8), parentID: t.root.id, name: phase, started: time.Now()}
+	t.spans = append(t.spans, t.phase)
+}
+
+// startSpan starts a span within the current phase of the run, which is nil if the run isn't being traced.
+func startSpan(name string, attributes map[string]string) *traceSpan {
+	t := currentTrace
+
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent := t.root
+
+	if t.phase != nil {
+		parent = t.phase
+	}
+
+	s := &traceSpan{id: randomID(8), parentID: parent.id, name: name, started: time.Now(), attributes: attributes}
+	t.spans = append(t.spans, s)
+
+	return s
+}
+
+// finish ends the span, recording err if it failed.
+func (s *traceSpan) finish(err error) {
+	t := currentTrace
+
+	if s == nil || t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.finished = time.Now()
+	s.err = err
+}
+
+// otlpAttributes encodes attributes in the OTLP JSON encoding.
+func otlpAttributes(attributes map[string]string) []map[string]interface{} {
+	encoded := []map[string]interface{}{}
+
+	for key, value := range attributes {
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": map[string]string{"stringValue": value}})
+	}
+
+	return encoded
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// endRunTrace ends the run's trace, exporting it and the run's metrics to -otlp-endpoint.
+func endRunTrace(runErr error) {
+	t := currentTrace
+	currentTrace = nil
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	if t.phase != nil {
+		t.phase.finished = now
+	}
+
+	t.root.finished = now
+	t.root.err = runErr
+
+	resource := map[string]interface{}{
+		"attributes": otlpAttributes(map[string]string{"service.name": "allthefirmwares", "service.version": version}),
+	}
+
+	var spans []map[string]interface{}
+
+	for _, s := range t.spans {
+		finished := s.finished
+
+		// spans which were never finished were interrupted by the end of the run
+		if finished.IsZero() {
+			finished = now
+		}
+
+		span := map[string]interface{}{
+			"traceId":           t.traceID,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": otlpTime(s.started),
+			"endTimeUnixNano":   otlpTime(finished),
+			"attributes":        otlpAttributes(s.attributes),
+		}
+
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+
+		if s.err != nil {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+
+		spans = append(spans, span)
+	}
+
+	scope := map[string]string{"name": "allthefirmwares"}
+
+	traces := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource":   resource,
+				"scopeSpans": []interface{}{map[string]interface{}{"scope": scope, "spans": spans}},
+			},
+		},
+	}
+
+	if err := postOTLP("/v1/traces", traces); err != nil {
+		warnf("Unable to export trace to %s, err: %s", otlpEndpoint, err)
+	}
+
+	gauge := func(name, unit, description string, value uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"name":        name,
+			"unit":        unit,
+			"description": description,
+			"gauge": map[string]interface{}{
+				"dataPoints": []interface{}{map[string]interface{}{
+					"timeUnixNano": otlpTime(now),
+					"asInt":        strconv.FormatUint(value, 10),
+					"attributes":   otlpAttributes(map[string]string{"command": t.root.name}),
+				}},
+			},
+		}
+	}
+
+	metrics := map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": resource,
+				"scopeMetrics": []interface{}{map[string]interface{}{
+					"scope": scope,
+					"metrics": []interface{}{
+						gauge("allthefirmwares.run.duration", "ms", "How long the run took", uint64(now.Sub(t.root.started)/time.Millisecond)),
+						gauge("allthefirmwares.downloaded", "By", "The bytes downloaded since allthefirmwares started", atomic.LoadUint64(&downloadedSize)),
+						gauge("allthefirmwares.firmwares.queued", "{firmware}", "The firmwares queued since allthefirmwares started", uint64(runTotals.Queued)),
+						gauge("allthefirmwares.firmwares.downloaded", "{firmware}", "The firmwares downloaded since allthefirmwares started", uint64(runTotals.Downloaded)),
+						gauge("allthefirmwares.firmwares.failed", "{firmware}", "The firmwares which failed to download since allthefirmwares started", uint64(runTotals.Failed)),
+					},
+				}},
+			},
+		},
+	}
+
+	if err := postOTLP("/v1/metrics", metrics); err != nil {
+		warnf("Unable to export metrics to %s, err: %s", otlpEndpoint, err)
+	}
+}
+
+// postOTLP posts the JSON encoding of payload to path beneath -otlp-endpoint, with the headers in OTEL_EXPORTER_OTLP_HEADERS.
+func postOTLP(path string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(otlpEndpoint, "/")+path, bytes.NewReader(b))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// e.g. api-key=secret,other=value
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+			req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	resp, err := telemetryClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return nil
+}
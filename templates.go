@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/cj123/go-ipsw/api"
+	"gopkg.in/guregu/null.v3"
+)
+
+// templateFuncs are the functions available in the -d and -filename templates, e.g. {{.Name | slug}} or {{.UploadDate | date "2006-01"}}
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"slug": slug,
+	"date": formatDate,
+}
+
+// slug lower cases s, replacing each run of characters other than letters and digits with a hyphen, e.g. "iPad Pro (12.9-inch)" becomes "ipad-pro-12-9-inch".
+func slug(s string) string {
+	var b strings.Builder
+
+	hyphen := false
+
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	return b.String()
+}
+
+// formatDate formats a date with a Go time layout, e.g. "2006-01-02". Unknown dates are formatted as "unknown".
+func formatDate(layout string, date interface{}) (string, error) {
+	var t time.Time
+
+	switch date := date.(type) {
+	case time.Time:
+		t = date
+	case null.Time:
+		if !date.Valid {
+			return "unknown", nil
+		}
+
+		t = date.Time
+	default:
+		return "", fmt.Errorf("date: unsupported type %T", date)
+	}
+
+	return t.Format(layout), nil
+}
+
+// MajorVersion is the firmware's major version, e.g. "11" for 11.0.3.
+func (c *fwDeviceCombo) MajorVersion() string {
+	return strings.SplitN(c.Version, ".", 2)[0]
+}
+
+// ReleaseYear is the year the firmware was released (or uploaded, if its release date is unknown).
+func (c *fwDeviceCombo) ReleaseYear() string {
+	date := firmwareDate(c.Firmware)
+
+	if date.IsZero() {
+		return "unknown"
+	}
+
+	return fmt.Sprint(date.Year())
+}
+
+// SignedState is "signed" or "unsigned".
+func (c *fwDeviceCombo) SignedState() string {
+	if c.Signed {
+		return "signed"
+	}
+
+	return "unsigned"
+}
+
+// ITunesDirectory is the directory iTunes and Finder look for the device's firmwares in, e.g. "iPhone Software Updates".
+func (c *fwDeviceCombo) ITunesDirectory() string {
+	for _, family := range []string{"iPhone", "iPad", "iPod"} {
+		if strings.HasPrefix(c.Identifier, family) {
+			return family + " Software Updates"
+		}
+	}
+
+	if strings.HasPrefix(c.Identifier, "AppleTV") {
+		return "Apple TV Software Updates"
+	}
+
+	return "Other Software Updates"
+}
+
+// windowsReservedNames can't be used as filenames on Windows, even with an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizePathComponent makes a file or directory name valid on Windows, replacing invalid characters with underscores,
+// removing trailing dots and spaces, and avoiding reserved names.
+func sanitizePathComponent(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	name = strings.TrimRight(name, ". ")
+
+	if base := strings.SplitN(name, ".", 2)[0]; windowsReservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+
+	if name == "" {
+		return "_"
+	}
+
+	return name
+}
+
+// sanitizeTemplatedPath sanitizes each component of a path produced by a template, after the given literal prefix.
+func sanitizeTemplatedPath(path, prefix string) string {
+	if !strings.HasPrefix(path, prefix) {
+		prefix = ""
+	}
+
+	components := strings.FieldsFunc(path[len(prefix):], func(r rune) bool {
+		return r == '/' || r == filepath.Separator
+	})
+
+	for i := range components {
+		components[i] = sanitizePathComponent(components[i])
+	}
+
+	return prefix + strings.Join(components, string(filepath.Separator))
+}
+
+// executeTemplate executes a path template for a firmware.
+func executeTemplate(text string, fw *api.Firmware, device *api.BaseDevice) (string, error) {
+	t, err := template.New("firmware").Funcs(templateFuncs).Parse(text)
+
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+
+	if err := t.Execute(&b, &fwDeviceCombo{device.Identifier, device, fw}); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+var (
+	// exampleDevice and exampleFirmware are used to check templates, and to show example values when there is no catalog
+	exampleDevice = api.BaseDevice{
+		Identifier:  "iPhone10,3",
+		Name:        "iPhone X",
+		BoardConfig: "D22AP",
+		Platform:    "t8015",
+		CPID:        32789,
+		BDID:        6,
+	}
+
+	exampleFirmware = api.Firmware{
+		Identifier:  "iPhone10,3",
+		Version:     "11.0",
+		BuildID:     "15A372",
+		SHA1Sum:     "0d1d3cbd2c4a4d1c3b2e7c0f6e9b8f5b9e3b1f0a",
+		MD5Sum:      "4a5f8d2c6b3e1f7a9c0d2e4f6a8b0c1d",
+		Filesize:    3020000000,
+		UploadDate:  null.TimeFrom(time.Date(2017, 9, 19, 17, 5, 12, 0, time.UTC)),
+		ReleaseDate: null.TimeFrom(time.Date(2017, 9, 19, 0, 0, 0, 0, time.UTC)),
+		URL:         "http://appldnld.apple.com/ios11.0/091-23384-20170919-AF03BA9C-8A0D-11E7-A385-E2B6E3C7D7D2/iPhone10,3,iPhone10,6_11.0_15A372_Restore.ipsw",
+	}
+)
+
+// checkTemplates checks that -d and -filename parse, and only refer to fields which exist.
+func checkTemplates() error {
+	if _, err := executeTemplate(directoryTemplate(), &exampleFirmware, &exampleDevice); err != nil {
+		return fmt.Errorf("invalid download directory template (-d), err: %s", err)
+	}
+
+	if filenameTemplate != "" {
+		if _, err := executeTemplate(filenameTemplate, &exampleFirmware, &exampleDevice); err != nil {
+			return fmt.Errorf("invalid filename template (-filename), err: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// templateFields returns the names of the fields (and methods) which templates can refer to.
+func templateFields() []string {
+	var names []string
+
+	seen := make(map[string]bool)
+
+	var addFields func(t reflect.Type)
+
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			if field.Anonymous {
+				continue
+			}
+
+			if !seen[field.Name] {
+				seen[field.Name] = true
+				names = append(names, field.Name)
+			}
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.Anonymous {
+				embedded := field.Type
+
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+
+				addFields(embedded)
+			}
+		}
+	}
+
+	comboType := reflect.TypeOf(fwDeviceCombo{})
+	addFields(comboType)
+
+	methods := reflect.TypeOf(&fwDeviceCombo{})
+
+	for i := 0; i < methods.NumMethod(); i++ {
+		if method := methods.Method(i); method.Type.NumIn() == 1 && !seen[method.Name] {
+			seen[method.Name] = true
+			names = append(names, method.Name)
+		}
+	}
+
+	return names
+}
+
+// templateFieldsCommand lists each field available in templates, with its value for a firmware from the catalog (or an example).
+func templateFieldsCommand() error {
+	device, fw := &exampleDevice, &exampleFirmware
+
+	if catalog, err := loadCatalog(); err == nil {
+		for i := range catalog.Devices {
+			if len(catalog.Devices[i].Firmwares) > 0 {
+				device, fw = &catalog.Devices[i].BaseDevice, &catalog.Devices[i].Firmwares[0]
+				break
+			}
+		}
+	}
+
+	for _, name := range templateFields() {
+		field := "{{." + name + "}}"
+
+		// dates are shown formatted, as they would be used
+		if _, err := executeTemplate("{{."+name+` | date "2006"}}`, fw, device); err == nil {
+			field = "{{." + name + ` | date "2006-01-02"}}`
+		}
+
+		value, err := executeTemplate(field, fw, device)
+
+		// fields which are ambiguous between the device and firmware can't be used
+		if err != nil {
+			continue
+		}
+
+		fmt.Printf("%s\t%s\n", field, value)
+	}
+
+	return nil
+}
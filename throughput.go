@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// throughputLogMu serialises appending to the throughput log
+var throughputLogMu sync.Mutex
+
+var throughputLogHeader = []string{"timestamp", "identifier", "device", "version", "buildid", "size", "duration_seconds", "bytes_per_second", "retries", "transferred"}
+
+// appendThroughputRecord appends a CSV record describing a completed download, of which transferred bytes were
+// downloaded by this attempt (less than its size if it was resumed), to the throughput log, writing the header first
+// if the log is empty.
+func appendThroughputRecord(device *api.BaseDevice, fw *api.Firmware, transferred uint64, duration time.Duration, retries int) error {
+	throughputLogMu.Lock()
+	defer throughputLogMu.Unlock()
+
+	file, err := os.OpenFile(throughputLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+
+	if info.Size() == 0 {
+		if err := w.Write(throughputLogHeader); err != nil {
+			return err
+		}
+	}
+
+	err = w.Write([]string{
+		time.Now().Format(time.RFC3339),
+		device.Identifier,
+		device.Name,
+		fw.Version,
+		fw.BuildID,
+		strconv.FormatUint(fw.Filesize, 10),
+		fmt.Sprintf("%.3f", duration.Seconds()),
+		fmt.Sprintf("%.0f", float64(transferred)/duration.Seconds()),
+		strconv.Itoa(retries),
+		strconv.FormatUint(transferred, 10),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
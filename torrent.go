@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bencode writes v, made of strings, ints, int64s, lists and string-keyed maps, in BitTorrent's bencoding.
+func bencode(w *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(w, "%d:%s", len(v), v)
+	case int:
+		fmt.Fprintf(w, "i%de", v)
+	case int64:
+		fmt.Fprintf(w, "i%de", v)
+	case []interface{}:
+		w.WriteByte('l')
+
+		for _, item := range v {
+			bencode(w, item)
+		}
+
+		w.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		// dictionary keys must be sorted
+		sort.Strings(keys)
+
+		w.WriteByte('d')
+
+		for _, k := range keys {
+			bencode(w, k)
+			bencode(w, v[k])
+		}
+
+		w.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
+
+// torrentPieceLength picks a power of two piece length between 256KiB and 16MiB, aiming for around 2000 pieces.
+func torrentPieceLength(size int64) int64 {
+	length := int64(256 << 10)
+
+	for length < 16<<20 && size/length > 2000 {
+		length *= 2
+	}
+
+	return length
+}
+
+// pieceHasher hashes everything written to it in pieces of a fixed length
+type pieceHasher struct {
+	length  int64
+	current bytes.Buffer
+	pieces  bytes.Buffer
+}
+
+func (h *pieceHasher) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		take := int(h.length) - h.current.Len()
+
+		if take > len(p) {
+			take = len(p)
+		}
+
+		h.current.Write(p[:take])
+		p = p[take:]
+
+		if int64(h.current.Len()) == h.length {
+			h.flush()
+		}
+	}
+
+	return n, nil
+}
+
+func (h *pieceHasher) flush() {
+	if h.current.Len() == 0 {
+		return
+	}
+
+	sum := sha1.Sum(h.current.Bytes())
+	h.pieces.Write(sum[:])
+	h.current.Reset()
+}
+
+// hashFiles hashes the files at paths, in order, as the pieces of a torrent.
+func hashFiles(paths []string, pieceLength int64) (string, error) {
+	h := &pieceHasher{length: pieceLength}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(h, bufio.NewReaderSize(file, 1<<20))
+		file.Close()
+
+		if err != nil {
+			return "", err
+		}
+
+		markAlive()
+	}
+
+	h.flush()
+
+	return h.pieces.String(), nil
+}
+
+// newTorrent returns the top level dictionary of a torrent with the given info dictionary.
+func newTorrent(info map[string]interface{}) map[string]interface{} {
+	torrent := map[string]interface{}{
+		"info":          info,
+		"created by":    "allthefirmwares",
+		"creation date": time.Now().Unix(),
+	}
+
+	if torrentTrackers != "" {
+		trackers := strings.Split(torrentTrackers, ",")
+
+		var tiers []interface{}
+
+		for _, tracker := range trackers {
+			tiers = append(tiers, []interface{}{strings.TrimSpace(tracker)})
+		}
+
+		torrent["announce"] = strings.TrimSpace(trackers[0])
+		torrent["announce-list"] = tiers
+	}
+
+	return torrent
+}
+
+func writeTorrent(path string, torrent map[string]interface{}) error {
+	var b bytes.Buffer
+
+	bencode(&b, torrent)
+
+	return os.WriteFile(path, b.Bytes(), 0600)
+}
+
+// firmwareTorrent writes job.Path.torrent, with Apple's CDN as a web seed.
+func firmwareTorrent(job *downloadJob) error {
+	info, err := os.Stat(job.Path)
+
+	if err != nil {
+		return err
+	}
+
+	pieceLength := torrentPieceLength(info.Size())
+	pieces, err := hashFiles([]string{job.Path}, pieceLength)
+
+	if err != nil {
+		return err
+	}
+
+	torrent := newTorrent(map[string]interface{}{
+		"name":         filepath.Base(job.Path),
+		"length":       info.Size(),
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	})
+
+	torrent["url-list"] = []interface{}{job.Firmware.URL}
+	torrent["comment"] = fmt.Sprintf("%s %s (%s)", job.Device.Name, job.Firmware.Version, job.Firmware.BuildID)
+
+	return writeTorrent(job.Path+".torrent", torrent)
+}
+
+// collectionTorrent writes a single torrent of all the jobs' files to path, named after it, with their paths relative to the download root.
+// It has no web seeds, as Apple's CDN doesn't lay firmwares out as the collection does.
+func collectionTorrent(path string, jobs []downloadJob) error {
+	var paths []string
+	var files []interface{}
+	var size int64
+
+	for _, job := range jobs {
+		info, err := os.Stat(job.Path)
+
+		if err != nil {
+			return err
+		}
+
+		_, rel, ok := poolRelative(job.Path)
+
+		if !ok {
+			return fmt.Errorf("%s is not in the download root", job.Path)
+		}
+
+		var components []interface{}
+
+		for _, component := range strings.Split(filepath.ToSlash(rel), "/") {
+			components = append(components, component)
+		}
+
+		paths = append(paths, job.Path)
+		files = append(files, map[string]interface{}{"length": info.Size(), "path": components})
+		size += info.Size()
+	}
+
+	pieceLength := torrentPieceLength(size)
+
+	infof("Hashing %d file(s) (%d bytes)", len(paths), size)
+
+	pieces, err := hashFiles(paths, pieceLength)
+
+	if err != nil {
+		return err
+	}
+
+	return writeTorrent(path, newTorrent(map[string]interface{}{
+		"name":         strings.TrimSuffix(filepath.Base(path), ".torrent"),
+		"files":        files,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}))
+}
+
+// torrentCommand writes a .torrent beside each downloaded firmware matching the flags, or one torrent of them all to -o.
+func torrentCommand() error {
+	if destination != nil {
+		return errors.New("torrents can only be made of local files, not those in -dest")
+	}
+
+	infof("Gathering IPSW information...")
+
+	jobs, err := planDownloads(true)
+
+	if err != nil {
+		return err
+	}
+
+	if exportOutput != "" {
+		if len(jobs) == 0 {
+			return errors.New("no downloaded firmwares match")
+		}
+
+		if err := collectionTorrent(exportOutput, jobs); err != nil {
+			return err
+		}
+
+		successf("Wrote %s", exportOutput)
+
+		return nil
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+
+		if shutdownRequested() {
+			break
+		}
+
+		if _, err := os.Stat(job.Path + ".torrent"); err == nil {
+			skipf("Skipping %s, torrent already exists", job.Path)
+			continue
+		}
+
+		infof("Creating torrent for %s", job.Path)
+
+		if err := firmwareTorrent(job); err != nil {
+			errorf("Unable to create torrent for %s, err: %s", job.Path, err)
+			continue
+		}
+
+		successf("Wrote %s.torrent", job.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// storageTimeout is how long a connection to a destination can go without sending or receiving anything before
+// the request on it fails. Uploads can take far longer than this, so it isn't a limit on the whole request.
+const storageTimeout = 2 * time.Minute
+
+var (
+	// downloadClient is the HTTP client which firmwares are downloaded with, set up by setupHTTPClients
+	downloadClient = http.DefaultClient
+
+	// storageClient is the HTTP client requests to destinations are made with, set up by setupHTTPClients so that
+	// stalled connections time out
+	storageClient = http.DefaultClient
+)
+
+// setupHTTPClients creates downloadClient from the -4, -6, -resolve and -dns flags, authenticating to hosts with
+// credentials, and storageClient, and applies the TLS and connection flags to all HTTP requests.
+func setupHTTPClients() error {
+	if forceIPv4 && forceIPv6 {
+		return errors.New("-4 and -6 can't be used together")
+	}
+
+	if maxIdleConns < 0 {
+		return fmt.Errorf("invalid -max-idle-conns: %d, it must not be negative", maxIdleConns)
+	}
+
+	if err := loadDownloadCredentials(); err != nil {
+		return err
+	}
+
+	tlsConfig, err := clientTLSConfig()
+
+	if err != nil {
+		return err
+	}
+
+	// the API client, health checks and destinations all use the default transport
+	tuneTransport(http.DefaultTransport.(*http.Transport), tlsConfig)
+
+	storageTransport := http.DefaultTransport.(*http.Transport).Clone()
+	storageTransport.DialContext = idleTimeoutDialer(storageTimeout)
+	storageTransport.ResponseHeaderTimeout = storageTimeout
+
+	storageClient = &http.Client{Transport: storageTransport}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	overrides, err := parseResolveOverrides(resolveOverrides)
+
+	if err != nil {
+		return err
+	}
+
+	if forceIPv4 || forceIPv6 || len(overrides) > 0 || dnsServer != "" {
+		network := ""
+
+		if forceIPv4 {
+			network = "tcp4"
+		} else if forceIPv6 {
+			network = "tcp6"
+		}
+
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+		if dnsServer != "" {
+			server := dnsServer
+
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+
+			dialer.Resolver = &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, server)
+				},
+			}
+		}
+
+		transport.DialContext = func(ctx context.Context, n, address string) (net.Conn, error) {
+			if override, ok := overrides[strings.ToLower(address)]; ok {
+				debugf("Connecting to %s for %s (-resolve)", override, address)
+				address = override
+			}
+
+			if network != "" {
+				n = network
+			}
+
+			return dialer.DialContext(ctx, n, address)
+		}
+	}
+
+	downloadClient = &http.Client{Transport: traced(&credentialTransport{next: transport})}
+
+	return nil
+}
+
+// idleTimeoutDialer returns a DialContext whose connections fail once nothing has been sent or received on them for
+// timeout.
+func idleTimeoutDialer(timeout time.Duration) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// idleTimeoutConn extends its deadline whenever anything is sent or received on it. Both directions are extended
+// together, as a response is waited for while the request body is still being sent.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// tuneTransport applies tlsConfig and the connection flags to transport: HTTP/2 with servers which support it (unless
+// -http2=false), -max-idle-conns connections kept alive to each server, and TLS sessions resumed rather than negotiated
+// again for each connection.
+func tuneTransport(transport *http.Transport, tlsConfig *tls.Config) {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.DisableKeepAlives = maxIdleConns == 0
+
+	if transport.MaxIdleConns < maxIdleConns {
+		transport.MaxIdleConns = maxIdleConns
+	}
+
+	transport.ForceAttemptHTTP2 = useHTTP2
+
+	if !useHTTP2 {
+		// a non-nil map disables HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+}
+
+// parseResolveOverrides parses curl style host:port:address overrides, separated by commas, into the address to
+// connect to for each host:port.
+func parseResolveOverrides(value string) (map[string]string, error) {
+	overrides := make(map[string]string)
+
+	if value == "" {
+		return overrides, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid -resolve: %s, use host:port:address", entry)
+		}
+
+		address := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("invalid -resolve: %s, %s is not an IP address", entry, address)
+		}
+
+		overrides[strings.ToLower(net.JoinHostPort(parts[0], parts[1]))] = net.JoinHostPort(address, parts[1])
+	}
+
+	return overrides, nil
+}
+
+// clientTLSConfig returns the TLS configuration given by -ca-cert, -client-cert, -client-key and -insecure,
+// or nil if they aren't set.
+func clientTLSConfig() (*tls.Config, error) {
+	if caCertificate == "" && clientCertificate == "" && clientKey == "" && !insecureTLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if caCertificate != "" {
+		b, err := os.ReadFile(caCertificate)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read -ca-cert: %s, err: %s", caCertificate, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+
+		if err != nil {
+			// e.g. Windows before go1.18, where the system pool isn't available
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM certificates found in -ca-cert: %s", caCertificate)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if clientCertificate != "" || clientKey != "" {
+		if clientCertificate == "" || clientKey == "" {
+			return nil, errors.New("-client-cert and -client-key must be used together")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(clientCertificate, clientKey)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s, err: %s", clientCertificate, err)
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if insecureTLS {
+		warnf("-insecure is set, TLS certificates will NOT be verified and connections can be intercepted. Checksums are still verified.")
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// trashBatch names the directory of -trash that the files removed by this run are moved to
+var trashBatch = time.Now().Format(snapshotTimeFormat)
+
+// removeOrTrash removes the file at path, beneath root, or w/ -trash, moves it to the same path relative to root in
+// this run's directory of the trash, returning where it was moved to. Either way, directories it leaves empty are
+// removed. Files which don't exist are ignored.
+func removeOrTrash(path, root string) (string, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if trashDirectory == "" {
+		return "", removeWithEmptyParents(path, root)
+	}
+
+	rel, err := filepath.Rel(root, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(trashDirectory, trashBatch, rel)
+
+	if err := makeDirectory(filepath.Dir(target)); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+
+	return target, removeWithEmptyParents(path, root)
+}
+
+// emptyTrashCommand removes the files moved to -trash longer ago than -trash-age, or w/ -dry-run, lists them.
+func emptyTrashCommand() error {
+	if trashDirectory == "" {
+		return errors.New("there is no trash, use -trash")
+	}
+
+	batches, err := ioutil.ReadDir(trashDirectory)
+
+	if os.IsNotExist(err) {
+		infof("The trash is empty")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var size int64
+	emptied, failed := 0, 0
+
+	for _, batch := range batches {
+		trashed, err := time.ParseInLocation(snapshotTimeFormat, batch.Name(), time.Local)
+
+		// anything else in the trash wasn't put there by allthefirmwares
+		if err != nil || !batch.IsDir() {
+			continue
+		}
+
+		if time.Since(trashed) < trashAge {
+			debugf("Keeping %s, trashed less than %s ago", batch.Name(), trashAge)
+			continue
+		}
+
+		directory := filepath.Join(trashDirectory, batch.Name())
+
+		var files []string
+		var batchSize int64
+
+		err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			files = append(files, path)
+			batchSize += info.Size()
+
+			return nil
+		})
+
+		if err == nil && !dryRun {
+			err = os.RemoveAll(directory)
+		}
+
+		if err != nil {
+			errorf("Unable to empty %s, err: %s", directory, err)
+			failed++
+			continue
+		}
+
+		for _, path := range files {
+			if dryRun {
+				fmt.Println(path)
+			} else {
+				auditf("delete", path, "", "emptied from the trash")
+			}
+		}
+
+		emptied += len(files)
+		size += batchSize
+	}
+
+	if dryRun {
+		infof("%d file(s) in the trash, %s", emptied, humanize.Bytes(uint64(size)))
+	} else {
+		infof("Emptied %d file(s) from the trash, %s", emptied, humanize.Bytes(uint64(size)))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to empty %d trash director(ies)", failed)
+	}
+
+	return nil
+}
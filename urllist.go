@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// exportURLs writes the URL of each firmware, one per line.
+func exportURLs(w io.Writer, jobs []downloadJob) error {
+	for _, job := range jobs {
+		if _, err := fmt.Fprintln(w, job.Firmware.URL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ipswFilename matches the names Apple gives IPSWs, e.g. iPhone10,3,iPhone10,6_11.0_15A372_Restore.ipsw
+var ipswFilename = regexp.MustCompile(`^([A-Za-z]+\d+,\d+)(?:,[A-Za-z]+\d+,\d+)*_([\d.]+)_([0-9A-Za-z]+)_Restore\.ipsw$`)
+
+// urlJobs makes the jobs for downloading URLs which ipsw.me may not know about.
+type urlJobs struct {
+	devices map[string]api.BaseDevice
+}
+
+func newURLJobs() *urlJobs {
+	u := &urlJobs{devices: make(map[string]api.BaseDevice)}
+
+	// the catalog has the names of the devices, for the -d template
+	if catalog, err := loadCatalog(); err == nil {
+		for _, device := range catalog.Devices {
+			u.devices[device.Identifier] = device.BaseDevice
+		}
+	}
+
+	return u
+}
+
+// job returns the job for downloading rawURL, checked against checksum (a SHA1 or MD5) if it isn't empty. If the
+// device, version and build can be read from the URL's filename, the firmware is downloaded to its path under the
+// -d and -filename templates, otherwise to the download root.
+func (u *urlJobs) job(rawURL, checksum string) (downloadJob, error) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return downloadJob{}, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+
+	fw := api.Firmware{URL: rawURL}
+
+	switch checksum = strings.ToLower(checksum); {
+	case checksum == "":
+	case validChecksum(checksum, sha1.Size):
+		fw.SHA1Sum = checksum
+	case validChecksum(checksum, md5.Size):
+		fw.MD5Sum = checksum
+	default:
+		return downloadJob{}, fmt.Errorf("invalid checksum for %s: %s, it must be a SHA1 or MD5", rawURL, checksum)
+	}
+
+	filename := path.Base(parsed.Path)
+	match := ipswFilename.FindStringSubmatch(filename)
+
+	if match == nil {
+		return downloadJob{Firmware: fw, Path: filepath.Join(downloadRoot(), filename)}, nil
+	}
+
+	fw.Identifier, fw.Version, fw.BuildID = match[1], match[2], match[3]
+
+	device, ok := u.devices[fw.Identifier]
+
+	if !ok {
+		device = api.BaseDevice{Identifier: fw.Identifier, Name: fw.Identifier}
+	}
+
+	downloadPath, err := firmwarePath(&fw, &device)
+
+	if err != nil {
+		return downloadJob{}, err
+	}
+
+	return downloadJob{Device: device, Firmware: fw, Path: downloadPath}, nil
+}
+
+// readURLList reads a list of URLs to download, one per line, each optionally followed by its expected SHA1 (or MD5).
+// Blank lines and lines starting with # are ignored.
+func readURLList(r io.Reader) ([]downloadJob, error) {
+	var jobs []downloadJob
+
+	u := newURLJobs()
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		checksum := ""
+
+		if len(fields) > 1 {
+			checksum = fields[1]
+		}
+
+		job, err := u.job(fields[0], checksum)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, scanner.Err()
+}
+
+// argumentURLs reads URLs to download from args, each optionally followed by its expected SHA1 (or MD5).
+func argumentURLs(args []string) ([]downloadJob, error) {
+	var jobs []downloadJob
+
+	u := newURLJobs()
+
+	for i := 0; i < len(args); i++ {
+		checksum := ""
+
+		if i+1 < len(args) && !strings.Contains(args[i+1], "://") {
+			checksum = args[i+1]
+		}
+
+		job, err := u.job(args[i], checksum)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if checksum != "" {
+			i++
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// importCommand downloads the URLs listed in a file (or stdin, given -), checking each against its SHA1 (or MD5) if
+// one is given.
+func importCommand() error {
+	if flag.NArg() != 1 {
+		return errors.New("usage: import FILE")
+	}
+
+	in := os.Stdin
+
+	if name := flag.Arg(0); name != "-" {
+		var err error
+
+		if in, err = os.Open(name); err != nil {
+			return err
+		}
+
+		defer in.Close()
+	}
+
+	jobs, err := readURLList(in)
+
+	if err != nil {
+		return err
+	}
+
+	downloadURLs(jobs)
+
+	return nil
+}
+
+// fetchCommand downloads the URLs given as arguments (or read from stdin, given -), each optionally followed by its
+// SHA1 (or MD5).
+func fetchCommand() error {
+	if flag.NArg() == 0 {
+		return errors.New("usage: fetch URL [CHECKSUM] [URL [CHECKSUM]...], or fetch - to read them from stdin")
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "-" {
+		return importCommand()
+	}
+
+	jobs, err := argumentURLs(flag.Args())
+
+	if err != nil {
+		return err
+	}
+
+	downloadURLs(jobs)
+
+	return nil
+}
+
+// downloadURLs downloads those of jobs which haven't already been downloaded.
+func downloadURLs(jobs []downloadJob) {
+	var missing []downloadJob
+
+	for _, job := range jobs {
+		if stored, err := firmwareStored(job.Path); err != nil {
+			errorf("Error reading download path: %s, err: %s", job.Path, err)
+		} else if stored {
+			skipf("Skipping %s, already exists", job.Path)
+		} else {
+			missing = append(missing, job)
+		}
+	}
+
+	infof("Downloading: %d of %d listed file(s)", len(missing), len(jobs))
+
+	processJobs(missing)
+}
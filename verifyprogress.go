@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// verifyProgress is how far through a verification run (w/ -c) allthefirmwares is
+type verifyProgress struct {
+	files, totalFiles int
+	bytes, totalBytes uint64
+	started           time.Time
+}
+
+func newVerifyProgress(jobs []downloadJob) *verifyProgress {
+	p := &verifyProgress{totalFiles: len(jobs), started: time.Now()}
+
+	for _, job := range jobs {
+		p.totalBytes += job.Firmware.Filesize
+	}
+
+	return p
+}
+
+// prefix is shown before the progress bar of the file being verified.
+func (p *verifyProgress) prefix() string {
+	return fmt.Sprintf("[%d/%d] ", p.files+1, p.totalFiles)
+}
+
+// fileVerified counts a file as verified, logging the overall progress.
+func (p *verifyProgress) fileVerified(size uint64) {
+	p.files++
+	p.bytes += size
+
+	if p.files == p.totalFiles {
+		infof("Verified %d file(s) (%s) in %s", p.files, humanize.Bytes(p.bytes), time.Since(p.started).Round(time.Second))
+		return
+	}
+
+	eta := "unknown"
+
+	if p.bytes > 0 && p.bytes < p.totalBytes {
+		elapsed := time.Since(p.started)
+		eta = time.Duration(float64(elapsed) / float64(p.bytes) * float64(p.totalBytes-p.bytes)).Round(time.Second).String()
+	}
+
+	percent := 0.0
+
+	if p.totalBytes > 0 {
+		percent = float64(p.bytes) / float64(p.totalBytes) * 100
+	}
+
+	infof("Verified %d of %d file(s), %s of %s (%.1f%%), about %s left", p.files, p.totalFiles,
+		humanize.Bytes(p.bytes), humanize.Bytes(p.totalBytes), percent, eta)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// verifyResult is the result of verifying one firmware, in the verification report
+type verifyResult struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	BuildID    string `json:"buildid"`
+	Path       string `json:"path"`
+
+	// Result is pass, fail (the checksum didn't match), missing (not downloaded), error (the file couldn't be read),
+	// unverifiable (the firmware has no checksum), wrong_device (its BuildManifest is for other devices, w/ -check-manifest)
+	// corrupt (a file inside it failed its CRC-32, w/ -deep) or chunklist_mismatch (it doesn't match Apple's chunklist,
+	// w/ -chunklist)
+	Result string `json:"result"`
+
+	// Algorithm is the checksum verified, sha1 or (for old firmwares without one) md5
+	Algorithm string `json:"algorithm,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+
+	// Recorded is the SHA-1 recorded in the catalog, when it has since changed upstream (w/ -refresh-checksums)
+	Recorded string `json:"recorded,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// Action is what was done about a file which didn't pass: none, redownloaded, redownload failed (after -retries), or
+	// locked (being downloaded by another instance)
+	Action string    `json:"action,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+var (
+	verifyResultsMu sync.Mutex
+	verifyResults   []verifyResult
+)
+
+func newVerifyResult(device *api.BaseDevice, fw *api.Firmware, path, result string) verifyResult {
+	r := verifyResult{
+		Identifier: device.Identifier,
+		Version:    fw.Version,
+		BuildID:    fw.BuildID,
+		Path:       path,
+		Result:     result,
+		Time:       time.Now(),
+	}
+
+	if checksum, ok := checksumFor(fw); ok {
+		r.Algorithm, r.Expected = checksum.algorithm, checksum.expected
+	}
+
+	return r
+}
+
+// recordVerifyResult adds a result to the verification report, if -verify-report is set.
+func recordVerifyResult(result verifyResult) {
+	if verifyReportPath == "" {
+		return
+	}
+
+	verifyResultsMu.Lock()
+	defer verifyResultsMu.Unlock()
+
+	verifyResults = append(verifyResults, result)
+}
+
+// writeVerifyReport writes the verification results to -verify-report, as CSV if its name ends in .csv, otherwise JSON.
+func writeVerifyReport() error {
+	if verifyReportPath == "" {
+		return nil
+	}
+
+	verifyResultsMu.Lock()
+	defer verifyResultsMu.Unlock()
+
+	results := verifyResults
+	verifyResults = nil
+
+	if results == nil {
+		results = []verifyResult{}
+	}
+
+	if !strings.EqualFold(filepath.Ext(verifyReportPath), ".csv") {
+		return writeJSONFile(verifyReportPath, results)
+	}
+
+	file, err := os.Create(verifyReportPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+
+	if err := w.Write([]string{"time", "identifier", "version", "buildid", "path", "result", "algorithm", "expected", "actual", "recorded", "error", "action"}); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		err := w.Write([]string{r.Time.Format(time.RFC3339), r.Identifier, r.Version, r.BuildID, r.Path, r.Result, r.Algorithm, r.Expected, r.Actual, r.Recorded, r.Error, r.Action})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cj123/go-ipsw/api"
+)
+
+// versionMatches reports whether version matches pattern, which is a version or a prefix of one,
+// where x or * matches any component, e.g. 16, 16.x and 16.* all match 16.0 and 16.1.2.
+func versionMatches(version, pattern string) bool {
+	v := strings.Split(version, ".")
+	p := strings.Split(strings.TrimSpace(pattern), ".")
+
+	if len(p) > len(v) {
+		return false
+	}
+
+	for i := range p {
+		if p[i] != "x" && p[i] != "X" && p[i] != "*" && p[i] != v[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// versionSelected reports whether version matches any of the -version patterns, which are separated by commas.
+func versionSelected(version string) bool {
+	if versionPatterns == "" {
+		return true
+	}
+
+	for _, pattern := range strings.Split(versionPatterns, ",") {
+		if versionMatches(version, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// versionConstraint is a condition on a firmware's version: a comparison with op (>=, >, <=, < or =), or a -version
+// style pattern if op is empty
+type versionConstraint struct {
+	op, version string
+}
+
+// allows reports whether version meets the constraint.
+func (c versionConstraint) allows(version string) bool {
+	cmp := compareVersions(version, c.version)
+
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "=":
+		return cmp == 0
+	}
+
+	return versionMatches(version, c.version)
+}
+
+// deviceVersionRule is the firmwares to download for the devices whose identifier matches pattern, from
+// -device-versions, which are used for them instead of -version and -latest
+type deviceVersionRule struct {
+	pattern     string
+	constraints []versionConstraint
+	latest      int
+	signed      bool
+}
+
+// deviceVersionRules is the parsed value of -device-versions
+var deviceVersionRules []deviceVersionRule
+
+// parseDeviceVersions parses -device-versions, rules separated by semicolons, each a device identifier (or glob),
+// a colon and its constraints separated by spaces, all of which a firmware must meet, e.g.
+// "iPhone12,1: >=15.0 <17; iPad7,11: latest 2; iPhone8,*: 15.x signed".
+func parseDeviceVersions() error {
+	deviceVersionRules = nil
+
+	for _, value := range strings.Split(deviceVersions, ";") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, ":", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid -device-versions rule: %s, expected e.g. iPhone12,1: >=15.0", strings.TrimSpace(value))
+		}
+
+		rule := deviceVersionRule{pattern: strings.TrimSpace(parts[0])}
+
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return fmt.Errorf("invalid -device-versions device: %s, err: %s", rule.pattern, err)
+		}
+
+		terms := strings.Fields(parts[1])
+
+		if len(terms) == 0 {
+			return fmt.Errorf("-device-versions rule for %s has no constraints", rule.pattern)
+		}
+
+		for i := 0; i < len(terms); i++ {
+			term := terms[i]
+
+			switch {
+			case term == "latest":
+				if i+1 == len(terms) {
+					rule.latest = 1
+					break
+				}
+
+				n, err := strconv.Atoi(terms[i+1])
+
+				if err != nil || n < 1 {
+					rule.latest = 1
+					break
+				}
+
+				rule.latest = n
+				i++
+			case term == "signed":
+				rule.signed = true
+			default:
+				op := strings.TrimRight(term, "0123456789.xX*")
+				version := term[len(op):]
+
+				// the version may follow the operator after a space, e.g. ">= 15.0"
+				if version == "" && i+1 < len(terms) {
+					i++
+					version = terms[i]
+				}
+
+				if (op != "" && op != ">=" && op != ">" && op != "<=" && op != "<" && op != "=") || version == "" {
+					return fmt.Errorf("invalid -device-versions constraint for %s: %s, use e.g. >=15.0, <17, =16.1, 16.x, latest 2 or signed", rule.pattern, term)
+				}
+
+				rule.constraints = append(rule.constraints, versionConstraint{op: op, version: version})
+			}
+		}
+
+		deviceVersionRules = append(deviceVersionRules, rule)
+	}
+
+	return nil
+}
+
+// deviceVersionRuleFor returns the first -device-versions rule for a device, or nil if there isn't one.
+func deviceVersionRuleFor(identifier string) *deviceVersionRule {
+	for i := range deviceVersionRules {
+		if matched, _ := path.Match(deviceVersionRules[i].pattern, identifier); matched {
+			return &deviceVersionRules[i]
+		}
+	}
+
+	return nil
+}
+
+// allows reports whether the firmware at index of its device's firmwares (newest first) meets the rule.
+func (r *deviceVersionRule) allows(index int, fw *api.Firmware) bool {
+	if (r.latest > 0 && index >= r.latest) || (r.signed && !fw.Signed) {
+		return false
+	}
+
+	for _, c := range r.constraints {
+		if !c.allows(fw.Version) {
+			return false
+		}
+	}
+
+	return true
+}
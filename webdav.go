@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// webdavStorage stores firmwares on a WebDAV server such as Nextcloud or ownCloud, set with -dest webdav://host/path
+// (or webdavs:// for HTTPS). Credentials are taken from the URL, or WEBDAV_USER and WEBDAV_PASSWORD.
+type webdavStorage struct {
+	base           *url.URL
+	user, password string
+
+	// createdDirectories are the collections known to exist, shared by the -j workers' uploads
+	directoriesMu      sync.Mutex
+	createdDirectories map[string]bool
+}
+
+func newWebDAVStorage(u *url.URL) (storage, error) {
+	s := &webdavStorage{
+		base:               &url.URL{Scheme: "http", Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")},
+		user:               os.Getenv("WEBDAV_USER"),
+		password:           os.Getenv("WEBDAV_PASSWORD"),
+		createdDirectories: make(map[string]bool),
+	}
+
+	if u.Scheme == "webdavs" {
+		s.base.Scheme = "https"
+	}
+
+	if u.User != nil {
+		s.user = u.User.Username()
+
+		if password, ok := u.User.Password(); ok {
+			s.password = password
+		}
+	}
+
+	return s, nil
+}
+
+func (s *webdavStorage) String() string {
+	return strings.Replace(s.base.String(), "http", "webdav", 1)
+}
+
+func (s *webdavStorage) url(name string) string {
+	u := *s.base
+	u.Path += "/" + name
+
+	return u.String()
+}
+
+func (s *webdavStorage) do(method, name string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(name), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	resp, err := storageClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+func (s *webdavStorage) exists(name string) (bool, error) {
+	resp, err := s.do(http.MethodHead, name, nil, nil, 0)
+
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+}
+
+// mkdirAll creates each collection leading to name.
+func (s *webdavStorage) mkdirAll(name string) error {
+	parts := strings.Split(name, "/")
+
+	for i := 1; i < len(parts); i++ {
+		directory := strings.Join(parts[:i], "/")
+
+		s.directoriesMu.Lock()
+		created := s.createdDirectories[directory]
+		s.directoriesMu.Unlock()
+
+		if created {
+			continue
+		}
+
+		resp, err := s.do("MKCOL", directory, nil, nil, 0)
+
+		if err != nil {
+			return err
+		}
+
+		// 405 Method Not Allowed is returned if the collection already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("unable to create directory: %s, unexpected response status: %s", directory, resp.Status)
+		}
+
+		s.directoriesMu.Lock()
+		s.createdDirectories[directory] = true
+		s.directoriesMu.Unlock()
+	}
+
+	return nil
+}
+
+func (s *webdavStorage) store(path, name string, job *downloadJob) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	return s.upload(file, info.Size(), name, job, nil)
+}
+
+func (s *webdavStorage) storeStream(r io.Reader, name string, job *downloadJob, check func() error) error {
+	return s.upload(r, int64(job.Firmware.Filesize), name, job, check)
+}
+
+// upload uploads r beside name, then moves it into place if check (if any) passes, so that interrupted or corrupt
+// uploads never appear complete. Servers which support it (Nextcloud and ownCloud) also check the upload against the firmware's SHA1.
+func (s *webdavStorage) upload(r io.Reader, size int64, name string, job *downloadJob, check func() error) error {
+	if err := s.mkdirAll(name); err != nil {
+		return err
+	}
+
+	partialName := name + partialSuffix
+
+	// long uploads are progress to the watchdog
+	r = io.TeeReader(r, aliveWriter{})
+
+	header := http.Header{}
+
+	// files without a SHA1, e.g. torrents and MD5-only firmwares, would be rejected with an empty one
+	if job.Firmware.SHA1Sum != "" {
+		header.Set("Oc-Checksum", "SHA1:"+job.Firmware.SHA1Sum)
+	}
+
+	resp, err := s.do(http.MethodPut, partialName, header, r, size)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to upload, unexpected response status: %s", resp.Status)
+	}
+
+	if check != nil {
+		if err := check(); err != nil {
+			if _, err := s.do(http.MethodDelete, partialName, nil, nil, 0); err != nil {
+				warnf("Unable to remove partial upload: %s, err: %s", partialName, err)
+			}
+
+			return err
+		}
+	}
+
+	resp, err = s.do("MOVE", partialName, http.Header{"Destination": {s.url(name)}, "Overwrite": {"T"}}, nil, 0)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unable to move upload into place, unexpected response status: %s", resp.Status)
+	}
+
+	return nil
+}
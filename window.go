@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily period of local time, which may span midnight
+type timeWindow struct {
+	start, end time.Duration
+}
+
+// parseTimeWindow parses a window in the form "01:00-07:00".
+func parseTimeWindow(s string) (timeWindow, error) {
+	parts := strings.Split(s, "-")
+
+	if len(parts) != 2 {
+		return timeWindow{}, fmt.Errorf("invalid time window: %s, expected e.g. 01:00-07:00", s)
+	}
+
+	var w timeWindow
+
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("invalid time window: %s, err: %s", s, err)
+		}
+
+		offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+		if i == 0 {
+			w.start = offset
+		} else {
+			w.end = offset
+		}
+	}
+
+	// it would never contain any time, rather than always
+	if w.start == w.end {
+		return timeWindow{}, fmt.Errorf("invalid time window: %s, it starts and ends at the same time", s)
+	}
+
+	return w, nil
+}
+
+// contains reports whether t falls within the window.
+func (w timeWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.start <= w.end {
+		return offset >= w.start && offset < w.end
+	}
+
+	return offset >= w.start || offset < w.end
+}
+
+// startDownloadWindow pauses downloads whenever the current time is outside of -download-window.
+func startDownloadWindow() error {
+	if downloadWindow == "" {
+		return nil
+	}
+
+	w, err := parseTimeWindow(downloadWindow)
+
+	if err != nil {
+		return err
+	}
+
+	reason := "outside download window " + downloadWindow
+
+	setPaused(reason, !w.contains(time.Now()))
+
+	go func() {
+		for now := range time.Tick(30 * time.Second) {
+			setPaused(reason, !w.contains(now))
+		}
+	}()
+
+	return nil
+}